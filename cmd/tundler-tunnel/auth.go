@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/tunnelapi"
+)
+
+// Control-API authentication. Off by default: with none of these set,
+// :4242 behaves as it always has (plain HTTP, anyone who can reach the
+// pod may call anything). Configuring tokens or a client CA turns it on.
+//
+//	TUNDLER_API_TOKENS          JSON array of bearer tokens:
+//	                            [{"name":"crawler","token":"…","scope":"admin"},
+//	                             {"name":"leak-detector","token":"…","scope":"read"}]
+//	TUNDLER_API_TLS_CERT_FILE   serve :4242 over HTTPS with this cert…
+//	TUNDLER_API_TLS_KEY_FILE    …and key (both or neither)
+//	TUNDLER_API_CLIENT_CA_FILE  verify client certificates against this CA
+//	                            (mTLS; requires the cert/key above)
+//	TUNDLER_API_CLIENT_SCOPES   CSV of certificate-CN=scope, "*" as the
+//	                            default, e.g. "crawler=admin,*=read".
+//	                            Unlisted CNs get read.
+//
+// Scopes: "read" may call the GET endpoints (/status, /selftest/…);
+// "admin" may also call mutating ones (/rotate and future admin
+// endpoints). /livez and /readyz stay open — kubelet probes carry no
+// credentials. Client certificates are requested, not required, for the
+// same reason; a request without one falls back to its bearer token.
+const (
+	envAPITokens       = "TUNDLER_API_TOKENS"
+	envAPITLSCertFile  = "TUNDLER_API_TLS_CERT_FILE"
+	envAPITLSKeyFile   = "TUNDLER_API_TLS_KEY_FILE"
+	envAPIClientCAFile = "TUNDLER_API_CLIENT_CA_FILE"
+	envAPIClientScopes = "TUNDLER_API_CLIENT_SCOPES"
+)
+
+// apiScope orders what a caller may do; a higher scope implies the lower.
+type apiScope int
+
+const (
+	scopeNone apiScope = iota
+	scopeRead
+	scopeAdmin
+)
+
+func (s apiScope) String() string {
+	switch s {
+	case scopeRead:
+		return "read"
+	case scopeAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func parseAPIScope(v string) (apiScope, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "read":
+		return scopeRead, nil
+	case "admin":
+		return scopeAdmin, nil
+	}
+	return scopeNone, fmt.Errorf("unknown scope %q (want read or admin)", v)
+}
+
+// apiToken is one entry of TUNDLER_API_TOKENS.
+type apiToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Scope string `json:"scope"`
+
+	scope apiScope
+}
+
+// apiCaller is who a request was authenticated as.
+type apiCaller struct {
+	Identity string   // token name, certificate CN, or "anonymous"
+	Method   string   // "token", "mtls" or "none"
+	Scope    apiScope // what it may do
+}
+
+// apiAuth authenticates control-API requests. The zero value (and a nil
+// pointer) is "auth disabled": every caller is an anonymous admin.
+type apiAuth struct {
+	tokens     []apiToken
+	certScopes map[string]apiScope // CN → scope; "*" is the default
+	tlsConfig  *tls.Config         // non-nil: serve HTTPS
+	clientCA   bool
+}
+
+// enabled reports whether any credential is configured.
+func (a *apiAuth) enabled() bool {
+	return a != nil && (len(a.tokens) > 0 || a.clientCA)
+}
+
+// loadAPIAuthFromEnv builds the apiAuth described by the TUNDLER_API_*
+// variables. A malformed configuration is an error — main treats it as
+// fatal rather than silently serving an unauthenticated API.
+func loadAPIAuthFromEnv() (*apiAuth, error) {
+	a := &apiAuth{}
+	if raw := strings.TrimSpace(os.Getenv(envAPITokens)); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &a.tokens); err != nil {
+			return nil, fmt.Errorf("%s: expected a JSON array: %w", envAPITokens, err)
+		}
+		for i := range a.tokens {
+			t := &a.tokens[i]
+			if t.Name == "" || t.Token == "" {
+				return nil, fmt.Errorf("%s: entry %d needs a name and a token", envAPITokens, i)
+			}
+			s, err := parseAPIScope(t.Scope)
+			if err != nil {
+				return nil, fmt.Errorf("%s: token %q: %w", envAPITokens, t.Name, err)
+			}
+			t.scope = s
+		}
+	}
+
+	certFile, keyFile := os.Getenv(envAPITLSCertFile), os.Getenv(envAPITLSKeyFile)
+	caFile := os.Getenv(envAPIClientCAFile)
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", envAPITLSCertFile, envAPITLSKeyFile)
+	}
+	if caFile != "" && certFile == "" {
+		return nil, fmt.Errorf("%s requires %s/%s (mTLS needs a TLS listener)", envAPIClientCAFile, envAPITLSCertFile, envAPITLSKeyFile)
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load API TLS key pair: %w", err)
+		}
+		a.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", envAPIClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates in %s", envAPIClientCAFile, caFile)
+		}
+		a.tlsConfig.ClientCAs = pool
+		a.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		a.clientCA = true
+		scopes, err := parseClientScopes(os.Getenv(envAPIClientScopes))
+		if err != nil {
+			return nil, err
+		}
+		a.certScopes = scopes
+	}
+	return a, nil
+}
+
+// parseClientScopes parses TUNDLER_API_CLIENT_SCOPES ("cn=scope,…").
+func parseClientScopes(csv string) (map[string]apiScope, error) {
+	out := map[string]apiScope{}
+	for _, part := range parseExcludedLocations(csv) {
+		cn, scope, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not cn=scope", envAPIClientScopes, part)
+		}
+		s, err := parseAPIScope(scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q: %w", envAPIClientScopes, cn, err)
+		}
+		out[strings.TrimSpace(cn)] = s
+	}
+	return out, nil
+}
+
+// errInvalidCredentials distinguishes "wrong token" from "no credentials".
+var errInvalidCredentials = errors.New("invalid bearer token")
+
+// authenticate identifies the caller. A presented bearer token must be
+// valid (a bad one is never downgraded to anonymous); otherwise a
+// verified client certificate identifies the caller by its CN.
+func (a *apiAuth) authenticate(r *http.Request) (apiCaller, error) {
+	if !a.enabled() {
+		return apiCaller{Identity: "anonymous", Method: "none", Scope: scopeAdmin}, nil
+	}
+	if h := r.Header.Get("Authorization"); h != "" {
+		presented, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			return apiCaller{}, errInvalidCredentials
+		}
+		// Compare against every token so timing doesn't reveal which
+		// (or whether any) prefix matched.
+		var match *apiToken
+		for i := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(a.tokens[i].Token)) == 1 {
+				match = &a.tokens[i]
+			}
+		}
+		if match == nil {
+			return apiCaller{}, errInvalidCredentials
+		}
+		return apiCaller{Identity: match.Name, Method: "token", Scope: match.scope}, nil
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		scope, ok := a.certScopes[cn]
+		if !ok {
+			scope, ok = a.certScopes["*"]
+		}
+		if !ok {
+			scope = scopeRead
+		}
+		return apiCaller{Identity: cn, Method: "mtls", Scope: scope}, nil
+	}
+	return apiCaller{Identity: "anonymous", Method: "none", Scope: scopeNone}, nil
+}
+
+// require wraps h so it only runs for callers holding at least scope.
+// 401 (with a Bearer challenge) when the caller is unauthenticated or
+// presented a bad token, 403 when authenticated but under-scoped. Both
+// are RFC 9457 problems like every other error on this API.
+func (a *apiAuth) require(scope apiScope, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, err := a.authenticate(r)
+		if err == nil && caller.Scope >= scope {
+			h(w, r)
+			return
+		}
+		if err != nil || caller.Scope == scopeNone {
+			detail := "this endpoint requires a bearer token or client certificate"
+			if err != nil {
+				detail = err.Error()
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tundler-tunnel"`)
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/unauthorized",
+				Title:  "Authentication required",
+				Status: http.StatusUnauthorized,
+				Detail: detail,
+			})
+			return
+		}
+		writeProblem(w, problemDetails{
+			Type:   "https://tundler-tunnel/errors/forbidden",
+			Title:  "Insufficient scope",
+			Status: http.StatusForbidden,
+			Detail: fmt.Sprintf("%s has scope %s; this endpoint requires %s", caller.Identity, caller.Scope, scope),
+		})
+	}
+}
+
+// AuditEntry records one call to a mutating control-API endpoint (see
+// tunnelapi.AuditEntry).
+type AuditEntry = tunnelapi.AuditEntry
+
+// AuditSink receives each completed audit entry. Production forwards it
+// to the notifier; nil drops it (after logging).
+type AuditSink func(AuditEntry)
+
+type auditKey struct{}
+
+// auditFrom returns the in-progress audit entry of the request, so a
+// handler can hand it on (e.g. into the rotation it triggers). Nil when
+// the route isn't audited.
+func auditFrom(ctx context.Context) *AuditEntry {
+	e, _ := ctx.Value(auditKey{}).(*AuditEntry)
+	return e
+}
+
+// audited wraps a mutating endpoint. guarded must already enforce auth
+// (require), so refused calls are audited too. Parameters are the query
+// string merged with a JSON object body (the body is restored for the
+// handler); result is the response status line.
+func (a *apiAuth) audited(sink AuditSink, guarded http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &AuditEntry{
+			At:         time.Now().UTC().Format(time.RFC3339),
+			Caller:     "anonymous",
+			AuthMethod: "none",
+			RemoteAddr: r.RemoteAddr,
+			Endpoint:   r.Method + " " + r.URL.Path,
+			Params:     auditParams(r),
+		}
+		if c, err := a.authenticate(r); err == nil {
+			entry.Caller, entry.AuthMethod = c.Identity, c.Method
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		guarded(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+		entry.Result = fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status))
+		log.Printf("tundler-tunnel: audit caller=%s auth=%s endpoint=%q params=%v result=%q",
+			entry.Caller, entry.AuthMethod, entry.Endpoint, entry.Params, entry.Result)
+		if sink != nil {
+			sink(*entry)
+		}
+	}
+}
+
+// auditParams collects a request's parameters for the audit trail:
+// query values (single values unwrapped) overlaid with the top-level
+// keys of a JSON object body. Reads at most 64 KiB of body and puts it
+// back so the handler sees it unchanged.
+func auditParams(r *http.Request) map[string]any {
+	params := map[string]any{}
+	for k, vs := range r.URL.Query() {
+		if len(vs) == 1 {
+			params[k] = vs[0]
+		} else {
+			params[k] = vs
+		}
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		raw, _ := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), r.Body))
+		var body map[string]any
+		if json.Unmarshal(raw, &body) == nil {
+			for k, v := range body {
+				params[k] = v
+			}
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush passes through so streaming handlers (GET /events) work behind
+// the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tokenAuth() *apiAuth {
+	return &apiAuth{tokens: []apiToken{
+		{Name: "crawler", Token: "admin-secret", scope: scopeAdmin},
+		{Name: "leak-detector", Token: "read-secret", scope: scopeRead},
+	}}
+}
+
+func readyAPI(auth *apiAuth, sink AuditSink, trigger RotateTrigger) controlAPI {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+	if trigger == nil {
+		trigger = func(RotateRequest) error { return nil }
+	}
+	return controlAPI{state: st, trigger: trigger, auth: auth, audit: sink}
+}
+
+func call(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// With no credentials configured the API stays as open as it always was.
+func TestRoutes_AuthDisabledIsOpen(t *testing.T) {
+	h := readyAPI(nil, nil, nil).routes()
+	if rr := call(h, http.MethodGet, "/status", ""); rr.Code != http.StatusOK {
+		t.Errorf("/status: got %d, want 200", rr.Code)
+	}
+	if rr := call(h, http.MethodPost, "/rotate", ""); rr.Code != http.StatusAccepted {
+		t.Errorf("/rotate: got %d, want 202", rr.Code)
+	}
+}
+
+func TestRoutes_TokenScopes(t *testing.T) {
+	h := readyAPI(tokenAuth(), nil, nil).routes()
+	for _, tc := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/livez", "", http.StatusOK},
+		{http.MethodGet, "/readyz", "", http.StatusOK},
+		{http.MethodGet, "/status", "", http.StatusUnauthorized},
+		{http.MethodGet, "/status", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/status", "read-secret", http.StatusOK},
+		{http.MethodPost, "/rotate", "", http.StatusUnauthorized},
+		{http.MethodPost, "/rotate", "read-secret", http.StatusForbidden},
+		{http.MethodPost, "/rotate", "admin-secret", http.StatusAccepted},
+	} {
+		rr := call(h, tc.method, tc.path, tc.token)
+		if rr.Code != tc.want {
+			t.Errorf("%s %s token=%q: got %d, want %d", tc.method, tc.path, tc.token, rr.Code, tc.want)
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: 401 without a WWW-Authenticate challenge", tc.method, tc.path)
+		}
+	}
+}
+
+// Every /rotate call — allowed or refused — is audited with caller,
+// endpoint, parameters and result, and the rotation it triggers carries
+// the entry.
+func TestRoutes_RotateIsAudited(t *testing.T) {
+	var mu sync.Mutex
+	var entries []AuditEntry
+	sink := func(e AuditEntry) {
+		mu.Lock()
+		entries = append(entries, e)
+		mu.Unlock()
+	}
+	got := make(chan RotateRequest, 1)
+	h := readyAPI(tokenAuth(), sink, func(req RotateRequest) error {
+		got <- req
+		return nil
+	}).routes()
+
+	call(h, http.MethodPost, "/rotate?country=Germany", "read-secret")
+	req := httptest.NewRequest(http.MethodPost, "/rotate?country=Germany",
+		strings.NewReader(`{"avoid_exit_ips":["9.9.9.9"]}`))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(entries) != 2 {
+		t.Fatalf("audit entries=%d, want 2", len(entries))
+	}
+	denied, allowed := entries[0], entries[1]
+	if denied.Caller != "leak-detector" || !strings.HasPrefix(denied.Result, "403") {
+		t.Errorf("denied entry=%+v, want caller leak-detector result 403", denied)
+	}
+	if allowed.Caller != "crawler" || allowed.AuthMethod != "token" || allowed.Endpoint != "POST /rotate" {
+		t.Errorf("allowed entry=%+v, want crawler via token on POST /rotate", allowed)
+	}
+	if allowed.Params["country"] != "Germany" || allowed.Params["avoid_exit_ips"] == nil {
+		t.Errorf("params=%v, want query and body parameters", allowed.Params)
+	}
+	if !strings.HasPrefix(allowed.Result, "202") {
+		t.Errorf("result=%q, want 202", allowed.Result)
+	}
+
+	select {
+	case r := <-got:
+		if r.Audit == nil || r.Audit.Caller != "crawler" {
+			t.Errorf("rotation request audit=%+v, want the crawler's entry", r.Audit)
+		}
+		if r.AvoidExitIPs[0] != "9.9.9.9" {
+			t.Errorf("body not restored for the handler: avoid_exit_ips=%v", r.AvoidExitIPs)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("rotation trigger was not invoked")
+	}
+}
+
+func TestStateTracker_RotationRecordCarriesAudit(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordRotation("1.1.1.1", "2.2.2.2", "success", time.Second)
+	st.RecordRotationAudit(&AuditEntry{Caller: "crawler", Endpoint: "POST /rotate"})
+
+	raw, _ := json.Marshal(st.Snapshot())
+	var snap struct {
+		LastRotation struct {
+			RequestedBy AuditEntry `json:"requested_by"`
+		} `json:"last_rotation"`
+	}
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.LastRotation.RequestedBy.Caller != "crawler" {
+		t.Errorf("last_rotation.requested_by=%+v, want crawler", snap.LastRotation.RequestedBy)
+	}
+}
+
+func TestLoadAPIAuthFromEnv(t *testing.T) {
+	t.Run("unset is disabled", func(t *testing.T) {
+		a, err := loadAPIAuthFromEnv()
+		if err != nil || a.enabled() {
+			t.Fatalf("got enabled=%t err=%v, want disabled", a.enabled(), err)
+		}
+	})
+	t.Run("tokens", func(t *testing.T) {
+		t.Setenv(envAPITokens, `[{"name":"crawler","token":"s","scope":"admin"}]`)
+		a, err := loadAPIAuthFromEnv()
+		if err != nil || !a.enabled() || a.tokens[0].scope != scopeAdmin {
+			t.Fatalf("got %+v err=%v, want one admin token", a, err)
+		}
+	})
+	for name, env := range map[string]map[string]string{
+		"bad json":       {envAPITokens: `{"name":"x"}`},
+		"bad scope":      {envAPITokens: `[{"name":"x","token":"s","scope":"root"}]`},
+		"missing token":  {envAPITokens: `[{"name":"x","scope":"read"}]`},
+		"half key pair":  {envAPITLSCertFile: "/tmp/cert.pem"},
+		"ca without tls": {envAPIClientCAFile: "/tmp/ca.pem"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := loadAPIAuthFromEnv(); err == nil {
+				t.Fatal("want a configuration error")
+			}
+		})
+	}
+}
+
+// A verified client certificate authenticates by CN, with its scope from
+// TUNDLER_API_CLIENT_SCOPES and read as the default.
+func TestRoutes_ClientCertificateScopes(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	auth := &apiAuth{
+		clientCA:   true,
+		certScopes: map[string]apiScope{"crawler": scopeAdmin},
+	}
+
+	srv := httptest.NewUnstartedServer(readyAPI(auth, nil, nil).routes())
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := func(cn string) *http.Client {
+		c := srv.Client()
+		tr := c.Transport.(*http.Transport).Clone()
+		if cn != "" {
+			tr.TLSClientConfig.Certificates = []tls.Certificate{newTestLeaf(t, ca, caKey, cn)}
+		}
+		c.Transport = tr
+		return c
+	}
+	for _, tc := range []struct {
+		cn   string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"dashboard", http.StatusForbidden},
+		{"crawler", http.StatusAccepted},
+	} {
+		resp, err := client(tc.cn).Post(srv.URL+"/rotate", "", nil)
+		if err != nil {
+			t.Fatalf("cn=%q: %v", tc.cn, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("cn=%q: got %d, want %d", tc.cn, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestLeaf(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/provider"
+)
+
+// LocationStats counts the connect outcomes observed for one provider
+// location since boot. A connect whose contract check fails counts as a
+// failure: the tunnel came up but was unusable.
+type LocationStats struct {
+	ConnectSuccesses int    `json:"connect_successes"`
+	ConnectFailures  int    `json:"connect_failures"`
+	LastSuccessAt    string `json:"last_success_at,omitempty"`
+	LastFailureAt    string `json:"last_failure_at,omitempty"`
+	LastError        string `json:"last_error,omitempty"`
+}
+
+// RecordLocationOutcome counts one connect attempt to location; err nil
+// means the tunnel came up and passed the contract check. took is how
+// long the provider's Connect ran. The outcome also feeds the location's
+// health score (locationhealth.go).
+func (s *StateTracker) RecordLocationOutcome(location string, took time.Duration, err error) {
+	defer s.recordLocationHealth(location, locationOutcome(err), took)
+	now := time.Now().UTC().Format(time.RFC3339)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locationStats == nil {
+		s.locationStats = make(map[string]*LocationStats)
+	}
+	st := s.locationStats[location]
+	if st == nil {
+		st = &LocationStats{}
+		s.locationStats[location] = st
+	}
+	if err == nil {
+		st.ConnectSuccesses++
+		st.LastSuccessAt = now
+		return
+	}
+	st.ConnectFailures++
+	st.LastFailureAt = now
+	st.LastError = err.Error()
+	if len(st.LastError) > 256 {
+		st.LastError = st.LastError[:256]
+	}
+}
+
+// LocationStats returns a copy of the per-location connect counters.
+func (s *StateTracker) LocationStats() map[string]LocationStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]LocationStats, len(s.locationStats))
+	for loc, st := range s.locationStats {
+		out[loc] = *st
+	}
+	return out
+}
+
+// CatalogEntry is one location in GET /locations.
+// Name is the provider-native string; Country (ISO-3166), City, Server
+// and Features describe it (provider.Describe).
+type CatalogEntry struct {
+	Name            string   `json:"name"`
+	Country         string   `json:"country,omitempty"`
+	City            string   `json:"city,omitempty"`
+	Server          string   `json:"server,omitempty"`
+	Features        []string `json:"features,omitempty"`
+	Excluded        bool     `json:"excluded"`
+	ExclusionReason string   `json:"exclusion_reason,omitempty"`
+	LocationStats
+}
+
+// CatalogView is the GET /locations body.
+type CatalogView struct {
+	Provider string `json:"provider"`
+	// FetchedAt / CacheAgeSeconds describe the cached catalog; absent
+	// until the provider has returned a non-empty list.
+	FetchedAt       string         `json:"fetched_at,omitempty"`
+	CacheAgeSeconds *int           `json:"cache_age_seconds,omitempty"`
+	CacheTTLSeconds int            `json:"cache_ttl_seconds"`
+	Total           int            `json:"total"`
+	Allowed         int            `json:"allowed"`
+	Locations       []CatalogEntry `json:"locations"`
+	// UnmatchedInclusions / UnmatchedExclusions are INCLUDED_LOCATIONS /
+	// EXCLUDED_LOCATIONS entries selecting no location in the catalog —
+	// usually a typo or a provider rename.
+	UnmatchedInclusions []string `json:"unmatched_inclusions,omitempty"`
+	UnmatchedExclusions []string `json:"unmatched_exclusions,omitempty"`
+	// Unlisted holds counters for locations that have since dropped out
+	// of the catalog.
+	Unlisted map[string]LocationStats `json:"unlisted,omitempty"`
+}
+
+// buildCatalogView joins the cached catalog with the location filter and
+// the per-location counters.
+func buildCatalogView(state *StateTracker, catalog *cachedLocationsProvider, filter *locationFilter) CatalogView {
+	locations, fetchedAt := catalog.Cached()
+	stats := state.LocationStats()
+	view := CatalogView{
+		Provider:        state.Snapshot().Provider,
+		CacheTTLSeconds: int(catalog.ttl.Seconds()),
+		Total:           len(locations),
+		Locations:       make([]CatalogEntry, 0, len(locations)),
+	}
+	if !fetchedAt.IsZero() {
+		view.FetchedAt = fetchedAt.UTC().Format(time.RFC3339)
+		age := int(catalog.now().Sub(fetchedAt).Round(time.Second).Seconds())
+		view.CacheAgeSeconds = &age
+	}
+
+	filter = filter.resolving(state.locationCountry)
+	described := make(map[string]provider.Location)
+	for _, l := range catalog.Described() {
+		described[l.Name] = l
+	}
+	listed := make(map[string]bool, len(locations))
+	for _, loc := range locations {
+		listed[loc] = true
+		d := described[loc]
+		entry := CatalogEntry{Name: loc, Country: d.Country, City: d.City, Server: d.Server, Features: d.Features, LocationStats: stats[loc]}
+		if reason := filter.reason(loc); reason != "" {
+			entry.Excluded, entry.ExclusionReason = true, reason
+		} else {
+			view.Allowed++
+		}
+		view.Locations = append(view.Locations, entry)
+	}
+	view.UnmatchedInclusions = filter.unmatched(filter.include, locations)
+	view.UnmatchedExclusions = filter.unmatched(filter.exclude, locations)
+	for loc, st := range stats {
+		if !listed[loc] {
+			if view.Unlisted == nil {
+				view.Unlisted = make(map[string]LocationStats)
+			}
+			view.Unlisted[loc] = st
+		}
+	}
+	sort.Slice(view.Locations, func(i, j int) bool { return view.Locations[i].Name < view.Locations[j].Name })
+	return view
+}
+
+// locationsHandler implements GET /locations. filter returns the live
+// location filter.
+func locationsHandler(state *StateTracker, catalog *cachedLocationsProvider, filter func() *locationFilter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if catalog == nil {
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/catalog-unavailable",
+				Title:  "Location catalog unavailable",
+				Status: http.StatusServiceUnavailable,
+				Detail: "the provider catalog is not wired up yet",
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(buildCatalogView(state, catalog, filter()))
+	}
+}
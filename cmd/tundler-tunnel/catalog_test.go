@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnectWithRetry_RecordsLocationOutcomes(t *testing.T) {
+	sp := newScriptedProvider([]string{"USA"}, []bool{false, true}, []string{"", "9.9.9.9"})
+	st := NewStateTracker("scripted")
+	// Two single-attempt batches: the first connect fails, the second lands.
+	_ = connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 1, (&noSleep{}).sleep, "")
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 1, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatal(err)
+	}
+	got := st.LocationStats()["USA"]
+	if got.ConnectSuccesses != 1 || got.ConnectFailures != 1 || got.LastError == "" {
+		t.Errorf("USA stats=%+v, want 1 success, 1 failure with an error", got)
+	}
+}
+
+func TestLocationsHandler(t *testing.T) {
+	under := newScripted(func(int) []string { return []string{"USA", "Bahrain", "Germany"} })
+	now := time.Unix(1000, 0)
+	catalog := newCachedLocationsProvider(under, time.Minute)
+	catalog.now = func() time.Time { return now }
+	st := NewStateTracker("fake")
+
+	// Before any fetch the catalog is empty and carries no age.
+	rr := httptest.NewRecorder()
+	locationsHandler(st, catalog, func() *locationFilter { return nil })(rr, httptest.NewRequest(http.MethodGet, "/locations", nil))
+	var view CatalogView
+	if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Total != 0 || view.CacheAgeSeconds != nil {
+		t.Errorf("cold view=%+v, want an empty catalog with no age", view)
+	}
+
+	catalog.Locations(context.Background())
+	now = now.Add(42 * time.Second)
+	st.RecordLocationOutcome("Germany", time.Second, nil)
+	st.RecordLocationOutcome("Atlantis", time.Second, nil)
+
+	rr = httptest.NewRecorder()
+	locationsHandler(st, catalog, func() *locationFilter { return newLocationFilter(nil, []string{"Bahrain", "Yemen"}) })(rr, httptest.NewRequest(http.MethodGet, "/locations", nil))
+	view = CatalogView{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Total != 3 || view.Allowed != 2 {
+		t.Errorf("total=%d allowed=%d, want 3 and 2", view.Total, view.Allowed)
+	}
+	if view.CacheAgeSeconds == nil || *view.CacheAgeSeconds != 42 || view.CacheTTLSeconds != 60 {
+		t.Errorf("cache age=%v ttl=%d, want 42 and 60", view.CacheAgeSeconds, view.CacheTTLSeconds)
+	}
+	byName := map[string]CatalogEntry{}
+	for _, e := range view.Locations {
+		byName[e.Name] = e
+	}
+	if b := byName["Bahrain"]; !b.Excluded || b.ExclusionReason == "" {
+		t.Errorf("Bahrain=%+v, want excluded with a reason", b)
+	}
+	if g := byName["Germany"]; g.Excluded || g.ConnectSuccesses != 1 {
+		t.Errorf("Germany=%+v, want allowed with 1 success", g)
+	}
+	if len(view.UnmatchedExclusions) != 1 || view.UnmatchedExclusions[0] != "Yemen" {
+		t.Errorf("unmatched=%v, want [Yemen]", view.UnmatchedExclusions)
+	}
+	if _, ok := view.Unlisted["Atlantis"]; !ok {
+		t.Errorf("unlisted=%v, want Atlantis's counters", view.Unlisted)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// envConfigFile names an optional JSON file (typically a mounted
+// ConfigMap) overlaid on the env-derived runtime config at boot and
+// re-read on SIGHUP.
+const envConfigFile = "TUNDLER_CONFIG_FILE"
+
+// seconds is a time.Duration that reads and writes JSON as a number of
+// seconds, matching the *_SECONDS env knobs.
+type seconds time.Duration
+
+func (s seconds) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(s).Seconds())
+}
+
+func (s *seconds) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("want a number of seconds, got %s", b)
+	}
+	*s = seconds(f * float64(time.Second))
+	return nil
+}
+
+func (s seconds) d() time.Duration { return time.Duration(s) }
+
+// RuntimeConfig holds the knobs that can change without a restart. Boot
+// values come from the env (see runtimeConfigFromEnv), then
+// TUNDLER_CONFIG_FILE; PUT /config and SIGHUP replace them live. Each
+// consumer re-reads its fields on every use or on liveConfig.Changed.
+type RuntimeConfig struct {
+	// Rotation window; 0/0 disables scheduled rotation (/rotate still
+	// works). Rotator.
+	MinRotation seconds `json:"min_rotation_seconds"`
+	MaxRotation seconds `json:"max_rotation_seconds"`
+	// IncludedLocations, when set, limit every location pick to the
+	// locations they select; ExcludedLocations are filtered out of it.
+	// Both hold selectors: names, countries, region groups, globs or
+	// regexes (locationfilter.go).
+	IncludedLocations []string `json:"included_locations"`
+	ExcludedLocations []string `json:"excluded_locations"`
+	// LocationWeights weighs locations (or whole countries) for the
+	// weighted location strategy; unlisted ones weigh 1 (strategy.go).
+	LocationWeights map[string]int `json:"location_weights"`
+	// WatchdogInterval is the watchdog's tick period.
+	WatchdogInterval seconds `json:"watchdog_interval_seconds"`
+	// WedgeGuardThreshold is the non-Ready window before os.Exit(1).
+	WedgeGuardThreshold seconds `json:"wedge_guard_threshold_seconds"`
+	// RecycleAfter is the (jittered) max container lifetime; 0 = off.
+	RecycleAfter seconds `json:"recycle_after_seconds"`
+	// RecycleAfterRotations makes the rotator recycle the container
+	// INSTEAD of performing the next SCHEDULED relocation once the pod
+	// has done that many. A recycle already yields a fresh exit IP, so
+	// it cleanly replaces a relocation while also refreshing the image +
+	// env. Only scheduled relocations count — crawler /rotate (throttle
+	// recovery) must not trigger recycles. 0 = off.
+	RecycleAfterRotations int `json:"recycle_after_rotations"`
+	// ExitReprobeInterval is the (jittered) period of the background
+	// exit-IP re-verification while Ready; 0 = off (exitprobe.go).
+	ExitReprobeInterval seconds `json:"exit_reprobe_interval_seconds"`
+	// RecentExitIPs is how many of the pod's last exits a rotation
+	// refuses to land on again, matched on the RecentExitPrefix* subnet;
+	// 0 = off (exitreuse.go).
+	RecentExitIPs      int `json:"recent_exit_ips"`
+	RecentExitPrefixV4 int `json:"recent_exit_prefix_v4"`
+	RecentExitPrefixV6 int `json:"recent_exit_prefix_v6"`
+	// ExitGeoPolicy is what a rotation does with an exit geolocating
+	// outside its location's country: flag or reject (exitgeo.go).
+	ExitGeoPolicy string `json:"exit_geo_policy"`
+	// ExitASNPolicy is what a rotation does with an exit in a
+	// HOSTING_ASNS network: flag or reject (exitasn.go).
+	ExitASNPolicy string `json:"exit_asn_policy"`
+}
+
+// Floors for the loop knobs. A sub-second watchdog hammers the provider
+// CLI, and a wedge guard shorter than a login plus connect would exit a
+// pod that is merely booting. vars so tests can dial them down.
+var (
+	minWatchdogInterval    = time.Second
+	minWedgeGuardThreshold = time.Minute
+)
+
+func (c RuntimeConfig) rotationEnabled() bool {
+	return c.MinRotation > 0 || c.MaxRotation > 0
+}
+
+// validate reports every problem at once so an operator fixes a bad
+// config in one round trip. It also trims the location lists.
+func (c *RuntimeConfig) validate() error {
+	var errs []error
+	for _, f := range []struct {
+		name string
+		v    seconds
+	}{
+		{"min_rotation_seconds", c.MinRotation},
+		{"max_rotation_seconds", c.MaxRotation},
+		{"recycle_after_seconds", c.RecycleAfter},
+		{"exit_reprobe_interval_seconds", c.ExitReprobeInterval},
+	} {
+		if f.v < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", f.name))
+		}
+	}
+	if c.MaxRotation < c.MinRotation {
+		errs = append(errs, fmt.Errorf("max_rotation_seconds (%s) is below min_rotation_seconds (%s)",
+			c.MaxRotation.d(), c.MinRotation.d()))
+	}
+	if c.WatchdogInterval.d() < minWatchdogInterval {
+		errs = append(errs, fmt.Errorf("watchdog_interval_seconds must be at least %s", minWatchdogInterval))
+	}
+	if c.WedgeGuardThreshold.d() < minWedgeGuardThreshold {
+		errs = append(errs, fmt.Errorf("wedge_guard_threshold_seconds must be at least %s", minWedgeGuardThreshold))
+	} else if c.WedgeGuardThreshold <= c.WatchdogInterval {
+		errs = append(errs, fmt.Errorf("wedge_guard_threshold_seconds (%s) must exceed watchdog_interval_seconds (%s)",
+			c.WedgeGuardThreshold.d(), c.WatchdogInterval.d()))
+	}
+	if c.RecycleAfterRotations < 0 {
+		errs = append(errs, errors.New("recycle_after_rotations must not be negative"))
+	}
+	if c.RecentExitIPs < 0 || c.RecentExitIPs > recentExitIPsKept {
+		errs = append(errs, fmt.Errorf("recent_exit_ips must be within 0..%d", recentExitIPsKept))
+	}
+	if c.RecentExitPrefixV4 < 1 || c.RecentExitPrefixV4 > 32 {
+		errs = append(errs, errors.New("recent_exit_prefix_v4 must be within 1..32"))
+	}
+	if c.RecentExitPrefixV6 < 1 || c.RecentExitPrefixV6 > 128 {
+		errs = append(errs, errors.New("recent_exit_prefix_v6 must be within 1..128"))
+	}
+	if c.ExitGeoPolicy != policyFlag && c.ExitGeoPolicy != policyReject {
+		errs = append(errs, fmt.Errorf("exit_geo_policy %q: want %s or %s", c.ExitGeoPolicy, policyFlag, policyReject))
+	}
+	if c.ExitASNPolicy != policyFlag && c.ExitASNPolicy != policyReject {
+		errs = append(errs, fmt.Errorf("exit_asn_policy %q: want %s or %s", c.ExitASNPolicy, policyFlag, policyReject))
+	}
+	for loc, w := range c.LocationWeights {
+		if strings.TrimSpace(loc) == "" || w < 0 || w > maxLocationWeight {
+			errs = append(errs, fmt.Errorf("location_weights[%q]=%d: want a location and a weight within 0..%d", loc, w, maxLocationWeight))
+		}
+	}
+	errs = append(errs, validateLocationSelectors("included_locations", c.IncludedLocations)...)
+	errs = append(errs, validateLocationSelectors("excluded_locations", c.ExcludedLocations)...)
+	c.IncludedLocations = trimLocations(c.IncludedLocations)
+	c.ExcludedLocations = trimLocations(c.ExcludedLocations)
+	return errors.Join(errs...)
+}
+
+// runtimeConfigFromEnv reads the boot values. Like getEnvInt it is
+// forgiving in one place only: an inverted rotation window is clamped
+// (max=min) and logged, as it always was.
+func runtimeConfigFromEnv() RuntimeConfig {
+	secs := func(name string, def int) seconds {
+		return seconds(time.Duration(getEnvInt(name, def)) * time.Second)
+	}
+	c := RuntimeConfig{
+		MinRotation:           secs(envMinRotationSec, defaultMinRotationSec),
+		MaxRotation:           secs(envMaxRotationSec, defaultMaxRotationSec),
+		IncludedLocations:     parseExcludedLocations(os.Getenv(envIncludedLocations)),
+		ExcludedLocations:     parseExcludedLocations(os.Getenv(envExcludedLocations)),
+		WatchdogInterval:      secs(envWatchdogIntervalSec, defaultWatchdogIntervSec),
+		WedgeGuardThreshold:   secs(envWedgeGuardSec, defaultWedgeGuardSec),
+		RecycleAfter:          secs(envRecycleAfterSec, 0),
+		RecycleAfterRotations: getEnvInt(envRecycleAfterRot, 0),
+		ExitReprobeInterval:   secs(envExitReprobeSec, defaultExitReprobeSec),
+		RecentExitIPs:         getEnvInt(envRecentExitIPs, defaultRecentExitIPs),
+		RecentExitPrefixV4:    getEnvInt(envRecentExitPrefixV4, defaultRecentExitPrefixV4),
+		RecentExitPrefixV6:    getEnvInt(envRecentExitPrefixV6, defaultRecentExitPrefixV6),
+		ExitGeoPolicy:         os.Getenv(envExitGeoPolicy),
+		ExitASNPolicy:         os.Getenv(envExitASNPolicy),
+	}
+	weights, err := parseLocationWeights(os.Getenv(envLocationWeights))
+	if err != nil {
+		log.Fatalf("tundler-tunnel: %s: %v", envLocationWeights, err)
+	}
+	c.LocationWeights = weights
+	for env, policy := range map[string]*string{envExitGeoPolicy: &c.ExitGeoPolicy, envExitASNPolicy: &c.ExitASNPolicy} {
+		switch *policy {
+		case "":
+			*policy = policyFlag
+		case policyFlag, policyReject:
+		default:
+			log.Fatalf("tundler-tunnel: %s=%q: want %s or %s", env, *policy, policyFlag, policyReject)
+		}
+	}
+	if c.MaxRotation < c.MinRotation {
+		log.Printf("tundler-tunnel: MAX_ROTATION_SECONDS (%s) < MIN_ROTATION_SECONDS (%s); clamping max=min",
+			c.MaxRotation.d(), c.MinRotation.d())
+		c.MaxRotation = c.MinRotation
+	}
+	return c
+}
+
+// overlayConfig decodes a JSON object over base: fields it names replace
+// base's, the rest are kept. Unknown fields are an error so a typo in a
+// ConfigMap doesn't silently do nothing. The result is validated.
+func overlayConfig(base RuntimeConfig, r io.Reader) (RuntimeConfig, error) {
+	next := base
+	next.IncludedLocations = append([]string(nil), base.IncludedLocations...)
+	next.ExcludedLocations = append([]string(nil), base.ExcludedLocations...)
+	// Decoding into base's map would merge; a named location_weights
+	// replaces it ({} clears it).
+	next.LocationWeights = nil
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&next); err != nil {
+		return base, fmt.Errorf("decode: %w", err)
+	}
+	if next.LocationWeights == nil {
+		next.LocationWeights = base.LocationWeights
+	}
+	if err := next.validate(); err != nil {
+		return base, err
+	}
+	return next, nil
+}
+
+// loadConfigFile overlays the file at path on base. An empty path
+// returns base unchanged.
+func loadConfigFile(base RuntimeConfig, path string) (RuntimeConfig, error) {
+	if path == "" {
+		return base, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return base, err
+	}
+	c, err := overlayConfig(base, bytes.NewReader(raw))
+	if err != nil {
+		return base, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// liveConfig is the running RuntimeConfig. Readers call Get on every
+// use; long-lived loops that cache a value (timers, tickers) also select
+// on Changed to re-arm.
+type liveConfig struct {
+	mu        sync.RWMutex
+	cur       RuntimeConfig
+	source    string // "env", "file", "api"
+	updatedAt time.Time
+	changed   chan struct{} // closed and replaced on every Apply
+}
+
+func newLiveConfig(c RuntimeConfig, source string) *liveConfig {
+	return &liveConfig{cur: c, source: source, updatedAt: time.Now().UTC(), changed: make(chan struct{})}
+}
+
+// Get returns a copy of the current config.
+func (l *liveConfig) Get() RuntimeConfig {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	c := l.cur
+	c.IncludedLocations = append([]string(nil), l.cur.IncludedLocations...)
+	c.ExcludedLocations = append([]string(nil), l.cur.ExcludedLocations...)
+	return c
+}
+
+// Changed returns a channel closed at the next Apply.
+func (l *liveConfig) Changed() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.changed
+}
+
+// Apply validates c and makes it current.
+func (l *liveConfig) Apply(c RuntimeConfig, source string) error {
+	return l.Update(func(RuntimeConfig) (RuntimeConfig, error) { return c, nil }, source)
+}
+
+// Update makes fn's result current, validated. fn gets a copy of the
+// current config and runs under the write lock, so read-modify-write
+// callers (PUT /config overlays) can't lose each other's changes. fn
+// must not call back into l.
+func (l *liveConfig) Update(fn func(RuntimeConfig) (RuntimeConfig, error), source string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur := l.cur
+	cur.IncludedLocations = append([]string(nil), l.cur.IncludedLocations...)
+	cur.ExcludedLocations = append([]string(nil), l.cur.ExcludedLocations...)
+	c, err := fn(cur)
+	if err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	l.cur = c
+	l.source = source
+	l.updatedAt = time.Now().UTC()
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+// ConfigView is the effective config as /status and GET /config show it.
+type ConfigView struct {
+	RuntimeConfig
+	Source    string `json:"source"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func (l *liveConfig) View() ConfigView {
+	c := l.Get()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return ConfigView{RuntimeConfig: c, Source: l.source, UpdatedAt: l.updatedAt.Format(time.RFC3339)}
+}
+
+// applyConfig applies next and announces it on the event stream.
+func applyConfig(cfg *liveConfig, state *StateTracker, next RuntimeConfig, source string) error {
+	return updateConfig(cfg, state, func(RuntimeConfig) (RuntimeConfig, error) { return next, nil }, source)
+}
+
+// updateConfig is applyConfig for a change computed from the current
+// config (see liveConfig.Update).
+func updateConfig(cfg *liveConfig, state *StateTracker, fn func(RuntimeConfig) (RuntimeConfig, error), source string) error {
+	if err := cfg.Update(fn, source); err != nil {
+		return err
+	}
+	v := cfg.View()
+	log.Printf("tundler-tunnel: config applied from %s: %+v", source, v.RuntimeConfig)
+	state.Publish(eventConfigChanged, map[string]any{"source": source, "config": v.RuntimeConfig})
+	return nil
+}
+
+// watchConfigReload re-reads TUNDLER_CONFIG_FILE over the env baseline
+// on every SIGHUP. Values set through PUT /config since the last reload
+// are replaced: the file is the source of truth. A bad file is logged
+// and the running config kept.
+func watchConfigReload(ctx context.Context, cfg *liveConfig, state *StateTracker, base RuntimeConfig, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if path == "" {
+				log.Printf("tundler-tunnel: SIGHUP ignored; %s is not set", envConfigFile)
+				continue
+			}
+			next, err := loadConfigFile(base, path)
+			if err == nil {
+				err = applyConfig(cfg, state, next, "file")
+			}
+			if err != nil {
+				log.Printf("tundler-tunnel: config reload failed, keeping current config: %v", err)
+			}
+		}
+	}
+}
+
+// getConfigHandler implements GET /config.
+func getConfigHandler(cfg *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(cfg.View())
+	}
+}
+
+// putConfigHandler implements PUT /config. The body is a JSON object of
+// RuntimeConfig fields; omitted fields keep their current value. The
+// whole result is validated before anything is applied: 200 with the
+// effective config, or 422 listing every problem.
+func putConfigHandler(cfg *liveConfig, state *StateTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err == nil {
+			// Overlay under the config lock: two PUTs naming different
+			// fields both land.
+			err = updateConfig(cfg, state, func(cur RuntimeConfig) (RuntimeConfig, error) {
+				return overlayConfig(cur, bytes.NewReader(body))
+			}, "api")
+		}
+		if err != nil {
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/invalid-config",
+				Title:  "Invalid configuration",
+				Status: http.StatusUnprocessableEntity,
+				Detail: strings.ReplaceAll(err.Error(), "\n", "; "),
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(cfg.View())
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
+)
+
+// defaultRuntimeConfig is the env defaults, for tests.
+func defaultRuntimeConfig() RuntimeConfig {
+	return RuntimeConfig{
+		MinRotation:         seconds(defaultMinRotationSec * time.Second),
+		MaxRotation:         seconds(defaultMaxRotationSec * time.Second),
+		WatchdogInterval:    seconds(defaultWatchdogIntervSec * time.Second),
+		WedgeGuardThreshold: seconds(defaultWedgeGuardSec * time.Second),
+		ExitReprobeInterval: seconds(defaultExitReprobeSec * time.Second),
+		RecentExitIPs:       defaultRecentExitIPs,
+		RecentExitPrefixV4:  defaultRecentExitPrefixV4,
+		RecentExitPrefixV6:  defaultRecentExitPrefixV6,
+		ExitGeoPolicy:       policyFlag,
+		ExitASNPolicy:       policyFlag,
+	}
+}
+
+// watchdogConfig is a live config ticking the watchdog every interval.
+func watchdogConfig(interval time.Duration) *liveConfig {
+	c := defaultRuntimeConfig()
+	c.WatchdogInterval = seconds(interval)
+	return newLiveConfig(c, "env")
+}
+
+func TestRuntimeConfigFromEnv(t *testing.T) {
+	t.Setenv(envMinRotationSec, "600")
+	t.Setenv(envMaxRotationSec, "300")
+	t.Setenv(envExcludedLocations, "Bahrain, ,Yemen")
+	c := runtimeConfigFromEnv()
+	if c.MinRotation.d() != 10*time.Minute || c.MaxRotation.d() != 10*time.Minute {
+		t.Errorf("window=%s..%s, want an inverted env window clamped to 10m..10m", c.MinRotation.d(), c.MaxRotation.d())
+	}
+	if strings.Join(c.ExcludedLocations, ",") != "Bahrain,Yemen" {
+		t.Errorf("excluded=%v, want [Bahrain Yemen]", c.ExcludedLocations)
+	}
+}
+
+func TestOverlayConfig(t *testing.T) {
+	base := defaultRuntimeConfig()
+	got, err := overlayConfig(base, strings.NewReader(`{"max_rotation_seconds": 20000, "excluded_locations": [" Bahrain "]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MaxRotation.d() != 20000*time.Second || got.MinRotation != base.MinRotation {
+		t.Errorf("got %+v, want max overlaid and min kept", got)
+	}
+	if len(got.ExcludedLocations) != 1 || got.ExcludedLocations[0] != "Bahrain" {
+		t.Errorf("excluded=%q, want trimmed [Bahrain]", got.ExcludedLocations)
+	}
+
+	for name, body := range map[string]string{
+		"unknown field":            `{"min_rotation": 5}`,
+		"inverted window":          `{"min_rotation_seconds": 100, "max_rotation_seconds": 50}`,
+		"zero watchdog":            `{"watchdog_interval_seconds": 0}`,
+		"negative":                 `{"recycle_after_seconds": -1}`,
+		"not a number":             `{"wedge_guard_threshold_seconds": "15m"}`,
+		"spinning watchdog":        `{"watchdog_interval_seconds": 0.001}`,
+		"hair-trigger wedge guard": `{"wedge_guard_threshold_seconds": 1}`,
+		"wedge guard under a tick": `{"watchdog_interval_seconds": 600, "wedge_guard_threshold_seconds": 300}`,
+	} {
+		if _, err := overlayConfig(base, strings.NewReader(body)); err == nil {
+			t.Errorf("%s: want a validation error", name)
+		}
+	}
+}
+
+func TestConfigHandlers(t *testing.T) {
+	cfg := newLiveConfig(defaultRuntimeConfig(), "env")
+	api := readyAPI(nil, nil, nil)
+	api.config = cfg
+	h := api.routes()
+	changed := cfg.Changed()
+
+	req := httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(`{"excluded_locations":["Yemen"],"watchdog_interval_seconds":5}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT: got %d (%s), want 200", rr.Code, rr.Body)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("Changed() not signalled by a successful PUT")
+	}
+	if c := cfg.Get(); c.WatchdogInterval.d() != 5*time.Second || c.ExcludedLocations[0] != "Yemen" {
+		t.Errorf("config=%+v, want the PUT values live", c)
+	}
+
+	// A bad PUT changes nothing and lists the problems.
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(`{"watchdog_interval_seconds":0,"wedge_guard_threshold_seconds":0}`)))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "wedge_guard_threshold_seconds") {
+		t.Errorf("bad PUT: got %d %s, want 422 naming both fields", rr.Code, rr.Body)
+	}
+	if cfg.Get().WatchdogInterval.d() != 5*time.Second {
+		t.Error("a rejected PUT modified the config")
+	}
+
+	// /status reports the effective config and where it came from.
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var snap struct {
+		Config struct {
+			Source           string  `json:"source"`
+			WatchdogInterval float64 `json:"watchdog_interval_seconds"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Config.Source != "api" || snap.Config.WatchdogInterval != 5 {
+		t.Errorf("/status config=%+v, want source api, watchdog 5", snap.Config)
+	}
+}
+
+// Concurrent PUTs naming different fields all land.
+func TestPutConfig_ConcurrentOverlays(t *testing.T) {
+	cfg := newLiveConfig(defaultRuntimeConfig(), "env")
+	h := putConfigHandler(cfg, NewStateTracker("fake"))
+	bodies := []string{
+		`{"recycle_after_rotations": 3}`,
+		`{"recent_exit_ips": 4}`,
+		`{"excluded_locations": ["Yemen"]}`,
+		`{"watchdog_interval_seconds": 5}`,
+	}
+	var wg sync.WaitGroup
+	for _, body := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			h(rr, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(body)))
+			if rr.Code != http.StatusOK {
+				t.Errorf("PUT %s: got %d (%s)", body, rr.Code, rr.Body)
+			}
+		}()
+	}
+	wg.Wait()
+	c := cfg.Get()
+	if c.RecycleAfterRotations != 3 || c.RecentExitIPs != 4 || len(c.ExcludedLocations) != 1 || c.WatchdogInterval.d() != 5*time.Second {
+		t.Errorf("config=%+v, want every PUT applied", c)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"recycle_after_rotations": 3}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfigFile(defaultRuntimeConfig(), path)
+	if err != nil || c.RecycleAfterRotations != 3 {
+		t.Fatalf("got %+v err=%v, want recycle_after_rotations=3", c, err)
+	}
+	if _, err := loadConfigFile(defaultRuntimeConfig(), filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file: want an error")
+	}
+}
+
+// A shorter watchdog interval takes effect without restarting the loop.
+func TestRunWatchdog_PicksUpIntervalChange(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA"}, connectIP: "5.6.7.8", connectOK: true}
+	st := NewStateTracker("fake")
+	st.Set(StateFailed)
+	cfg := watchdogConfig(time.Hour)
+	prevMin := minWatchdogInterval
+	minWatchdogInterval = time.Millisecond
+	defer func() { minWatchdogInterval = prevMin }()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runWatchdog(ctx, fp, st, "fake", cfg, proxy.New("", "", ""), "")
+		close(done)
+	}()
+	defer func() { cancel(); <-done }()
+
+	next := cfg.Get()
+	next.WatchdogInterval = seconds(10 * time.Millisecond)
+	if err := cfg.Apply(next, "api"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for st.Get() != StateReady {
+		if time.Now().After(deadline) {
+			t.Fatalf("state=%s, want the watchdog to recover on the new interval", st.Get())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Enabling scheduled rotation on a running pod arms the rotator's timer.
+func TestRunRotator_ArmsOnWindowChange(t *testing.T) {
+	c := defaultRuntimeConfig()
+	c.MinRotation, c.MaxRotation = 0, 0
+	cfg := newLiveConfig(c, "env")
+	st := NewStateTracker("fake")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runRotator(ctx, &fakeProvider{}, st, "fake", cfg, nil, "")
+		close(done)
+	}()
+	defer func() { cancel(); <-done }()
+
+	c.MinRotation, c.MaxRotation = seconds(time.Hour), seconds(time.Hour)
+	if err := cfg.Apply(c, "api"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for st.Snapshot().NextRotationInSeconds == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rotator not armed after the window was enabled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := st.Snapshot().NextRotationInSeconds; got < 3590 || got > 3600 {
+		t.Errorf("next_rotation_in_seconds=%d, want ~3600", got)
+	}
+}
@@ -48,8 +48,8 @@ func ensureLoggedIn(ctx context.Context, prov provider.VPNProvider, providerName
 	return prov.Login(ctx)
 }
 
-// connectTunnel picks a random allowed location (provider.Locations()
-// minus `excluded`) and connects through it. On success, verifies the
+// connectTunnel picks an allowed location (provider.Locations() through
+// `filter`, see locationfilter.go) and connects through it. On success, verifies the
 // exit-IP contract (post-VPN egress IP differs from baselineEgressIP),
 // records the tunnel-up details into state, and transitions to
 // StateReady. On failure, returns an error; caller decides whether
@@ -64,36 +64,51 @@ func ensureLoggedIn(ctx context.Context, prov provider.VPNProvider, providerName
 //
 //	(any) → StateConnecting → (Connect call + exit-IP verify) → StateReady (success)
 //	                                                          → (return error) (failure; caller sets Failed)
-func connectTunnel(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, baselineEgressIP string) error {
+func connectTunnel(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, baselineEgressIP string) error {
 	state.Set(StateConnecting)
+	state.countConnectAttempt()
 	if err := ensureLoggedIn(ctx, prov, providerName); err != nil {
 		return err
 	}
 	available := prov.Locations(ctx)
-	location, err := pickLocation(available, excluded)
+	location, err := state.PickLocation(available, filter)
 	if err != nil {
-		return fmt.Errorf("pick location for provider=%s (%d available, %d excluded): %w",
-			providerName, len(available), len(excluded), err)
+		return fmt.Errorf("pick location for provider=%s (%d available, %d allowed): %w",
+			providerName, len(available), len(state.AllowedLocations(available, filter)), err)
 	}
 	log.Printf("tundler-tunnel: provider=%s connecting to location=%s", providerName, location)
+	started := time.Now()
 	status := prov.Connect(ctx, location)
+	took := time.Since(started)
 	if !status.Connected {
-		return fmt.Errorf("connect failed for provider=%s location=%s status=%+v",
+		err := fmt.Errorf("connect failed for provider=%s location=%s status=%+v",
 			providerName, location, status)
+		state.RecordLocationOutcome(location, took, err)
+		return err
 	}
 	observed, err := verifyExitIPDiffers(ctx, baselineEgressIP)
+	state.RecordContractProbe(observed, err)
+	exitIP := exitIPOrProbe(status.IP, observed)
+	if err == nil {
+		var qual *ExitQualification
+		qual, err = qualifyExit(ctx, exitIP)
+		state.RecordExitQualification(location, qual)
+	}
+	state.RecordLocationOutcome(location, took, err)
 	if err != nil {
 		// Tear the tunnel down so the pod doesn't sit in a half-up
-		// state where /status reports Connected but traffic leaks.
+		// state where /status reports Connected but traffic leaks
+		// or crawls.
 		// Best-effort: ignore Disconnect's error — we're already in
 		// a failure path, and the subsequent retry / fail-and-exit
 		// will fire either way.
 		_ = prov.Disconnect(ctx)
 		return fmt.Errorf("connect succeeded but %w (provider=%s location=%s)", err, providerName, location)
 	}
-	exitIP := exitIPOrProbe(status.IP, observed)
+	state.RecordExitGeo(location, checkExitGeo(state, location, exitIP))
+	state.RecordExitASN(lookupExitASN(exitIP))
 	state.RecordTunnelUp(location, exitIP)
-	state.Set(StateReady)
+	state.Transition(StateReady, "tunnel up at "+location)
 	log.Printf("tundler-tunnel: provider=%s tunnel up location=%s exit_ip=%s",
 		providerName, location, exitIP)
 	return nil
@@ -177,9 +192,10 @@ func loginInitialWithRetry(ctx context.Context, prov provider.VPNProvider, state
 //
 // backoff is injected so tests can drive it without real sleeps;
 // production passes bootConnectBackoff.
-func connectInitialWithRetry(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, baselineEgressIP string, backoff func(attempt int) time.Duration) error {
+func connectInitialWithRetry(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, baselineEgressIP string, backoff func(attempt int) time.Duration) error {
+	state.BeginConnect(triggerBoot)
 	for attempt := 1; ; attempt++ {
-		err := connectTunnel(ctx, prov, state, providerName, excluded, baselineEgressIP)
+		err := connectTunnel(ctx, prov, state, providerName, filter, baselineEgressIP)
 		if err == nil {
 			if attempt > 1 {
 				log.Printf("tundler-tunnel: initial connect succeeded on attempt %d (stayed up, no re-login)", attempt)
@@ -247,21 +263,30 @@ var errRotationExhausted = errors.New("rotation exhausted retry attempts")
 // (so a single rotation doesn't retry the same broken location twice).
 // Exponential backoff between attempts: 1s, 2s, 4s, 8s, ...
 //
+// req narrows the candidates to the caller's location / country, adds
+// its exclusions, and turns an exit IP in req.AvoidExitIPs, one reusing
+// a recent exit under req.RecentExits (exitreuse.go), one geolocating
+// outside its location's country under req.ExitGeo (exitgeo.go), or one
+// in a hosting ASN under req.ExitASN (exitasn.go), into a failed attempt.
+// So is an exit the kept one would be that fails qualification
+// (qualify.go); unlike a rejection it always burns the location.
+//
 // `sleep` is injected so tests can pass a no-op. Production passes
 // time.Sleep.
 //
 // Mirrors the design-doc pseudocode in "Failed-rotation handling":
 //
 //	for attempt in 1..ROTATION_RETRY_MAX:
-//	    location = pickRandom(provider.Locations() \ excluded \ recentlyFailed)
+//	    location = pickRandom(provider.Locations() \ filtered \ recentlyFailed)
 //	    if connect(location) succeeds: state = Ready; return
 //	    recentlyFailed.add(location)
 //	    sleep(backoff)
 //	// All attempts exhausted: caller transitions state = Failed.
-func connectWithRetry(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, maxAttempts int, sleep func(time.Duration), baselineEgressIP string) error {
+func connectWithRetry(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, req RotateRequest, maxAttempts int, sleep func(time.Duration), baselineEgressIP string) error {
 	var recentlyFailed []string
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		state.Set(StateConnecting)
+		state.countConnectAttempt()
 		if err := ensureLoggedIn(ctx, prov, providerName); err != nil {
 			log.Printf("tundler-tunnel: rotation attempt %d/%d: %v", attempt, maxAttempts, err)
 			if attempt < maxAttempts {
@@ -269,20 +294,22 @@ func connectWithRetry(ctx context.Context, prov provider.VPNProvider, state *Sta
 			}
 			continue
 		}
-		available := prov.Locations(ctx)
-		combined := append([]string(nil), excluded...)
-		combined = append(combined, recentlyFailed...)
-		location, err := pickLocation(available, combined)
+		available := req.candidates(prov.Locations(ctx), state.locationCountry)
+		location, err := state.PickLocation(available, filter.excluding(req.Exclude, recentlyFailed))
 		if err != nil {
-			// No more allowed locations — either all excluded by config,
+			// No more allowed locations — either all filtered out by config,
 			// or we've burned through them via recentlyFailed.
 			return fmt.Errorf("attempt %d/%d: %w", attempt, maxAttempts, err)
 		}
 		log.Printf("tundler-tunnel: rotation attempt %d/%d to location=%s", attempt, maxAttempts, location)
+		started := time.Now()
 		status := prov.Connect(ctx, location)
+		took := time.Since(started)
 		if status.Connected {
 			observed, err := verifyExitIPDiffers(ctx, baselineEgressIP)
+			state.RecordContractProbe(observed, err)
 			if err != nil {
+				state.RecordLocationOutcome(location, took, err)
 				// Leak detected: treat as a failed attempt so the
 				// rotator retries a different location (the failure
 				// might be location-specific routing) rather than
@@ -297,12 +324,66 @@ func connectWithRetry(ctx context.Context, prov provider.VPNProvider, state *Sta
 				continue
 			}
 			exitIP := exitIPOrProbe(status.IP, observed)
+			geo := checkExitGeo(state, location, exitIP)
+			asn := lookupExitASN(exitIP)
+			rejection := ExitRejection{ExitIP: exitIP, Location: location}
+			if req.avoids(exitIP) {
+				rejection.Reason = rejectAvoided
+				rejection.Detail = "caller asked to avoid it"
+			} else if elsewhere := req.ExitGeo.rejects(geo); elsewhere != "" {
+				rejection.Reason, rejection.Detail = rejectCountry, elsewhere
+			} else if listed := req.ExitASN.rejects(asn); listed != "" {
+				rejection.Reason, rejection.Detail = rejectASN, listed
+			} else if reuse := req.RecentExits.match(exitIP, state.RecentExitIPs()); reuse != "" {
+				if attempt < maxAttempts {
+					rejection.Reason, rejection.Detail = rejectRecent, reuse
+				} else {
+					log.Printf("tundler-tunnel: rotation attempt %d/%d keeping a recent exit on the last attempt: %s",
+						attempt, maxAttempts, reuse)
+				}
+			}
+			if rejection.Reason != "" {
+				// The caller has already seen this exit blocked, the pod
+				// just had it, or it is in the wrong country or network.
+				// Only burn the location while others remain: a pinned
+				// location usually hands out another server on retry.
+				state.RecordLocationOutcome(location, took, nil)
+				_ = prov.Disconnect(ctx)
+				if len(available) > 1 {
+					recentlyFailed = append(recentlyFailed, location)
+				}
+				state.RecordExitRejection(rejection)
+				log.Printf("tundler-tunnel: rotation attempt %d/%d rejected exit_ip=%s (%s: %s, location=%s)",
+					attempt, maxAttempts, exitIP, rejection.Reason, rejection.Detail, location)
+				if attempt < maxAttempts {
+					sleep(retryBackoff(attempt))
+				}
+				continue
+			}
+			qual, err := qualifyExit(ctx, exitIP)
+			state.RecordExitQualification(location, qual)
+			state.RecordLocationOutcome(location, took, err)
+			if err != nil {
+				// Up and honest, but too slow, lossy or MTU-broken to
+				// serve: a failed attempt for this location.
+				_ = prov.Disconnect(ctx)
+				recentlyFailed = append(recentlyFailed, location)
+				log.Printf("tundler-tunnel: rotation attempt %d/%d FAILED qualification (location=%s exit_ip=%s): %v",
+					attempt, maxAttempts, location, exitIP, err)
+				if attempt < maxAttempts {
+					sleep(retryBackoff(attempt))
+				}
+				continue
+			}
+			state.RecordExitGeo(location, geo)
+			state.RecordExitASN(asn)
 			state.RecordTunnelUp(location, exitIP)
-			state.Set(StateReady)
+			state.Transition(StateReady, "tunnel up at "+location)
 			log.Printf("tundler-tunnel: rotation attempt %d/%d succeeded location=%s exit_ip=%s",
 				attempt, maxAttempts, location, exitIP)
 			return nil
 		}
+		state.RecordLocationOutcome(location, took, fmt.Errorf("connect failed: status=%+v", status))
 		recentlyFailed = append(recentlyFailed, location)
 		log.Printf("tundler-tunnel: rotation attempt %d/%d failed (location=%s); will try another",
 			attempt, maxAttempts, location)
@@ -137,7 +137,7 @@ func TestConnectTunnel_Success(t *testing.T) {
 func TestConnectTunnel_NoAllowedLocations(t *testing.T) {
 	fp := &fakeProvider{locations: []string{"Bahrain"}}
 	st := NewStateTracker("fake")
-	err := connectTunnel(context.Background(), fp, st, "fake", []string{"Bahrain"}, "")
+	err := connectTunnel(context.Background(), fp, st, "fake", newLocationFilter(nil, []string{"Bahrain"}), "")
 	if err == nil {
 		t.Fatal("expected error from connectTunnel, got nil")
 	}
@@ -187,7 +187,7 @@ func TestWatchdog_DetectsDropAndReconnects(t *testing.T) {
 	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		runWatchdog(ctx, fp, st, "fake", nil, 20*time.Millisecond, proxySrv, "")
+		runWatchdog(ctx, fp, st, "fake", watchdogConfig(20*time.Millisecond), proxySrv, "")
 		close(done)
 	}()
 	// Wait until the watchdog has triggered at least one reconnect.
@@ -233,7 +233,7 @@ func TestWatchdog_SkipsWhenNotReady(t *testing.T) {
 	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		runWatchdog(ctx, fp, st, "fake", nil, 20*time.Millisecond, proxySrv, "")
+		runWatchdog(ctx, fp, st, "fake", watchdogConfig(20*time.Millisecond), proxySrv, "")
 		close(done)
 	}()
 	<-done
@@ -264,7 +264,7 @@ func TestWatchdog_StaysOutOfWayWhenProxyHealthy(t *testing.T) {
 	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		runWatchdog(ctx, fp, st, "fake", nil, 10*time.Millisecond, proxySrv, "")
+		runWatchdog(ctx, fp, st, "fake", watchdogConfig(10*time.Millisecond), proxySrv, "")
 		close(done)
 	}()
 	<-done
@@ -294,7 +294,7 @@ func TestWatchdog_AbstainsWhenProxySilent(t *testing.T) {
 	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		runWatchdog(ctx, fp, st, "fake", nil, 10*time.Millisecond, proxySrv, "")
+		runWatchdog(ctx, fp, st, "fake", watchdogConfig(10*time.Millisecond), proxySrv, "")
 		close(done)
 	}()
 	<-done
@@ -334,7 +334,7 @@ func TestWatchdog_ReconnectFailureMarksFailedAndRetries(t *testing.T) {
 	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		runWatchdog(ctx, fp, st, "fake", nil, 10*time.Millisecond, proxySrv, "")
+		runWatchdog(ctx, fp, st, "fake", watchdogConfig(10*time.Millisecond), proxySrv, "")
 		close(done)
 	}()
 
@@ -388,7 +388,7 @@ func TestWatchdog_RecoversFromFailed(t *testing.T) {
 	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		runWatchdog(ctx, fp, st, "fake", nil, 10*time.Millisecond, proxySrv, "")
+		runWatchdog(ctx, fp, st, "fake", watchdogConfig(10*time.Millisecond), proxySrv, "")
 		close(done)
 	}()
 
@@ -4,63 +4,78 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/laurentpellegrino/tundler/internal/proxy"
 )
 
 // drainController is the contract the rotator uses to bleed in-flight
-// CONNECT tunnels before tearing the VPN down. Two steps:
+// CONNECT tunnels and fetches before tearing the VPN down. Two steps:
 //
-//	TriggerGracefulDrain — flip the proxy into drain mode so new
-//	    CONNECTs get 503; existing tunnels keep going.
-//	WaitForActiveConnectionsToDrain — poll the proxy's open-tunnel
-//	    count until it reaches 0 or the hard timeout elapses.
+//	TriggerGracefulDrain — flip the proxies into drain mode so new
+//	    CONNECTs / fetches get 503; existing ones keep going.
+//	WaitForActiveConnectionsToDrain — poll the proxies' open-tunnel and
+//	    open-request counts until both reach 0 or the hard timeout elapses.
 //
 // Both steps run BEFORE the VPN daemon Disconnect, so requests don't
 // land on a half-torn-down tunnel.
 //
 // Injected via interface so production wires it to the in-process
-// proxy.Server while tests use a fake controller that records calls.
+// proxies while tests use a fake controller that records calls.
 type drainController interface {
 	TriggerGracefulDrain(ctx context.Context) error
 	WaitForActiveConnectionsToDrain(ctx context.Context, timeout time.Duration) error
 }
 
 // proxyDrainController is the production drainController, backed by
-// the in-process Go CONNECT proxy.
+// the in-process Go CONNECT proxy (:8485) and the browser-impersonating
+// fetch proxy (:8486). Both egress through the tunnel being torn down, so
+// both are drained together and the wait covers the sum of their open
+// tunnels / requests. imp may be nil (no fetch proxy running).
 type proxyDrainController struct {
 	srv *proxy.Server
+	imp *proxy.ImpersonateServer
+
+	// final is set by the shutdown drain: from then on drain mode is
+	// never cleared, whatever a concurrent rotation or park does.
+	final atomic.Bool
+	// held is set by a park and cleared once a tunnel is back up
+	// (releaseDrain): until then no wait or rotation clears drain mode.
+	held atomic.Bool
 }
 
-func newProxyDrainController(srv *proxy.Server) *proxyDrainController {
-	return &proxyDrainController{srv: srv}
+func newProxyDrainController(srv *proxy.Server, imp *proxy.ImpersonateServer) *proxyDrainController {
+	return &proxyDrainController{srv: srv, imp: imp}
 }
 
-// TriggerGracefulDrain flips the proxy into drain mode. Subsequent
-// CONNECTs get a 503; existing tunnels drain naturally.
+// TriggerGracefulDrain flips both proxies into drain mode. Subsequent
+// CONNECTs and fetches get a 503; existing ones drain naturally.
 func (c *proxyDrainController) TriggerGracefulDrain(_ context.Context) error {
-	c.srv.SetDraining(true)
+	c.setDraining(true)
 	return nil
 }
 
-// WaitForActiveConnectionsToDrain polls the proxy's OpenTunnels stat
-// every 500ms until it reaches 0 (drained) or timeout elapses.
+// WaitForActiveConnectionsToDrain polls the proxies' open counts every
+// 500ms until both reach 0 (drained) or timeout elapses.
 // Returns nil on drained, ctx error on cancellation, or
 // errDrainTimeout if the hard timeout fired while open > 0. After
 // the wait completes (success or timeout), drain mode is cleared so
-// the proxy can accept again post-reconnect.
+// the proxies can accept again post-reconnect.
 func (c *proxyDrainController) WaitForActiveConnectionsToDrain(ctx context.Context, timeout time.Duration) error {
-	defer c.srv.SetDraining(false)
+	defer c.setDraining(false)
 	deadline := time.Now().Add(timeout)
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 	for {
-		if open := c.srv.Stats().OpenTunnels; open == 0 {
+		tunnels, requests := c.open()
+		if tunnels == 0 && requests == 0 {
 			return nil
 		}
 		if time.Now().After(deadline) {
-			return fmt.Errorf("%w (last active=%d)", errDrainTimeout, c.srv.Stats().OpenTunnels)
+			return fmt.Errorf("%w (last active: tunnels=%d fetches=%d)", errDrainTimeout, tunnels, requests)
 		}
 		select {
 		case <-ctx.Done():
@@ -70,7 +85,150 @@ func (c *proxyDrainController) WaitForActiveConnectionsToDrain(ctx context.Conte
 	}
 }
 
+func (c *proxyDrainController) setDraining(draining bool) {
+	if !draining && (c.final.Load() || c.held.Load()) {
+		return
+	}
+	c.srv.SetDraining(draining)
+	if c.imp != nil {
+		c.imp.SetDraining(draining)
+	}
+}
+
+// holdDrain puts both proxies in drain mode and keeps them there until
+// releaseDrain.
+func (c *proxyDrainController) holdDrain() {
+	c.held.Store(true)
+	c.setDraining(true)
+}
+
+// releaseDrain ends a holdDrain and reopens the proxies. A no-op when
+// nothing is held, so it can run on every move into Ready.
+func (c *proxyDrainController) releaseDrain() {
+	if c.held.CompareAndSwap(true, false) {
+		c.setDraining(false)
+	}
+}
+
+// open returns the CONNECT proxy's open tunnels and the fetch proxy's
+// open requests.
+func (c *proxyDrainController) open() (tunnels, requests int64) {
+	tunnels = c.srv.Stats().OpenTunnels
+	if c.imp != nil {
+		requests = c.imp.Stats().OpenRequests
+	}
+	return tunnels, requests
+}
+
 // errDrainTimeout is returned when the hard timeout fires with
 // in-flight tunnels still > 0. Rotator callers proceed to Disconnect
 // anyway — the crawler's slot retry catches anything that survives.
 var errDrainTimeout = errors.New("drain wait timed out with active connections > 0")
+
+// Bounds for POST /drain?timeout=. The default matches the rotation
+// drain; set the preStop timeout just under terminationGracePeriodSeconds.
+const (
+	defaultShutdownDrainTimeout = drainWaitTimeout
+	maxShutdownDrainTimeout     = 10 * time.Minute
+)
+
+// BeginShutdown marks the pod as going away: /readyz answers 503 from
+// now on, whatever the state, and rotations and park/connect are
+// refused. One-way. Returns false if already shutting down.
+func (s *StateTracker) BeginShutdown(source string, timeout time.Duration) bool {
+	s.mu.Lock()
+	if !s.shutdownSince.IsZero() {
+		s.mu.Unlock()
+		return false
+	}
+	s.shutdownSince = time.Now().UTC()
+	s.mu.Unlock()
+	s.Publish(eventDrainStarted, map[string]any{"source": source, "timeout_seconds": timeout.Seconds()})
+	return true
+}
+
+// ShuttingDown reports whether a shutdown drain has started.
+func (s *StateTracker) ShuttingDown() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.shutdownSince.IsZero()
+}
+
+// shutdownDrain is the drain behind POST /drain (the preStop hook) and
+// the SIGTERM path: flip /readyz to 503, stop both proxies accepting
+// for good, and wait until their open tunnels and fetches reach zero or
+// timeout passes. Safe to run more than once and concurrently — the
+// SIGTERM run after a completed preStop returns at once. The tunnel is
+// left up; gracefulDisconnect tears it down on exit.
+func shutdownDrain(ctx context.Context, state *StateTracker, drain *proxyDrainController, source string, timeout time.Duration) error {
+	if state.BeginShutdown(source, timeout) {
+		log.Printf("tundler-tunnel: shutdown drain started (source=%s, timeout=%s)", source, timeout)
+	}
+	drain.final.Store(true)
+	drain.setDraining(true)
+
+	started := time.Now()
+	err := drain.WaitForActiveConnectionsToDrain(ctx, timeout)
+	tunnels, requests := drain.open()
+	outcome := "drained"
+	switch {
+	case errors.Is(err, errDrainTimeout):
+		outcome = "timeout"
+	case err != nil:
+		outcome = "cancelled"
+	}
+	log.Printf("tundler-tunnel: shutdown drain %s after %s (source=%s, open tunnels=%d fetches=%d)",
+		outcome, time.Since(started).Round(time.Millisecond), source, tunnels, requests)
+	state.Publish(eventDrainFinished, map[string]any{
+		"source":         source,
+		"outcome":        outcome,
+		"waited_seconds": time.Since(started).Seconds(),
+		"open_tunnels":   tunnels,
+		"open_fetches":   requests,
+	})
+	return err
+}
+
+// drainHandler implements POST /drain?timeout= for a Kubernetes preStop
+// hook: it blocks while shutdownDrain runs. 200 once drained, 504 if
+// connections were still open at the timeout (the pod stays unready
+// either way). timeout is a duration or seconds (default 30s, max 10m).
+func drainHandler(state *StateTracker, drain *proxyDrainController) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := defaultShutdownDrainTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			d, err := parseTimeoutParam(v)
+			if err == nil && d <= 0 {
+				err = fmt.Errorf("timeout must be positive")
+			}
+			if err != nil {
+				writeProblem(w, problemDetails{
+					Type:   "https://tundler-tunnel/errors/invalid-drain-request",
+					Title:  "Invalid drain parameters",
+					Status: http.StatusBadRequest,
+					Detail: err.Error(),
+				})
+				return
+			}
+			timeout = min(d, maxShutdownDrainTimeout)
+		}
+		started := time.Now()
+		err := shutdownDrain(r.Context(), state, drain, "api", timeout)
+		switch {
+		case errors.Is(err, errDrainTimeout):
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/drain-timeout",
+				Title:  "Connections still open at the drain timeout",
+				Status: http.StatusGatewayTimeout,
+				Detail: err.Error(),
+			})
+		case err != nil:
+			// The caller went away; nothing to answer.
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{
+				"drained":        true,
+				"waited_seconds": time.Since(started).Seconds(),
+			})
+		}
+	}
+}
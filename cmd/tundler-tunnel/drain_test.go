@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -11,7 +13,7 @@ import (
 
 func TestProxyDrainController_TriggerSetsDraining(t *testing.T) {
 	srv := proxy.New("placeholder", "pod", "")
-	dc := newProxyDrainController(srv)
+	dc := newProxyDrainController(srv, nil)
 	if err := dc.TriggerGracefulDrain(context.Background()); err != nil {
 		t.Fatalf("TriggerGracefulDrain: %v", err)
 	}
@@ -22,7 +24,7 @@ func TestProxyDrainController_TriggerSetsDraining(t *testing.T) {
 
 func TestProxyDrainController_WaitReturnsNilWhenAlreadyEmpty(t *testing.T) {
 	srv := proxy.New("placeholder", "pod", "")
-	dc := newProxyDrainController(srv)
+	dc := newProxyDrainController(srv, nil)
 	// No open tunnels — should return nil immediately and clear drain.
 	srv.SetDraining(true)
 	err := dc.WaitForActiveConnectionsToDrain(context.Background(), 100*time.Millisecond)
@@ -36,7 +38,7 @@ func TestProxyDrainController_WaitReturnsNilWhenAlreadyEmpty(t *testing.T) {
 
 func TestProxyDrainController_WaitTimesOutWithActiveTunnels(t *testing.T) {
 	srv := proxy.New("placeholder", "pod", "")
-	dc := newProxyDrainController(srv)
+	dc := newProxyDrainController(srv, nil)
 	// Simulate an open tunnel — proxy.Server exposes this via the
 	// IncOpenTunnels test helper so we don't need real network IO.
 	srv.IncOpenTunnels(1)
@@ -49,3 +51,97 @@ func TestProxyDrainController_WaitTimesOutWithActiveTunnels(t *testing.T) {
 		t.Fatal("expected drain flag cleared even on timeout")
 	}
 }
+
+// The fetch proxy on :8486 egresses through the same tunnel, so a drain
+// must flip it too and wait for its open requests as well.
+func TestProxyDrainController_DrainsImpersonateServer(t *testing.T) {
+	srv := proxy.New("placeholder", "pod", "")
+	imp := proxy.NewImpersonateServer("placeholder", "pod", nil)
+	dc := newProxyDrainController(srv, imp)
+	if err := dc.TriggerGracefulDrain(context.Background()); err != nil {
+		t.Fatalf("TriggerGracefulDrain: %v", err)
+	}
+	if !imp.IsDraining() {
+		t.Fatal("expected impersonate proxy to be draining after TriggerGracefulDrain")
+	}
+
+	imp.IncOpenRequests(1)
+	err := dc.WaitForActiveConnectionsToDrain(context.Background(), 50*time.Millisecond)
+	imp.IncOpenRequests(-1)
+	if !errors.Is(err, errDrainTimeout) {
+		t.Fatalf("expected errDrainTimeout with an open fetch, got: %v", err)
+	}
+	if imp.IsDraining() || srv.IsDraining() {
+		t.Fatal("expected both drain flags cleared after wait")
+	}
+}
+
+// POST /drain flips /readyz, holds both proxies in drain mode for good and
+// blocks until the last tunnel closes.
+func TestDrainHandler_BlocksUntilTunnelsClose(t *testing.T) {
+	srv := proxy.New("placeholder", "pod", "")
+	imp := proxy.NewImpersonateServer("placeholder", "pod", nil)
+	api := readyAPI(nil, nil, nil)
+	api.drain = newProxyDrainController(srv, imp)
+	h := api.routes()
+
+	srv.IncOpenTunnels(1)
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- call(h, http.MethodPost, "/drain?timeout=10s", "") }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !api.state.ShuttingDown() {
+		if time.Now().After(deadline) {
+			t.Fatal("drain never started")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rr := call(h, http.MethodGet, "/readyz", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz during drain: got %d, want 503", rr.Code)
+	}
+	if rr := call(h, http.MethodPost, "/rotate", ""); rr.Code != http.StatusConflict {
+		t.Errorf("/rotate during drain: got %d, want 409", rr.Code)
+	}
+	select {
+	case rr := <-done:
+		t.Fatalf("drain returned %d with a tunnel still open", rr.Code)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	srv.IncOpenTunnels(-1)
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusOK {
+			t.Errorf("got %d (%s), want 200 once drained", rr.Code, rr.Body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("drain did not return after the last tunnel closed")
+	}
+	if !srv.IsDraining() || !imp.IsDraining() {
+		t.Error("proxies accepting again after the shutdown drain, want both held draining")
+	}
+	// A rotation's wait must not reopen them either.
+	_ = api.drain.WaitForActiveConnectionsToDrain(context.Background(), time.Millisecond)
+	if !srv.IsDraining() {
+		t.Error("drain mode cleared after the shutdown drain")
+	}
+}
+
+func TestDrainHandler_TimeoutAndBadParams(t *testing.T) {
+	srv := proxy.New("placeholder", "pod", "")
+	api := readyAPI(nil, nil, nil)
+	api.drain = newProxyDrainController(srv, nil)
+	h := api.routes()
+
+	if rr := call(h, http.MethodPost, "/drain?timeout=soon", ""); rr.Code != http.StatusBadRequest || api.state.ShuttingDown() {
+		t.Errorf("bad timeout: got %d shutting_down=%t, want 400 and nothing started", rr.Code, api.state.ShuttingDown())
+	}
+	srv.IncOpenTunnels(1)
+	defer srv.IncOpenTunnels(-1)
+	if rr := call(h, http.MethodPost, "/drain?timeout=50ms", ""); rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("open tunnel at timeout: got %d, want 504", rr.Code)
+	}
+	if !api.state.Snapshot().ShuttingDown {
+		t.Error("/status shutting_down=false after a drain")
+	}
+}
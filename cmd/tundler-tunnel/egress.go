@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,18 +30,25 @@ import (
 // the real node IP), so it keeps calling probeEgressIP directly.
 var contractProbeDialer func(ctx context.Context, target string) (net.Conn, bool, error)
 
-// egressCheckURL is the upstream we probe to learn what source IP
-// the world sees us from. Plain text body containing the IP and
-// nothing else — used by countless tools, so we don't get blocked
-// even from datacenter ranges. Probing it both pre- and post-VPN
-// catches the class of bug where a provider's tunnel ends up in
-// the wrong netns: Connect succeeds, /status looks Ready, but
-// crawler traffic actually exits via the pod's node IP.
-//
-// Kept as a const (not env-overrideable) deliberately: a node-IP
-// leak is the kind of contract violation an operator should not
-// be able to silently disable through misconfiguration.
-const egressCheckURL = "https://checkip.amazonaws.com"
+// Exit-probe knobs. The endpoint list, quorum and modes are deployment
+// settings read once at boot — deliberately not part of the live
+// RuntimeConfig: a node-IP leak is the kind of contract violation an
+// operator should not be able to switch off with a PUT /config.
+const (
+	envExitProbeEndpoints = "EXIT_PROBE_ENDPOINTS" // space-separated specs, see parseProbeEndpoint
+	envExitProbeQuorum    = "EXIT_PROBE_QUORUM"    // agreeing endpoints required; 0 = majority
+	envExitProbeIPv6      = "EXIT_PROBE_IPV6"      // also probe over IPv6 and hold it to the contract
+	envExitProbeStrict    = "EXIT_PROBE_STRICT"    // an unverifiable contract fails the connect
+)
+
+// defaultEgressCheckURL is the endpoint probed when EXIT_PROBE_ENDPOINTS
+// is unset. Plain text body containing the IP and nothing else — used
+// by countless tools, so we don't get blocked even from datacenter
+// ranges. Probing it both pre- and post-VPN catches the class of bug
+// where a provider's tunnel ends up in the wrong netns: Connect
+// succeeds, /status looks Ready, but crawler traffic actually exits via
+// the pod's node IP.
+const defaultEgressCheckURL = "https://checkip.amazonaws.com"
 
 // egressProbeTimeout bounds a single probe attempt. Pre-VPN baseline
 // runs once at boot and we can afford the cost; the post-Connect
@@ -42,81 +56,289 @@ const egressCheckURL = "https://checkip.amazonaws.com"
 // timeout needs to be tight enough that a slow probe doesn't cost
 // us live throughput while remaining loose enough to absorb the
 // post-handshake settle period of a fresh tunnel (DNS + TLS +
-// HTTP all within the window).
+// HTTP all within the window). Endpoints are queried in parallel,
+// so the bound holds for the whole quorum.
 const egressProbeTimeout = 8 * time.Second
 
-// probeEgressIP returns the source IPv4 the egress endpoint sees
-// when we call it from THIS process. Two important properties:
+// Body read bounds: a plain-text answer is one address (IPv6 max is
+// 39 chars + newline); JSON answers carry a few more fields.
+const (
+	probeTextBodyLimit = 64
+	probeJSONBodyLimit = 4 << 10
+)
+
+// errExitIPUnverified marks a contract that could not be checked (no
+// baseline, probe errors, no quorum) in strict mode, where it fails the
+// connect attempt like a leak instead of soft-passing.
+var errExitIPUnverified = errors.New("exit-ip contract unverifiable")
+
+// probeEndpoint is one "what is my IP" service.
+type probeEndpoint struct {
+	url   string // request URL, fragment stripped
+	field string // dot path to the address in a JSON body; "" = plain text
+}
+
+// parseProbeEndpoint parses one EXIT_PROBE_ENDPOINTS entry:
 //
-//   - Uses http.DefaultClient — the dial path is the same one the
-//     in-process CONNECT proxy uses (plain net.DialTimeout, no
-//     fwmark, no netns wrapping), so a leak that bypasses the
-//     proxy's intended VPN tunnel is the SAME class of leak this
-//     probe surfaces. If this probe sees the node IP, the proxy
-//     will too.
-//   - Read-bounded to 64 bytes (IPv4 max is 15 chars + newline);
-//     pathological responses can't OOM us.
-func probeEgressIP(ctx context.Context) (string, error) {
-	probeCtx, cancel := context.WithTimeout(ctx, egressProbeTimeout)
-	defer cancel()
-	req, err := http.NewRequestWithContext(probeCtx, http.MethodGet, egressCheckURL, nil)
+//	https://checkip.amazonaws.com             plain-text body
+//	https://ipinfo.io/json#ip                 JSON body, address at "ip"
+//	https://example.net/v1/whoami#client.addr JSON body, nested field
+//	http://echo.internal:8080/                plain http, for a self-hosted
+//	                                          stand-in for the third parties
+//
+// The fragment is never sent to the server, so it is free to carry the
+// field path.
+func parseProbeEndpoint(spec string) (probeEndpoint, error) {
+	u, err := url.Parse(spec)
 	if err != nil {
-		return "", err
+		return probeEndpoint{}, fmt.Errorf("probe endpoint %q: %w", spec, err)
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return probeEndpoint{}, fmt.Errorf("probe endpoint %q: want an http(s) URL", spec)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("egress probe: status %d", resp.StatusCode)
+	field := u.Fragment
+	u.Fragment, u.RawFragment = "", ""
+	for _, part := range strings.Split(field, ".") {
+		if field != "" && part == "" {
+			return probeEndpoint{}, fmt.Errorf("probe endpoint %q: empty element in field path %q", spec, field)
+		}
 	}
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
-	if err != nil {
-		return "", err
+	return probeEndpoint{url: u.String(), field: field}, nil
+}
+
+// egressProber asks every endpoint for the source address it sees and
+// takes the answer at least quorum of them agree on, so neither the
+// baseline nor the contract rests on a single third party.
+type egressProber struct {
+	endpoints []probeEndpoint
+	quorum    int
+	ipv6      bool
+	strict    bool
+	// baselineV6 is the pre-VPN IPv6 egress; "" when IPv6 probing is
+	// off or the node has no IPv6 egress. Set once at boot.
+	baselineV6 string
+}
+
+// egressProbe is the prober the baseline, contract and re-probe use.
+// main replaces it from the environment (egressProberFromEnv) before
+// the baseline probe; the default is the historical single endpoint.
+var egressProbe = &egressProber{endpoints: []probeEndpoint{{url: defaultEgressCheckURL}}, quorum: 1}
+
+// newEgressProber validates a prober; quorum 0 means a majority of the
+// endpoints.
+func newEgressProber(specs []string, quorum int, ipv6, strict bool) (*egressProber, error) {
+	if len(specs) == 0 {
+		specs = []string{defaultEgressCheckURL}
+	}
+	p := &egressProber{quorum: quorum, ipv6: ipv6, strict: strict}
+	for _, spec := range specs {
+		ep, err := parseProbeEndpoint(spec)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, ep)
+	}
+	if p.quorum == 0 {
+		p.quorum = len(p.endpoints)/2 + 1
 	}
-	return strings.TrimSpace(string(body)), nil
+	if p.quorum > len(p.endpoints) {
+		return nil, fmt.Errorf("%s=%d exceeds the %d probe endpoints", envExitProbeQuorum, quorum, len(p.endpoints))
+	}
+	return p, nil
 }
 
-// probeContractEgressIP is probeEgressIP for the post-connect contract
-// check. When a proxy-chain dialer is installed (contractProbeDialer
-// non-nil and the proxy has a custom dialer) the probe is tunneled
-// through it — the same path crawler traffic takes. Otherwise it dials
-// directly, matching probeEgressIP exactly.
-func probeContractEgressIP(ctx context.Context) (string, error) {
-	if contractProbeDialer == nil {
-		return probeEgressIP(ctx)
+// egressProberFromEnv builds the prober from the EXIT_PROBE_* knobs.
+func egressProberFromEnv() (*egressProber, error) {
+	var flags [2]bool
+	for i, name := range []string{envExitProbeIPv6, envExitProbeStrict} {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%q is not a boolean", name, v)
+			}
+			flags[i] = b
+		}
 	}
-	probeCtx, cancel := context.WithTimeout(ctx, egressProbeTimeout)
-	defer cancel()
-	tr := &http.Transport{
-		DisableKeepAlives: true,
-		DialContext: func(c context.Context, network, addr string) (net.Conn, error) {
-			if conn, ok, err := contractProbeDialer(c, addr); ok {
+	return newEgressProber(strings.Fields(os.Getenv(envExitProbeEndpoints)),
+		getEnvInt(envExitProbeQuorum, 0), flags[0], flags[1])
+}
+
+// String summarises the prober for the boot log.
+func (p *egressProber) String() string {
+	urls := make([]string, len(p.endpoints))
+	for i, ep := range p.endpoints {
+		urls[i] = ep.url
+		if ep.field != "" {
+			urls[i] += "#" + ep.field
+		}
+	}
+	return fmt.Sprintf("endpoints=%s quorum=%d/%d ipv6=%t strict=%t",
+		strings.Join(urls, ","), p.quorum, len(p.endpoints), p.ipv6, p.strict)
+}
+
+// probeClient dials network ("tcp4" or "tcp6") directly, or through
+// upstream when it is non-nil and reports a custom dialer.
+func probeClient(network string, upstream func(ctx context.Context, target string) (net.Conn, bool, error)) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DisableKeepAlives = true
+	if upstream != nil {
+		tr.Proxy = nil
+	}
+	tr.DialContext = func(c context.Context, _, addr string) (net.Conn, error) {
+		if upstream != nil {
+			if conn, ok, err := upstream(c, addr); ok {
 				return conn, err
 			}
-			var d net.Dialer
-			return d.DialContext(c, network, addr)
-		},
+		}
+		var d net.Dialer
+		return d.DialContext(c, network, addr)
 	}
-	client := &http.Client{Transport: tr}
-	req, err := http.NewRequestWithContext(probeCtx, http.MethodGet, egressCheckURL, nil)
+	return &http.Client{Transport: tr}
+}
+
+// fetch asks one endpoint for the address it sees us from.
+func (ep probeEndpoint) fetch(ctx context.Context, client *http.Client) (netip.Addr, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.url, nil)
 	if err != nil {
-		return "", err
+		return netip.Addr{}, err
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return netip.Addr{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("egress probe: status %d", resp.StatusCode)
+		return netip.Addr{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	limit := int64(probeTextBodyLimit)
+	if ep.field != "" {
+		limit = probeJSONBodyLimit
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	raw := strings.TrimSpace(string(body))
+	if ep.field != "" {
+		if raw, err = jsonField(body, ep.field); err != nil {
+			return netip.Addr{}, err
+		}
 	}
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	addr, err := netip.ParseAddr(raw)
 	if err != nil {
-		return "", err
+		return netip.Addr{}, fmt.Errorf("answer %q is not an IP address", raw)
+	}
+	return addr.Unmap(), nil
+}
+
+// jsonField reads the string at a dot path in a JSON object.
+func jsonField(body []byte, path string) (string, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", fmt.Errorf("JSON answer: %w", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("JSON answer: no object at %q", key)
+		}
+		if v, ok = obj[key]; !ok {
+			return "", fmt.Errorf("JSON answer: no field %q", path)
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("JSON answer: field %q is not a string", path)
+	}
+	return s, nil
+}
+
+// probe queries every endpoint in parallel over network and returns
+// the address at least p.quorum of them agree on. Answers of the wrong
+// family count as errors: a dual-stack endpoint must not mix an IPv6
+// answer into an IPv4 vote.
+func (p *egressProber) probe(ctx context.Context, network string, upstream func(ctx context.Context, target string) (net.Conn, bool, error)) (string, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, egressProbeTimeout)
+	defer cancel()
+	client := probeClient(network, upstream)
+
+	type answer struct {
+		addr netip.Addr
+		err  error
+	}
+	answers := make([]answer, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range p.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := ep.fetch(probeCtx, client)
+			if err == nil && addr.Is4() != (network == "tcp4") {
+				err = fmt.Errorf("answer %s is not an %s address", addr, network)
+			}
+			answers[i] = answer{addr, err}
+		}()
+	}
+	wg.Wait()
+
+	votes := make(map[netip.Addr]int)
+	var winner netip.Addr
+	for _, a := range answers {
+		if a.err != nil {
+			continue
+		}
+		votes[a.addr]++
+		if votes[a.addr] > votes[winner] {
+			winner = a.addr
+		}
 	}
-	return strings.TrimSpace(string(body)), nil
+	var dissent []string
+	for i, a := range answers {
+		switch {
+		case a.err != nil:
+			dissent = append(dissent, fmt.Sprintf("%s: %v", p.endpoints[i].url, a.err))
+		case a.addr != winner:
+			dissent = append(dissent, fmt.Sprintf("%s: %s", p.endpoints[i].url, a.addr))
+		}
+	}
+	if votes[winner] < p.quorum {
+		return "", fmt.Errorf("egress probe (%s): no quorum, %d of %d endpoints agree, need %d [%s]",
+			network, votes[winner], len(p.endpoints), p.quorum, strings.Join(dissent, "; "))
+	}
+	if len(dissent) > 0 {
+		log.Printf("tundler-tunnel: egress probe (%s): %s by %d of %d endpoints; dissenting: %s",
+			network, winner, votes[winner], len(p.endpoints), strings.Join(dissent, "; "))
+	}
+	return winner.String(), nil
+}
+
+// probeEgressIP returns the source IPv4 the probe endpoints agree they
+// see when we call them from THIS process. Two important properties:
+//
+//   - Dials directly — the dial path is the same one the in-process
+//     CONNECT proxy uses (plain net.Dialer, no fwmark, no netns
+//     wrapping), so a leak that bypasses the proxy's intended VPN
+//     tunnel is the SAME class of leak this probe surfaces. If this
+//     probe sees the node IP, the proxy will too.
+//   - Read-bounded per endpoint (probeTextBodyLimit /
+//     probeJSONBodyLimit); pathological responses can't OOM us.
+func probeEgressIP(ctx context.Context) (string, error) {
+	return egressProbe.probe(ctx, "tcp4", nil)
+}
+
+// probeEgressIPv6 is probeEgressIP over IPv6. Only endpoints that
+// publish AAAA records can answer it.
+func probeEgressIPv6(ctx context.Context) (string, error) {
+	return egressProbe.probe(ctx, "tcp6", nil)
+}
+
+// probeContractEgressIP is probeEgressIP for the post-connect contract
+// check. When a proxy-chain dialer is installed (contractProbeDialer
+// non-nil and the proxy has a custom dialer) the probe is tunneled
+// through it — the same path crawler traffic takes. Otherwise it dials
+// directly, matching probeEgressIP exactly.
+func probeContractEgressIP(ctx context.Context) (string, error) {
+	return egressProbe.probe(ctx, "tcp4", contractProbeDialer)
 }
 
 // errExitIPLeak is the canonical signal that a Connect succeeded
@@ -126,34 +348,60 @@ func probeContractEgressIP(ctx context.Context) (string, error) {
 // drives the response — no separate code path.
 var errExitIPLeak = fmt.Errorf("exit-ip leak: post-VPN egress matches pre-VPN baseline")
 
-// verifyExitIPDiffers probes the egress endpoint and returns nil if
+// verifyExitIPDiffers probes the egress endpoints and returns nil if
 // the observed IP differs from baseline (= the tunnel is genuinely
 // routing traffic out a different source). If the probe itself
-// fails — usually a transient post-Connect settle — we soft-pass:
-// the watchdog runs anyway and will catch a wedged tunnel via
-// dial-failure tracking. We only fail the contract on a CONFIRMED
-// equality, not on probe failure, because that would convert a
-// network blip into a CrashLoopBackOff.
+// fails — usually a transient post-Connect settle, or endpoints that
+// disagree — we soft-pass by default: the watchdog runs anyway and
+// will catch a wedged tunnel via dial-failure tracking. We only fail
+// the contract on a CONFIRMED equality, not on probe failure, because
+// that would convert a network blip into a CrashLoopBackOff. With
+// EXIT_PROBE_STRICT an unverifiable contract fails the attempt instead
+// (errExitIPUnverified), and the caller's retry machinery takes it.
 //
 // `baseline == ""` means the pre-VPN baseline was unavailable
-// (cluster egress firewall, probe endpoint unreachable, etc.).
-// In that mode we can't compare, so we soft-pass with a warning.
-// Returns the observed post-VPN egress IP (empty when the contract was
+// (cluster egress firewall, probe endpoints unreachable, etc.).
+// In that mode we can't compare, so we soft-pass with a warning,
+// or fail in strict mode.
+//
+// With EXIT_PROBE_IPV6 and a pre-VPN IPv6 baseline, IPv6 egress is held
+// to the same contract: it must be unreachable through the tunnel or
+// differ from the baseline. Proxy-chain providers skip the IPv6 leg;
+// their upstream proxy picks the address family.
+//
+// Returns the observed post-VPN egress IPv4 (empty when the contract was
 // skipped or the probe soft-passed on error) so callers can use it as the
 // exit IP for providers whose CLI doesn't self-report one.
 func verifyExitIPDiffers(ctx context.Context, baseline string) (string, error) {
+	p := egressProbe
 	if baseline == "" {
+		if p.strict {
+			return "", fmt.Errorf("%w: no pre-VPN baseline", errExitIPUnverified)
+		}
 		log.Printf("tundler-tunnel: exit-ip contract: SKIPPED (no pre-VPN baseline)")
 		return "", nil
 	}
 	observed, err := probeContractEgressIP(ctx)
 	if err != nil {
+		if p.strict {
+			return "", fmt.Errorf("%w: %v", errExitIPUnverified, err)
+		}
 		log.Printf("tundler-tunnel: exit-ip contract: probe error: %v — soft-pass (watchdog will catch a wedged tunnel)", err)
 		return "", nil
 	}
 	if observed == baseline {
 		return "", fmt.Errorf("%w (baseline=%s observed=%s)", errExitIPLeak, baseline, observed)
 	}
+	if p.ipv6 && p.baselineV6 != "" && contractProbeDialer == nil {
+		switch observed6, err := probeEgressIPv6(ctx); {
+		case err != nil:
+			log.Printf("tundler-tunnel: exit-ip contract: no IPv6 egress through the tunnel (%v)", err)
+		case observed6 == p.baselineV6:
+			return "", fmt.Errorf("%w over IPv6 (baseline=%s observed=%s)", errExitIPLeak, p.baselineV6, observed6)
+		default:
+			log.Printf("tundler-tunnel: exit-ip contract: IPv6 OK (baseline=%s, post-VPN=%s)", p.baselineV6, observed6)
+		}
+	}
 	log.Printf("tundler-tunnel: exit-ip contract: OK (baseline=%s, post-VPN=%s)", baseline, observed)
 	return observed, nil
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// standIn serves body as a "what is my IP" endpoint; status 0 means 200.
+func standIn(t *testing.T, status int, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// useProber installs a prober over specs for the test.
+func useProber(t *testing.T, quorum int, strict bool, specs ...string) *egressProber {
+	t.Helper()
+	p, err := newEgressProber(specs, quorum, false, strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := egressProbe
+	egressProbe = p
+	t.Cleanup(func() { egressProbe = prev })
+	return p
+}
+
+func TestParseProbeEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		spec, url, field, err string
+	}{
+		{spec: "https://checkip.amazonaws.com", url: "https://checkip.amazonaws.com"},
+		{spec: "https://ipinfo.io/json#ip", url: "https://ipinfo.io/json", field: "ip"},
+		{spec: "http://echo.internal:8080/v1?fmt=json#client.addr", url: "http://echo.internal:8080/v1?fmt=json", field: "client.addr"},
+		{spec: "checkip.amazonaws.com", err: "want an http(s) URL"},
+		{spec: "ftp://example.net", err: "want an http(s) URL"},
+		{spec: "https://example.net#a..b", err: "empty element"},
+	} {
+		ep, err := parseProbeEndpoint(tc.spec)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: err=%v, want %q", tc.spec, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || ep.url != tc.url || ep.field != tc.field {
+			t.Errorf("%s: got %+v %v, want url=%s field=%s", tc.spec, ep, err, tc.url, tc.field)
+		}
+	}
+}
+
+func TestNewEgressProber(t *testing.T) {
+	p, err := newEgressProber(nil, 0, false, false)
+	if err != nil || len(p.endpoints) != 1 || p.endpoints[0].url != defaultEgressCheckURL || p.quorum != 1 {
+		t.Fatalf("default: %+v %v, want the single historical endpoint", p, err)
+	}
+	specs := []string{"https://a.example", "https://b.example", "https://c.example", "https://d.example"}
+	if p, _ := newEgressProber(specs, 0, false, false); p.quorum != 3 {
+		t.Errorf("quorum=%d, want a majority of 4 (3)", p.quorum)
+	}
+	if _, err := newEgressProber(specs[:2], 3, false, false); err == nil {
+		t.Error("quorum above the endpoint count accepted")
+	}
+}
+
+func TestEgressProbe_Quorum(t *testing.T) {
+	text := standIn(t, 0, "203.0.113.7\n")
+	nested := standIn(t, 0, `{"data":{"ip":"203.0.113.7","asn":64500}}`) + "#data.ip"
+	other := standIn(t, 0, "198.51.100.1")
+	down := standIn(t, http.StatusBadGateway, "")
+
+	useProber(t, 0, false, text, nested, other)
+	if got, err := probeEgressIP(context.Background()); err != nil || got != "203.0.113.7" {
+		t.Errorf("2 of 3 agree: got %q %v, want 203.0.113.7", got, err)
+	}
+
+	useProber(t, 0, false, text, other, down)
+	if _, err := probeEgressIP(context.Background()); err == nil || !strings.Contains(err.Error(), "no quorum, 1 of 3 endpoints agree, need 2") ||
+		!strings.Contains(err.Error(), "status 502") {
+		t.Errorf("split vote: err=%v, want a no-quorum error naming the dissent", err)
+	}
+
+	// Garbage and wrong-family answers don't vote.
+	for _, body := range []string{"<html>blocked</html>", "2001:db8::1"} {
+		useProber(t, 0, false, standIn(t, 0, body))
+		if _, err := probeEgressIP(context.Background()); err == nil {
+			t.Errorf("answer %q accepted as an IPv4 egress", body)
+		}
+	}
+	useProber(t, 0, false, standIn(t, 0, `{"ip":7}`)+"#ip")
+	if _, err := probeEgressIP(context.Background()); err == nil || !strings.Contains(err.Error(), "not a string") {
+		t.Errorf("numeric JSON field: err=%v", err)
+	}
+}
+
+func TestEgressProbe_IPv6(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	srv := &httptest.Server{Listener: ln, Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "2001:db8::7")
+	})}}
+	srv.Start()
+	defer srv.Close()
+
+	useProber(t, 0, false, srv.URL)
+	if got, err := probeEgressIPv6(context.Background()); err != nil || got != "2001:db8::7" {
+		t.Errorf("got %q %v, want 2001:db8::7", got, err)
+	}
+	// The IPv4 probe can't reach an IPv6-only endpoint.
+	if _, err := probeEgressIP(context.Background()); err == nil {
+		t.Error("IPv4 probe answered through an IPv6-only endpoint")
+	}
+}
+
+func TestVerifyExitIPDiffers_StrictMode(t *testing.T) {
+	down := standIn(t, http.StatusServiceUnavailable, "")
+
+	useProber(t, 0, false, down)
+	if observed, err := verifyExitIPDiffers(context.Background(), "198.51.100.1"); err != nil || observed != "" {
+		t.Errorf("lenient probe error: got %q %v, want a soft-pass", observed, err)
+	}
+
+	useProber(t, 0, true, down)
+	for _, baseline := range []string{"198.51.100.1", ""} {
+		_, err := verifyExitIPDiffers(context.Background(), baseline)
+		if !errors.Is(err, errExitIPUnverified) {
+			t.Errorf("strict, baseline=%q: err=%v, want unverified", baseline, err)
+		}
+	}
+}
+
+// A stand-in answering with the baseline makes the leak path hermetic:
+// the connect fails and the tunnel is torn down.
+func TestConnectTunnel_ContractLeak(t *testing.T) {
+	useProber(t, 0, false, standIn(t, 0, "198.51.100.1"))
+	st := NewStateTracker("fake")
+	fp := &fakeProvider{locations: []string{"USA"}, connectOK: true}
+	err := connectTunnel(context.Background(), fp, st, "fake", nil, "198.51.100.1")
+	if !errors.Is(err, errExitIPLeak) || fp.disconnectCalls.Load() != 1 {
+		t.Fatalf("err=%v disconnects=%d, want a leak and a teardown", err, fp.disconnectCalls.Load())
+	}
+	if st.contractProbeOutcome != contractLeak {
+		t.Errorf("contract outcome=%q, want leak", st.contractProbeOutcome)
+	}
+
+	// Strict and unverifiable: failed like a leak, recorded as unverified.
+	useProber(t, 0, true, standIn(t, http.StatusBadGateway, ""))
+	st = NewStateTracker("fake")
+	if err := connectTunnel(context.Background(), fp, st, "fake", nil, "198.51.100.1"); !errors.Is(err, errExitIPUnverified) {
+		t.Fatalf("strict: err=%v, want unverified", err)
+	}
+	if st.contractProbeOutcome != contractUnverified {
+		t.Errorf("strict: contract outcome=%q, want unverified", st.contractProbeOutcome)
+	}
+
+	useProber(t, 0, true, standIn(t, 0, "203.0.113.7"))
+	st = NewStateTracker("fake")
+	if err := connectTunnel(context.Background(), fp, st, "fake", nil, "198.51.100.1"); err != nil {
+		t.Fatal(err)
+	}
+	if exit, _ := st.CurrentTunnel(); exit != "203.0.113.7" {
+		t.Errorf("exit=%q, want the probed 203.0.113.7", exit)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types published on GET /events. Payload keys are documented at
+// each publish site; every event also carries id, type and at.
+const (
+	eventStateChanged        = "state_changed"      // from, to, reason
+	eventRotationStarted     = "rotation_started"   // previous_exit_ip, request, requested_by
+	eventRotationFinished    = "rotation_finished"  // outcome, previous/new exit, location, duration_seconds, error
+	eventAuthFailure         = "auth_failure"       // source (provider_login | control_api), reason / endpoint
+	eventWatchdogReconnect   = "watchdog_reconnect" // outcome, state, consecutive_dial_failures, error
+	eventWedgeGuardArmed     = "wedge_guard_armed"  // state, threshold_seconds
+	eventWedgeGuardCleared   = "wedge_guard_cleared"
+	eventWedgeGuardTripped   = "wedge_guard_tripped"
+	eventRecycle             = "recycle"              // reason
+	eventConfigChanged       = "config_changed"       // source (api | file), config
+	eventRotationPaused      = "rotation_paused"      // by
+	eventRotationResumed     = "rotation_resumed"     // by, paused_seconds
+	eventDrainStarted        = "drain_started"        // source (api | sigterm), timeout_seconds
+	eventDrainFinished       = "drain_finished"       // source, outcome (drained | timeout | cancelled), waited_seconds, open_tunnels, open_fetches
+	eventExitChanged         = "exit_changed"         // previous_exit_ip, exit_ip, location, leak
+	eventExitRejected        = "exit_rejected"        // exit_ip, location, reason (avoid_exit_ips | recent_exit | exit_country | exit_asn), detail
+	eventExitGeoMismatch     = "exit_geo_mismatch"    // exit_ip, location, country, expected_country
+	eventExitUnqualified     = "exit_unqualified"     // exit_ip, location, failure, pmtu_black_hole
+	eventLocationQuarantined = "location_quarantined" // location, score, outcome, quarantined_until, quarantine_seconds, consecutive
+)
+
+const (
+	// eventBufferSize bounds the replay ring. At the pod's event rate
+	// (a handful per rotation) it covers hours, which is what a client
+	// reconnecting after a blip or a rollout needs.
+	eventBufferSize = 512
+	// eventSubscriberBuffer is how far one SSE client may lag before it
+	// is cut off; it reconnects with Last-Event-ID and replays the gap.
+	eventSubscriberBuffer = 64
+	// sseKeepAlive is the comment-line cadence that keeps idle streams
+	// open through proxies and load balancers.
+	sseKeepAlive = 15 * time.Second
+)
+
+// Event is one entry of the pod's event stream.
+type Event struct {
+	ID   uint64         `json:"id"`
+	Type string         `json:"type"`
+	At   string         `json:"at"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// eventBus is an in-memory ring of recent events plus live subscribers.
+// IDs are strictly increasing from 1, and across respawns: a restored
+// tracker resumes at the persisted next ID (resumeAt). Safe for
+// concurrent use; Publish never blocks on a slow subscriber.
+type eventBus struct {
+	mu     sync.Mutex
+	ring   []Event // oldest first, len ≤ size
+	size   int
+	nextID uint64
+	subs   map[chan Event]struct{}
+}
+
+func newEventBus(size int) *eventBus {
+	return &eventBus{size: size, nextID: 1, subs: make(map[chan Event]struct{})}
+}
+
+// NextID is the ID the next event will get.
+func (b *eventBus) NextID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID
+}
+
+// resumeAt continues an earlier process's numbering at id. It never
+// moves the numbering back.
+func (b *eventBus) resumeAt(id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID = max(b.nextID, id)
+}
+
+// Publish appends an event and fans it out. A subscriber whose buffer is
+// full is dropped (its channel closed) rather than stalling the caller —
+// the publishing goroutine is usually the rotator or the watchdog.
+func (b *eventBus) Publish(typ string, data map[string]any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ev := Event{ID: b.nextID, Type: typ, At: time.Now().UTC().Format(time.RFC3339Nano), Data: data}
+	b.nextID++
+	if len(b.ring) == b.size {
+		copy(b.ring, b.ring[1:])
+		b.ring = b.ring[:b.size-1]
+	}
+	b.ring = append(b.ring, ev)
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// Subscribe returns the buffered events after lastID and a channel of
+// everything published from now on, atomically, so nothing falls in
+// between. truncated is true when events after lastID have already
+// rotated out of the ring, or were published by an earlier process and
+// not kept. A lastID this bus hasn't reached yet comes from an earlier
+// process that died before saving its last IDs: the whole ring is
+// replayed, truncated. cancel must be called when done.
+func (b *eventBus) Subscribe(lastID uint64) (replay []Event, truncated bool, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	oldest := b.nextID
+	if len(b.ring) > 0 {
+		oldest = b.ring[0].ID
+	}
+	if lastID >= b.nextID {
+		lastID, truncated = 0, true
+	} else if lastID+1 < oldest {
+		truncated = true
+	}
+	for _, ev := range b.ring {
+		if ev.ID > lastID {
+			replay = append(replay, ev)
+		}
+	}
+	c := make(chan Event, eventSubscriberBuffer)
+	b.subs[c] = struct{}{}
+	return replay, truncated, c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
+
+// eventsHandler implements GET /events as a Server-Sent Events stream.
+// A reconnecting client sends Last-Event-ID (EventSource does so
+// automatically; ?last_event_id= works for the first connect) and gets
+// the buffered events after it before the live tail. If the ring no
+// longer reaches back that far, a "replay_truncated" event says so
+// first. Without either, the stream starts live.
+func eventsHandler(bus *eventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		last := r.Header.Get("Last-Event-ID")
+		if last == "" {
+			last = r.URL.Query().Get("last_event_id")
+		}
+		var lastID uint64
+		replaying := last != ""
+		if replaying {
+			id, err := strconv.ParseUint(last, 10, 64)
+			if err != nil {
+				writeProblem(w, problemDetails{
+					Type:   "https://tundler-tunnel/errors/invalid-last-event-id",
+					Title:  "Invalid Last-Event-ID",
+					Status: http.StatusBadRequest,
+					Detail: fmt.Sprintf("%q is not an event id", last),
+				})
+				return
+			}
+			lastID = id
+		}
+
+		replay, truncated, ch, cancel := bus.Subscribe(lastID)
+		defer cancel()
+		if !replaying {
+			replay, truncated = nil, false
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 2000\n\n")
+		if truncated {
+			fmt.Fprintf(w, "event: replay_truncated\ndata: {\"last_event_id\":%d}\n\n", lastID)
+		}
+		for _, ev := range replay {
+			writeSSE(w, ev)
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev, open := <-ch:
+				if !open {
+					// Fell too far behind; the client reconnects with
+					// Last-Event-ID and replays what it missed.
+					return
+				}
+				writeSSE(w, ev)
+				flusher.Flush()
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev Event) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
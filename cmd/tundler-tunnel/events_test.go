@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func eventTypes(evs []Event) []string {
+	out := make([]string, len(evs))
+	for i, ev := range evs {
+		out[i] = ev.Type
+	}
+	return out
+}
+
+func TestEventBus_ReplayAndTruncation(t *testing.T) {
+	b := newEventBus(3)
+	for i := 0; i < 5; i++ {
+		b.Publish(fmt.Sprintf("e%d", i+1), nil)
+	}
+	// Ring holds ids 3..5.
+	replay, truncated, _, cancel := b.Subscribe(3)
+	cancel()
+	if got := eventTypes(replay); strings.Join(got, ",") != "e4,e5" || truncated {
+		t.Errorf("after 3: got %v truncated=%t, want [e4 e5] untruncated", got, truncated)
+	}
+	replay, truncated, _, cancel = b.Subscribe(1)
+	cancel()
+	if len(replay) != 3 || !truncated {
+		t.Errorf("after 1: got %d events truncated=%t, want 3 truncated (id 2 rotated out)", len(replay), truncated)
+	}
+}
+
+// A respawned bus numbers on from the earlier process. A client that
+// saw IDs the earlier process never saved gets the ring, truncated.
+func TestEventBus_ResumeAcrossRespawn(t *testing.T) {
+	b := newEventBus(eventBufferSize)
+	b.resumeAt(500)
+	replay, truncated, _, cancel := b.Subscribe(499)
+	cancel()
+	if len(replay) != 0 || truncated {
+		t.Errorf("caught-up client: got %v truncated=%t, want nothing", replay, truncated)
+	}
+	replay, truncated, _, cancel = b.Subscribe(450)
+	cancel()
+	if len(replay) != 0 || !truncated {
+		t.Errorf("client behind the respawn: truncated=%t, want true", truncated)
+	}
+	if ev := b.Publish("e1", nil); ev.ID != 500 {
+		t.Fatalf("first id after resume=%d, want 500", ev.ID)
+	}
+	replay, truncated, _, cancel = b.Subscribe(510)
+	cancel()
+	if got := eventTypes(replay); strings.Join(got, ",") != "e1" || !truncated {
+		t.Errorf("client ahead of the bus: got %v truncated=%t, want [e1] truncated", got, truncated)
+	}
+	b.resumeAt(10)
+	if got := b.NextID(); got != 501 {
+		t.Errorf("next id=%d after resuming lower, want 501", got)
+	}
+}
+
+// A subscriber that stops reading is cut off instead of blocking Publish.
+func TestEventBus_SlowSubscriberIsDropped(t *testing.T) {
+	b := newEventBus(eventBufferSize)
+	_, _, ch, cancel := b.Subscribe(0)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventSubscriberBuffer+10; i++ {
+			b.Publish("tick", nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != eventSubscriberBuffer {
+		t.Errorf("delivered %d before close, want %d", n, eventSubscriberBuffer)
+	}
+}
+
+func TestStateTracker_TransitionPublishes(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.Set(StateConnecting)
+	st.Transition(StateReady, "tunnel up at USA")
+	st.Set(StateReady) // no change, no event
+
+	replay, _, _, cancel := st.Events().Subscribe(0)
+	cancel()
+	if len(replay) != 2 {
+		t.Fatalf("got %v, want 2 state_changed events", eventTypes(replay))
+	}
+	last := replay[1]
+	if last.Type != eventStateChanged || last.Data["from"] != StateConnecting ||
+		last.Data["to"] != StateReady || last.Data["reason"] != "tunnel up at USA" {
+		t.Errorf("got %+v, want Connecting→Ready with reason", last)
+	}
+}
+
+func TestRotateIfReadyWithDeps_PublishesRotationEvents(t *testing.T) {
+	sp := newScriptedProvider([]string{"USA", "UK"}, []bool{true}, []string{"9.9.9.9"})
+	st := NewStateTracker("scripted")
+	st.RecordTunnelUp("USA", "1.1.1.1")
+	st.Set(StateReady)
+
+	if err := rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, (&noSleep{}).sleep, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	replay, _, _, cancel := st.Events().Subscribe(0)
+	cancel()
+	var started, finished *Event
+	for i := range replay {
+		switch replay[i].Type {
+		case eventRotationStarted:
+			started = &replay[i]
+		case eventRotationFinished:
+			finished = &replay[i]
+		}
+	}
+	if started == nil || started.Data["previous_exit_ip"] != "1.1.1.1" {
+		t.Errorf("rotation_started=%+v, want previous_exit_ip 1.1.1.1", started)
+	}
+	if finished == nil || finished.Data["outcome"] != "success" || finished.Data["new_exit_ip"] != "9.9.9.9" {
+		t.Errorf("rotation_finished=%+v, want success to 9.9.9.9", finished)
+	}
+}
+
+// readSSE collects n events from an SSE stream.
+func readSSE(t *testing.T, sc *bufio.Scanner, n int) []Event {
+	t.Helper()
+	var out []Event
+	for len(out) < n && sc.Scan() {
+		line := sc.Text()
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var ev Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("bad data line %q: %v", line, err)
+			}
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// A reconnecting client replays from Last-Event-ID, then gets the live tail.
+func TestEventsHandler_ReplaysThenStreams(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.Set(StateLoggingIn)  // id 1
+	st.Set(StateConnecting) // id 2
+	st.Set(StateReady)      // id 3
+
+	api := readyAPI(nil, nil, nil)
+	api.state = st
+	srv := httptest.NewServer(api.routes())
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type=%q, want text/event-stream", ct)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	replayed := readSSE(t, sc, 2)
+	if len(replayed) != 2 || replayed[0].ID != 2 || replayed[1].ID != 3 {
+		t.Fatalf("replay=%+v, want ids 2 and 3", replayed)
+	}
+	st.Publish(eventRecycle, map[string]any{"reason": "test"})
+	live := readSSE(t, sc, 1)
+	if len(live) != 1 || live[0].Type != eventRecycle || live[0].ID != 4 {
+		t.Errorf("live=%+v, want recycle id 4", live)
+	}
+}
+
+func TestEventsHandler_BadLastEventID(t *testing.T) {
+	h := readyAPI(nil, nil, nil).routes()
+	req := httptest.NewRequest(http.MethodGet, "/events?last_event_id=abc", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", rr.Code)
+	}
+}
+
+func TestRoutes_AuthFailurePublishesEvent(t *testing.T) {
+	api := readyAPI(tokenAuth(), nil, nil)
+	call(api.routes(), http.MethodGet, "/status", "wrong")
+
+	replay, _, _, cancel := api.state.Events().Subscribe(0)
+	cancel()
+	last := replay[len(replay)-1]
+	if last.Type != eventAuthFailure || last.Data["source"] != "control_api" || last.Data["status"] != http.StatusUnauthorized {
+		t.Errorf("last event=%+v, want control_api auth_failure 401", last)
+	}
+}
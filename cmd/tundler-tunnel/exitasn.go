@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/mmdb"
+	"github.com/laurentpellegrino/tundler/tunnelapi"
+)
+
+// Exit ASN. Some providers hand out exits in hosting networks that
+// targets block outright. With ASN_DB pointing at a MaxMind-format ASN
+// database (GeoLite2-ASN, DB-IP ASN, IPinfo), every new exit is looked up
+// offline for its autonomous system and organisation, and classed as
+// hosting when its ASN is listed in HOSTING_ASNS ("16509,AS14061,...");
+// anything else counts as residential. Both are deployment settings read
+// once at boot, like GEOIP_DB.
+//
+// The live exit_asn_policy says what a hosting exit does:
+//
+//	flag    keep the tunnel (the default)
+//	reject  a rotation turns the exit down like an avoid_exit_ips hit
+//	        and retries within ROTATION_RETRY_MAX
+//
+// As with exit_geo_policy, connects outside a rotation always flag. The
+// kept exit's ASN shows in /status (exit_asn), its session in /history
+// and the notifier payloads (asn, asn_org, hosting).
+const (
+	envASNDB         = "ASN_DB"
+	envHostingASNs   = "HOSTING_ASNS"
+	envExitASNPolicy = "EXIT_ASN_POLICY" // boot exit_asn_policy
+)
+
+// ExitASN is `exit_asn` in /status.
+type ExitASN = tunnelapi.ExitASN
+
+// asnLocator looks exits up; the ASN_DB reader in production.
+type asnLocator interface {
+	lookupASN(addr netip.Addr) (asn uint32, org string, network netip.Prefix, err error)
+}
+
+// exitASNDB is the database new exits are looked up in, and hostingASNs
+// the ASNs classed as hosting; a nil exitASNDB (the default) turns the
+// lookup off. main sets both from the environment.
+var (
+	exitASNDB   asnLocator
+	hostingASNs []uint32 // sorted
+)
+
+// mmdbASNLocator reads the ASN and organisation out of the record
+// layouts the common databases use.
+type mmdbASNLocator struct{ r *mmdb.Reader }
+
+func (l mmdbASNLocator) lookupASN(addr netip.Addr) (uint32, string, netip.Prefix, error) {
+	rec, network, err := l.r.Lookup(addr)
+	if err != nil || rec == nil {
+		return 0, "", network, err
+	}
+	// MaxMind and DB-IP.
+	if asn := mmdb.Uint(rec, "autonomous_system_number"); asn != 0 {
+		return uint32(asn), mmdb.String(rec, "autonomous_system_organization"), network, nil
+	}
+	// IPinfo: "asn": "AS13335" with "as_name" (lite) or "name" (ASN).
+	asn, err := parseASN(mmdb.String(rec, "asn"))
+	if err != nil {
+		return 0, "", network, nil
+	}
+	org := mmdb.String(rec, "as_name")
+	if org == "" {
+		org = mmdb.String(rec, "name")
+	}
+	return asn, org, network, nil
+}
+
+// parseASN reads "16509" or "AS16509".
+func parseASN(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("%q is not an ASN", s)
+	}
+	return uint32(n), nil
+}
+
+// parseASNList parses HOSTING_ASNS, returning them sorted.
+func parseASNList(csv string) ([]uint32, error) {
+	var out []uint32
+	for _, part := range strings.Split(csv, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		asn, err := parseASN(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, asn)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// exitASNDBFromEnv opens ASN_DB and parses HOSTING_ASNS; a nil locator
+// when ASN_DB is unset.
+func exitASNDBFromEnv() (asnLocator, []uint32, error) {
+	hosting, err := parseASNList(os.Getenv(envHostingASNs))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", envHostingASNs, err)
+	}
+	path := os.Getenv(envASNDB)
+	if path == "" {
+		if len(hosting) > 0 {
+			log.Printf("tundler-tunnel: %s set without %s; exits are not classified", envHostingASNs, envASNDB)
+		}
+		return nil, nil, nil
+	}
+	r, err := mmdb.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", envASNDB, err)
+	}
+	log.Printf("tundler-tunnel: exit ASNs from %s (%s, built %s), %d hosting ASN(s)", path, r.Metadata.DatabaseType,
+		time.Unix(int64(r.Metadata.BuildEpoch), 0).UTC().Format(time.DateOnly), len(hosting))
+	return mmdbASNLocator{r}, hosting, nil
+}
+
+// lookupExitASN looks exitIP up in exitASNDB and classes it. Nil when
+// the lookup is off, exitIP isn't an address or the database doesn't
+// hold it.
+func lookupExitASN(exitIP string) *ExitASN {
+	db := exitASNDB
+	addr, err := netip.ParseAddr(exitIP)
+	if db == nil || err != nil {
+		return nil
+	}
+	asn, org, network, err := db.lookupASN(addr)
+	if err != nil {
+		log.Printf("tundler-tunnel: looking up the ASN of exit %s: %v", exitIP, err)
+		return nil
+	}
+	if asn == 0 {
+		return nil
+	}
+	_, hosting := slices.BinarySearch(hostingASNs, asn)
+	return &ExitASN{ExitIP: exitIP, ASN: asn, Org: org, Network: network.String(), Hosting: hosting}
+}
+
+// exitASNPolicy is the live exit_asn_policy a rotation runs with; the
+// zero value flags.
+type exitASNPolicy struct {
+	reject bool
+}
+
+func (c RuntimeConfig) exitASNPolicy() exitASNPolicy {
+	return exitASNPolicy{reject: c.ExitASNPolicy == policyReject}
+}
+
+// rejects reports why the policy turns an exit in a down, or "" when it
+// keeps it.
+func (p exitASNPolicy) rejects(a *ExitASN) string {
+	if !p.reject || a == nil || !a.Hosting {
+		return ""
+	}
+	return fmt.Sprintf("exit is in AS%d (%s), listed in %s", a.ASN, a.Org, envHostingASNs)
+}
+
+// RecordExitASN notes the ASN of the exit about to be recorded by
+// RecordTunnelUp (nil when unknown), which files it on the session.
+func (s *StateTracker) RecordExitASN(a *ExitASN) {
+	s.mu.Lock()
+	s.exitASN = a
+	s.mu.Unlock()
+}
+
+// ExitASN returns the current exit's ASN, nil when unknown.
+func (s *StateTracker) ExitASN() *ExitASN {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exitASNFor(s.currentExitIP)
+}
+
+// exitASNFor is the recorded ASN while it is exitIP's; s.mu held.
+func (s *StateTracker) exitASNFor(exitIP string) *ExitASN {
+	if s.exitASN == nil || s.exitASN.ExitIP != exitIP {
+		return nil
+	}
+	a := *s.exitASN
+	return &a
+}
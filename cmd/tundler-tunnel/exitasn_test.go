@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeASNDB maps the exits it lists to their ASN.
+type fakeASNDB map[string]uint32
+
+func (f fakeASNDB) lookupASN(addr netip.Addr) (uint32, string, netip.Prefix, error) {
+	network, _ := addr.Prefix(24)
+	asn := f[addr.String()]
+	if asn == 0 {
+		return 0, "", network, nil
+	}
+	return asn, "Org " + addr.String(), network, nil
+}
+
+// useASNDB installs db and the hosting list for the test.
+func useASNDB(t *testing.T, db asnLocator, hosting ...uint32) {
+	prevDB, prevHosting := exitASNDB, hostingASNs
+	exitASNDB, hostingASNs = db, hosting
+	t.Cleanup(func() { exitASNDB, hostingASNs = prevDB, prevHosting })
+}
+
+func TestParseASNList(t *testing.T) {
+	got, err := parseASNList(" AS16509, 14061,as16509,,")
+	if err != nil || !slices.Equal(got, []uint32{14061, 16509}) {
+		t.Errorf("parseASNList = %v, %v", got, err)
+	}
+	for _, bad := range []string{"AS", "cloudflare", "0", "AS4294967296"} {
+		if _, err := parseASNList(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestLookupExitASN(t *testing.T) {
+	if a := lookupExitASN("1.2.3.4"); a != nil {
+		t.Fatalf("no database: %+v", a)
+	}
+	useASNDB(t, fakeASNDB{"1.2.3.4": 16509, "5.6.7.8": 3320}, 16509)
+	if a := lookupExitASN("1.2.3.4"); a == nil || a.ASN != 16509 || !a.Hosting || a.Network != "1.2.3.0/24" {
+		t.Errorf("1.2.3.4: %+v, want hosting AS16509", a)
+	}
+	if a := lookupExitASN("5.6.7.8"); a == nil || a.Hosting {
+		t.Errorf("5.6.7.8: %+v, want residential AS3320", a)
+	}
+	if a := lookupExitASN("9.9.9.9"); a != nil {
+		t.Errorf("unlisted exit: %+v", a)
+	}
+}
+
+// reject turns a hosting exit down and keeps the residential one, which
+// /status and its history session then describe.
+func TestConnectWithRetry_RejectsHostingASN(t *testing.T) {
+	useASNDB(t, fakeASNDB{"5.6.7.8": 16509, "1.2.3.4": 3320}, 16509)
+	sp := newScriptedProvider([]string{"Germany", "France"}, []bool{true, true}, []string{"5.6.7.8", "1.2.3.4"})
+	st := NewStateTracker("scripted")
+	req := RotateRequest{ExitASN: exitASNPolicy{reject: true}}
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, req, 3, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := st.SnapshotCurrentExitIP(); got != "1.2.3.4" || sp.attemptCount() != 2 {
+		t.Errorf("exit=%q after %d attempts, want 1.2.3.4 after 2", got, sp.attemptCount())
+	}
+	st.RecordRotation("", "1.2.3.4", "success", time.Second)
+	snap := st.Snapshot()
+	if r := snap.LastRotation.RejectedExits; len(r) != 1 || r[0].Reason != rejectASN || r[0].Detail != "exit is in AS16509 (Org 5.6.7.8), listed in HOSTING_ASNS" {
+		t.Errorf("rejected_exits=%+v", r)
+	}
+	if a := snap.ExitASN; a == nil || a.ASN != 3320 || a.Hosting {
+		t.Errorf("exit_asn=%+v, want the kept exit's AS3320", a)
+	}
+	if h := st.History(); len(h) != 1 || h[0].ASN != 3320 || h[0].ASNOrg != "Org 1.2.3.4" || h[0].Hosting {
+		t.Errorf("history=%+v", h)
+	}
+}
+
+// flag keeps a hosting exit, shows it in the CSV history and drops it
+// with the exit.
+func TestConnectWithRetry_FlagsHostingASN(t *testing.T) {
+	useASNDB(t, fakeASNDB{"5.6.7.8": 16509}, 16509)
+	sp := newScriptedProvider([]string{"Germany"}, []bool{true}, []string{"5.6.7.8"})
+	st := NewStateTracker("scripted")
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if a := st.ExitASN(); a == nil || !a.Hosting {
+		t.Fatalf("exit_asn=%+v, want hosting", a)
+	}
+	rr := httptest.NewRecorder()
+	historyHandler(st)(rr, httptest.NewRequest(http.MethodGet, "/history?format=csv", nil))
+	if body := rr.Body.String(); !strings.Contains(body, ",16509,Org 5.6.7.8,true\n") {
+		t.Errorf("csv=%q, want the session's ASN columns", body)
+	}
+	st.RecordTunnelUp("Germany", "9.9.9.9")
+	if a := st.ExitASN(); a != nil {
+		t.Errorf("stale exit_asn: %+v", a)
+	}
+}
+
+func TestRuntimeConfig_ExitASNPolicy(t *testing.T) {
+	c := defaultRuntimeConfig()
+	c.ExitASNPolicy = "drop"
+	if err := c.validate(); err == nil {
+		t.Error("exit_asn_policy=drop validated")
+	}
+	c.ExitASNPolicy = policyReject
+	if err := c.validate(); err != nil || !c.exitASNPolicy().reject {
+		t.Errorf("reject: %v, %+v", err, c.exitASNPolicy())
+	}
+}
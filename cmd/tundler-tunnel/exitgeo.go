@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/mmdb"
+	"github.com/laurentpellegrino/tundler/tunnelapi"
+)
+
+// Exit geolocation. A provider's "Germany" sometimes exits from a
+// server that geolocates to the Netherlands, and crawl jobs care about
+// the country. With GEOIP_DB pointing at a MaxMind-format country or
+// city database (GeoLite2, GeoIP2, DB-IP, IPinfo), every new exit is
+// looked up offline and compared with the ISO country of the location it
+// was picked for (provider.Location.Country):
+//
+//	match     the exit geolocates to the location's country
+//	mismatch  it geolocates to another country
+//	unknown   the exit isn't in the database, or the location has no
+//	          country (WARP's and Psiphon's "auto")
+//
+// The live exit_geo_policy says what a mismatch does:
+//
+//	flag    keep the tunnel (the default)
+//	reject  a rotation turns the exit down like an avoid_exit_ips hit:
+//	        disconnect, burn the location while others remain, retry
+//	        within ROTATION_RETRY_MAX
+//
+// Connects outside a rotation (boot, watchdog reconnect, unpark, exit
+// re-probe) always flag: they have no retry budget to spend. The result
+// of the kept exit shows in /status (exit_geo) and on every CONNECT
+// response (x-tundler-exit-country, x-tundler-exit-country-check); a
+// flagged mismatch also publishes exit_geo_mismatch.
+const (
+	envGeoIPDB       = "GEOIP_DB"
+	envExitGeoPolicy = "EXIT_GEO_POLICY" // boot exit_geo_policy
+	policyFlag       = "flag"
+	policyReject     = "reject"
+)
+
+// Verdicts (ExitGeo.Verdict).
+const (
+	geoMatch    = "match"
+	geoMismatch = "mismatch"
+	geoUnknown  = "unknown"
+)
+
+// ExitGeo is `exit_geo` in /status.
+type ExitGeo = tunnelapi.ExitGeo
+
+// exitGeolocator looks exits up; the GEOIP_DB reader in production.
+type exitGeolocator interface {
+	geolocate(addr netip.Addr) (country, city string, network netip.Prefix, err error)
+}
+
+// exitGeoDB is the database new exits are checked against; nil (the
+// default) turns the check off. main sets it from GEOIP_DB.
+var exitGeoDB exitGeolocator
+
+// mmdbGeolocator reads the country and city out of the record layouts
+// the common databases use.
+type mmdbGeolocator struct{ r *mmdb.Reader }
+
+func (g mmdbGeolocator) geolocate(addr netip.Addr) (country, city string, network netip.Prefix, err error) {
+	rec, network, err := g.r.Lookup(addr)
+	if err != nil || rec == nil {
+		return "", "", network, err
+	}
+	for _, path := range [][]string{
+		{"country", "iso_code"},            // MaxMind, DB-IP
+		{"registered_country", "iso_code"}, // MaxMind, for anycast and satellite ranges
+		{"country_code"},                   // IPinfo
+		{"country"},                        // flat country databases
+	} {
+		if country = mmdb.String(rec, path...); country != "" {
+			break
+		}
+	}
+	city = mmdb.String(rec, "city", "names", "en")
+	if city == "" {
+		city = mmdb.String(rec, "city")
+	}
+	return country, city, network, nil
+}
+
+// exitGeoDBFromEnv opens GEOIP_DB; nil when it is unset.
+func exitGeoDBFromEnv() (exitGeolocator, error) {
+	path := os.Getenv(envGeoIPDB)
+	if path == "" {
+		return nil, nil
+	}
+	r, err := mmdb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", envGeoIPDB, err)
+	}
+	log.Printf("tundler-tunnel: exit geolocation from %s (%s, built %s)", path, r.Metadata.DatabaseType,
+		time.Unix(int64(r.Metadata.BuildEpoch), 0).UTC().Format(time.DateOnly))
+	return mmdbGeolocator{r}, nil
+}
+
+// checkExitGeo geolocates exitIP and compares it with the country
+// location resolves to. Nil when the check is off or exitIP isn't an
+// address.
+func checkExitGeo(state *StateTracker, location, exitIP string) *ExitGeo {
+	db := exitGeoDB
+	addr, err := netip.ParseAddr(exitIP)
+	if db == nil || err != nil {
+		return nil
+	}
+	g := &ExitGeo{
+		ExitIP:          exitIP,
+		ExpectedCountry: state.locationCountry(location),
+		Verdict:         geoUnknown,
+		CheckedAt:       time.Now().UTC().Format(time.RFC3339),
+	}
+	country, city, network, err := db.geolocate(addr)
+	if err != nil {
+		log.Printf("tundler-tunnel: geolocating exit %s: %v", exitIP, err)
+		return g
+	}
+	g.Country, g.City = country, city
+	if country != "" {
+		g.Network = network.String()
+	}
+	switch {
+	case country == "" || g.ExpectedCountry == "":
+	case country == g.ExpectedCountry:
+		g.Verdict = geoMatch
+	default:
+		g.Verdict = geoMismatch
+	}
+	return g
+}
+
+// exitGeoPolicy is the live exit_geo_policy a rotation runs with; the
+// zero value flags.
+type exitGeoPolicy struct {
+	reject bool
+}
+
+func (c RuntimeConfig) exitGeoPolicy() exitGeoPolicy {
+	return exitGeoPolicy{reject: c.ExitGeoPolicy == policyReject}
+}
+
+// rejects reports why the policy turns an exit geolocated as g down, or
+// "" when it keeps it.
+func (p exitGeoPolicy) rejects(g *ExitGeo) string {
+	if !p.reject || g == nil || g.Verdict != geoMismatch {
+		return ""
+	}
+	return fmt.Sprintf("exit geolocates to %s, not %s", g.Country, g.ExpectedCountry)
+}
+
+// RecordExitGeo notes the geolocation of the exit about to be recorded
+// by RecordTunnelUp (nil when unchecked), and publishes a mismatch.
+func (s *StateTracker) RecordExitGeo(location string, g *ExitGeo) {
+	s.mu.Lock()
+	s.exitGeo = g
+	s.mu.Unlock()
+	if g != nil && g.Verdict == geoMismatch {
+		log.Printf("tundler-tunnel: exit %s at location=%s geolocates to %s, not %s (flagged)",
+			g.ExitIP, location, g.Country, g.ExpectedCountry)
+		s.Publish(eventExitGeoMismatch, map[string]any{
+			"exit_ip":          g.ExitIP,
+			"location":         location,
+			"country":          g.Country,
+			"expected_country": g.ExpectedCountry,
+		})
+	}
+}
+
+// ExitGeo returns the current exit's geolocation, nil when unchecked.
+func (s *StateTracker) ExitGeo() *ExitGeo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exitGeoLocked()
+}
+
+// exitGeoLocked is the recorded geolocation while it is still the
+// current exit's.
+func (s *StateTracker) exitGeoLocked() *ExitGeo {
+	if s.exitGeo == nil || s.exitGeo.ExitIP != s.currentExitIP {
+		return nil
+	}
+	g := *s.exitGeo
+	return &g
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// fakeGeoDB geolocates the exits it lists and errors on 0.0.0.0.
+type fakeGeoDB map[string]string
+
+func (f fakeGeoDB) geolocate(addr netip.Addr) (string, string, netip.Prefix, error) {
+	if addr.IsUnspecified() {
+		return "", "", netip.Prefix{}, errors.New("corrupt")
+	}
+	network, _ := addr.Prefix(24)
+	return f[addr.String()], "", network, nil
+}
+
+// useGeoDB installs db as exitGeoDB for the test.
+func useGeoDB(t *testing.T, db exitGeolocator) {
+	prev := exitGeoDB
+	exitGeoDB = db
+	t.Cleanup(func() { exitGeoDB = prev })
+}
+
+func TestCheckExitGeo(t *testing.T) {
+	st := NewStateTracker("fake")
+	if g := checkExitGeo(st, "Germany", "1.2.3.4"); g != nil {
+		t.Fatalf("no database: %+v", g)
+	}
+	useGeoDB(t, fakeGeoDB{"1.2.3.4": "DE", "5.6.7.8": "NL"})
+	for _, c := range []struct {
+		location, exit, verdict, country string
+	}{
+		{"Germany", "1.2.3.4", geoMatch, "DE"},
+		{"de-frankfurt", "5.6.7.8", geoMismatch, "NL"},
+		{"auto", "5.6.7.8", geoUnknown, "NL"},
+		{"Germany", "9.9.9.9", geoUnknown, ""},
+		{"Germany", "0.0.0.0", geoUnknown, ""},
+	} {
+		g := checkExitGeo(st, c.location, c.exit)
+		if g == nil || g.Verdict != c.verdict || g.Country != c.country || g.ExitIP != c.exit {
+			t.Errorf("%s via %s: %+v, want %s/%s", c.exit, c.location, g, c.verdict, c.country)
+		}
+	}
+	if g := checkExitGeo(st, "Germany", ""); g != nil {
+		t.Errorf("empty exit: %+v", g)
+	}
+}
+
+// reject turns a mismatched exit down like an avoided one; a pinned
+// location is retried rather than burned.
+func TestConnectWithRetry_RejectsExitCountry(t *testing.T) {
+	useGeoDB(t, fakeGeoDB{"5.6.7.8": "NL", "1.2.3.4": "DE"})
+	sp := newScriptedProvider([]string{"Germany"}, []bool{true, true}, []string{"5.6.7.8", "1.2.3.4"})
+	st := NewStateTracker("scripted")
+	req := RotateRequest{ExitGeo: exitGeoPolicy{reject: true}}
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, req, 3, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := st.SnapshotCurrentExitIP(); got != "1.2.3.4" || sp.attemptCount() != 2 {
+		t.Errorf("exit=%q after %d attempts, want 1.2.3.4 after 2", got, sp.attemptCount())
+	}
+	st.RecordRotation("", "1.2.3.4", "success", time.Second)
+	snap := st.Snapshot()
+	if r := snap.LastRotation.RejectedExits; len(r) != 1 || r[0].Reason != rejectCountry || r[0].Detail != "exit geolocates to NL, not DE" {
+		t.Errorf("rejected_exits=%+v", r)
+	}
+	if g := snap.ExitGeo; g == nil || g.Verdict != geoMatch || g.ExitIP != "1.2.3.4" {
+		t.Errorf("exit_geo=%+v, want the kept exit's match", g)
+	}
+	if evs := eventsOfType(st, eventExitGeoMismatch); len(evs) != 0 {
+		t.Errorf("a rejected exit was flagged too: %+v", evs)
+	}
+}
+
+// flag keeps the exit, reports the mismatch and drops it with the exit.
+func TestConnectWithRetry_FlagsExitCountry(t *testing.T) {
+	useGeoDB(t, fakeGeoDB{"5.6.7.8": "NL"})
+	sp := newScriptedProvider([]string{"Germany"}, []bool{true}, []string{"5.6.7.8"})
+	st := NewStateTracker("scripted")
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	g := st.Snapshot().ExitGeo
+	if g == nil || g.Verdict != geoMismatch || g.Country != "NL" || g.ExpectedCountry != "DE" || g.Network != "5.6.7.0/24" {
+		t.Fatalf("exit_geo=%+v", g)
+	}
+	if evs := eventsOfType(st, eventExitGeoMismatch); len(evs) != 1 || evs[0].Data["country"] != "NL" {
+		t.Errorf("exit_geo_mismatch events=%+v", evs)
+	}
+	// An exit recorded without a check (no database by then) isn't
+	// reported with the old exit's result.
+	st.RecordTunnelUp("Germany", "9.9.9.9")
+	if g := st.ExitGeo(); g != nil {
+		t.Errorf("stale exit_geo: %+v", g)
+	}
+}
+
+func TestRuntimeConfig_ExitGeoPolicy(t *testing.T) {
+	c := defaultRuntimeConfig()
+	c.ExitGeoPolicy = "drop"
+	if err := c.validate(); err == nil {
+		t.Error("exit_geo_policy=drop validated")
+	}
+	c.ExitGeoPolicy = policyReject
+	if err := c.validate(); err != nil || !c.exitGeoPolicy().reject {
+		t.Errorf("reject: %v, %+v", err, c.exitGeoPolicy())
+	}
+}
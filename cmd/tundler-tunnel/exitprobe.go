@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/provider"
+)
+
+// Exit-IP re-verification. The contract check (verifyExitIPDiffers) runs
+// once per connect, but a provider can move the tunnel to another server
+// mid-life, or routes can fall back to eth0, and /status would keep
+// showing the exit from connect time. runExitReprobe repeats the probe
+// while the pod is Ready, every exit_reprobe_interval_seconds (±20 %),
+// through the same path crawler traffic takes (probeContractEgressIP):
+//
+//	observed == baseline → leak: exit_changed (leak=true), then reconnect
+//	                       at once, like the watchdog; Failed if that fails
+//	observed != current  → the provider moved us: RecordTunnelUp with the
+//	                       new exit (new session and generation, fresh
+//	                       x-tundler-exit-ip), exit_changed
+//	observed == current  → refreshes contract_probe's age in /readyz
+//	probe error          → logged; tried again next interval
+//
+// A rotation or reconnect that lands while a probe is in flight wins:
+// the result is dropped when the tunnel generation moved.
+
+// exitReprobeJitter spreads probes ±20 % so a fleet doesn't hit the
+// probe endpoint in lockstep.
+const exitReprobeJitter = 0.2
+
+// Outcomes of one re-probe, logged and returned for tests.
+const (
+	reprobeSkipped   = "skipped"
+	reprobeError     = "error"
+	reprobeUnchanged = "unchanged"
+	reprobeChanged   = "changed"
+	reprobeLeak      = "leak"
+)
+
+// nextReprobe is interval jittered by ±exitReprobeJitter.
+func nextReprobe(interval time.Duration) time.Duration {
+	f := 1 + exitReprobeJitter*(2*rand.Float64()-1)
+	return time.Duration(float64(interval) * f)
+}
+
+// runExitReprobe re-verifies the exit on a jittered interval read live
+// from cfg (0 idles the loop until re-enabled). probe is
+// probeContractEgressIP in production.
+func runExitReprobe(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, cfg *liveConfig, baselineEgressIP string, probe func(context.Context) (string, error)) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	// Take the change channel before the first read so an Apply in
+	// between is never missed.
+	changed := cfg.Changed()
+	arm := func() {
+		timer.Stop()
+		if interval := cfg.Get().ExitReprobeInterval.d(); interval > 0 {
+			timer.Reset(nextReprobe(interval))
+		}
+	}
+	arm()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			changed = cfg.Changed()
+			arm()
+		case <-timer.C:
+			reprobeExit(ctx, prov, state, providerName, cfg.Get().locationFilter(), baselineEgressIP, probe)
+			arm()
+		}
+	}
+}
+
+// reprobeExit runs one re-verification and acts on it; see the table
+// above.
+func reprobeExit(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, baselineEgressIP string, probe func(context.Context) (string, error)) string {
+	if state.Get() != StateReady || state.ShuttingDown() {
+		return reprobeSkipped
+	}
+	previous, generation := state.CurrentTunnel()
+	observed, err := probe(ctx)
+	if err != nil {
+		log.Printf("tundler-tunnel: exit re-probe: %v (next interval)", err)
+		return reprobeError
+	}
+	if current, gen := state.CurrentTunnel(); gen != generation || current != previous || state.Get() != StateReady {
+		return reprobeSkipped
+	}
+	location := state.Snapshot().CurrentLocation
+
+	if baselineEgressIP != "" && observed == baselineEgressIP {
+		state.RecordContractProbe("", errExitIPLeak)
+		log.Printf("tundler-tunnel: exit re-probe: LEAK — egress is the pre-VPN baseline %s (recorded exit %s); reconnecting",
+			observed, previous)
+		state.Publish(eventExitChanged, map[string]any{
+			"previous_exit_ip": previous,
+			"exit_ip":          observed,
+			"location":         location,
+			"leak":             true,
+		})
+		state.RecordDisconnect(triggerExitReprobe)
+		state.BeginConnect(triggerExitReprobe)
+		if err := connectTunnel(ctx, prov, state, providerName, filter, baselineEgressIP); err != nil {
+			log.Printf("tundler-tunnel: exit re-probe reconnect failed: %v (watchdog takes over)", err)
+			state.RecordConnectFailure(err)
+			state.Transition(StateFailed, "exit-ip leak; reconnect failed: "+err.Error())
+		}
+		return reprobeLeak
+	}
+
+	state.RecordContractProbe(observed, nil)
+	if observed == previous {
+		return reprobeUnchanged
+	}
+	log.Printf("tundler-tunnel: exit re-probe: exit moved %s → %s (location=%s)", previous, observed, location)
+	state.RecordDisconnect(triggerExitReprobe)
+	state.BeginConnect(triggerExitReprobe)
+	state.RecordExitGeo(location, checkExitGeo(state, location, observed))
+	state.RecordExitASN(lookupExitASN(observed))
+	state.RecordTunnelUp(location, observed)
+	state.Publish(eventExitChanged, map[string]any{
+		"previous_exit_ip": previous,
+		"exit_ip":          observed,
+		"location":         location,
+		"leak":             false,
+	})
+	return reprobeChanged
+}
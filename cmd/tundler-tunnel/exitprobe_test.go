@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func stubProbe(ip string, err error) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) { return ip, err }
+}
+
+func readyTracker() *StateTracker {
+	st := NewStateTracker("fake")
+	st.BeginConnect(triggerBoot)
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+	return st
+}
+
+func eventsOfType(st *StateTracker, typ string) []Event {
+	replay, _, _, cancel := st.Events().Subscribe(0)
+	cancel()
+	var out []Event
+	for _, ev := range replay {
+		if ev.Type == typ {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestReprobeExit_ExitMoved(t *testing.T) {
+	st := readyTracker()
+	_, gen := st.CurrentTunnel()
+	got := reprobeExit(context.Background(), &fakeProvider{}, st, "fake", nil, "9.9.9.9", stubProbe("5.6.7.8", nil))
+	if got != reprobeChanged {
+		t.Fatalf("outcome=%s, want changed", got)
+	}
+	exit, next := st.CurrentTunnel()
+	if exit != "5.6.7.8" || next != gen+1 || st.Get() != StateReady {
+		t.Errorf("exit=%s generation=%d state=%s, want Ready on 5.6.7.8 with a new generation", exit, next, st.Get())
+	}
+	if h := st.History(); len(h) != 2 || h[0].EndedBy != triggerExitReprobe || h[1].Trigger != triggerExitReprobe || h[1].Location != "USA" {
+		t.Errorf("history=%+v, want the old session ended and a new one opened by exit_reprobe", h)
+	}
+	evs := eventsOfType(st, eventExitChanged)
+	if len(evs) != 1 || evs[0].Data["previous_exit_ip"] != "1.2.3.4" || evs[0].Data["exit_ip"] != "5.6.7.8" || evs[0].Data["leak"] != false {
+		t.Errorf("exit_changed events=%+v", evs)
+	}
+}
+
+func TestReprobeExit_Unchanged(t *testing.T) {
+	st := readyTracker()
+	_, gen := st.CurrentTunnel()
+	if got := reprobeExit(context.Background(), &fakeProvider{}, st, "fake", nil, "9.9.9.9", stubProbe("1.2.3.4", nil)); got != reprobeUnchanged {
+		t.Fatalf("outcome=%s, want unchanged", got)
+	}
+	if _, next := st.CurrentTunnel(); next != gen || len(eventsOfType(st, eventExitChanged)) != 0 {
+		t.Error("an unchanged exit opened a new tunnel or published exit_changed")
+	}
+	if st.contractProbeOutcome != contractPassed {
+		t.Errorf("contract outcome=%q, want passed", st.contractProbeOutcome)
+	}
+	if got := reprobeExit(context.Background(), &fakeProvider{}, st, "fake", nil, "9.9.9.9", stubProbe("", errors.New("timeout"))); got != reprobeError {
+		t.Errorf("probe error: outcome=%s, want error", got)
+	}
+}
+
+// The baseline coming back is a leak: the pod reconnects at once, and a
+// failed reconnect hands it to the watchdog.
+func TestReprobeExit_LeakReconnects(t *testing.T) {
+	st := readyTracker()
+	fp := &fakeProvider{locations: []string{"USA"}}
+	if got := reprobeExit(context.Background(), fp, st, "fake", nil, "9.9.9.9", stubProbe("9.9.9.9", nil)); got != reprobeLeak {
+		t.Fatalf("outcome=%s, want leak", got)
+	}
+	if len(fp.calls) != 1 || st.Get() != StateFailed {
+		t.Errorf("connects=%v state=%s, want one reconnect attempt, then Failed", fp.calls, st.Get())
+	}
+	if h := st.History(); len(h) != 2 || h[0].EndedBy != triggerExitReprobe || h[1].Outcome != "failed" {
+		t.Errorf("history=%+v, want the leaking session ended by exit_reprobe and a failed reconnect", h)
+	}
+	if evs := eventsOfType(st, eventExitChanged); len(evs) != 1 || evs[0].Data["leak"] != true {
+		t.Errorf("exit_changed events=%+v, want one with leak=true", evs)
+	}
+}
+
+func TestReprobeExit_SkipsUnlessReady(t *testing.T) {
+	st := readyTracker()
+	st.Set(StateParked)
+	if got := reprobeExit(context.Background(), &fakeProvider{}, st, "fake", nil, "", stubProbe("5.6.7.8", nil)); got != reprobeSkipped {
+		t.Errorf("Parked: outcome=%s, want skipped", got)
+	}
+
+	// A rotation landing mid-probe wins.
+	st = readyTracker()
+	probe := func(context.Context) (string, error) {
+		st.RecordTunnelUp("UK", "7.7.7.7")
+		return "5.6.7.8", nil
+	}
+	if got := reprobeExit(context.Background(), &fakeProvider{}, st, "fake", nil, "", probe); got != reprobeSkipped {
+		t.Errorf("rotated mid-probe: outcome=%s, want skipped", got)
+	}
+	if exit, _ := st.CurrentTunnel(); exit != "7.7.7.7" {
+		t.Errorf("exit=%s, want the rotation's 7.7.7.7 kept", exit)
+	}
+}
+
+func TestRunExitReprobe_FollowsInterval(t *testing.T) {
+	c := defaultRuntimeConfig()
+	c.ExitReprobeInterval = 0
+	cfg := newLiveConfig(c, "env")
+	st := readyTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runExitReprobe(ctx, &fakeProvider{}, st, "fake", cfg, "", stubProbe("5.6.7.8", nil))
+
+	time.Sleep(30 * time.Millisecond)
+	if exit, _ := st.CurrentTunnel(); exit != "1.2.3.4" {
+		t.Fatalf("exit=%s while re-probing is off, want unchanged", exit)
+	}
+	c.ExitReprobeInterval = seconds(5 * time.Millisecond)
+	if err := cfg.Apply(c, "api"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if exit, _ := st.CurrentTunnel(); exit == "5.6.7.8" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("exit not re-probed after enabling the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNextReprobe_Jitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := nextReprobe(100 * time.Second); d < 80*time.Second || d > 120*time.Second {
+			t.Fatalf("nextReprobe(100s)=%s, want within ±20%%", d)
+		}
+	}
+}
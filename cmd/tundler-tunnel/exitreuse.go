@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Exit reuse. A rotation that lands back on the exit it just left — or
+// on that exit's /24 — hasn't rotated as far as a block is concerned.
+// connectWithRetry checks every new exit against the pod's last
+// recent_exit_ips exits (StateTracker.recentExitIPs, persisted across
+// respawns by persist.go) and turns a subnet match into a rejected
+// attempt, like an avoid_exit_ips hit: disconnect, burn the location
+// while others remain, retry within ROTATION_RETRY_MAX.
+//
+// The last attempt keeps a matching exit instead of failing the
+// rotation: a repeat exit beats Failed, which the watchdog would end by
+// reconnecting without this check anyway.
+
+// Rejection reasons (ExitRejection.Reason).
+const (
+	rejectAvoided = "avoid_exit_ips"
+	rejectRecent  = "recent_exit"
+	rejectCountry = "exit_country" // exitgeo.go
+	rejectASN     = "exit_asn"     // exitasn.go
+)
+
+// recentExitPolicy is the live recent_exit_* config a rotation runs
+// with; the zero value checks nothing.
+type recentExitPolicy struct {
+	keep               int
+	prefixV4, prefixV6 int
+}
+
+func (c RuntimeConfig) recentExitPolicy() recentExitPolicy {
+	return recentExitPolicy{keep: c.RecentExitIPs, prefixV4: c.RecentExitPrefixV4, prefixV6: c.RecentExitPrefixV6}
+}
+
+// match reports why exitIP counts as a reuse of one of the recent exits
+// (oldest first), or "" when it doesn't.
+func (p recentExitPolicy) match(exitIP string, recent []string) string {
+	addr, err := netip.ParseAddr(exitIP)
+	if p.keep == 0 || err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := p.prefixV4
+	if addr.Is6() {
+		bits = p.prefixV6
+	}
+	subnet, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	if len(recent) > p.keep {
+		recent = recent[len(recent)-p.keep:]
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		prev, err := netip.ParseAddr(recent[i])
+		if err != nil || !subnet.Contains(prev.Unmap()) {
+			continue
+		}
+		if prev.Unmap() == addr {
+			return fmt.Sprintf("exit %s served this pod %d exit(s) ago", exitIP, len(recent)-i)
+		}
+		return fmt.Sprintf("%s is in %s with %s, which served this pod %d exit(s) ago", exitIP, subnet, recent[i], len(recent)-i)
+	}
+	return ""
+}
+
+// RecentExitIPs returns the pod's last distinct exits, newest last.
+func (s *StateTracker) RecentExitIPs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.recentExitIPs...)
+}
+
+// RecordExitRejection notes a tunnel the rotation in progress turned
+// down; RecordRotation attaches the list to the rotation record.
+func (s *StateTracker) RecordExitRejection(r ExitRejection) {
+	s.mu.Lock()
+	s.exitRejections = append(s.exitRejections, r)
+	s.mu.Unlock()
+	s.Publish(eventExitRejected, map[string]any{
+		"exit_ip":  r.ExitIP,
+		"location": r.Location,
+		"reason":   r.Reason,
+		"detail":   r.Detail,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecentExitPolicy_Match(t *testing.T) {
+	p := recentExitPolicy{keep: 3, prefixV4: 24, prefixV6: 64}
+	recent := []string{"7.7.7.7", "1.2.3.4", "5.6.7.8", "2001:db8:1::1"}
+	for _, tc := range []struct {
+		exit string
+		want string // substring of the reason; "" = no match
+	}{
+		{"1.2.3.4", "served this pod 3 exit(s) ago"},
+		{"1.2.3.77", "1.2.3.0/24 with 1.2.3.4"},
+		{"5.6.7.8", "2 exit(s) ago"},
+		{"1.2.4.4", ""},
+		{"7.7.7.7", ""}, // older than keep
+		{"2001:db8:1::ffff", "2001:db8:1::/64"},
+		{"2001:db8:2::1", ""},
+		{"::ffff:1.2.3.9", "1.2.3.0/24"},
+		{"", ""},
+	} {
+		got := p.match(tc.exit, recent)
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("match(%q)=%q, want %q", tc.exit, got, tc.want)
+		}
+	}
+	if got := (recentExitPolicy{}).match("1.2.3.4", recent); got != "" {
+		t.Errorf("zero policy matched: %q", got)
+	}
+}
+
+// A new exit in the /24 of a recent one is rejected like an avoided
+// exit, and the rejection lands on the rotation record.
+func TestConnectWithRetry_RejectsRecentExit(t *testing.T) {
+	sp := newScriptedProvider(
+		[]string{"USA", "UK"},
+		[]bool{true, true},
+		[]string{"1.2.3.77", "5.6.7.8"},
+	)
+	st := NewStateTracker("scripted")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	req := RotateRequest{RecentExits: defaultRuntimeConfig().recentExitPolicy()}
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, req, 3, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := st.SnapshotCurrentExitIP(); got != "5.6.7.8" || sp.attemptCount() != 2 {
+		t.Errorf("exit=%q after %d attempts, want 5.6.7.8 after 2", got, sp.attemptCount())
+	}
+	st.RecordRotation("1.2.3.4", "5.6.7.8", "success", time.Second)
+	rec := st.Snapshot().LastRotation
+	if len(rec.RejectedExits) != 1 || rec.RejectedExits[0].ExitIP != "1.2.3.77" || rec.RejectedExits[0].Reason != rejectRecent {
+		t.Errorf("rejected_exits=%+v, want 1.2.3.77 as recent_exit", rec.RejectedExits)
+	}
+	if evs := eventsOfType(st, eventExitRejected); len(evs) != 1 || evs[0].Data["reason"] != rejectRecent {
+		t.Errorf("exit_rejected events=%+v", evs)
+	}
+	// The next rotation starts with a clean list.
+	st.RecordRotation("5.6.7.8", "9.9.9.9", "success", time.Second)
+	if rec := st.Snapshot().LastRotation; len(rec.RejectedExits) != 0 {
+		t.Errorf("rejections carried over: %+v", rec.RejectedExits)
+	}
+}
+
+// The last attempt keeps a recent exit rather than failing the rotation.
+func TestConnectWithRetry_KeepsRecentExitOnLastAttempt(t *testing.T) {
+	sp := newScriptedProvider(
+		[]string{"USA"},
+		[]bool{true, true},
+		[]string{"1.2.3.5", "1.2.3.6"},
+	)
+	st := NewStateTracker("scripted")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	req := RotateRequest{RecentExits: defaultRuntimeConfig().recentExitPolicy()}
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, req, 2, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := st.SnapshotCurrentExitIP(); got != "1.2.3.6" {
+		t.Errorf("exit=%q, want 1.2.3.6 kept on the last attempt", got)
+	}
+	if evs := eventsOfType(st, eventExitRejected); len(evs) != 1 {
+		t.Errorf("%d rejections, want only the first attempt's", len(evs))
+	}
+}
+
+func TestRuntimeConfig_RecentExitBounds(t *testing.T) {
+	for _, mutate := range []func(*RuntimeConfig){
+		func(c *RuntimeConfig) { c.RecentExitIPs = recentExitIPsKept + 1 },
+		func(c *RuntimeConfig) { c.RecentExitIPs = -1 },
+		func(c *RuntimeConfig) { c.RecentExitPrefixV4 = 33 },
+		func(c *RuntimeConfig) { c.RecentExitPrefixV6 = 0 },
+	} {
+		c := defaultRuntimeConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
+	"github.com/laurentpellegrino/tundler/tunnelapi"
+)
+
+// Structured probes. /livez and /readyz keep answering kubelet with bare
+// status codes; ?verbose adds a JSON ProbeReport naming every check and
+// why it failed:
+//
+//	readiness  state           state == Ready
+//	           draining        not shutting down, proxy accepting CONNECTs
+//	           listener        the CONNECT proxy holds its listener
+//	           last_dial       no sustained upstream dial failures (the
+//	                           watchdog's own tunnelLooksHealthy rule)
+//	           contract_probe  the last exit-IP contract check found no leak
+//	liveness   watchdog, rotator, wedge_guard
+//	                           the goroutine beat within its announced budget
+//	           control_loop    not stuck in a transitional state
+//
+// Liveness still never looks at the tunnel itself: a down VPN is the
+// wedge guard's job (runWedgeGuard), a restart would only add a re-login.
+// It fails only when a goroutine that heals the tunnel has stopped, which
+// nothing in-process can recover from.
+
+type (
+	ProbeReport = tunnelapi.ProbeReport
+	ProbeCheck  = tunnelapi.ProbeCheck
+)
+
+// Loops that beat (StateTracker.Heartbeat). A loop that has not started
+// yet (the boot connect is still running) is reported, not failed.
+const (
+	loopWatchdog   = "watchdog"
+	loopRotator    = "rotator"
+	loopWedgeGuard = "wedge_guard"
+)
+
+var livenessLoops = []string{loopWatchdog, loopRotator, loopWedgeGuard}
+
+const (
+	// heartbeatInterval is how often an otherwise idle loop beats.
+	heartbeatInterval = 30 * time.Second
+	// heartbeatGrace is the slack past a loop's announced budget before
+	// it counts as stuck.
+	heartbeatGrace = time.Minute
+	// controlLoopStuckAfter bounds how long the pod may sit in
+	// LoggingIn, Connecting, Draining or Rotating without a single state
+	// update. Every retry loop re-sets its state on each attempt, at
+	// most bootConnectBackoff's 5 min apart, so only a hung provider
+	// call gets this far.
+	controlLoopStuckAfter = 30 * time.Minute
+)
+
+// heartbeat is a loop's last beat and how soon it promised the next.
+type heartbeat struct {
+	at     time.Time
+	within time.Duration
+}
+
+// Heartbeat records that loop is running and will beat again within
+// next: its tick, or the budget of the blocking call it is about to make.
+func (s *StateTracker) Heartbeat(loop string, next time.Duration) {
+	s.mu.Lock()
+	if s.heartbeats == nil {
+		s.heartbeats = make(map[string]heartbeat)
+	}
+	s.heartbeats[loop] = heartbeat{at: time.Now(), within: next}
+	s.mu.Unlock()
+}
+
+// Contract-probe outcomes, as recorded by RecordContractProbe.
+const (
+	contractPassed     = "passed"
+	contractUnverified = "unverified" // no baseline, or the probe errored / found no quorum
+	contractLeak       = "leak"
+)
+
+// RecordContractProbe records the outcome of a post-connect exit-IP
+// contract check (verifyExitIPDiffers): observed is the probed exit, ""
+// when the check was skipped or soft-passed; err a confirmed leak, or
+// errExitIPUnverified in strict mode.
+func (s *StateTracker) RecordContractProbe(observed string, err error) {
+	outcome := contractPassed
+	switch {
+	case errors.Is(err, errExitIPUnverified):
+		outcome = contractUnverified
+	case err != nil:
+		outcome = contractLeak
+	case observed == "":
+		outcome = contractUnverified
+	}
+	s.mu.Lock()
+	s.contractProbeAt = time.Now()
+	s.contractProbeOutcome = outcome
+	s.mu.Unlock()
+}
+
+// dataPlane is what the readiness checks read off the CONNECT proxy
+// (*proxy.Server). nil skips the listener and last_dial checks.
+type dataPlane interface {
+	Listening() bool
+	IsDraining() bool
+	RecentTunnelHealth() proxy.TunnelHealth
+}
+
+// readinessChecks evaluates the readiness checks, in report order.
+func readinessChecks(state *StateTracker, dp dataPlane) []ProbeCheck {
+	now := time.Now()
+	s := state.Get()
+	checks := make([]ProbeCheck, 0, 5)
+
+	draining := ProbeCheck{Name: "draining", OK: true, Detail: "accepting"}
+	state.mu.RLock()
+	shutdownSince := state.shutdownSince
+	contractAt, contractOutcome := state.contractProbeAt, state.contractProbeOutcome
+	state.mu.RUnlock()
+	switch {
+	case !shutdownSince.IsZero():
+		draining = ProbeCheck{Name: "draining", Detail: "shutting down", AgeSeconds: now.Sub(shutdownSince).Seconds()}
+	case dp != nil && dp.IsDraining():
+		draining = ProbeCheck{Name: "draining", Detail: "proxy refusing new CONNECTs (state=" + string(s) + ")"}
+	}
+	checks = append(checks, draining,
+		ProbeCheck{Name: "state", OK: s == StateReady, Detail: "state=" + string(s)})
+
+	if dp != nil {
+		listener := ProbeCheck{Name: "listener", OK: dp.Listening(), Detail: "proxy listening"}
+		if !listener.OK {
+			listener.Detail = "proxy not listening"
+		}
+		h := dp.RecentTunnelHealth()
+		dial := ProbeCheck{Name: "last_dial", OK: dialsLookHealthy(h, now)}
+		switch {
+		case h.LastDialAt.IsZero():
+			dial.Detail = "no dial yet"
+		case h.LastSuccessAt.IsZero():
+			dial.Detail = fmt.Sprintf("no successful dial yet; %d consecutive failures", h.ConsecutiveFailures)
+		default:
+			dial.AgeSeconds = now.Sub(h.LastSuccessAt).Seconds()
+			dial.Detail = fmt.Sprintf("last successful dial %s ago; %d consecutive failures",
+				now.Sub(h.LastSuccessAt).Round(time.Second), h.ConsecutiveFailures)
+		}
+		checks = append(checks, listener, dial)
+	}
+
+	contract := ProbeCheck{Name: "contract_probe", OK: contractOutcome != contractLeak, Detail: "not run yet"}
+	if !contractAt.IsZero() {
+		contract.AgeSeconds = now.Sub(contractAt).Seconds()
+		contract.Detail = fmt.Sprintf("%s %s ago", contractOutcome, now.Sub(contractAt).Round(time.Second))
+	}
+	return append(checks, contract)
+}
+
+// livenessChecks evaluates the liveness checks, in report order.
+func livenessChecks(state *StateTracker) []ProbeCheck {
+	now := time.Now()
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	checks := make([]ProbeCheck, 0, len(livenessLoops)+1)
+	for _, loop := range livenessLoops {
+		hb, ok := state.heartbeats[loop]
+		if !ok {
+			checks = append(checks, ProbeCheck{Name: loop, OK: true, Detail: "not started"})
+			continue
+		}
+		age := now.Sub(hb.at)
+		c := ProbeCheck{Name: loop, OK: age <= hb.within+heartbeatGrace, AgeSeconds: age.Seconds()}
+		c.Detail = fmt.Sprintf("last beat %s ago (budget %s)", age.Round(time.Second), hb.within)
+		checks = append(checks, c)
+	}
+
+	loop := ProbeCheck{Name: "control_loop", OK: true, Detail: "state=" + string(state.state)}
+	switch state.state {
+	case StateLoggingIn, StateConnecting, StateDraining, StateRotating:
+		idle := now.Sub(state.lastProgressAt)
+		loop.AgeSeconds = idle.Seconds()
+		loop.Detail = fmt.Sprintf("state=%s, no progress for %s", state.state, idle.Round(time.Second))
+		loop.OK = idle <= controlLoopStuckAfter
+	}
+	return append(checks, loop)
+}
+
+// probeReport folds checks into the report; the first failed check
+// names the reason for the plain-text body.
+func probeReport(checks []ProbeCheck) (ProbeReport, string) {
+	r := ProbeReport{OK: true, Checks: checks}
+	var reason string
+	for _, c := range checks {
+		if !c.OK && r.OK {
+			r.OK, reason = false, c.Detail
+		}
+	}
+	return r, reason
+}
+
+// writeProbe answers a probe: a bare 200 / 503-with-reason for kubelet,
+// the full report with ?verbose.
+func writeProbe(w http.ResponseWriter, r *http.Request, checks []ProbeCheck, failure string) {
+	report, reason := probeReport(checks)
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	if r.URL.Query().Has("verbose") {
+		writeJSON(w, status, report)
+		return
+	}
+	if !report.OK {
+		http.Error(w, failure+": "+reason, status)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// dialsLookHealthy is tunnelLooksHealthy on a health snapshot taken at
+// now.
+func dialsLookHealthy(h proxy.TunnelHealth, now time.Time) bool {
+	if h.LastDialAt.IsZero() || now.Sub(h.LastDialAt) > dialSilenceWindow {
+		return true
+	}
+	if h.LastDialSucceeded {
+		return true
+	}
+	return h.ConsecutiveFailures < dialFailureThreshold
+}
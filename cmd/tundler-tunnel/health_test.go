@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
+)
+
+// stubDataPlane stands in for the CONNECT proxy in readiness checks.
+type stubDataPlane struct {
+	listening, draining bool
+	health              proxy.TunnelHealth
+}
+
+func (d *stubDataPlane) Listening() bool                        { return d.listening }
+func (d *stubDataPlane) IsDraining() bool                       { return d.draining }
+func (d *stubDataPlane) RecentTunnelHealth() proxy.TunnelHealth { return d.health }
+
+func probe(t *testing.T, h http.HandlerFunc, target string) (int, string, ProbeReport) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	var report ProbeReport
+	if strings.Contains(target, "verbose") {
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: %v (%s)", target, err, rr.Body)
+		}
+	}
+	return rr.Code, strings.TrimSpace(rr.Body.String()), report
+}
+
+func failedChecks(r ProbeReport) []string {
+	var out []string
+	for _, c := range r.Checks {
+		if !c.OK {
+			out = append(out, c.Name)
+		}
+	}
+	return out
+}
+
+func TestReadyzChecks(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordContractProbe("5.6.7.8", nil)
+	st.Set(StateReady)
+	dp := &stubDataPlane{listening: true}
+	h := readyzHandler(st, dp)
+
+	if code, body, _ := probe(t, h, "/readyz"); code != http.StatusOK || body != "" {
+		t.Fatalf("ready: got %d %q, want a bare 200", code, body)
+	}
+	code, _, report := probe(t, h, "/readyz?verbose")
+	var names []string
+	for _, c := range report.Checks {
+		names = append(names, c.Name)
+	}
+	if code != http.StatusOK || !report.OK || strings.Join(names, ",") != "draining,state,listener,last_dial,contract_probe" {
+		t.Fatalf("verbose: got %d %+v, want 200 with all five checks passing", code, report)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		breaks func()
+		check  string
+		reason string
+	}{
+		{"listener", func() { dp.listening = false }, "listener", "proxy not listening"},
+		{"dial failures", func() {
+			dp.health = proxy.TunnelHealth{LastDialAt: time.Now(), LastSuccessAt: time.Now().Add(-time.Hour), ConsecutiveFailures: dialFailureThreshold}
+		}, "last_dial", "last successful dial 1h0m0s ago"},
+		{"leak", func() { st.RecordContractProbe("", errExitIPLeak) }, "contract_probe", "leak"},
+		{"state", func() { st.Set(StateRotating) }, "state", "state=Rotating"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			*dp = stubDataPlane{listening: true}
+			st.RecordContractProbe("5.6.7.8", nil)
+			st.Set(StateReady)
+			tc.breaks()
+			code, body, _ := probe(t, h, "/readyz")
+			if code != http.StatusServiceUnavailable || !strings.Contains(body, tc.reason) {
+				t.Errorf("plain: got %d %q, want 503 naming %q", code, body, tc.reason)
+			}
+			if _, _, report := probe(t, h, "/readyz?verbose"); report.OK || strings.Join(failedChecks(report), ",") != tc.check {
+				t.Errorf("verbose: failed=%v, want only %s", failedChecks(report), tc.check)
+			}
+		})
+	}
+
+	// Shutting down wins over everything, as before.
+	*dp = stubDataPlane{listening: true}
+	st.Set(StateReady)
+	st.BeginShutdown("test", time.Second)
+	if code, body, _ := probe(t, h, "/readyz"); code != http.StatusServiceUnavailable || body != "not ready: shutting down" {
+		t.Errorf("shutting down: got %d %q", code, body)
+	}
+}
+
+func TestLivezChecks(t *testing.T) {
+	st := NewStateTracker("fake")
+	h := livezHandler(st)
+	if code, _, report := probe(t, h, "/livez?verbose"); code != http.StatusOK || len(report.Checks) != 4 {
+		t.Fatalf("fresh: got %d %+v, want 200 with loops not started yet", code, report)
+	}
+
+	st.Heartbeat(loopRotator, heartbeatInterval)
+	st.mu.Lock()
+	st.heartbeats[loopWatchdog] = heartbeat{at: time.Now().Add(-time.Hour), within: heartbeatInterval}
+	st.mu.Unlock()
+	code, body, _ := probe(t, h, "/livez")
+	if code != http.StatusServiceUnavailable || !strings.HasPrefix(body, "not alive: last beat 1h0m0s ago") {
+		t.Errorf("stale watchdog: got %d %q, want 503 naming the missed beat", code, body)
+	}
+	if _, _, report := probe(t, h, "/livez?verbose"); strings.Join(failedChecks(report), ",") != loopWatchdog {
+		t.Errorf("failed=%v, want only the watchdog", failedChecks(report))
+	}
+	st.Heartbeat(loopWatchdog, heartbeatInterval)
+
+	// A transitional state without progress is a stuck control loop;
+	// Failed is not (the watchdog owns it).
+	st.Set(StateConnecting)
+	st.mu.Lock()
+	st.lastProgressAt = time.Now().Add(-controlLoopStuckAfter - time.Minute)
+	st.mu.Unlock()
+	if _, _, report := probe(t, h, "/livez?verbose"); strings.Join(failedChecks(report), ",") != "control_loop" {
+		t.Errorf("stuck Connecting: failed=%v, want control_loop", failedChecks(report))
+	}
+	st.Set(StateFailed)
+	st.mu.Lock()
+	st.lastProgressAt = time.Now().Add(-controlLoopStuckAfter - time.Minute)
+	st.mu.Unlock()
+	if code, _, _ := probe(t, h, "/livez"); code != http.StatusOK {
+		t.Errorf("long Failed: got %d, want 200", code)
+	}
+}
+
+func TestRecordContractProbe(t *testing.T) {
+	st := NewStateTracker("fake")
+	for _, tc := range []struct {
+		observed string
+		err      error
+		want     string
+	}{
+		{"5.6.7.8", nil, contractPassed},
+		{"", nil, contractUnverified},
+		{"", errors.New("leak"), contractLeak},
+		{"", fmt.Errorf("%w: no quorum", errExitIPUnverified), contractUnverified},
+	} {
+		st.RecordContractProbe(tc.observed, tc.err)
+		if st.contractProbeOutcome != tc.want {
+			t.Errorf("observed=%q err=%v: outcome=%s, want %s", tc.observed, tc.err, st.contractProbeOutcome, tc.want)
+		}
+	}
+}
+
+// The loops beat from the moment they start.
+func TestLoopsHeartbeat(t *testing.T) {
+	st := NewStateTracker("fake")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := watchdogConfig(time.Hour)
+	go runWatchdog(ctx, &fakeProvider{}, st, "fake", cfg, proxy.New("", "", ""), "")
+	go runRotator(ctx, &fakeProvider{}, st, "fake", cfg, nil, "")
+	go runWedgeGuard(ctx, st, cfg)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st.mu.RLock()
+		n := len(st.heartbeats)
+		st.mu.RUnlock()
+		if n == len(livenessLoops) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d loops beat", n, len(livenessLoops))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, _, report := probe(t, livezHandler(st), "/livez?verbose"); !report.OK {
+		t.Errorf("report=%+v, want every loop live", report)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionHistorySize bounds GET /history. At the default 2-4 h rotation
+// window that is weeks of sessions; a flapping pod rolls over sooner,
+// which is fine — the recent past is what gets asked about.
+const sessionHistorySize = 200
+
+// Session triggers: what opened a session (or failed to), and what
+// ended one.
+const (
+	triggerBoot       = "boot"
+	triggerScheduled  = "scheduled"
+	triggerAPI        = "api"
+	triggerWatchdog   = "watchdog"
+	triggerRecycle    = "recycle"
+	triggerShutdown   = "shutdown"
+	triggerWedgeGuard = "wedge_guard"
+	triggerPark       = "park"
+	// triggerExitReprobe: the background exit re-probe found the exit
+	// moved (or leaking) under a live tunnel (exitprobe.go).
+	triggerExitReprobe = "exit_reprobe"
+)
+
+// SessionRecord is one tunnel session in GET /history: the tunnel from
+// connect to disconnect, or a connect batch that never produced one
+// (outcome "failed", no location/exit IP/connected_at).
+type SessionRecord struct {
+	Location       string `json:"location,omitempty"`
+	ExitIP         string `json:"exit_ip,omitempty"`
+	StartedAt      string `json:"started_at,omitempty"` // first connect attempt of the batch
+	ConnectedAt    string `json:"connected_at,omitempty"`
+	DisconnectedAt string `json:"disconnected_at,omitempty"` // empty while current
+	Trigger        string `json:"trigger"`                   // boot, scheduled, api, watchdog
+	EndedBy        string `json:"ended_by,omitempty"`        // scheduled, api, watchdog, recycle, shutdown, wedge_guard, park, exit_reprobe
+	Outcome        string `json:"outcome"`                   // "success" or "failed"
+	Attempts       int    `json:"attempts"`
+	Error          string `json:"error,omitempty"`
+	// ASN, ASNOrg and Hosting describe the exit's network when ASN_DB
+	// holds it (exitasn.go).
+	ASN     uint32 `json:"asn,omitempty"`
+	ASNOrg  string `json:"asn_org,omitempty"`
+	Hosting bool   `json:"hosting,omitempty"`
+}
+
+// sessionLog is the tracker's history state; guarded by StateTracker.mu.
+type sessionLog struct {
+	sessions []SessionRecord // oldest first, len ≤ sessionHistorySize
+	open     bool            // the last entry is the live tunnel
+
+	// The connect batch in progress: BeginConnect opens it, each
+	// provider Connect counts an attempt, and RecordTunnelUp or
+	// RecordConnectFailure closes it into a SessionRecord.
+	pendingTrigger  string
+	pendingSince    time.Time
+	pendingAttempts int
+}
+
+func (l *sessionLog) push(rec SessionRecord) {
+	if len(l.sessions) == sessionHistorySize {
+		copy(l.sessions, l.sessions[1:])
+		l.sessions = l.sessions[:sessionHistorySize-1]
+	}
+	l.sessions = append(l.sessions, rec)
+}
+
+func (l *sessionLog) closeOpen(endedBy string, at time.Time) {
+	if !l.open {
+		return
+	}
+	last := &l.sessions[len(l.sessions)-1]
+	last.DisconnectedAt = at.Format(time.RFC3339)
+	last.EndedBy = endedBy
+	l.open = false
+}
+
+// BeginConnect starts a connect batch attributed to trigger. A no-op
+// while a batch with the same trigger is pending, so the watchdog's
+// backoff loop and the boot retry loop accumulate their attempts into
+// one record.
+func (s *StateTracker) BeginConnect(trigger string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.history.pendingTrigger == trigger {
+		return
+	}
+	s.history.pendingTrigger = trigger
+	s.history.pendingSince = time.Now().UTC()
+	s.history.pendingAttempts = 0
+}
+
+// countConnectAttempt notes one provider Connect of the pending batch.
+func (s *StateTracker) countConnectAttempt() {
+	s.mu.Lock()
+	if s.history.pendingSince.IsZero() {
+		s.history.pendingSince = time.Now().UTC()
+	}
+	s.history.pendingAttempts++
+	s.mu.Unlock()
+}
+
+// RecordConnectFailure closes the pending batch as a failed session.
+func (s *StateTracker) RecordConnectFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := SessionRecord{
+		Trigger:  s.history.pendingTrigger,
+		Outcome:  "failed",
+		Attempts: s.history.pendingAttempts,
+	}
+	if !s.history.pendingSince.IsZero() {
+		rec.StartedAt = s.history.pendingSince.Format(time.RFC3339)
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	s.history.push(rec)
+	s.history.pendingTrigger, s.history.pendingSince, s.history.pendingAttempts = "", time.Time{}, 0
+}
+
+// RecordDisconnect closes the live session, if any, noting what ended
+// it. Called before the tunnel is torn down on purpose (rotation,
+// recycle, shutdown) or found dead by the watchdog.
+func (s *StateTracker) RecordDisconnect(endedBy string) {
+	s.mu.Lock()
+	s.history.closeOpen(endedBy, time.Now().UTC())
+	location, until, score := s.recordDropLocked(endedBy)
+	s.mu.Unlock()
+	s.publishQuarantine(location, outcomeDrop, until, score)
+}
+
+// openSessionLocked records a new live session; called from
+// RecordTunnelUp under s.mu.
+func (s *StateTracker) openSessionLocked(location, exitIP string, at time.Time) {
+	s.history.closeOpen("replaced", at)
+	rec := SessionRecord{
+		Location:    location,
+		ExitIP:      exitIP,
+		ConnectedAt: at.Format(time.RFC3339),
+		Trigger:     s.history.pendingTrigger,
+		Outcome:     "success",
+		Attempts:    s.history.pendingAttempts,
+	}
+	if !s.history.pendingSince.IsZero() {
+		rec.StartedAt = s.history.pendingSince.Format(time.RFC3339)
+	}
+	if a := s.exitASNFor(exitIP); a != nil {
+		rec.ASN, rec.ASNOrg, rec.Hosting = a.ASN, a.Org, a.Hosting
+	}
+	s.history.push(rec)
+	s.history.open = true
+	s.history.pendingTrigger, s.history.pendingSince, s.history.pendingAttempts = "", time.Time{}, 0
+}
+
+// History returns a copy of the session history, oldest first.
+func (s *StateTracker) History() []SessionRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]SessionRecord(nil), s.history.sessions...)
+}
+
+var sessionCSVHeader = []string{
+	"location", "exit_ip", "started_at", "connected_at", "disconnected_at",
+	"trigger", "ended_by", "outcome", "attempts", "error",
+	"asn", "asn_org", "hosting",
+}
+
+// historyHandler implements GET /history: the bounded session history,
+// oldest first, as {"sessions":[...]} or, with ?format=csv or
+// Accept: text/csv, as CSV with a header row. ?limit=N keeps the newest N.
+func historyHandler(state *StateTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessions := state.History()
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeProblem(w, problemDetails{
+					Type:   "https://tundler-tunnel/errors/invalid-history-request",
+					Title:  "Invalid history request",
+					Status: http.StatusBadRequest,
+					Detail: "limit must be a non-negative integer",
+				})
+				return
+			}
+			if n < len(sessions) {
+				sessions = sessions[len(sessions)-n:]
+			}
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+		switch format {
+		case "", "json":
+			if sessions == nil {
+				sessions = []SessionRecord{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"sessions": sessions})
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			cw := csv.NewWriter(w)
+			_ = cw.Write(sessionCSVHeader)
+			for _, s := range sessions {
+				asn, hosting := "", ""
+				if s.ASN != 0 {
+					asn, hosting = strconv.FormatUint(uint64(s.ASN), 10), strconv.FormatBool(s.Hosting)
+				}
+				_ = cw.Write([]string{
+					s.Location, s.ExitIP, s.StartedAt, s.ConnectedAt, s.DisconnectedAt,
+					s.Trigger, s.EndedBy, s.Outcome, strconv.Itoa(s.Attempts), s.Error,
+					asn, s.ASNOrg, hosting,
+				})
+			}
+			cw.Flush()
+		default:
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/invalid-history-request",
+				Title:  "Invalid history request",
+				Status: http.StatusBadRequest,
+				Detail: "format must be json or csv",
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A boot connect, an API rotation that fails and a watchdog recovery
+// leave one record each, the boot session closed by the rotation that
+// ended it.
+func TestStateTracker_SessionHistory(t *testing.T) {
+	sp := newScriptedProvider([]string{"USA", "UK", "Germany"},
+		[]bool{true, false, false, false, true}, []string{"1.1.1.1", "", "", "", "3.3.3.3"})
+	st := NewStateTracker("scripted")
+	ctx := context.Background()
+	ns := &noSleep{}
+
+	st.BeginConnect(triggerBoot)
+	if err := connectWithRetry(ctx, sp, st, "scripted", nil, RotateRequest{}, 1, ns.sleep, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotateIfReadyWithDeps(ctx, sp, st, "scripted", nil, RotateRequest{Audit: &AuditEntry{Caller: "crawler"}}, 3, ns.sleep, nil, ""); err == nil {
+		t.Fatal("want the API rotation to fail")
+	}
+	st.BeginConnect(triggerWatchdog)
+	if err := connectWithRetry(ctx, sp, st, "scripted", nil, RotateRequest{}, 1, ns.sleep, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	h := st.History()
+	if len(h) != 3 {
+		t.Fatalf("history=%+v, want 3 records", h)
+	}
+	boot, failed, recovered := h[0], h[1], h[2]
+	if boot.Trigger != triggerBoot || boot.ExitIP != "1.1.1.1" || boot.Attempts != 1 || boot.EndedBy != triggerAPI || boot.DisconnectedAt == "" {
+		t.Errorf("boot=%+v, want boot session on 1.1.1.1 ended by api", boot)
+	}
+	if failed.Trigger != triggerAPI || failed.Outcome != "failed" || failed.Attempts != 3 || failed.Error == "" || failed.ConnectedAt != "" {
+		t.Errorf("failed=%+v, want a failed api batch of 3 attempts", failed)
+	}
+	if recovered.Trigger != triggerWatchdog || recovered.ExitIP != "3.3.3.3" || recovered.DisconnectedAt != "" {
+		t.Errorf("recovered=%+v, want the live watchdog session on 3.3.3.3", recovered)
+	}
+}
+
+// The watchdog's backoff loop keeps calling BeginConnect; attempts add up
+// into one record instead of restarting.
+func TestStateTracker_BeginConnectAccumulates(t *testing.T) {
+	st := NewStateTracker("fake")
+	for i := 0; i < 3; i++ {
+		st.BeginConnect(triggerWatchdog)
+		st.countConnectAttempt()
+	}
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	if h := st.History(); len(h) != 1 || h[0].Attempts != 3 {
+		t.Errorf("history=%+v, want one record with 3 attempts", h)
+	}
+}
+
+func TestStateTracker_SessionHistoryIsBounded(t *testing.T) {
+	st := NewStateTracker("fake")
+	for i := 0; i < sessionHistorySize+5; i++ {
+		st.RecordConnectFailure(errors.New("boom"))
+	}
+	if n := len(st.History()); n != sessionHistorySize {
+		t.Errorf("len=%d, want %d", n, sessionHistorySize)
+	}
+}
+
+func TestHistoryHandler_JSONAndCSV(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.BeginConnect(triggerBoot)
+	st.RecordTunnelUp("USA", "1.1.1.1")
+	st.RecordDisconnect(triggerScheduled)
+	st.BeginConnect(triggerScheduled)
+	st.RecordTunnelUp("UK", "2.2.2.2")
+	h := historyHandler(st)
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodGet, "/history?limit=1", nil))
+	var body struct {
+		Sessions []SessionRecord `json:"sessions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sessions) != 1 || body.Sessions[0].Location != "UK" {
+		t.Errorf("limit=1: got %+v, want the newest (UK) session", body.Sessions)
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/history", nil)
+	req.Header.Set("Accept", "text/csv")
+	h(rr, req)
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "location" || rows[1][0] != "USA" || rows[1][6] != triggerScheduled {
+		t.Errorf("csv=%v, want header + USA (ended_by scheduled) + UK", rows)
+	}
+
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodGet, "/history?format=xml", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: got %d, want 400", rr.Code)
+	}
+}
+
+func TestHistoryHandler_EmptyIsArray(t *testing.T) {
+	rr := httptest.NewRecorder()
+	historyHandler(NewStateTracker("fake"))(rr, httptest.NewRequest(http.MethodGet, "/history", nil))
+	if got := rr.Body.String(); got != "{\"sessions\":[]}\n" {
+		t.Errorf("got %q, want an empty sessions array", got)
+	}
+}
@@ -3,60 +3,167 @@ package main
 import (
 	"errors"
 	"math/rand/v2"
+	"slices"
 	"strings"
+
+	"github.com/laurentpellegrino/tundler/internal/geo"
 )
 
-// errNoAllowedLocations is returned by pickLocation when every location
-// reported by the provider is in the excluded set (or the provider
-// reported zero locations). The caller is expected to surface this as
-// a fatal startup error — the pod has no usable location.
-var errNoAllowedLocations = errors.New("no allowed locations after applying excluded_locations filter")
+// errNoAllowedLocations is returned by pickLocation when the location
+// filter (INCLUDED_LOCATIONS / EXCLUDED_LOCATIONS, locationfilter.go)
+// rules out every location reported by the provider (or the provider
+// reported zero locations). The caller is expected to surface this as a
+// fatal startup error — the pod has no usable location.
+var errNoAllowedLocations = errors.New("no allowed locations after applying included_locations/excluded_locations filters")
 
 // pickLocation returns a uniformly random location from `locations`
-// minus any matches in `excluded` (case-sensitive — same casing as the
-// provider's own location names).
-//
-// excluded is normalized: empty strings and whitespace-only entries are
-// dropped so an env var like "EXCLUDED_LOCATIONS=Bahrain,,Yemen, " still
-// excludes exactly Bahrain and Yemen.
+// that filter allows (a nil filter allows all).
 //
 // Operators add known-bad exits to vpn-providers.yaml on the
-// kubernetes side; the rendered env var arrives here as a CSV.
-func pickLocation(locations []string, excluded []string) (string, error) {
-	excludeSet := make(map[string]struct{}, len(excluded))
-	for _, e := range excluded {
-		e = strings.TrimSpace(e)
-		if e != "" {
-			excludeSet[e] = struct{}{}
-		}
+// kubernetes side; the rendered env vars arrive here as CSVs.
+//
+// Connects pick through StateTracker.PickLocation, which applies the
+// pod's location strategy (strategy.go) instead of the uniform pick;
+// this remains the feasibility check.
+func pickLocation(locations []string, filter *locationFilter) (string, error) {
+	allowed := allowedLocations(locations, filter)
+	if len(allowed) == 0 {
+		return "", errNoAllowedLocations
 	}
+	return allowed[rand.IntN(len(allowed))], nil
+}
 
+// allowedLocations is the locations filter allows.
+func allowedLocations(locations []string, filter *locationFilter) []string {
+	if filter.empty() {
+		return slices.Clone(locations)
+	}
 	allowed := make([]string, 0, len(locations))
 	for _, loc := range locations {
-		if _, blocked := excludeSet[loc]; blocked {
-			continue
+		if filter.allows(loc) {
+			allowed = append(allowed, loc)
 		}
-		allowed = append(allowed, loc)
 	}
+	return allowed
+}
 
-	if len(allowed) == 0 {
-		return "", errNoAllowedLocations
+// AllowedLocations is allowedLocations reading countries the pod's way
+// (SetLocationCountries).
+func (s *StateTracker) AllowedLocations(locations []string, filter *locationFilter) []string {
+	return allowedLocations(locations, filter.resolving(s.locationCountry))
+}
+
+// SetLocationCountries installs how the pod reads a location's ISO
+// country: the catalog's descriptions (cachedLocationsProvider.Country).
+// Until then a name is resolved on its own.
+func (s *StateTracker) SetLocationCountries(countryOf func(string) string) {
+	s.mu.Lock()
+	s.countryOf = countryOf
+	s.mu.Unlock()
+}
+
+// locationCountry is the ISO country of a location, or "" when unknown.
+func (s *StateTracker) locationCountry(location string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.locationCountryLocked(location)
+}
+
+func (s *StateTracker) locationCountryLocked(location string) string {
+	if location == "" {
+		return ""
 	}
-	return allowed[rand.IntN(len(allowed))], nil
+	if s.countryOf == nil {
+		return resolveCountry(location)
+	}
+	return s.countryOf(location)
 }
 
 // parseExcludedLocations parses a CSV env-var value into a slice. Empty
-// string returns nil (no exclusions). Whitespace is trimmed.
+// string returns nil (no selectors). Whitespace is trimmed and empty
+// entries are dropped, so "Bahrain,,Yemen, " is exactly Bahrain and
+// Yemen.
 func parseExcludedLocations(csv string) []string {
 	if csv == "" {
 		return nil
 	}
-	parts := strings.Split(csv, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		if p = strings.TrimSpace(p); p != "" {
-			out = append(out, p)
+	return trimLocations(strings.Split(csv, ","))
+}
+
+// trimLocations trims every entry and drops the empty ones; nil if none
+// remain.
+func trimLocations(entries []string) []string {
+	var out []string
+	for _, e := range entries {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// candidates narrows the provider's catalog to what the request targets:
+// exactly req.Location, or every location of req.Country — by leading
+// words (matchesCountry) or, for a country name, alias or ISO code, by
+// the location's ISO country as countryOf reads it. An unconstrained
+// request returns locations unchanged.
+func (req RotateRequest) candidates(locations []string, countryOf func(string) string) []string {
+	if req.Location == "" && req.Country == "" {
+		return locations
+	}
+	code := ""
+	if c, ok := geo.Named(req.Country); ok {
+		code = c.Code
+	}
+	var out []string
+	for _, loc := range locations {
+		if req.Location != "" {
+			if loc == req.Location {
+				out = append(out, loc)
+			}
+			continue
+		}
+		if matchesCountry(loc, req.Country) || code != "" && countryOf(loc) == code {
+			out = append(out, loc)
 		}
 	}
 	return out
 }
+
+// matchesCountry reports whether a provider location belongs to country.
+// Catalogs name locations either by country ("Germany") or by country
+// plus city ("Germany - Frankfurt", "Germany Frankfurt"), so a
+// case-insensitive match on the whole name or on its leading words is
+// the common denominator. Providers with slug-style names (PIA's
+// "de-frankfurt") need the exact Location instead.
+func matchesCountry(location, country string) bool {
+	country = strings.TrimSpace(country)
+	if country == "" || len(location) < len(country) {
+		return false
+	}
+	if !strings.EqualFold(location[:len(country)], country) {
+		return false
+	}
+	if len(location) == len(country) {
+		return true
+	}
+	// Require a word boundary so "Niger" doesn't match "Nigeria".
+	switch location[len(country)] {
+	case ' ', '-', ',', '(', '/':
+		return true
+	}
+	return false
+}
+
+// avoids reports whether exitIP is one the caller asked not to land on.
+func (req RotateRequest) avoids(exitIP string) bool {
+	if exitIP == "" {
+		return false
+	}
+	for _, ip := range req.AvoidExitIPs {
+		if strings.TrimSpace(ip) == exitIP {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"slices"
 	"sort"
 	"testing"
 )
@@ -20,7 +21,7 @@ func TestPickLocation_BasicSelection(t *testing.T) {
 
 func TestPickLocation_ExcludesBlocked(t *testing.T) {
 	locations := []string{"USA", "Bahrain", "Yemen", "UK"}
-	excluded := []string{"Bahrain", "Yemen"}
+	excluded := newLocationFilter(nil, []string{"Bahrain", "Yemen"})
 	// Run many times to ensure the excluded ones are never picked.
 	for i := 0; i < 200; i++ {
 		got, err := pickLocation(locations, excluded)
@@ -35,7 +36,7 @@ func TestPickLocation_ExcludesBlocked(t *testing.T) {
 
 func TestPickLocation_ErrorsWhenAllExcluded(t *testing.T) {
 	locations := []string{"Bahrain", "Yemen"}
-	excluded := []string{"Bahrain", "Yemen"}
+	excluded := newLocationFilter(nil, []string{"Bahrain", "Yemen"})
 	_, err := pickLocation(locations, excluded)
 	if !errors.Is(err, errNoAllowedLocations) {
 		t.Errorf("pickLocation got err=%v, want errNoAllowedLocations", err)
@@ -107,3 +108,52 @@ func equalStrings(a, b []string) bool {
 	}
 	return true
 }
+
+func TestMatchesCountry(t *testing.T) {
+	for _, tc := range []struct {
+		location, country string
+		want              bool
+	}{
+		{"Germany", "Germany", true},
+		{"Germany", "germany", true},
+		{"Germany - Frankfurt", "Germany", true},
+		{"USA New York", "usa", true},
+		{"Nigeria", "Niger", false},
+		{"UK", "Ukraine", false},
+		{"Germany", "", false},
+	} {
+		if got := matchesCountry(tc.location, tc.country); got != tc.want {
+			t.Errorf("matchesCountry(%q, %q) = %t, want %t", tc.location, tc.country, got, tc.want)
+		}
+	}
+}
+
+func TestRotateRequestCandidates(t *testing.T) {
+	catalog := []string{"Germany - Berlin", "Germany - Frankfurt", "France", "Niger", "Nigeria"}
+
+	if got := (RotateRequest{}).candidates(catalog, resolveCountry); len(got) != len(catalog) {
+		t.Errorf("unconstrained: got %v, want the whole catalog", got)
+	}
+	got := RotateRequest{Country: "germany"}.candidates(catalog, resolveCountry)
+	if len(got) != 2 || got[0] != "Germany - Berlin" || got[1] != "Germany - Frankfurt" {
+		t.Errorf("country=germany: got %v, want both German locations", got)
+	}
+	// Location wins over Country and is exact.
+	got = RotateRequest{Location: "Niger", Country: "Germany"}.candidates(catalog, resolveCountry)
+	if len(got) != 1 || got[0] != "Niger" {
+		t.Errorf("location=Niger: got %v, want [Niger]", got)
+	}
+	if got := (RotateRequest{Location: "niger"}).candidates(catalog, resolveCountry); len(got) != 0 {
+		t.Errorf("location match must be case-sensitive like exclusions, got %v", got)
+	}
+	// An ISO code matches on the location's country, however it's named.
+	got = RotateRequest{Country: "DE"}.candidates(append(catalog, "de-munich", "pool-9"), func(loc string) string {
+		if loc == "pool-9" {
+			return "DE"
+		}
+		return resolveCountry(loc)
+	})
+	if !slices.Equal(got, []string{"Germany - Berlin", "Germany - Frankfurt", "de-munich", "pool-9"}) {
+		t.Errorf("country=DE: got %v", got)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/laurentpellegrino/tundler/internal/geo"
+)
+
+// Location filtering. INCLUDED_LOCATIONS (the allowlist) and
+// EXCLUDED_LOCATIONS (the blocklist) are lists of selectors:
+//
+//	eu, @apac          a region group (internal/geo): eu, eea, europe,
+//	                   asia, apac, middle_east, oceania, africa,
+//	                   north_america, south_america, latam, americas
+//	Germany, DE, uk    a country by name, common alias or ISO code;
+//	                   matches every location of that country whatever
+//	                   the provider calls it ("Germany - Frankfurt",
+//	                   "de-frankfurt", "DE")
+//	us-*, *London*     a glob over the whole name (* and ?)
+//	re:^de-, /^de-/    a regular expression, unanchored
+//	anything else      that exact provider location name
+//
+// Globs, regexes and country names match case-insensitively. A location
+// is allowed when the allowlist is empty or one of its selectors matches,
+// and no blocklist selector does — so INCLUDED_LOCATIONS=eu with
+// EXCLUDED_LOCATIONS=Hungary is "EU but Hungary" on every provider.
+// Countries and groups match on a location's ISO country: the provider's
+// own description when it has one (provider.LocationDescriber), else
+// what the name resolves to. Locations whose country is unknown ("auto",
+// a bare city) match only globs, regexes and exact names.
+const envIncludedLocations = "INCLUDED_LOCATIONS"
+
+// locationSelector is one parsed selector. With neither re nor codes set
+// it matches its raw text exactly.
+type locationSelector struct {
+	raw   string
+	re    *regexp.Regexp
+	codes []string // sorted ISO codes of a country or region group
+}
+
+// parseLocationSelector parses one selector (see the package comment
+// above).
+func parseLocationSelector(s string) (locationSelector, error) {
+	s = strings.TrimSpace(s)
+	sel := locationSelector{raw: s}
+	switch {
+	case s == "":
+		return sel, errors.New("empty selector")
+	case strings.HasPrefix(s, "re:"), len(s) > 2 && s[0] == '/' && s[len(s)-1] == '/':
+		expr := strings.TrimPrefix(s, "re:")
+		if expr == s {
+			expr = s[1 : len(s)-1]
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return sel, fmt.Errorf("%q: %w", s, err)
+		}
+		sel.re = re
+	case strings.ContainsAny(s, "*?"):
+		expr := regexp.QuoteMeta(s)
+		expr = strings.NewReplacer(`\*`, `.*`, `\?`, `.`).Replace(expr)
+		sel.re = regexp.MustCompile("(?i)^" + expr + "$")
+	default:
+		if codes, ok := geo.Group(strings.TrimPrefix(s, "@")); ok {
+			sel.codes = codes
+		} else if strings.HasPrefix(s, "@") {
+			return sel, fmt.Errorf("%q: unknown region group (want one of %s)", s, strings.Join(geo.Groups(), ", "))
+		} else if c, ok := geo.Named(s); ok {
+			sel.codes = []string{c.Code}
+		}
+	}
+	return sel, nil
+}
+
+// matches reports whether sel selects location; country gives its ISO
+// country.
+func (sel locationSelector) matches(location string, country func(string) string) bool {
+	if sel.raw == location {
+		return true
+	}
+	if sel.re != nil {
+		return sel.re.MatchString(location)
+	}
+	if sel.codes != nil {
+		if code := country(location); code != "" {
+			_, found := slices.BinarySearch(sel.codes, code)
+			return found
+		}
+	}
+	return false
+}
+
+// resolveCountry is the ISO country location's name resolves to, or "".
+func resolveCountry(location string) string {
+	c, _ := geo.Resolve(location)
+	return c.Code
+}
+
+// locationFilter is the allow/block pair applied to every pick. A nil
+// filter allows everything.
+type locationFilter struct {
+	include, exclude []locationSelector
+	// country gives a location's ISO country; resolveCountry when nil.
+	country func(string) string
+}
+
+// newLocationFilter parses include and exclude. Selectors are validated
+// where they enter (RuntimeConfig.validate, POST /rotate), so one that
+// still fails to parse here degrades to an exact match.
+func newLocationFilter(include, exclude []string) *locationFilter {
+	f := &locationFilter{}
+	f.include = parseSelectorsLenient(include)
+	f.exclude = parseSelectorsLenient(exclude)
+	return f
+}
+
+func parseSelectorsLenient(entries []string) []locationSelector {
+	var out []locationSelector
+	for _, e := range entries {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		sel, err := parseLocationSelector(e)
+		if err != nil {
+			sel = locationSelector{raw: e}
+		}
+		out = append(out, sel)
+	}
+	return out
+}
+
+// validateLocationSelectors reports every selector in entries that
+// doesn't parse; field names the list in the errors.
+func validateLocationSelectors(field string, entries []string) []error {
+	var errs []error
+	for _, e := range entries {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		if _, err := parseLocationSelector(e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	return errs
+}
+
+// excluding returns f plus the selectors in selectors and the exact
+// location names in names (a rotation's burned locations), leaving f
+// untouched.
+func (f *locationFilter) excluding(selectors, names []string) *locationFilter {
+	out := &locationFilter{}
+	if f != nil {
+		out.include = f.include
+		out.exclude = slices.Clone(f.exclude)
+		out.country = f.country
+	}
+	out.exclude = append(out.exclude, parseSelectorsLenient(selectors)...)
+	for _, n := range names {
+		out.exclude = append(out.exclude, locationSelector{raw: n})
+	}
+	return out
+}
+
+// resolving returns f reading countries from country (a catalog's
+// descriptions), leaving f untouched.
+func (f *locationFilter) resolving(country func(string) string) *locationFilter {
+	out := &locationFilter{country: country}
+	if f != nil {
+		out.include, out.exclude = f.include, f.exclude
+	}
+	return out
+}
+
+func (f *locationFilter) countryOf() func(string) string {
+	if f == nil || f.country == nil {
+		return resolveCountry
+	}
+	return f.country
+}
+
+// reason explains why location is filtered out, or "" when it is
+// allowed.
+func (f *locationFilter) reason(location string) string {
+	if f == nil {
+		return ""
+	}
+	country := f.countryOf()
+	if len(f.include) > 0 && !slices.ContainsFunc(f.include, func(sel locationSelector) bool { return sel.matches(location, country) }) {
+		return fmt.Sprintf("matches no %s entry", envIncludedLocations)
+	}
+	for _, sel := range f.exclude {
+		if sel.matches(location, country) {
+			return fmt.Sprintf("%s entry %q", envExcludedLocations, sel.raw)
+		}
+	}
+	return ""
+}
+
+func (f *locationFilter) allows(location string) bool { return f.reason(location) == "" }
+
+// empty reports whether f filters nothing.
+func (f *locationFilter) empty() bool {
+	return f == nil || len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// unmatched returns the selectors of sels matching none of locations —
+// usually a typo or a provider rename.
+func (f *locationFilter) unmatched(sels []locationSelector, locations []string) []string {
+	country := f.countryOf()
+	var out []string
+	for _, sel := range sels {
+		if !slices.ContainsFunc(locations, func(loc string) bool { return sel.matches(loc, country) }) {
+			out = append(out, sel.raw)
+		}
+	}
+	return out
+}
+
+// locationFilter is the live allow/block pair.
+func (c RuntimeConfig) locationFilter() *locationFilter {
+	return newLocationFilter(c.IncludedLocations, c.ExcludedLocations)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLocationSelector_Matches(t *testing.T) {
+	for _, c := range []struct {
+		sel string
+		yes []string
+		no  []string
+	}{
+		{"eu", []string{"Germany", "de-frankfurt", "France - Paris", "NL"}, []string{"Switzerland", "uk-london", "auto"}},
+		{"@apac", []string{"Japan", "jp-tokyo", "Australia"}, []string{"Germany"}},
+		{"Germany", []string{"Germany", "Germany - Frankfurt", "de-berlin", "DE"}, []string{"Germanyx", "France"}},
+		{"uk", []string{"United Kingdom", "uk-london", "GB"}, []string{"Ukraine"}},
+		{"us-*", []string{"us-chicago", "US-NEW-YORK"}, []string{"usa-new-york", "de-us"}},
+		{"*london*", []string{"uk-london", "London"}, []string{"Paris"}},
+		{"re:^(de|fr)-", []string{"de-frankfurt", "FR-paris"}, []string{"uk-london"}},
+		{"/paris/", []string{"France - Paris"}, []string{"Germany"}},
+		{"Frankfurt Beta", []string{"Frankfurt Beta"}, []string{"frankfurt beta", "Germany"}},
+	} {
+		sel, err := parseLocationSelector(c.sel)
+		if err != nil {
+			t.Fatalf("%s: %v", c.sel, err)
+		}
+		for _, loc := range c.yes {
+			if !sel.matches(loc, resolveCountry) {
+				t.Errorf("%s doesn't select %q", c.sel, loc)
+			}
+		}
+		for _, loc := range c.no {
+			if sel.matches(loc, resolveCountry) {
+				t.Errorf("%s selects %q", c.sel, loc)
+			}
+		}
+	}
+	for _, bad := range []string{"re:(", "/[a/", "@narnia", " "} {
+		if _, err := parseLocationSelector(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+// One config reads "EU but Hungary" across providers' naming schemes.
+func TestLocationFilter_AllowAndBlock(t *testing.T) {
+	f := newLocationFilter([]string{"eu"}, []string{"Hungary"})
+	catalog := []string{"Germany", "de-frankfurt", "HU", "Hungary", "hu-budapest", "USA", "auto"}
+	if got := allowedLocations(catalog, f); !slices.Equal(got, []string{"Germany", "de-frankfurt"}) {
+		t.Errorf("allowed=%v", got)
+	}
+	if got := f.reason("USA"); got != "matches no INCLUDED_LOCATIONS entry" {
+		t.Errorf("USA reason=%q", got)
+	}
+	if got := f.reason("hu-budapest"); got != `EXCLUDED_LOCATIONS entry "Hungary"` {
+		t.Errorf("hu-budapest reason=%q", got)
+	}
+	// Burned locations exclude exactly: "Germany" failing doesn't take
+	// de-frankfurt down with it.
+	if got := allowedLocations(catalog, f.excluding(nil, []string{"Germany"})); !slices.Equal(got, []string{"de-frankfurt"}) {
+		t.Errorf("after burning Germany: %v", got)
+	}
+	if len(f.exclude) != 1 {
+		t.Error("excluding modified the filter")
+	}
+	if got := allowedLocations(catalog, (*locationFilter)(nil).excluding([]string{"re:^h"}, nil)); len(got) != 4 {
+		t.Errorf("nil filter plus a regex: %v", got)
+	}
+}
+
+func TestRuntimeConfig_ValidatesSelectors(t *testing.T) {
+	c := defaultRuntimeConfig()
+	c.IncludedLocations = []string{" eu ", "", "@narnia"}
+	c.ExcludedLocations = []string{"re:("}
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), "included_locations") || !strings.Contains(err.Error(), "excluded_locations") {
+		t.Fatalf("validate: %v", err)
+	}
+	c.IncludedLocations = []string{" eu ", ""}
+	c.ExcludedLocations = nil
+	if err := c.validate(); err != nil || !slices.Equal(c.IncludedLocations, []string{"eu"}) {
+		t.Errorf("validate: %v, included=%q", err, c.IncludedLocations)
+	}
+}
+
+func TestRotate_BadExcludeSelector(t *testing.T) {
+	rr := call(fullAPI().routes(), "POST", "/rotate?exclude=re:(", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("POST /rotate?exclude=re:( = %d, want 400", rr.Code)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Per-location health. connectWithRetry only remembers a failed location
+// for the rest of its rotation, so a location that always times out, or
+// always fails the exit-IP contract, used to be picked again on every
+// rotation. Each location now keeps decaying counts of what it did:
+//
+//	successes          tunnels that came up and passed the contract; a
+//	                   connect slower than LOCATION_SLOW_CONNECT_SECONDS
+//	                   counts partly as a connect failure
+//	connect_failures   Connect calls that didn't bring a tunnel up
+//	contract_failures  tunnels that leaked or couldn't be verified
+//	drops              live tunnels the watchdog or the exit re-probe
+//	                   tore down
+//
+// Every count halves each LOCATION_SCORE_HALF_LIFE_SECONDS, and the
+// score is
+//
+//	(successes + 1) / (successes + 1 + connect_failures + 2·contract_failures + drops)
+//
+// so an unknown location scores 1 and a contract failure weighs double.
+// A failure that leaves the score below LOCATION_QUARANTINE_SCORE
+// quarantines the location for LOCATION_QUARANTINE_SECONDS, doubling on
+// each consecutive quarantine up to LOCATION_QUARANTINE_MAX_SECONDS; a
+// success resets the doubling. Picks skip quarantined locations unless
+// nothing else is allowed.
+const (
+	envLocationScoreHalfLife   = "LOCATION_SCORE_HALF_LIFE_SECONDS"
+	envLocationQuarantineScore = "LOCATION_QUARANTINE_SCORE"
+	envLocationQuarantineSec   = "LOCATION_QUARANTINE_SECONDS"
+	envLocationQuarantineMax   = "LOCATION_QUARANTINE_MAX_SECONDS"
+	envLocationSlowConnectSec  = "LOCATION_SLOW_CONNECT_SECONDS"
+
+	defaultLocationScoreHalfLife   = 6 * time.Hour
+	defaultLocationQuarantineScore = 0.3
+	defaultLocationQuarantine      = 10 * time.Minute
+	defaultLocationQuarantineMax   = 24 * time.Hour
+	defaultLocationSlowConnect     = 30 * time.Second
+
+	// connectTimeEWMA is the weight of the newest time-to-connect.
+	connectTimeEWMA = 0.3
+)
+
+// Location outcomes fed to the health model.
+const (
+	outcomeSuccess         = "success"
+	outcomeConnectFailure  = "connect_failure"
+	outcomeContractFailure = "contract_failure"
+	outcomeDrop            = "drop"
+)
+
+// healthPolicy holds the scoring and quarantine knobs.
+type healthPolicy struct {
+	halfLife        time.Duration
+	quarantineScore float64 // 0 disables quarantine
+	quarantine      time.Duration
+	quarantineMax   time.Duration
+	slowConnect     time.Duration
+}
+
+func defaultHealthPolicy() healthPolicy {
+	return healthPolicy{
+		halfLife:        defaultLocationScoreHalfLife,
+		quarantineScore: defaultLocationQuarantineScore,
+		quarantine:      defaultLocationQuarantine,
+		quarantineMax:   defaultLocationQuarantineMax,
+		slowConnect:     defaultLocationSlowConnect,
+	}
+}
+
+// healthPolicyFromEnv reads the LOCATION_SCORE_* and
+// LOCATION_QUARANTINE_* knobs.
+func healthPolicyFromEnv() (healthPolicy, error) {
+	p := defaultHealthPolicy()
+	secs := func(name string, def time.Duration) time.Duration {
+		return time.Duration(getEnvInt(name, int(def.Seconds()))) * time.Second
+	}
+	p.halfLife = secs(envLocationScoreHalfLife, p.halfLife)
+	p.quarantine = secs(envLocationQuarantineSec, p.quarantine)
+	p.quarantineMax = secs(envLocationQuarantineMax, p.quarantineMax)
+	p.slowConnect = secs(envLocationSlowConnectSec, p.slowConnect)
+	if v := os.Getenv(envLocationQuarantineScore); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f >= 1 {
+			return p, fmt.Errorf("%s=%q: want a score within [0, 1)", envLocationQuarantineScore, v)
+		}
+		p.quarantineScore = f
+	}
+	if p.halfLife <= 0 || p.slowConnect <= 0 {
+		return p, fmt.Errorf("%s and %s must be positive", envLocationScoreHalfLife, envLocationSlowConnectSec)
+	}
+	if p.quarantineMax < p.quarantine {
+		return p, fmt.Errorf("%s (%s) is below %s (%s)", envLocationQuarantineMax, p.quarantineMax, envLocationQuarantineSec, p.quarantine)
+	}
+	return p, nil
+}
+
+// locationHealth is one location's decayed record. Exported fields are
+// persisted in the state file.
+type locationHealth struct {
+	Successes        float64   `json:"successes"`
+	ConnectFailures  float64   `json:"connect_failures"`
+	ContractFailures float64   `json:"contract_failures"`
+	Drops            float64   `json:"drops"`
+	ConnectSeconds   float64   `json:"connect_seconds,omitempty"` // EWMA of successful connects
+	UpdatedAt        time.Time `json:"updated_at"`
+	QuarantinedUntil time.Time `json:"quarantined_until,omitzero"`
+	Quarantines      int       `json:"quarantines,omitempty"` // consecutive; reset by a success
+}
+
+// decay ages the counts to now.
+func (h *locationHealth) decay(now time.Time, halfLife time.Duration) {
+	if dt := now.Sub(h.UpdatedAt); dt > 0 && !h.UpdatedAt.IsZero() {
+		f := math.Exp2(-dt.Seconds() / halfLife.Seconds())
+		h.Successes *= f
+		h.ConnectFailures *= f
+		h.ContractFailures *= f
+		h.Drops *= f
+	}
+	h.UpdatedAt = now
+}
+
+func (h locationHealth) score() float64 {
+	credit := h.Successes + 1
+	return credit / (credit + h.ConnectFailures + 2*h.ContractFailures + h.Drops)
+}
+
+// view is the /status shape of h at now.
+func (h locationHealth) view(now time.Time, halfLife time.Duration) LocationHealth {
+	h.decay(now, halfLife)
+	round := func(f float64) float64 { return math.Round(f*100) / 100 }
+	v := LocationHealth{
+		Score:                  round(h.score()),
+		Successes:              round(h.Successes),
+		ConnectFailures:        round(h.ConnectFailures),
+		ContractFailures:       round(h.ContractFailures),
+		Drops:                  round(h.Drops),
+		AvgConnectSeconds:      round(h.ConnectSeconds),
+		ConsecutiveQuarantines: h.Quarantines,
+	}
+	if now.Before(h.QuarantinedUntil) {
+		v.Quarantined = true
+		v.QuarantinedUntil = h.QuarantinedUntil.Format(time.RFC3339)
+	}
+	return v
+}
+
+// locationOutcome classifies a connect result for the health model.
+func locationOutcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case errors.Is(err, errExitIPLeak), errors.Is(err, errExitIPUnverified):
+		return outcomeContractFailure
+	}
+	return outcomeConnectFailure
+}
+
+// SetHealthPolicy installs the scoring knobs (defaultHealthPolicy until
+// then).
+func (s *StateTracker) SetHealthPolicy(p healthPolicy) {
+	s.mu.Lock()
+	s.healthPolicy = p
+	s.mu.Unlock()
+}
+
+// recordLocationHealthLocked folds one outcome into location's record,
+// returning the quarantine it triggered (zero if none). took is the
+// time-to-connect of a success. Called under s.mu.
+func (s *StateTracker) recordLocationHealthLocked(location, outcome string, took time.Duration, now time.Time) (until time.Time, score float64) {
+	if location == "" {
+		return time.Time{}, 0
+	}
+	if s.locationHealth == nil {
+		s.locationHealth = make(map[string]*locationHealth)
+	}
+	h := s.locationHealth[location]
+	if h == nil {
+		h = &locationHealth{}
+		s.locationHealth[location] = h
+	}
+	p := s.healthPolicy
+	h.decay(now, p.halfLife)
+	switch outcome {
+	case outcomeSuccess:
+		// A slow connect earns part of a success and part of a failure.
+		credit := 1.0
+		if took > p.slowConnect {
+			credit = p.slowConnect.Seconds() / took.Seconds()
+		}
+		h.Successes += credit
+		h.ConnectFailures += 1 - credit
+		if h.ConnectSeconds == 0 {
+			h.ConnectSeconds = took.Seconds()
+		} else {
+			h.ConnectSeconds += connectTimeEWMA * (took.Seconds() - h.ConnectSeconds)
+		}
+		h.Quarantines = 0
+		h.QuarantinedUntil = time.Time{}
+		return time.Time{}, 0
+	case outcomeConnectFailure:
+		h.ConnectFailures++
+	case outcomeContractFailure:
+		h.ContractFailures++
+	case outcomeDrop:
+		h.Drops++
+	}
+	score = h.score()
+	if p.quarantineScore <= 0 || score >= p.quarantineScore || now.Before(h.QuarantinedUntil) {
+		return time.Time{}, score
+	}
+	// A long base with a large max can shift past int64: a shifted
+	// duration that went non-positive, lost bits or passed the max is
+	// clamped to the max.
+	shift := min(h.Quarantines, 16)
+	d := p.quarantine << shift
+	if d <= 0 || d>>shift != p.quarantine || d > p.quarantineMax {
+		d = p.quarantineMax
+	}
+	h.Quarantines++
+	h.QuarantinedUntil = now.Add(d)
+	return h.QuarantinedUntil, score
+}
+
+// recordLocationHealth is recordLocationHealthLocked plus the
+// location_quarantined event.
+func (s *StateTracker) recordLocationHealth(location, outcome string, took time.Duration) {
+	s.mu.Lock()
+	until, score := s.recordLocationHealthLocked(location, outcome, took, time.Now().UTC())
+	s.mu.Unlock()
+	s.publishQuarantine(location, outcome, until, score)
+}
+
+// publishQuarantine logs and publishes a quarantine; a zero until is
+// no quarantine.
+func (s *StateTracker) publishQuarantine(location, outcome string, until time.Time, score float64) {
+	if until.IsZero() {
+		return
+	}
+	s.mu.RLock()
+	level := s.locationHealth[location].Quarantines
+	s.mu.RUnlock()
+	log.Printf("tundler-tunnel: location=%s quarantined until %s (score %.2f after a %s, quarantine #%d)",
+		location, until.Format(time.RFC3339), score, outcome, level)
+	s.Publish(eventLocationQuarantined, map[string]any{
+		"location":           location,
+		"score":              math.Round(score*100) / 100,
+		"outcome":            outcome,
+		"quarantined_until":  until.Format(time.RFC3339),
+		"quarantine_seconds": math.Round(time.Until(until).Seconds()),
+		"consecutive":        level,
+	})
+}
+
+// recordDropLocked counts a watchdog or re-probe teardown against the
+// live tunnel's location. Called under s.mu; the quarantine event, if
+// any, is the caller's to publish.
+func (s *StateTracker) recordDropLocked(endedBy string) (location string, until time.Time, score float64) {
+	if endedBy != triggerWatchdog && endedBy != triggerExitReprobe {
+		return "", time.Time{}, 0
+	}
+	until, score = s.recordLocationHealthLocked(s.currentLocation, outcomeDrop, 0, time.Now().UTC())
+	return s.currentLocation, until, score
+}
+
+// quarantinedLocations returns which of allowed are quarantined now.
+func (s *StateTracker) quarantinedLocations(allowed []string) map[string]bool {
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]bool)
+	for _, loc := range allowed {
+		if h := s.locationHealth[loc]; h != nil && now.Before(h.QuarantinedUntil) {
+			out[loc] = true
+		}
+	}
+	return out
+}
+
+// withoutQuarantined drops quarantined locations from allowed, unless
+// that would leave nothing: a pinned or narrowed rotation still goes
+// ahead.
+func (s *StateTracker) withoutQuarantined(allowed []string) []string {
+	q := s.quarantinedLocations(allowed)
+	if len(q) == 0 || len(q) == len(allowed) {
+		return allowed
+	}
+	return slices.DeleteFunc(slices.Clone(allowed), func(loc string) bool { return q[loc] })
+}
+
+// LocationHealth returns the /status view of every scored location.
+func (s *StateTracker) LocationHealth() map[string]LocationHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.locationHealthViewLocked(time.Now().UTC())
+}
+
+func (s *StateTracker) locationHealthViewLocked(now time.Time) map[string]LocationHealth {
+	if len(s.locationHealth) == 0 {
+		return nil
+	}
+	out := make(map[string]LocationHealth, len(s.locationHealth))
+	for loc, h := range s.locationHealth {
+		v := h.view(now, s.healthPolicy.halfLife)
+		v.Country = s.locationCountryLocked(loc)
+		out[loc] = v
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocationHealth_Score(t *testing.T) {
+	st := NewStateTracker("fake")
+	p := st.healthPolicy
+	now := time.Now()
+	st.recordLocationHealthLocked("USA", outcomeSuccess, time.Second, now)
+	st.recordLocationHealthLocked("USA", outcomeConnectFailure, 0, now)
+	if got := st.locationHealth["USA"].score(); got != 2.0/3 {
+		t.Errorf("1 success + 1 failure: score=%v, want 2/3", got)
+	}
+	// A connect three times slower than the threshold earns a third of
+	// a success.
+	st.recordLocationHealthLocked("UK", outcomeSuccess, 3*p.slowConnect, now)
+	if h := st.locationHealth["UK"]; math.Abs(h.Successes-1.0/3) > 1e-9 || math.Abs(h.ConnectFailures-2.0/3) > 1e-9 {
+		t.Errorf("slow connect: %+v", h)
+	}
+	// Counts halve every half-life.
+	h := *st.locationHealth["USA"]
+	h.decay(now.Add(p.halfLife), p.halfLife)
+	if h.Successes != 0.5 || h.ConnectFailures != 0.5 {
+		t.Errorf("after one half-life: %+v", h)
+	}
+	if got := (locationHealth{}).score(); got != 1 {
+		t.Errorf("unknown location scores %v, want 1", got)
+	}
+}
+
+func TestLocationOutcome(t *testing.T) {
+	for err, want := range map[error]string{
+		nil:                                  outcomeSuccess,
+		fmt.Errorf("x: %w", errExitIPLeak):   outcomeContractFailure,
+		errExitIPUnverified:                  outcomeContractFailure,
+		errors.New("connect failed: status"): outcomeConnectFailure,
+	} {
+		if got := locationOutcome(err); got != want {
+			t.Errorf("%v: %s, want %s", err, got, want)
+		}
+	}
+}
+
+// Quarantines double while the location keeps failing and reset on a
+// success.
+func TestLocationHealth_Quarantine(t *testing.T) {
+	st := NewStateTracker("fake")
+	p := st.healthPolicy
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if until, _ := st.recordLocationHealthLocked("USA", outcomeConnectFailure, 0, now); !until.IsZero() {
+			t.Fatalf("quarantined after %d failures", i+1)
+		}
+	}
+	until, score := st.recordLocationHealthLocked("USA", outcomeConnectFailure, 0, now)
+	if until != now.Add(p.quarantine) || score >= p.quarantineScore {
+		t.Fatalf("third failure: until=%s score=%v, want a %s quarantine", until, score, p.quarantine)
+	}
+	// Failing again once released doubles the period.
+	now = until.Add(time.Second)
+	if until, _ = st.recordLocationHealthLocked("USA", outcomeConnectFailure, 0, now); until != now.Add(2*p.quarantine) {
+		t.Errorf("second quarantine until %s, want %s", until, now.Add(2*p.quarantine))
+	}
+	st.recordLocationHealthLocked("USA", outcomeSuccess, time.Second, now)
+	if h := st.locationHealth["USA"]; h.Quarantines != 0 || !h.QuarantinedUntil.IsZero() {
+		t.Errorf("after a success: %+v", h)
+	}
+
+	// A contract failure weighs double: two quarantine.
+	st.recordLocationHealthLocked("UK", outcomeContractFailure, 0, now)
+	if until, _ := st.recordLocationHealthLocked("UK", outcomeContractFailure, 0, now); until.IsZero() {
+		t.Error("two contract failures didn't quarantine")
+	}
+
+	st.SetHealthPolicy(healthPolicy{halfLife: time.Hour, slowConnect: time.Second})
+	for i := 0; i < 10; i++ {
+		if until, _ := st.recordLocationHealthLocked("Japan", outcomeDrop, 0, now); !until.IsZero() {
+			t.Fatal("quarantined with quarantine off")
+		}
+	}
+}
+
+func TestLocationHealth_MaxQuarantine(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.SetHealthPolicy(healthPolicy{halfLife: time.Hour, quarantineScore: 0.9, quarantine: time.Minute, quarantineMax: 3 * time.Minute, slowConnect: time.Second})
+	now := time.Now()
+	var last time.Duration
+	for i := 0; i < 5; i++ {
+		until, _ := st.recordLocationHealthLocked("USA", outcomeConnectFailure, 0, now)
+		last = until.Sub(now)
+		now = until
+	}
+	if last != 3*time.Minute {
+		t.Errorf("quarantine=%s, want capped at 3m", last)
+	}
+}
+
+// Doubling a long quarantine under a huge max saturates instead of
+// wrapping around int64.
+func TestLocationHealth_QuarantineDoesNotOverflow(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.SetHealthPolicy(healthPolicy{halfLife: time.Hour, quarantineScore: 0.9, quarantine: 1e6 * time.Second, quarantineMax: math.MaxInt64, slowConnect: time.Second})
+	now := time.Now()
+	var last time.Duration
+	for i := 0; i < 20; i++ {
+		until, _ := st.recordLocationHealthLocked("USA", outcomeConnectFailure, 0, now)
+		d := until.Sub(now)
+		if d < last {
+			t.Fatalf("quarantine %d = %s, shorter than the previous %s", i+1, d, last)
+		}
+		last = d
+		now = until
+	}
+	if last != math.MaxInt64 {
+		t.Errorf("quarantine=%s, want saturated at the max", last)
+	}
+}
+
+// A quarantined location is skipped on later rotations while others
+// remain, and still used when it is the only one allowed.
+func TestPickLocation_SkipsQuarantined(t *testing.T) {
+	st := NewStateTracker("fake")
+	for i := 0; i < 3; i++ {
+		st.RecordLocationOutcome("USA", time.Second, errors.New("timeout"))
+	}
+	if evs := eventsOfType(st, eventLocationQuarantined); len(evs) != 1 || evs[0].Data["location"] != "USA" {
+		t.Fatalf("location_quarantined events=%+v", evs)
+	}
+	for i := 0; i < 20; i++ {
+		if got, _ := st.PickLocation([]string{"USA", "UK"}, nil); got != "UK" {
+			t.Fatalf("pick=%q, want UK while USA is quarantined", got)
+		}
+	}
+	if got, err := st.PickLocation([]string{"USA", "UK"}, newLocationFilter(nil, []string{"UK"})); err != nil || got != "USA" {
+		t.Errorf("only USA allowed: %q %v", got, err)
+	}
+	snap := st.Snapshot()
+	if h := snap.LocationHealth["USA"]; !h.Quarantined || h.ConnectFailures != 3 || h.ConsecutiveQuarantines != 1 {
+		t.Errorf("/status location_health=%+v", snap.LocationHealth)
+	}
+}
+
+// The watchdog tearing a tunnel down counts against its location; a
+// rotation doesn't.
+func TestRecordDisconnect_CountsDrops(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.RecordDisconnect(triggerScheduled)
+	st.RecordTunnelUp("USA", "1.2.3.5")
+	st.RecordDisconnect(triggerWatchdog)
+	st.RecordTunnelUp("USA", "1.2.3.6")
+	st.RecordDisconnect(triggerExitReprobe)
+	if h := st.LocationHealth()["USA"]; h.Drops != 2 {
+		t.Errorf("drops=%v, want 2", h.Drops)
+	}
+}
+
+func TestHealthPolicyFromEnv(t *testing.T) {
+	if p, err := healthPolicyFromEnv(); err != nil || p != defaultHealthPolicy() {
+		t.Errorf("defaults: %+v %v", p, err)
+	}
+	t.Setenv(envLocationQuarantineScore, "1.5")
+	if _, err := healthPolicyFromEnv(); err == nil {
+		t.Error("score 1.5 accepted")
+	}
+	t.Setenv(envLocationQuarantineScore, "0")
+	t.Setenv(envLocationQuarantineSec, "7200")
+	t.Setenv(envLocationQuarantineMax, "3600")
+	if _, err := healthPolicyFromEnv(); err == nil {
+		t.Error("max below base accepted")
+	}
+}
+
+func TestLocationHealth_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := NewStateTracker("fake")
+	for i := 0; i < 3; i++ {
+		st.RecordLocationOutcome("USA", time.Second, errors.New("timeout"))
+	}
+	if err := newStatePersister(path, st, nil, "fake", "p-0", "").save(); err != nil {
+		t.Fatal(err)
+	}
+	next := NewStateTracker("fake")
+	if newStatePersister(path, next, nil, "fake", "p-0", "").restore() == nil {
+		t.Fatal("nothing restored")
+	}
+	if h := next.LocationHealth()["USA"]; !h.Quarantined || h.ConnectFailures < 2.99 {
+		t.Errorf("restored health=%+v", h)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordLocationOutcome(`Odd "Name"`, 2*time.Second, nil)
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	writeMetrics(w, st.Snapshot())
+	w.Flush()
+	for _, want := range []string{
+		"# TYPE tundler_tunnel_ready gauge\ntundler_tunnel_ready 0\n",
+		`tundler_tunnel_location_score{location="Odd \"Name\""} 1`,
+		`tundler_tunnel_location_connect_seconds{location="Odd \"Name\""} 2`,
+		`tundler_tunnel_location_quarantined{location="Odd \"Name\""} 0`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, b.String())
+		}
+	}
+
+	rr := call(fullAPI().routes(), "GET", "/metrics", "")
+	if rr.Code != 200 || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("GET /metrics: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+// A location quarantined by one rotation is skipped by the next.
+func TestConnectWithRetry_SkipsQuarantined(t *testing.T) {
+	st := NewStateTracker("scripted")
+	st.SetHealthPolicy(healthPolicy{halfLife: time.Hour, quarantineScore: 0.6, quarantine: time.Hour, quarantineMax: time.Hour, slowConnect: time.Minute})
+	st.SetLocationStrategy(roundRobinStrategy{})
+	sp := newScriptedProvider([]string{"UK", "USA"}, []bool{false, true, true}, []string{"", "1.1.1.1", "2.2.2.2"})
+	// Rotation 1: UK fails (quarantined), USA succeeds.
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 2, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Rotation 2: round_robin would pick UK next; it's quarantined.
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 1, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatal(err)
+	}
+	if sp.callsByLocation["UK"] != 1 || sp.callsByLocation["USA"] != 2 {
+		t.Errorf("calls=%v, want UK once", sp.callsByLocation)
+	}
+}
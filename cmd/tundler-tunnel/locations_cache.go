@@ -39,6 +39,10 @@ func locationsCacheTTL() time.Duration {
 // file/static-list providers are unaffected — caching a cheap read is
 // harmless. Every other VPNProvider method forwards to the embedded
 // provider unchanged.
+//
+// Each refresh also describes the catalog (provider.Describe): the
+// structured entries, ISO country first, are cached with the names and
+// served by DescribeLocations and Country.
 type cachedLocationsProvider struct {
 	provider.VPNProvider
 	ttl time.Duration
@@ -46,6 +50,8 @@ type cachedLocationsProvider struct {
 
 	mu        sync.Mutex
 	cached    []string
+	described []provider.Location
+	countries map[string]string // name → ISO country, for described names
 	fetchedAt time.Time
 }
 
@@ -73,9 +79,71 @@ func (c *cachedLocationsProvider) Locations(ctx context.Context) []string {
 		return got
 	}
 
+	described := provider.DescribeNames(ctx, c.VPNProvider, got)
 	c.mu.Lock()
 	c.cached = got
+	c.setDescribedLocked(described)
 	c.fetchedAt = c.now()
 	c.mu.Unlock()
 	return got
 }
+
+func (c *cachedLocationsProvider) setDescribedLocked(described []provider.Location) {
+	c.described = described
+	c.countries = make(map[string]string, len(described))
+	for _, l := range described {
+		c.countries[l.Name] = l.Country
+	}
+}
+
+// DescribeLocations is Locations with its cached descriptions, so the
+// cache is itself a provider.LocationDescriber.
+func (c *cachedLocationsProvider) DescribeLocations(ctx context.Context) []provider.Location {
+	c.Locations(ctx)
+	return c.Described()
+}
+
+// Described returns the descriptions of the last-good catalog without
+// triggering a refresh.
+func (c *cachedLocationsProvider) Described() []provider.Location {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.described
+}
+
+// Country is the ISO country of a location name: the provider's own
+// description when the catalog lists the name, else what the name
+// resolves to (geo.Resolve); "" when unknown.
+func (c *cachedLocationsProvider) Country(name string) string {
+	c.mu.Lock()
+	country, ok := c.countries[name]
+	c.mu.Unlock()
+	if ok {
+		return country
+	}
+	return resolveCountry(name)
+}
+
+// Cached returns the last-good catalog and when it was fetched, without
+// triggering a refresh. fetchedAt is zero until a fetch has succeeded.
+func (c *cachedLocationsProvider) Cached() (locations []string, fetchedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cached, c.fetchedAt
+}
+
+// Seed installs a catalog an earlier process fetched (persist.go) as the
+// last-good one: it is served until ttl past fetchedAt, then refreshed as
+// usual, and kept as the fallback if that refresh comes back empty. A
+// no-op once a fetch has succeeded here. described are the saved
+// descriptions; names they lack are described from the name.
+func (c *cachedLocationsProvider) Seed(locations []string, described []provider.Location, fetchedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(locations) == 0 || len(c.cached) > 0 {
+		return
+	}
+	c.cached = locations
+	c.setDescribedLocked(provider.Join(locations, described))
+	c.fetchedAt = fetchedAt
+}
@@ -5,6 +5,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/provider"
 )
 
 // scriptedLocations is a VPNProvider whose Locations() return value is
@@ -123,3 +125,69 @@ func TestLocationsCache_ColdStartPassesThroughEmpty(t *testing.T) {
 		t.Errorf("underlying forked %d times, want 3 (calls 1-3, then a cache hit)", under.callCount())
 	}
 }
+
+// A catalog restored from the state file is served like a fetched one
+// until its TTL runs out, and stays the fallback after that.
+func TestLocationsCache_Seed(t *testing.T) {
+	under := newScripted(func(int) []string { return nil })
+	now := time.Unix(1000, 0)
+	c := newCachedLocationsProvider(under, time.Minute)
+	c.now = func() time.Time { return now }
+	c.Seed([]string{"USA", "Canada"}, nil, now.Add(-30*time.Second))
+
+	if got := c.Locations(context.Background()); len(got) != 2 || under.callCount() != 0 {
+		t.Fatalf("within TTL: got %v after %d forks, want the seed without a fork", got, under.callCount())
+	}
+	now = now.Add(time.Minute)
+	if got := c.Locations(context.Background()); len(got) != 2 || under.callCount() != 1 {
+		t.Errorf("after TTL: got %v after %d forks, want one refresh falling back to the seed", got, under.callCount())
+	}
+}
+
+// describedLocations adds provider.LocationDescriber to scriptedLocations.
+type describedLocations struct {
+	*scriptedLocations
+	entries []provider.Location
+}
+
+func (d describedLocations) DescribeLocations(context.Context) []provider.Location { return d.entries }
+
+// The cache describes what it fetches: the provider's own country wins
+// over the name, and names it doesn't describe are resolved.
+func TestLocationsCache_Describes(t *testing.T) {
+	under := describedLocations{
+		scriptedLocations: newScripted(func(int) []string { return []string{"pool-7", "Germany", "auto"} }),
+		entries:           []provider.Location{{Name: "pool-7", Country: "nl", City: "Amsterdam", Features: []string{"p2p"}}},
+	}
+	c := newCachedLocationsProvider(under, time.Minute)
+	got := c.DescribeLocations(context.Background())
+	if len(got) != 3 || got[0].Country != "NL" || got[0].City != "Amsterdam" || got[1].Country != "DE" || got[2].Country != "" {
+		t.Fatalf("described=%+v", got)
+	}
+	if c.Country("pool-7") != "NL" || c.Country("uk-london") != "GB" {
+		t.Errorf("Country: pool-7=%q uk-london=%q", c.Country("pool-7"), c.Country("uk-london"))
+	}
+
+	// Filters, /status and /locations read the catalog's countries.
+	st := NewStateTracker("fake")
+	st.SetLocationCountries(c.Country)
+	if got := st.AllowedLocations(c.Locations(context.Background()), newLocationFilter([]string{"eu"}, []string{"Germany"})); len(got) != 1 || got[0] != "pool-7" {
+		t.Errorf("eu minus Germany: %v", got)
+	}
+	st.RecordTunnelUp("pool-7", "1.2.3.4")
+	if snap := st.Snapshot(); snap.CurrentCountry != "NL" {
+		t.Errorf("current_country=%q, want NL", snap.CurrentCountry)
+	}
+	for _, e := range buildCatalogView(st, c, nil).Locations {
+		if e.Name == "pool-7" && (e.Country != "NL" || e.City != "Amsterdam" || len(e.Features) != 1) {
+			t.Errorf("/locations entry=%+v", e)
+		}
+	}
+
+	// A seeded catalog keeps its saved descriptions.
+	seeded := newCachedLocationsProvider(newScripted(func(int) []string { return nil }), time.Minute)
+	seeded.Seed([]string{"pool-7"}, got, time.Now())
+	if seeded.Country("pool-7") != "NL" {
+		t.Errorf("seeded Country(pool-7)=%q", seeded.Country("pool-7"))
+	}
+}
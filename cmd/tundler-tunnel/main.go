@@ -38,8 +38,13 @@ const (
 	// 0 = disabled (the default; set via env in the fleet).
 	envRecycleAfterSec       = "RECYCLE_AFTER_SECONDS"
 	envRecycleAfterRot       = "RECYCLE_AFTER_ROTATIONS"
+	envExitReprobeSec        = "EXIT_REPROBE_INTERVAL_SECONDS"
+	envRecentExitIPs         = "RECENT_EXIT_IPS"
+	envRecentExitPrefixV4    = "RECENT_EXIT_PREFIX_V4"
+	envRecentExitPrefixV6    = "RECENT_EXIT_PREFIX_V6"
 	envPodName               = "POD_NAME"               // downward API; → x-tundler-tunnel-id
 	envNodeIP                = "TUNDLER_TUNNEL_NODE_IP" // from caller; → x-tundler-node-ip
+	envImpersonateHTTP3      = "IMPERSONATE_HTTP3"      // opt-in h3; the QUIC hello is not impersonated
 	defaultBootJitterSec     = 60
 	defaultWatchdogIntervSec = 30
 	// Rotation cadence: each interval (and the initial boot offset) is
@@ -53,6 +58,12 @@ const (
 	defaultMinRotationSec = 7200  // 2h
 	defaultMaxRotationSec = 14400 // 4h
 	defaultWedgeGuardSec  = 900   // 15 min — tunable via WEDGE_GUARD_THRESHOLD_SECONDS
+	defaultExitReprobeSec = 300   // 5 min ±20 % — tunable via EXIT_REPROBE_INTERVAL_SECONDS
+	// A rotation re-rolls an exit in the same /24 (/64) as one of the
+	// last 8 — tunable via RECENT_EXIT_IPS and RECENT_EXIT_PREFIX_V4/_V6.
+	defaultRecentExitIPs      = 8
+	defaultRecentExitPrefixV4 = 24
+	defaultRecentExitPrefixV6 = 64
 
 	// Port the in-process Go CONNECT proxy listens on (replaces the
 	// sibling envoy container retired in phase 4). The Service
@@ -63,9 +74,10 @@ const (
 	// Port the browser-impersonating fetch proxy listens on. Unlike the
 	// CONNECT proxy (which byte-pipes the client's own TLS end-to-end), a
 	// client asks THIS one to fetch a page, so the upstream TLS is originated
-	// here with a real browser ClientHello (uTLS + h2) and an edge sees a
-	// genuine browser JA3/JA4 instead of the client's. Runs alongside :8485
-	// during migration; clients switch over in a later phase.
+	// here with a real browser ClientHello (uTLS + h2, or h3 where the host
+	// advertises it via Alt-Svc) and an edge sees a genuine browser JA3/JA4
+	// instead of the client's. Runs alongside :8485 during migration;
+	// clients switch over in a later phase.
 	impersonateListenPort = 8486
 )
 
@@ -128,12 +140,36 @@ func main() {
 	// the process/container goes away — see registerShutdownDisconnect.
 	registerShutdownDisconnect(prov, providerName)
 
+	// Control-API auth (opt-in via TUNDLER_API_*). Refuse to start on a
+	// malformed config rather than fall back to an open admin API.
+	apiAuth, err := loadAPIAuthFromEnv()
+	if err != nil {
+		log.Fatalf("tundler-tunnel: control API auth: %v", err)
+	}
+
 	// Wire state + HTTP server up front so probes can see the pod's
 	// lifecycle from t=0 (Booting → LoggingIn → Ready / Failed).
 	state := NewStateTracker(providerName)
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	// Live-reconfigurable knobs (rotation window, location filters, watchdog,
+	// wedge guard, recycle): env first, then TUNDLER_CONFIG_FILE on top.
+	// PUT /config and SIGHUP change them later without a re-login. A bad
+	// file at boot is fatal, like a bad env value.
+	envConfig := runtimeConfigFromEnv()
+	configFile := os.Getenv(envConfigFile)
+	bootConfig, err := loadConfigFile(envConfig, configFile)
+	if err != nil {
+		log.Fatalf("tundler-tunnel: %s: %v", envConfigFile, err)
+	}
+	configSource := "env"
+	if configFile != "" {
+		configSource = "file"
+	}
+	cfg := newLiveConfig(bootConfig, configSource)
+	go watchConfigReload(ctx, cfg, state, envConfig, configFile)
+
 	// In-process Go HTTP CONNECT proxy — replaces the sibling envoy
 	// container retired in phase 4 of the migration. Single process
 	// for the whole tunnel pod: VPN provider + proxy + HTTP control
@@ -144,6 +180,22 @@ func main() {
 		podName = "tundler-tunnel-local"
 	}
 	nodeIP := os.Getenv(envNodeIP)
+
+	// How connects pick among the allowed locations (strategy.go).
+	// weighted reads location_weights live; partition needs the ordinal
+	// in podName.
+	strategy, err := locationStrategyFromEnv(func() map[string]int { return cfg.Get().LocationWeights }, podName)
+	if err != nil {
+		log.Fatalf("tundler-tunnel: %s: %v", envLocationStrategy, err)
+	}
+	state.SetLocationStrategy(strategy)
+	log.Printf("tundler-tunnel: location strategy %s", strategy.Name())
+	health, err := healthPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("tundler-tunnel: location health: %v", err)
+	}
+	state.SetHealthPolicy(health)
+
 	proxySrv := proxy.New(fmt.Sprintf("0.0.0.0:%d", proxyListenPort), podName, nodeIP)
 	go func() {
 		if err := proxySrv.Serve(ctx); err != nil {
@@ -160,18 +212,27 @@ func main() {
 		if snap.CurrentExitIP == "" {
 			return nil, false
 		}
-		return map[string]any{
+		fields := map[string]any{
 			"provider_id": providerName,
 			"exit_ip":     snap.CurrentExitIP,
 			"node_ip":     nodeIP,
 			"pod":         podName,
-		}, true
+		}
+		if a := snap.ExitASN; a != nil {
+			fields["asn"], fields["asn_org"], fields["hosting"] = a.ASN, a.Org, a.Hosting
+		}
+		return fields, true
 	})
 
 	state.SetTunnelUpListener(func(exitIP string) {
 		// In-process pointer swap — read by proxy.handle on every
 		// subsequent CONNECT, no IPC, no file IO.
 		proxySrv.SetExitIP(exitIP)
+		if g := state.ExitGeo(); g != nil {
+			proxySrv.SetExitGeo(&proxy.ExitGeo{Country: g.Country, Check: g.Verdict})
+		} else {
+			proxySrv.SetExitGeo(nil)
+		}
 		if notifEnabled {
 			// Fire a fresh-exit-IP event without blocking this listener.
 			notif.OnTunnelUp()
@@ -188,7 +249,8 @@ func main() {
 	// through that same dialer (a direct probe would bypass the
 	// upstream proxy and read the node IP). Kernel-tunnel providers
 	// implement neither hook and are unaffected.
-	if pc, ok := prov.(interface{ AttachProxy(*proxy.Server) }); ok {
+	pc, proxyChain := prov.(interface{ AttachProxy(*proxy.Server) })
+	if proxyChain {
 		pc.AttachProxy(proxySrv)
 		contractProbeDialer = proxySrv.DialUpstream
 	}
@@ -214,6 +276,21 @@ func main() {
 	}
 	impSrv := proxy.NewImpersonateServer(
 		fmt.Sprintf("0.0.0.0:%d", impersonateListenPort), podName, impDial)
+	// HTTP/3 only on request (IMPERSONATE_HTTP3): the QUIC hello is not
+	// impersonated, so an h3 edge sees Go's TLS stack behind a browser's
+	// headers. And only where UDP follows the tunnel: kernel-tunnel
+	// providers route every socket via tun0, but a proxy-chain provider's
+	// only egress is its upstream HTTPS proxy — a bare UDP socket there
+	// would leave via the node IP. Hosts that don't advertise h3, or a path
+	// that drops UDP, stay on h2.
+	if getEnvBool(envImpersonateHTTP3) {
+		if proxyChain {
+			log.Printf("tundler-tunnel: %s ignored: proxy-chain provider %s has no UDP path", envImpersonateHTTP3, providerName)
+		} else {
+			impSrv.EnableHTTP3(nil)
+			log.Printf("tundler-tunnel: impersonate proxy h3 enabled; the QUIC hello is not impersonated")
+		}
+	}
 	go func() {
 		if err := impSrv.Serve(ctx); err != nil {
 			log.Printf("tundler-tunnel: impersonate proxy: %v", err)
@@ -227,7 +304,14 @@ func main() {
 	// instead of seeing "0 available" and spiralling. Done AFTER AttachProxy
 	// (handled on the concrete provider above); harmless for static-list
 	// providers. From here on, every prov.Locations() call is cached.
-	prov = newCachedLocationsProvider(prov, locationsCacheTTL())
+	catalog := newCachedLocationsProvider(prov, locationsCacheTTL())
+	prov = catalog
+	state.SetLocationCountries(catalog.Country)
+
+	// Restore what an earlier process of this pod persisted (counters,
+	// recent exits, baseline, catalog) before anything reads them.
+	persist := newStatePersister(stateFilePath(), state, catalog, providerName, podName, nodeIP)
+	restored := persist.restore()
 
 	// Capture the pod's pre-VPN egress IP so the post-Connect contract
 	// check (verifyExitIPDiffers) has a baseline to compare against.
@@ -237,27 +321,80 @@ func main() {
 	// not the baseline). A failed baseline probe is non-fatal: the
 	// contract check degrades to a no-op with a logged warning, so a
 	// cluster with strict pre-VPN egress restrictions still boots.
-	baselineEgressIP, err := probeEgressIP(ctx)
-	if err != nil {
-		log.Printf("tundler-tunnel: pre-VPN baseline egress probe failed: %v — exit-ip contract test disabled", err)
-		baselineEgressIP = ""
-	} else {
-		log.Printf("tundler-tunnel: pre-VPN baseline egress=%s", baselineEgressIP)
+	// In strict mode (EXIT_PROBE_STRICT) a missing baseline instead
+	// fails every connect until a later process probes one. A respawned
+	// process reuses the persisted baseline (see baselineEgress).
+	if egressProbe, err = egressProberFromEnv(); err != nil {
+		log.Fatalf("tundler-tunnel: %v", err)
+	}
+	log.Printf("tundler-tunnel: exit probe %s", egressProbe)
+	// Optional offline geolocation of every new exit (exitgeo.go). A
+	// GEOIP_DB that doesn't open is fatal, like a bad probe endpoint.
+	if exitGeoDB, err = exitGeoDBFromEnv(); err != nil {
+		log.Fatalf("tundler-tunnel: %v", err)
+	}
+	// Likewise the exit ASN lookup (exitasn.go).
+	if exitASNDB, hostingASNs, err = exitASNDBFromEnv(); err != nil {
+		log.Fatalf("tundler-tunnel: %v", err)
 	}
+	// Optional post-connect performance qualification (qualify.go).
+	if exitQualifier, err = qualifierFromEnv(); err != nil {
+		log.Fatalf("tundler-tunnel: %v", err)
+	}
+	if exitQualifier != nil {
+		log.Printf("tundler-tunnel: exit qualification %s", exitQualifier)
+	}
+	baselineEgressIP, baselineV6 := baselineEgress(ctx, prov, restored, probeEgressIP, probeEgressIPv6, egressProbe.ipv6)
+	egressProbe.baselineV6 = baselineV6
+	persist.setBaseline(baselineEgressIP, baselineV6)
+	registerShutdownSave(persist.flush)
+	go persist.run(ctx)
 
 	// /rotate handler invokes this closure in a goroutine; rotateIfReady
 	// guards on state==Ready internally so this is safe to call even if
 	// the hourly rotator timer is racing with an HTTP-driven rotation.
-	// The drain controller now backs onto the in-process proxy (no more
-	// envoy admin HTTP calls).
-	excluded := parseExcludedLocations(os.Getenv(envExcludedLocations))
-	drain := newProxyDrainController(proxySrv)
-	triggerRotation := func() {
-		rotateIfReady(ctx, prov, state, providerName, excluded, drain, baselineEgressIP)
+	// The drain controller backs onto both in-process proxies (no more
+	// envoy admin HTTP calls): the CONNECT proxy and the fetch proxy
+	// egress through the same tunnel, so a rotation bleeds both.
+	drain := newProxyDrainController(proxySrv, impSrv)
+	// Whatever brings a tunnel back up ends a park's drain hold.
+	state.SetOnReady(drain.releaseDrain)
+	triggerRotation := func(req RotateRequest) error {
+		c := cfg.Get()
+		req.RecentExits = c.recentExitPolicy()
+		req.ExitGeo = c.exitGeoPolicy()
+		req.ExitASN = c.exitASNPolicy()
+		return rotateIfReady(ctx, prov, state, providerName, c.locationFilter(), req, drain, baselineEgressIP)
 	}
 
+	api := controlAPI{
+		state:    state,
+		trigger:  triggerRotation,
+		tunnelID: podName,
+		nodeIP:   nodeIP,
+		proxyStats: func() proxyStats {
+			return proxyStats{Connect: proxySrv.Stats(), Impersonate: impSrv.Stats()}
+		},
+		auth:      apiAuth,
+		catalog:   catalog,
+		config:    cfg,
+		drain:     drain,
+		dataPlane: proxySrv,
+		tunnel: tunnelControl{
+			park: func(by string) error {
+				return parkTunnel(ctx, prov, state, drain, by)
+			},
+			unpark: func(string) error {
+				return unparkTunnel(ctx, prov, state, providerName, cfg.Get().locationFilter(), drain, baselineEgressIP)
+			},
+		},
+	}
+	if notifEnabled {
+		// Forward every audited control-API call as an "audit" event.
+		api.audit = func(e AuditEntry) { notif.Event("audit", map[string]any{"audit": e}) }
+	}
 	go func() {
-		if err := startServer(ctx, state, triggerRotation, podName, nodeIP); err != nil {
+		if err := startServer(ctx, api); err != nil {
 			log.Fatalf("tundler-tunnel: HTTP server: %v", err)
 		}
 	}()
@@ -281,16 +418,15 @@ func main() {
 		return
 	}
 
-	// Tunnel hold: pick a random allowed location (provider-filtered minus
-	// EXCLUDED_LOCATIONS) and Connect, retrying IN-PROCESS with backoff
-	// until it succeeds. Crucially we do NOT re-login and do NOT exit on a
-	// connect failure: the session token is established once by Login()
-	// above, and a container restart would force a fresh login — re-login
-	// storms are what trip a provider's shared-account / device-limit
-	// throttle. /readyz stays 503 while we retry, so no traffic is routed
-	// here until the tunnel is actually up. `excluded` is captured in
-	// triggerRotation above; reuse it here.
-	if err := connectInitialWithRetry(ctx, prov, state, providerName, excluded, baselineEgressIP, bootConnectBackoff); err != nil {
+	// Tunnel hold: pick an allowed location (the provider's, through
+	// INCLUDED_LOCATIONS / EXCLUDED_LOCATIONS) and Connect, retrying
+	// IN-PROCESS with backoff until it succeeds. Crucially we do NOT
+	// re-login and do NOT exit on a connect failure: the session token is
+	// established once by Login() above, and a container restart would
+	// force a fresh login — re-login storms are what trip a provider's
+	// shared-account / device-limit throttle. /readyz stays 503 while we retry, so no traffic is routed
+	// here until the tunnel is actually up.
+	if err := connectInitialWithRetry(ctx, prov, state, providerName, cfg.Get().locationFilter(), baselineEgressIP, bootConnectBackoff); err != nil {
 		// Returns only on ctx cancellation — the pod is shutting down
 		// mid-connect. Release any half-up session and exit cleanly.
 		log.Printf("tundler-tunnel: shutting down during initial connect: %v", err)
@@ -302,8 +438,7 @@ func main() {
 	// to a (possibly different) random allowed location. The design doc
 	// says drops should reconnect WITHOUT a re-login (login is one-shot;
 	// session token cached by the VPN client).
-	watchdogInterval := time.Duration(getEnvInt(envWatchdogIntervalSec, defaultWatchdogIntervSec)) * time.Second
-	go runWatchdog(ctx, prov, state, providerName, excluded, watchdogInterval, proxySrv, baselineEgressIP)
+	go runWatchdog(ctx, prov, state, providerName, cfg, proxySrv, baselineEgressIP)
 
 	// Start the rotator: each interval is a fresh uniform random pick
 	// from [MIN_ROTATION_SECONDS, MAX_ROTATION_SECONDS] (defaults 2h-4h),
@@ -321,19 +456,8 @@ func main() {
 	// The disabled mode keeps /rotate available — crawler-driven rotation
 	// (AIMD-triggered POST /rotate) still works; only the scheduled
 	// timer is silenced. Useful for debug pods or single-shot tunnels.
-	minRotation := time.Duration(getEnvInt(envMinRotationSec, defaultMinRotationSec)) * time.Second
-	maxRotation := time.Duration(getEnvInt(envMaxRotationSec, defaultMaxRotationSec)) * time.Second
-	if maxRotation < minRotation {
-		log.Printf("tundler-tunnel: MAX_ROTATION_SECONDS (%s) < MIN_ROTATION_SECONDS (%s); clamping max=min",
-			maxRotation, minRotation)
-		maxRotation = minRotation
-	}
-	rotationEnabled := minRotation > 0 || maxRotation > 0
-	if rotationEnabled {
-		go runRotator(ctx, prov, state, providerName, excluded, minRotation, maxRotation, drain, baselineEgressIP)
-	} else {
-		log.Printf("tundler-tunnel: periodic rotation disabled (MIN_ROTATION_SECONDS=MAX_ROTATION_SECONDS=0); /rotate still honored")
-	}
+	// The rotator runs either way so a live config change can enable it.
+	go runRotator(ctx, prov, state, providerName, cfg, drain, baselineEgressIP)
 
 	// Wedge guard: if state stays continuously not-Ready for longer
 	// than WEDGE_GUARD_THRESHOLD_SECONDS (default 15 min), the watchdog
@@ -344,8 +468,7 @@ func main() {
 	// clearing a wedged provider daemon. Doing it this way (not
 	// kubelet liveness 503) preserves /var/log/journal across the
 	// recovery and keeps the kubelet-visible restart count quiet.
-	wedgeThreshold := time.Duration(getEnvInt(envWedgeGuardSec, defaultWedgeGuardSec)) * time.Second
-	go runWedgeGuard(ctx, state, wedgeThreshold)
+	go runWedgeGuard(ctx, state, cfg)
 
 	// Self-recycle: after a jittered max lifetime and/or a max rotation
 	// count, gracefully drain and exit the CONTAINER so kubelet recreates
@@ -353,11 +476,12 @@ func main() {
 	// new builds/config roll out without hand-restarting pods. 0/0 = off.
 	// Rotation-count trigger: handled inside the rotator (recycle replaces
 	// the next rotation). Time trigger: the runRecycler backstop below.
-	recycleRotationLimit = getEnvInt(envRecycleAfterRot, 0)
-	recycleAfter := time.Duration(getEnvInt(envRecycleAfterSec, 0)) * time.Second
-	if recycleAfter > 0 {
-		go runRecycler(ctx, state, drain, recycleAfter)
-	}
+	go runRecycler(ctx, state, drain, cfg)
+
+	// Exit re-probe: while Ready, re-run the exit-IP contract probe on a
+	// jittered interval so a provider-side server move or a fallback to
+	// eth0 is caught mid-life, not at the next rotation (exitprobe.go).
+	go runExitReprobe(ctx, prov, state, providerName, cfg, baselineEgressIP, probeContractEgressIP)
 
 	// Rotation is now exclusively driven by the crawler: each slot
 	// tracks AIMD + per-tunnel 429s and, on sustained throttling,
@@ -366,17 +490,21 @@ func main() {
 	// flow that scheduled rotations use.
 	_ = triggerRotation // referenced by /rotate handler in startServer
 
-	rotationDesc := fmt.Sprintf("[%s..%s]", minRotation, maxRotation)
-	if !rotationEnabled {
+	c := cfg.Get()
+	rotationDesc := fmt.Sprintf("[%s..%s]", c.MinRotation.d(), c.MaxRotation.d())
+	if !c.rotationEnabled() {
 		rotationDesc = "disabled"
 	}
 	log.Printf("tundler-tunnel: holding tunnel; watchdog=%s rotation=%s wedge_guard=%s",
-		watchdogInterval, rotationDesc, wedgeThreshold)
+		c.WatchdogInterval.d(), rotationDesc, c.WedgeGuardThreshold.d())
 
 	// Hold the tunnel until SIGTERM. Future slices add the self-monitor
 	// (Trigger C) and Layer 1+2 envoy drain hooks.
 	<-ctx.Done()
 	log.Printf("tundler-tunnel: shutting down")
+	// Same drain as POST /drain (fresh context: ctx is cancelled).
+	_ = shutdownDrain(context.Background(), state, drain, "sigterm", sigtermDrainTimeout)
+	state.RecordDisconnect(triggerShutdown)
 	// Release the device slot client-side before exiting (fresh context —
 	// ctx is the now-cancelled signal context).
 	gracefulDisconnect()
@@ -398,8 +526,9 @@ func main() {
 //	                                                    → attempt reconnect
 //	state == Failed → always attempt reconnect (with backoff)
 //	other states (Booting / LoggingIn / Connecting / Draining /
-//	              Rotating) → another code path owns the lifecycle,
-//	                          stay out of the way
+//	              Rotating / Parked) → another code path (or the
+//	                          operator) owns the lifecycle, stay out
+//	                          of the way
 //
 // The goroutine STAYS ALIVE across reconnect failures: a failed
 // attempt sets state=Failed and sleeps with exponential backoff,
@@ -408,15 +537,30 @@ func main() {
 // caught by runWedgeGuard, which exits the process after the wedge
 // threshold; systemd then respawns the binary fresh in-container
 // (login-free), and the boot login/connect retry loops take over.
-func runWatchdog(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, interval time.Duration, proxySrv *proxy.Server, baselineEgressIP string) {
+//
+// The tick period and location filters are read from cfg live.
+func runWatchdog(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, cfg *liveConfig, proxySrv *proxy.Server, baselineEgressIP string) {
+	// Take the change channel before reading the config so an Apply in
+	// between is never missed.
+	changed := cfg.Changed()
+	interval := cfg.Get().WatchdogInterval.d()
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	backoff := watchdogMinBackoff
+	state.Heartbeat(loopWatchdog, interval)
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-changed:
+			changed = cfg.Changed()
+			if next := cfg.Get().WatchdogInterval.d(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+			state.Heartbeat(loopWatchdog, interval)
 		case <-ticker.C:
+			state.Heartbeat(loopWatchdog, interval)
 			current := state.Get()
 			// Stay out of the way while some other code path drives
 			// the connection. /rotate handlers, initial connect, and
@@ -431,10 +575,24 @@ func runWatchdog(ctx context.Context, prov provider.VPNProvider, state *StateTra
 			h := proxySrv.RecentTunnelHealth()
 			log.Printf("tundler-tunnel: watchdog reconnect attempt (state=%s, consecutiveDialFails=%d, lastDialAt=%s)",
 				current, h.ConsecutiveFailures, h.LastDialAt.Format(time.RFC3339))
-			if err := connectTunnel(ctx, prov, state, providerName, excluded, baselineEgressIP); err != nil {
+			if current == StateReady {
+				state.RecordDisconnect(triggerWatchdog)
+			}
+			// A reconnect plus its backoff may outlast the tick; past the
+			// wedge threshold the wedge guard has exited anyway.
+			state.Heartbeat(loopWatchdog, cfg.Get().WedgeGuardThreshold.d()+backoff)
+			state.BeginConnect(triggerWatchdog)
+			if err := connectTunnel(ctx, prov, state, providerName, cfg.Get().locationFilter(), baselineEgressIP); err != nil {
 				log.Printf("tundler-tunnel: watchdog reconnect failed: %v (next retry in %s)",
 					err, backoff)
-				state.Set(StateFailed)
+				state.Transition(StateFailed, "watchdog reconnect failed: "+err.Error())
+				state.Publish(eventWatchdogReconnect, map[string]any{
+					"outcome":                   "failed",
+					"state":                     current,
+					"consecutive_dial_failures": h.ConsecutiveFailures,
+					"error":                     err.Error(),
+					"next_retry_seconds":        backoff.Seconds(),
+				})
 				select {
 				case <-ctx.Done():
 					return
@@ -448,6 +606,11 @@ func runWatchdog(ctx context.Context, prov provider.VPNProvider, state *StateTra
 				}
 				continue
 			}
+			state.Publish(eventWatchdogReconnect, map[string]any{
+				"outcome":                   "success",
+				"state":                     current,
+				"consecutive_dial_failures": h.ConsecutiveFailures,
+			})
 			backoff = watchdogMinBackoff
 		}
 	}
@@ -468,14 +631,7 @@ func runWatchdog(ctx context.Context, prov provider.VPNProvider, state *StateTra
 // recent traffic AND a sustained failure pattern that the proxy
 // observed end-to-end.
 func tunnelLooksHealthy(proxySrv *proxy.Server) bool {
-	h := proxySrv.RecentTunnelHealth()
-	if h.LastDialAt.IsZero() || time.Since(h.LastDialAt) > dialSilenceWindow {
-		return true
-	}
-	if h.LastDialSucceeded {
-		return true
-	}
-	return h.ConsecutiveFailures < dialFailureThreshold
+	return dialsLookHealthy(proxySrv.RecentTunnelHealth(), time.Now())
 }
 
 // runWedgeGuard exits the process when state stays continuously NOT
@@ -489,34 +645,52 @@ func tunnelLooksHealthy(proxySrv *proxy.Server) bool {
 // and kubelet's restart count stays clean.
 //
 // nonReadySince is reset every time state re-enters Ready, so a
-// flaky-but-recovering watchdog never trips the guard.
-func runWedgeGuard(ctx context.Context, state *StateTracker, threshold time.Duration) {
-	ticker := time.NewTicker(30 * time.Second)
+// flaky-but-recovering watchdog never trips the guard. Parked counts as
+// healthy: the operator asked for the tunnel to be down.
+//
+// The threshold is read from cfg on every tick.
+func runWedgeGuard(ctx context.Context, state *StateTracker, cfg *liveConfig) {
+	ticker := time.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 	var nonReadySince time.Time
+	state.Heartbeat(loopWedgeGuard, heartbeatInterval)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if state.Get() == StateReady {
+			state.Heartbeat(loopWedgeGuard, heartbeatInterval)
+			if s := state.Get(); s == StateReady || s == StateParked {
 				if !nonReadySince.IsZero() {
 					log.Printf("tundler-tunnel: wedge guard cleared after %s",
 						time.Since(nonReadySince).Round(time.Second))
+					state.Publish(eventWedgeGuardCleared, map[string]any{
+						"not_ready_seconds": time.Since(nonReadySince).Seconds(),
+					})
 					nonReadySince = time.Time{}
 				}
 				continue
 			}
+			threshold := cfg.Get().WedgeGuardThreshold.d()
 			if nonReadySince.IsZero() {
 				nonReadySince = time.Now()
+				state.Publish(eventWedgeGuardArmed, map[string]any{
+					"state":             state.Get(),
+					"threshold_seconds": threshold.Seconds(),
+				})
 				continue
 			}
 			elapsed := time.Since(nonReadySince)
 			if elapsed > threshold {
 				log.Printf("tundler-tunnel: wedge guard tripped — state=%s for %s (> %s); exiting for systemd respawn",
 					state.Get(), elapsed.Round(time.Second), threshold)
+				state.Publish(eventWedgeGuardTripped, map[string]any{
+					"state":             state.Get(),
+					"not_ready_seconds": elapsed.Seconds(),
+				})
 				// Drop any half-up tunnel so the respawn doesn't briefly
 				// overlap a second session on the account.
+				state.RecordDisconnect(triggerWedgeGuard)
 				gracefulDisconnect()
 				os.Exit(1)
 			}
@@ -541,6 +715,20 @@ func registryKeys() []string {
 	return keys
 }
 
+// getEnvBool reads a boolean env var; unset is false, anything
+// strconv.ParseBool rejects is fatal.
+func getEnvBool(name string) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("tundler-tunnel: %s=%q is not a boolean", name, v)
+	}
+	return b
+}
+
 // getEnvInt reads an integer env var; returns def if unset, fatals if
 // set to a non-integer. Used for the small handful of numeric tuning
 // knobs (jitter window, watchdog interval).
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/provider"
+)
+
+// Maintenance controls for incident triage:
+//
+//	POST /rotation/pause, /rotation/resume — hold scheduled rotations
+//	    (and the recycler) so the pod keeps its exit; /rotate still works.
+//	POST /tunnel/disconnect, /tunnel/connect — drain and disconnect the
+//	    tunnel into StateParked, and bring it back. Nothing reconnects a
+//	    Parked pod on its own: the watchdog, rotator and recycler only act
+//	    from Ready/Failed, and the wedge guard does not count Parked as
+//	    wedged.
+//
+// Neither survives a process restart: a respawned pod boots, connects
+// and rotates normally.
+
+// errTunnelBusy is returned by parkTunnel / unparkTunnel when the pod is
+// not in a state they act from (another code path owns the connection).
+var errTunnelBusy = errors.New("tunnel is busy")
+
+// PauseRotation holds scheduled rotations. Returns false if they were
+// already paused (the original pause is kept).
+func (s *StateTracker) PauseRotation(by string) bool {
+	s.mu.Lock()
+	if !s.rotationPausedAt.IsZero() {
+		s.mu.Unlock()
+		return false
+	}
+	s.rotationPausedAt = time.Now().UTC()
+	s.rotationPausedBy = by
+	s.mu.Unlock()
+	s.Publish(eventRotationPaused, map[string]any{"by": by})
+	return true
+}
+
+// ResumeRotation lifts a pause. Returns false if rotation wasn't paused.
+func (s *StateTracker) ResumeRotation(by string) bool {
+	s.mu.Lock()
+	since := s.rotationPausedAt
+	s.rotationPausedAt, s.rotationPausedBy = time.Time{}, ""
+	s.mu.Unlock()
+	if since.IsZero() {
+		return false
+	}
+	s.Publish(eventRotationResumed, map[string]any{"by": by, "paused_seconds": time.Since(since).Seconds()})
+	return true
+}
+
+// RotationPaused reports whether scheduled rotations are held.
+func (s *StateTracker) RotationPaused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.rotationPausedAt.IsZero()
+}
+
+// drainHolder is implemented by proxyDrainController: it keeps the
+// proxies refusing new work for as long as the tunnel is parked, so
+// nothing egresses through the node while no tunnel is up. The hold
+// starts before the drain wait (which would otherwise reopen the
+// proxies when it returns) and ends only once a tunnel is up again:
+// on a successful connect, or through StateTracker.SetOnReady when
+// the watchdog recovers a pod whose connect failed.
+type drainHolder interface {
+	holdDrain()
+	releaseDrain()
+}
+
+// parkTunnel drains the proxies, disconnects and moves the pod to
+// StateParked. Acts only from Ready or Failed; a Disconnect error is
+// logged and the pod parked anyway (the daemon is told again on connect
+// or shutdown).
+func parkTunnel(ctx context.Context, prov provider.VPNProvider, state *StateTracker, drain drainController, by string) error {
+	if state.ShuttingDown() {
+		return fmt.Errorf("%w: shutting down", errTunnelBusy)
+	}
+	// Check and drain in one step so a racing rotation or second park
+	// can't also start from the same Ready.
+	if err := state.TransitionIf(StateDraining, "park", func(current State, _ string, _ uint64) error {
+		if current != StateReady && current != StateFailed {
+			return fmt.Errorf("%w: state=%s", errTunnelBusy, current)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	log.Printf("tundler-tunnel: parking tunnel (requested by %s)", by)
+	if drain != nil {
+		if h, ok := drain.(drainHolder); ok {
+			h.holdDrain()
+		}
+		if err := drain.TriggerGracefulDrain(ctx); err != nil {
+			log.Printf("tundler-tunnel: drain trigger failed (continuing): %v", err)
+		}
+		if err := drain.WaitForActiveConnectionsToDrain(ctx, drainWaitTimeout); err != nil {
+			log.Printf("tundler-tunnel: drain wait: %v (proceeding to Disconnect)", err)
+		}
+	}
+	state.RecordDisconnect(triggerPark)
+	if err := prov.Disconnect(ctx); err != nil {
+		log.Printf("tundler-tunnel: park Disconnect failed (parking anyway): %v", err)
+	}
+	state.Transition(StateParked, "parked by "+by)
+	return nil
+}
+
+// unparkTunnel reconnects a Parked pod with one connectTunnel attempt.
+// On failure the pod goes to Failed, where the watchdog takes over, and
+// the proxies stay drained until it brings a tunnel up.
+func unparkTunnel(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, drain drainController, baselineEgressIP string) error {
+	if state.ShuttingDown() {
+		return fmt.Errorf("%w: shutting down", errTunnelBusy)
+	}
+	if err := state.TransitionIf(StateConnecting, "connect from Parked", func(current State, _ string, _ uint64) error {
+		if current != StateParked {
+			return fmt.Errorf("%w: state=%s", errTunnelBusy, current)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	state.BeginConnect(triggerAPI)
+	if err := connectTunnel(ctx, prov, state, providerName, filter, baselineEgressIP); err != nil {
+		// No tunnel: the proxies stay held until one is up.
+		state.RecordConnectFailure(err)
+		state.Transition(StateFailed, "connect from Parked failed: "+err.Error())
+		return err
+	}
+	if h, ok := drain.(drainHolder); ok {
+		h.releaseDrain()
+	}
+	return nil
+}
+
+// tunnelControl parks and un-parks the tunnel for /tunnel/disconnect and
+// /tunnel/connect. by is the audited caller. Production wires parkTunnel
+// and unparkTunnel; tests pass stubs.
+type tunnelControl struct {
+	park   func(by string) error
+	unpark func(by string) error
+}
+
+// callerOf names the audited caller of r, for pause/park attribution.
+func callerOf(r *http.Request) string {
+	if e := auditFrom(r.Context()); e != nil && e.Caller != "" {
+		return e.Caller
+	}
+	return "anonymous"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// rotationPauseHandler implements POST /rotation/pause and
+// POST /rotation/resume (pause=false). Both are idempotent.
+func rotationPauseHandler(state *StateTracker, pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		by := callerOf(r)
+		var changed bool
+		if pause {
+			changed = state.PauseRotation(by)
+		} else {
+			changed = state.ResumeRotation(by)
+		}
+		if changed {
+			log.Printf("tundler-tunnel: scheduled rotation paused=%t by %s", pause, by)
+		}
+		snap := state.Snapshot()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"rotation_paused":    snap.RotationPaused,
+			"rotation_paused_at": snap.RotationPausedAt,
+			"rotation_paused_by": snap.RotationPausedBy,
+			"changed":            changed,
+		})
+	}
+}
+
+// tunnelDisconnectHandler implements POST /tunnel/disconnect: drain,
+// disconnect and park, answering once the pod is Parked. 409 while
+// another code path owns the connection.
+func tunnelDisconnectHandler(state *StateTracker, park func(by string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state.Get() == StateParked {
+			writeJSON(w, http.StatusOK, map[string]string{"state": string(StateParked), "message": "already parked"})
+			return
+		}
+		previous := state.SnapshotCurrentExitIP()
+		if err := park(callerOf(r)); err != nil {
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/tunnel-busy",
+				Title:  "Tunnel cannot be parked now",
+				Status: http.StatusConflict,
+				Detail: err.Error(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"state": string(state.Get()), "previous_exit_ip": previous})
+	}
+}
+
+// tunnelConnectHandler implements POST /tunnel/connect: reconnect a
+// Parked pod and answer with the new tunnel. 409 unless Parked (200 if
+// already Ready); 502 if the connect failed, leaving the pod Failed for
+// the watchdog.
+func tunnelConnectHandler(state *StateTracker, unpark func(by string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state.Get() == StateReady {
+			writeJSON(w, http.StatusOK, map[string]string{"state": string(StateReady), "message": "tunnel already up"})
+			return
+		}
+		err := unpark(callerOf(r))
+		switch {
+		case errors.Is(err, errTunnelBusy):
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/not-parked",
+				Title:  "Tunnel is not parked",
+				Status: http.StatusConflict,
+				Detail: err.Error(),
+			})
+		case err != nil:
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/connect-failed",
+				Title:  "Connect from Parked failed",
+				Status: http.StatusBadGateway,
+				Detail: err.Error() + "; the watchdog keeps retrying",
+			})
+		default:
+			snap := state.Snapshot()
+			writeJSON(w, http.StatusOK, map[string]string{
+				"state":            string(snap.State),
+				"current_location": snap.CurrentLocation,
+				"current_exit_ip":  snap.CurrentExitIP,
+			})
+		}
+	}
+}
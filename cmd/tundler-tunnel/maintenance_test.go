@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
+)
+
+// Park holds the proxies drained and the watchdog leaves the pod alone;
+// connect brings it back and reopens them.
+func TestParkAndUnparkTunnel(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA"}, connectIP: "5.6.7.8", connectOK: true}
+	st := NewStateTracker("fake")
+	st.BeginConnect(triggerBoot)
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+	srv := proxy.New("", "", "")
+	drain := newProxyDrainController(srv, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := parkTunnel(ctx, fp, st, drain, "oncall"); err != nil {
+		t.Fatal(err)
+	}
+	if st.Get() != StateParked || fp.disconnectCalls.Load() != 1 {
+		t.Fatalf("state=%s disconnects=%d, want Parked after one Disconnect", st.Get(), fp.disconnectCalls.Load())
+	}
+	if !srv.IsDraining() {
+		t.Error("proxy accepting while parked, want it held in drain mode")
+	}
+	if h := st.History(); len(h) != 1 || h[0].EndedBy != triggerPark {
+		t.Errorf("history=%+v, want the session ended by park", h)
+	}
+	if err := parkTunnel(ctx, fp, st, drain, "oncall"); !errors.Is(err, errTunnelBusy) {
+		t.Errorf("park while Parked: err=%v, want errTunnelBusy", err)
+	}
+
+	wctx, stopWatchdog := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		runWatchdog(wctx, fp, st, "fake", watchdogConfig(5*time.Millisecond), srv, "")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stopWatchdog()
+	<-done
+	if st.Get() != StateParked || fp.callCount() != 0 {
+		t.Fatalf("state=%s connects=%d, want the watchdog to leave a Parked pod alone", st.Get(), fp.callCount())
+	}
+
+	if err := unparkTunnel(ctx, fp, st, "fake", nil, drain, ""); err != nil {
+		t.Fatal(err)
+	}
+	if st.Get() != StateReady || st.SnapshotCurrentExitIP() != "5.6.7.8" || srv.IsDraining() {
+		t.Errorf("state=%s exit=%s draining=%t, want Ready on 5.6.7.8 and accepting",
+			st.Get(), st.SnapshotCurrentExitIP(), srv.IsDraining())
+	}
+	if err := unparkTunnel(ctx, fp, st, "fake", nil, drain, ""); !errors.Is(err, errTunnelBusy) {
+		t.Errorf("connect while Ready: err=%v, want errTunnelBusy", err)
+	}
+}
+
+// disconnectHook runs hook at the provider's Disconnect.
+type disconnectHook struct {
+	*fakeProvider
+	hook func()
+}
+
+func (d disconnectHook) Disconnect(ctx context.Context) error {
+	d.hook()
+	return d.fakeProvider.Disconnect(ctx)
+}
+
+// A fetch arriving between the drain wait and the Disconnect is refused:
+// the park holds drain mode through the wait instead of reopening.
+func TestParkTunnel_HoldsDrainThroughDisconnect(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA"}}
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+	srv := proxy.New("", "", "")
+	imp := proxy.NewImpersonateServer("", "", nil)
+	drain := newProxyDrainController(srv, imp)
+
+	var code int
+	prov := disconnectHook{fp, func() {
+		rr := httptest.NewRecorder()
+		imp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		code = rr.Code
+	}}
+	if err := parkTunnel(context.Background(), prov, st, drain, "oncall"); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusServiceUnavailable || !srv.IsDraining() {
+		t.Errorf("fetch during the park got %d (connect proxy draining=%t), want 503 and draining", code, srv.IsDraining())
+	}
+}
+
+// A failed connect from Parked leaves the proxies drained; the watchdog
+// bringing a tunnel up reopens them.
+func TestUnparkTunnel_FailureKeepsDrain(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA"}, connectIP: "5.6.7.8"}
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+	srv := proxy.New("", "", "")
+	imp := proxy.NewImpersonateServer("", "", nil)
+	drain := newProxyDrainController(srv, imp)
+	st.SetOnReady(drain.releaseDrain)
+	ctx := context.Background()
+
+	if err := parkTunnel(ctx, fp, st, drain, "oncall"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unparkTunnel(ctx, fp, st, "fake", nil, drain, ""); err == nil {
+		t.Fatal("unpark succeeded with a failing provider")
+	}
+	if st.Get() != StateFailed || !srv.IsDraining() || !imp.IsDraining() {
+		t.Fatalf("state=%s draining=%t/%t, want Failed with both proxies still draining", st.Get(), srv.IsDraining(), imp.IsDraining())
+	}
+
+	fp.mu.Lock()
+	fp.connectOK = true
+	fp.mu.Unlock()
+	if err := connectTunnel(ctx, fp, st, "fake", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if srv.IsDraining() || imp.IsDraining() {
+		t.Error("proxies still draining after the tunnel came back up")
+	}
+}
+
+// A park landing while a rotation is still picking its location wins:
+// the rotation finds the pod Parked instead of draining it again.
+func TestParkTunnel_BeatsRotationInFlight(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA", "UK"}, connectIP: "5.6.7.8", connectOK: true}
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+
+	rotated := make(chan error, 1)
+	go func() {
+		rotated <- rotateIfReadyWithDeps(context.Background(), slowLocations{fp}, st, "fake", nil,
+			RotateRequest{Location: "UK"}, 3, (&noSleep{}).sleep, nil, "")
+	}()
+	time.Sleep(5 * time.Millisecond)
+	if err := parkTunnel(context.Background(), fp, st, nil, "oncall"); err != nil {
+		t.Fatalf("park: %v", err)
+	}
+	if err := <-rotated; !errors.Is(err, errRotationSkipped) {
+		t.Errorf("rotation err=%v, want errRotationSkipped", err)
+	}
+	if st.Get() != StateParked || fp.callCount() != 0 || fp.disconnectCalls.Load() != 1 {
+		t.Errorf("state=%s connects=%d disconnects=%d, want Parked after the park's one Disconnect",
+			st.Get(), fp.callCount(), fp.disconnectCalls.Load())
+	}
+}
+
+func TestUnparkTunnel_FailureHandsOverToWatchdog(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA"}}
+	st := NewStateTracker("fake")
+	st.Set(StateParked)
+	if err := unparkTunnel(context.Background(), fp, st, "fake", nil, nil, ""); err == nil {
+		t.Fatal("want the failed connect reported")
+	}
+	if st.Get() != StateFailed {
+		t.Errorf("state=%s, want Failed so the watchdog retries", st.Get())
+	}
+}
+
+func TestRotationPauseEndpoints(t *testing.T) {
+	triggered := make(chan struct{}, 1)
+	api := readyAPI(nil, nil, func(RotateRequest) error { triggered <- struct{}{}; return nil })
+	h := api.routes()
+
+	rr := call(h, http.MethodPost, "/rotation/pause", "")
+	var body struct {
+		Paused  bool `json:"rotation_paused"`
+		Changed bool `json:"changed"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || !body.Paused || !body.Changed || !api.state.RotationPaused() {
+		t.Fatalf("pause: got %d %s, want 200 paused", rr.Code, rr.Body)
+	}
+	if rr := call(h, http.MethodPost, "/rotation/pause", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"changed":false`) {
+		t.Errorf("second pause: got %d, want an idempotent 200", rr.Code)
+	}
+	if rr := call(h, http.MethodGet, "/rotation/pause", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %d, want 405", rr.Code)
+	}
+
+	// Explicit rotations still go through while paused.
+	if rr := call(h, http.MethodPost, "/rotate", ""); rr.Code != http.StatusAccepted {
+		t.Errorf("/rotate while paused: got %d, want 202", rr.Code)
+	}
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Error("rotation not triggered while paused")
+	}
+
+	if rr := call(h, http.MethodPost, "/rotation/resume", ""); rr.Code != http.StatusOK || api.state.RotationPaused() {
+		t.Errorf("resume: got %d paused=%t, want 200 and unpaused", rr.Code, api.state.RotationPaused())
+	}
+}
+
+func TestTunnelEndpoints(t *testing.T) {
+	api := readyAPI(nil, nil, nil)
+	api.tunnel = tunnelControl{
+		park: func(string) error { api.state.Set(StateParked); return nil },
+		unpark: func(string) error {
+			if api.state.Get() != StateParked {
+				return errTunnelBusy
+			}
+			api.state.RecordTunnelUp("UK", "9.9.9.9")
+			api.state.Set(StateReady)
+			return nil
+		},
+	}
+	h := api.routes()
+
+	if rr := call(h, http.MethodPost, "/tunnel/disconnect", ""); rr.Code != http.StatusOK || api.state.Get() != StateParked {
+		t.Fatalf("disconnect: got %d state=%s, want 200 Parked", rr.Code, api.state.Get())
+	}
+	if rr := call(h, http.MethodPost, "/rotate", ""); rr.Code != http.StatusConflict {
+		t.Errorf("/rotate while Parked: got %d, want 409", rr.Code)
+	}
+	if rr := call(h, http.MethodGet, "/readyz", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while Parked: got %d, want 503", rr.Code)
+	}
+	rr := call(h, http.MethodPost, "/tunnel/connect", "")
+	if rr.Code != http.StatusOK || api.state.SnapshotCurrentExitIP() != "9.9.9.9" {
+		t.Errorf("connect: got %d %s, want 200 on the new tunnel", rr.Code, rr.Body)
+	}
+	if rr := call(h, http.MethodPost, "/tunnel/connect", ""); rr.Code != http.StatusOK {
+		t.Errorf("connect while Ready: got %d, want 200", rr.Code)
+	}
+
+	api.state.Set(StateRotating)
+	api.tunnel.park = func(string) error { return errTunnelBusy }
+	if rr := call(api.routes(), http.MethodPost, "/tunnel/disconnect", ""); rr.Code != http.StatusConflict {
+		t.Errorf("disconnect mid-rotation: got %d, want 409", rr.Code)
+	}
+}
+
+// A paused rotator keeps its cadence but does not rotate.
+func TestRunRotator_SkipsWhilePaused(t *testing.T) {
+	c := defaultRuntimeConfig()
+	c.MinRotation, c.MaxRotation = seconds(5*time.Millisecond), seconds(5*time.Millisecond)
+	fp := &fakeProvider{locations: []string{"USA"}, connectIP: "5.6.7.8", connectOK: true}
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+	st.PauseRotation("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runRotator(ctx, fp, st, "fake", newLiveConfig(c, "env"), nil, "")
+
+	time.Sleep(50 * time.Millisecond)
+	if n := st.Snapshot().RotationCountTotal; n != 0 {
+		t.Fatalf("rotation_count_total=%d while paused, want 0", n)
+	}
+	st.ResumeRotation("test")
+	deadline := time.Now().Add(2 * time.Second)
+	for st.Snapshot().RotationCountTotal == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no rotation after resume")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
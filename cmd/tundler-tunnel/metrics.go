@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// GET /metrics: the Prometheus text exposition format, hand-written to
+// keep the client library out of the pod. Location series are labelled
+// by provider location name.
+
+// metricsContentType is the Prometheus text format, version 0.0.4.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsHandler implements GET /metrics.
+func metricsHandler(state *StateTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw, state.Snapshot())
+		_ = bw.Flush()
+	}
+}
+
+func writeMetrics(w *bufio.Writer, snap Snapshot) {
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	counter := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+	ready := 0
+	if snap.State == StateReady {
+		ready = 1
+	}
+	gauge("tundler_tunnel_ready", "1 while the tunnel is Ready.")
+	fmt.Fprintf(w, "tundler_tunnel_ready %d\n", ready)
+	counter("tundler_tunnel_rotations_total", "Rotations since the pod started.")
+	fmt.Fprintf(w, "tundler_tunnel_rotations_total %d\n", snap.RotationCountTotal)
+	counter("tundler_tunnel_auth_failures_total", "Provider login failures since the pod started.")
+	fmt.Fprintf(w, "tundler_tunnel_auth_failures_total %d\n", snap.AuthFailuresTotal)
+	counter("tundler_tunnel_process_restarts_total", "Process respawns since the pod started.")
+	fmt.Fprintf(w, "tundler_tunnel_process_restarts_total %d\n", snap.ProcessRestarts)
+
+	locations := make([]string, 0, len(snap.LocationHealth))
+	for loc := range snap.LocationHealth {
+		locations = append(locations, loc)
+	}
+	slices.Sort(locations)
+	series := []struct {
+		name, help string
+		value      func(LocationHealth) float64
+	}{
+		{"tundler_tunnel_location_score", "Decaying health score of a location, 0 to 1.",
+			func(h LocationHealth) float64 { return h.Score }},
+		{"tundler_tunnel_location_quarantined", "1 while a location is quarantined.",
+			func(h LocationHealth) float64 { return b2f(h.Quarantined) }},
+		{"tundler_tunnel_location_connect_seconds", "Moving average of a location's time to connect.",
+			func(h LocationHealth) float64 { return h.AvgConnectSeconds }},
+		{"tundler_tunnel_location_successes", "Decayed successful connects of a location.",
+			func(h LocationHealth) float64 { return h.Successes }},
+		{"tundler_tunnel_location_connect_failures", "Decayed failed connects of a location.",
+			func(h LocationHealth) float64 { return h.ConnectFailures }},
+		{"tundler_tunnel_location_contract_failures", "Decayed exit-IP contract failures of a location.",
+			func(h LocationHealth) float64 { return h.ContractFailures }},
+		{"tundler_tunnel_location_drops", "Decayed watchdog and re-probe teardowns of a location.",
+			func(h LocationHealth) float64 { return h.Drops }},
+	}
+	for _, s := range series {
+		if len(locations) == 0 {
+			break
+		}
+		gauge(s.name, s.help)
+		for _, loc := range locations {
+			fmt.Fprintf(w, "%s{location=\"%s\"} %g\n", s.name, escapeLabel(loc), s.value(snap.LocationHealth[loc]))
+		}
+	}
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// labelEscaper escapes a label value: backslash, double quote, newline.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
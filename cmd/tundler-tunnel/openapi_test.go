@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
+	"github.com/laurentpellegrino/tundler/tunnelapi"
+)
+
+// openAPISchema is the subset of a JSON Schema the checks below read.
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Required   []string                  `json:"required"`
+	AllOf      []*openAPISchema          `json:"allOf"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema *openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(tunnelapi.OpenAPI(), &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc
+}
+
+// properties resolves refs and allOf into the flat property set.
+func (d openAPIDoc) properties(s *openAPISchema) map[string]bool {
+	out := map[string]bool{}
+	if s == nil {
+		return out
+	}
+	if s.Ref != "" {
+		return d.properties(d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")])
+	}
+	for name := range s.Properties {
+		out[name] = true
+	}
+	for _, part := range s.AllOf {
+		for name := range d.properties(part) {
+			out[name] = true
+		}
+	}
+	return out
+}
+
+// jsonFields lists the JSON keys of a struct type, embedded structs
+// flattened the way encoding/json does.
+func jsonFields(t reflect.Type) map[string]bool {
+	out := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" {
+			for name := range jsonFields(f.Type) {
+				out[name] = true
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out[name] = true
+	}
+	return out
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// fullAPI is a controlAPI with every optional route wired.
+func fullAPI() controlAPI {
+	api := readyAPI(nil, nil, nil)
+	api.tunnelID, api.nodeIP = "tundler-tunnel-0", "10.0.0.1"
+	api.proxyStats = func() proxyStats { return proxyStats{} }
+	api.config = newLiveConfig(defaultRuntimeConfig(), "env")
+	api.catalog = newCachedLocationsProvider(newScripted(func(int) []string { return []string{"USA"} }), time.Minute)
+	api.tunnel = tunnelControl{
+		park:   func(string) error { return errTunnelBusy },
+		unpark: func(string) error { return errTunnelBusy },
+	}
+	api.drain = newProxyDrainController(proxy.New("", "", ""), nil)
+	api.dataPlane = &stubDataPlane{listening: true}
+	return api
+}
+
+// Every served route is documented, and nothing undocumented is served.
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	served := map[string]map[string]bool{} // path → methods ("" = any)
+	for _, p := range fullAPI().routes().patterns {
+		method, path, ok := strings.Cut(p, " ")
+		if !ok {
+			method, path = "", p
+		}
+		if served[path] == nil {
+			served[path] = map[string]bool{}
+		}
+		served[path][strings.ToLower(method)] = true
+		ops, documented := doc.Paths[path]
+		if !documented {
+			t.Errorf("route %q is not in openapi.json", p)
+			continue
+		}
+		if method != "" && ops[strings.ToLower(method)] == nil {
+			t.Errorf("route %q: openapi.json has no %s operation", p, method)
+		}
+	}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if m := served[path]; m == nil || (!m[""] && !m[method]) {
+				t.Errorf("openapi.json documents %s %s, which is not served", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+// The schemas name exactly the JSON keys of the Go types behind them.
+func TestOpenAPI_SchemasMatchTypes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	rotateBody := reflect.TypeOf(struct {
+		RotateRequest
+		Wait           bool `json:"wait"`
+		TimeoutSeconds int  `json:"timeout_seconds"`
+	}{})
+	clientRotateBody := reflect.TypeOf(struct {
+		tunnelapi.RotateOptions
+		TimeoutSeconds int `json:"timeout_seconds"`
+	}{})
+	for name, types := range map[string][]reflect.Type{
+		"Snapshot":       {reflect.TypeOf(Snapshot{})},
+		"RotationRecord": {reflect.TypeOf(RotationRecord{})},
+		"ExitRejection":  {reflect.TypeOf(ExitRejection{})},
+		"LocationHealth": {reflect.TypeOf(LocationHealth{})},
+		"AuditEntry":     {reflect.TypeOf(AuditEntry{})},
+		"Problem":        {reflect.TypeOf(problemDetails{})},
+		"RotateResult":   {reflect.TypeOf(rotateResult{})},
+		"Status":         {reflect.TypeOf(tunnelapi.Status{})},
+		"RotateRequest":  {rotateBody, clientRotateBody},
+		"RuntimeConfig":  {reflect.TypeOf(RuntimeConfig{})},
+		"ConfigView":     {reflect.TypeOf(ConfigView{})},
+		"SessionRecord":  {reflect.TypeOf(SessionRecord{})},
+		"LocationStats":  {reflect.TypeOf(LocationStats{})},
+		"CatalogEntry":   {reflect.TypeOf(CatalogEntry{})},
+		"CatalogView":    {reflect.TypeOf(CatalogView{})},
+		"Event":          {reflect.TypeOf(Event{})},
+		"ProbeReport":    {reflect.TypeOf(ProbeReport{})},
+		"ProbeCheck":     {reflect.TypeOf(ProbeCheck{})},
+	} {
+		s := doc.Components.Schemas[name]
+		if s == nil {
+			t.Errorf("schema %s missing", name)
+			continue
+		}
+		want := doc.properties(s)
+		for _, typ := range types {
+			if got := jsonFields(typ); !reflect.DeepEqual(got, want) {
+				t.Errorf("schema %s: properties %v, %s has %v", name, keys(want), typ, keys(got))
+			}
+		}
+	}
+}
+
+// Handlers answer only with documented statuses and media types, and
+// JSON bodies carry only documented keys.
+func TestOpenAPI_ResponsesMatchHandlers(t *testing.T) {
+	doc := loadOpenAPI(t)
+	for _, tc := range []struct {
+		name, method, target, body string
+		setup                      func(*controlAPI)
+	}{
+		{name: "livez", method: "GET", target: "/livez"},
+		{name: "ready", method: "GET", target: "/readyz"},
+		{name: "not ready", method: "GET", target: "/readyz", setup: func(a *controlAPI) { a.state.Set(StateFailed) }},
+		{name: "ready verbose", method: "GET", target: "/readyz?verbose"},
+		{name: "not ready verbose", method: "GET", target: "/readyz?verbose", setup: func(a *controlAPI) { a.state.Set(StateFailed) }},
+		{name: "livez verbose", method: "GET", target: "/livez?verbose"},
+		{name: "stuck", method: "GET", target: "/livez?verbose", setup: func(a *controlAPI) {
+			a.state.Heartbeat(loopWatchdog, -time.Hour)
+		}},
+		{name: "status", method: "GET", target: "/status"},
+		{name: "rotate accepted", method: "POST", target: "/rotate"},
+		{name: "rotate bad param", method: "POST", target: "/rotate?if_generation=x"},
+		{name: "rotate failed pod", method: "POST", target: "/rotate", setup: func(a *controlAPI) { a.state.Set(StateFailed) }},
+		{name: "rotate superseded", method: "POST", target: "/rotate?if_exit_ip=9.9.9.9"},
+		{name: "pause", method: "POST", target: "/rotation/pause"},
+		{name: "resume", method: "POST", target: "/rotation/resume"},
+		{name: "park busy", method: "POST", target: "/tunnel/disconnect"},
+		{name: "connect ready", method: "POST", target: "/tunnel/connect"},
+		{name: "connect not parked", method: "POST", target: "/tunnel/connect", setup: func(a *controlAPI) { a.state.Set(StateFailed) }},
+		{name: "drain bad timeout", method: "POST", target: "/drain?timeout=soon"},
+		{name: "locations", method: "GET", target: "/locations"},
+		{name: "locations unwired", method: "GET", target: "/locations", setup: func(a *controlAPI) { a.catalog = nil }},
+		{name: "history", method: "GET", target: "/history"},
+		{name: "history bad limit", method: "GET", target: "/history?limit=-1"},
+		{name: "config", method: "GET", target: "/config"},
+		{name: "put config", method: "PUT", target: "/config", body: `{"watchdog_interval_seconds":10}`},
+		{name: "put bad config", method: "PUT", target: "/config", body: `{"watchdog_interval_seconds":0}`},
+		{name: "events bad id", method: "GET", target: "/events?last_event_id=x"},
+		{name: "metrics", method: "GET", target: "/metrics"},
+		{name: "openapi", method: "GET", target: "/openapi.json"},
+		{name: "unauthenticated", method: "GET", target: "/status", setup: func(a *controlAPI) { a.auth = tokenAuth() }},
+		// Last: drains the pod for good.
+		{name: "drain", method: "POST", target: "/drain?timeout=1s"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := fullAPI()
+			if tc.setup != nil {
+				tc.setup(&api)
+			}
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.body == "" {
+				req = httptest.NewRequest(tc.method, tc.target, nil)
+			}
+			rr := httptest.NewRecorder()
+			api.routes().ServeHTTP(rr, req)
+
+			op := doc.Paths[req.URL.Path][strings.ToLower(tc.method)]
+			if op == nil {
+				t.Fatalf("%s %s not documented", tc.method, req.URL.Path)
+			}
+			resp, ok := op.Responses[strconv.Itoa(rr.Code)]
+			if !ok {
+				t.Fatalf("got undocumented status %d (%s)", rr.Code, rr.Body)
+			}
+			mt, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+			if len(resp.Content) == 0 || rr.Body.Len() == 0 {
+				return
+			}
+			media, ok := resp.Content[mt]
+			if !ok {
+				t.Fatalf("status %d: media type %q not documented (have %v)", rr.Code, mt, resp.Content)
+			}
+			if mt != "application/json" && mt != "application/problem+json" {
+				return
+			}
+			var body map[string]any
+			raw, _ := io.ReadAll(rr.Body)
+			if err := json.Unmarshal(raw, &body); err != nil {
+				t.Fatalf("body %s: %v", raw, err)
+			}
+			if req.URL.Path == "/openapi.json" {
+				return
+			}
+			documented := doc.properties(media.Schema)
+			for k := range body {
+				if !documented[k] {
+					t.Errorf("status %d: key %q not in the documented schema %v", rr.Code, k, keys(documented))
+				}
+			}
+		})
+	}
+}
+
+// /status as served decodes into the client's Status with nothing left
+// over.
+func TestOpenAPI_StatusDecodesStrictly(t *testing.T) {
+	rr := call(fullAPI().routes(), http.MethodGet, "/status", "")
+	dec := json.NewDecoder(rr.Body)
+	dec.DisallowUnknownFields()
+	var s tunnelapi.Status
+	if err := dec.Decode(&s); err != nil {
+		t.Fatalf("decode /status into tunnelapi.Status: %v", err)
+	}
+	if s.State != StateReady || s.TunnelID != "tundler-tunnel-0" || len(s.Config) == 0 {
+		t.Errorf("status=%+v, want Ready with identity and config", s)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/provider"
+)
+
+// Persisted runtime state. The wedge guard exits the process for a
+// systemd respawn, and a recycle recreates the container; either used to
+// reset the StateTracker (rotation and auth-failure counts, recent
+// exits), re-probe the pre-VPN baseline — through a kernel tunnel that
+// may still be up — and reload the catalog from a possibly wedged
+// daemon. The state file carries those across:
+//
+//	rotation_count_total, last_rotation, tunnel_generation
+//	auth_failures_total, last_auth_failure_*
+//	recent_exit_ips
+//	location_last_used      the lru location strategy
+//	location_health         scores and quarantines
+//	baseline_egress_ip(v6)  restored instead of re-probed (baselineEgress)
+//	next_event_id           GET /events IDs keep increasing
+//	locations               seeds the Locations() cache
+//	location_details        its structured descriptions
+//	pod_started_at          /status pod_uptime_seconds
+//
+// The file is written atomically (temp file, fsync, rename) every
+// statePersistInterval when something changed, and once more on every
+// exit path (gracefulDisconnect). It is only restored by the same
+// provider, pod and node that wrote it; a file from another schema
+// version is ignored. Mount /var/lib/tundler-tunnel as an emptyDir to
+// carry it across container recycles too.
+
+const (
+	envStateFile     = "TUNDLER_STATE_FILE"
+	defaultStateFile = "/var/lib/tundler-tunnel/state.json"
+	// stateFileOff disables persistence.
+	stateFileOff = "off"
+
+	// stateFileVersion is bumped on any incompatible change to
+	// persistedState.
+	stateFileVersion = 1
+
+	statePersistInterval = 15 * time.Second
+
+	// recentExitIPsKept bounds StateTracker.recentExitIPs, and with it
+	// recent_exit_ips.
+	recentExitIPsKept = 64
+)
+
+// persistedState is the state file's schema, version stateFileVersion.
+type persistedState struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+
+	// Identity: a file written by another provider, pod or node is not
+	// restored.
+	Provider string `json:"provider"`
+	PodName  string `json:"pod_name"`
+	NodeIP   string `json:"node_ip,omitempty"`
+
+	PodStartedAt    time.Time `json:"pod_started_at"`
+	ProcessRestarts int       `json:"process_restarts"`
+
+	RotationCountTotal    int                       `json:"rotation_count_total"`
+	LastRotation          *RotationRecord           `json:"last_rotation,omitempty"`
+	TunnelGeneration      uint64                    `json:"tunnel_generation"`
+	AuthFailuresTotal     int                       `json:"auth_failures_total"`
+	LastAuthFailureAt     time.Time                 `json:"last_auth_failure_at,omitzero"`
+	LastAuthFailureReason string                    `json:"last_auth_failure_reason,omitempty"`
+	RecentExitIPs         []string                  `json:"recent_exit_ips,omitempty"`
+	LocationLastUsed      map[string]time.Time      `json:"location_last_used,omitempty"`
+	LocationHealth        map[string]locationHealth `json:"location_health,omitempty"`
+	NextEventID           uint64                    `json:"next_event_id,omitempty"`
+
+	BaselineEgressIP   string `json:"baseline_egress_ip,omitempty"`
+	BaselineEgressIPv6 string `json:"baseline_egress_ipv6,omitempty"`
+
+	Locations          []string            `json:"locations,omitempty"`
+	LocationDetails    []provider.Location `json:"location_details,omitempty"`
+	LocationsFetchedAt time.Time           `json:"locations_fetched_at,omitzero"`
+}
+
+// stateFilePath is TUNDLER_STATE_FILE, or "" when persistence is off.
+func stateFilePath() string {
+	switch p := os.Getenv(envStateFile); p {
+	case "":
+		return defaultStateFile
+	case stateFileOff:
+		return ""
+	default:
+		return p
+	}
+}
+
+// readStateFile loads the state file; a missing file is (nil, nil).
+func readStateFile(path string) (*persistedState, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ps persistedState
+	if err := json.Unmarshal(raw, &ps); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if ps.Version != stateFileVersion {
+		return nil, fmt.Errorf("%s: schema version %d, want %d", path, ps.Version, stateFileVersion)
+	}
+	return &ps, nil
+}
+
+// writeStateFile replaces path atomically: a reader (or a crash) sees
+// the old file or the new one, never a torn write.
+func writeStateFile(path string, raw []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Make the rename itself durable.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// exportState copies the tracker's persisted fields into ps.
+func (s *StateTracker) exportState(ps *persistedState) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ps.PodStartedAt = s.podStartedAt
+	ps.ProcessRestarts = s.processRestarts
+	ps.RotationCountTotal = s.rotationCountTotal
+	ps.LastRotation = s.lastRotation
+	ps.TunnelGeneration = s.tunnelGeneration
+	ps.AuthFailuresTotal = s.authFailuresTotal
+	ps.LastAuthFailureAt = s.lastAuthFailureAt
+	ps.LastAuthFailureReason = s.lastAuthFailureReason
+	ps.RecentExitIPs = append([]string(nil), s.recentExitIPs...)
+	ps.LocationLastUsed = maps.Clone(s.locationLastUsed)
+	if len(s.locationHealth) > 0 {
+		ps.LocationHealth = make(map[string]locationHealth, len(s.locationHealth))
+		for loc, h := range s.locationHealth {
+			ps.LocationHealth[loc] = *h
+		}
+	}
+	ps.NextEventID = s.events.NextID()
+}
+
+// restoreState loads an earlier process's fields into a fresh tracker
+// and counts the respawn.
+func (s *StateTracker) restoreState(ps *persistedState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ps.PodStartedAt.IsZero() {
+		s.podStartedAt = ps.PodStartedAt
+	}
+	s.processRestarts = ps.ProcessRestarts + 1
+	s.rotationCountTotal = ps.RotationCountTotal
+	s.lastRotation = ps.LastRotation
+	s.tunnelGeneration = ps.TunnelGeneration
+	s.authFailuresTotal = ps.AuthFailuresTotal
+	s.lastAuthFailureAt = ps.LastAuthFailureAt
+	s.lastAuthFailureReason = ps.LastAuthFailureReason
+	s.recentExitIPs = append([]string(nil), ps.RecentExitIPs...)
+	s.locationLastUsed = maps.Clone(ps.LocationLastUsed)
+	s.locationHealth = nil
+	for loc, h := range ps.LocationHealth {
+		if s.locationHealth == nil {
+			s.locationHealth = make(map[string]*locationHealth, len(ps.LocationHealth))
+		}
+		s.locationHealth[loc] = &h
+	}
+	s.events.resumeAt(ps.NextEventID)
+}
+
+// baselineEgress returns the pre-VPN egress baselines (IPv6 only when
+// wantV6): an earlier process's, or fresh probes. The provider's tunnel
+// may have outlived that process, and probing through it would record
+// the VPN exit as the baseline — so a respawn whose predecessor never
+// got a baseline probes again only while the provider reports no
+// tunnel up. A failed probe is logged and leaves that baseline empty,
+// which the state file doesn't keep.
+func baselineEgress(ctx context.Context, prov provider.VPNProvider, restored *persistedState, probe, probeV6 func(context.Context) (string, error), wantV6 bool) (v4, v6 string) {
+	switch {
+	case restored != nil && restored.BaselineEgressIP != "":
+		log.Printf("tundler-tunnel: pre-VPN baseline egress=%s (restored)", restored.BaselineEgressIP)
+		return restored.BaselineEgressIP, restored.BaselineEgressIPv6
+	case restored != nil && prov.Status(ctx).Connected:
+		log.Printf("tundler-tunnel: no pre-VPN baseline restored and a tunnel is up; not probing through it — exit-ip contract test disabled")
+		return "", ""
+	}
+	v4, err := probe(ctx)
+	if err != nil {
+		log.Printf("tundler-tunnel: pre-VPN baseline egress probe failed: %v — exit-ip contract test disabled", err)
+		v4 = ""
+	} else {
+		log.Printf("tundler-tunnel: pre-VPN baseline egress=%s", v4)
+	}
+	if wantV6 {
+		if v6, err = probeV6(ctx); err != nil {
+			log.Printf("tundler-tunnel: no pre-VPN IPv6 egress (%v) — IPv6 contract leg disabled", err)
+			v6 = ""
+		} else {
+			log.Printf("tundler-tunnel: pre-VPN baseline egress IPv6=%s", v6)
+		}
+	}
+	return v4, v6
+}
+
+// statePersister owns the state file for one process.
+type statePersister struct {
+	path                   string
+	provider, pod, nodeIP  string
+	state                  *StateTracker
+	catalog                *cachedLocationsProvider
+	baseline, baselineIPv6 string
+
+	mu   sync.Mutex
+	last []byte // last content written, saved_at zeroed
+}
+
+func newStatePersister(path string, state *StateTracker, catalog *cachedLocationsProvider, provider, pod, nodeIP string) *statePersister {
+	return &statePersister{path: path, state: state, catalog: catalog, provider: provider, pod: pod, nodeIP: nodeIP}
+}
+
+// restore loads the state file into the tracker and the catalog and
+// returns it, or nil when there is nothing usable to restore (logged).
+func (p *statePersister) restore() *persistedState {
+	if p.path == "" {
+		return nil
+	}
+	ps, err := readStateFile(p.path)
+	switch {
+	case err != nil:
+		log.Printf("tundler-tunnel: state file: %v — starting fresh", err)
+		return nil
+	case ps == nil:
+		return nil
+	case ps.Provider != p.provider || ps.PodName != p.pod || (ps.NodeIP != "" && p.nodeIP != "" && ps.NodeIP != p.nodeIP):
+		log.Printf("tundler-tunnel: state file %s was written by provider=%s pod=%s node=%s — starting fresh",
+			p.path, ps.Provider, ps.PodName, ps.NodeIP)
+		return nil
+	}
+	p.state.restoreState(ps)
+	if p.catalog != nil {
+		p.catalog.Seed(ps.Locations, ps.LocationDetails, ps.LocationsFetchedAt)
+	}
+	p.setBaseline(ps.BaselineEgressIP, ps.BaselineEgressIPv6)
+	log.Printf("tundler-tunnel: restored state from %s (saved %s, respawn #%d, %d rotations, %d auth failures, %d cached locations)",
+		p.path, ps.SavedAt.Format(time.RFC3339), ps.ProcessRestarts+1, ps.RotationCountTotal, ps.AuthFailuresTotal, len(ps.Locations))
+	return ps
+}
+
+// setBaseline records the pre-VPN baselines to persist.
+func (p *statePersister) setBaseline(v4, v6 string) {
+	p.mu.Lock()
+	p.baseline, p.baselineIPv6 = v4, v6
+	p.mu.Unlock()
+}
+
+// save writes the state file if anything changed since the last write.
+func (p *statePersister) save() error {
+	if p.path == "" {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ps := persistedState{
+		Version:            stateFileVersion,
+		Provider:           p.provider,
+		PodName:            p.pod,
+		NodeIP:             p.nodeIP,
+		BaselineEgressIP:   p.baseline,
+		BaselineEgressIPv6: p.baselineIPv6,
+	}
+	p.state.exportState(&ps)
+	if p.catalog != nil {
+		ps.Locations, ps.LocationsFetchedAt = p.catalog.Cached()
+		ps.LocationDetails = p.catalog.Described()
+	}
+	content, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	if string(content) == string(p.last) {
+		return nil
+	}
+	ps.SavedAt = time.Now().UTC()
+	raw, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeStateFile(p.path, raw); err != nil {
+		return err
+	}
+	p.last = content
+	return nil
+}
+
+// flush is save for the exit paths: errors are logged, not returned.
+func (p *statePersister) flush() {
+	if err := p.save(); err != nil {
+		log.Printf("tundler-tunnel: state file: %v", err)
+	}
+}
+
+// run saves every statePersistInterval until ctx ends. A failing write
+// (read-only filesystem) is logged once per distinct error.
+func (p *statePersister) run(ctx context.Context) {
+	ticker := time.NewTicker(statePersistInterval)
+	defer ticker.Stop()
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.save()
+			if err != nil && err.Error() != lastErr {
+				log.Printf("tundler-tunnel: state file: %v (will retry)", err)
+			}
+			lastErr = ""
+			if err != nil {
+				lastErr = err.Error()
+			}
+		}
+	}
+}
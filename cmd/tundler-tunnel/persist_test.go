@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// persistedTracker is a tracker with something worth persisting.
+func persistedTracker() *StateTracker {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.RecordTunnelUp("UK", "5.6.7.8")
+	st.RecordRotation("1.2.3.4", "5.6.7.8", "success", time.Second)
+	st.RecordAuthFailure("AUTH_FAILED")
+	return st
+}
+
+func TestStatePersister_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib", "state.json")
+	st := persistedTracker()
+	catalog := newCachedLocationsProvider(newScripted(func(int) []string { return []string{"USA", "UK"} }), time.Hour)
+	catalog.Locations(context.Background())
+	p := newStatePersister(path, st, catalog, "fake", "tundler-tunnel-0", "10.0.0.1")
+	p.setBaseline("9.9.9.9", "2001:db8::9")
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The respawned process.
+	next := NewStateTracker("fake")
+	under := newScripted(func(int) []string { return nil })
+	nextCatalog := newCachedLocationsProvider(under, time.Hour)
+	ps := newStatePersister(path, next, nextCatalog, "fake", "tundler-tunnel-0", "10.0.0.1").restore()
+	if ps == nil || ps.BaselineEgressIP != "9.9.9.9" || ps.BaselineEgressIPv6 != "2001:db8::9" {
+		t.Fatalf("restored %+v, want the baselines back", ps)
+	}
+	snap := next.Snapshot()
+	want := st.Snapshot()
+	if snap.RotationCountTotal != 1 || snap.AuthFailuresTotal != 1 || snap.LastAuthFailureReason != "AUTH_FAILED" ||
+		snap.LastRotation == nil || snap.LastRotation.NewExitIP != "5.6.7.8" || snap.TunnelGeneration != 2 {
+		t.Errorf("restored snapshot %+v", snap)
+	}
+	if snap.ProcessRestarts != 1 || snap.PodStartedAt != want.PodStartedAt {
+		t.Errorf("process_restarts=%d pod_started_at=%s, want 1 and %s", snap.ProcessRestarts, snap.PodStartedAt, want.PodStartedAt)
+	}
+	if strings.Join(next.recentExitIPs, ",") != "1.2.3.4,5.6.7.8" {
+		t.Errorf("recent exits=%v", next.recentExitIPs)
+	}
+	if got := nextCatalog.Locations(context.Background()); len(got) != 2 || under.callCount() != 0 {
+		t.Errorf("catalog=%v after %d forks, want the persisted one without a fork", got, under.callCount())
+	}
+	if got, want := next.Events().NextID(), st.Events().NextID(); want == 1 || got != want {
+		t.Errorf("next event id=%d, want %d carried over", got, want)
+	}
+	// A new tunnel keeps the generation monotonic across the respawn.
+	next.RecordTunnelUp("USA", "7.7.7.7")
+	if _, gen := next.CurrentTunnel(); gen != 3 {
+		t.Errorf("generation=%d, want 3", gen)
+	}
+}
+
+// A first boot whose baseline probe failed saves no baseline; the
+// respawn probes again unless the provider reports a tunnel up.
+func TestBaselineEgress_RestoreAfterFailedProbe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	failing := func(context.Context) (string, error) { return "", errors.New("probe timed out") }
+	fp := &fakeProvider{}
+	p := newStatePersister(path, NewStateTracker("fake"), nil, "fake", "tundler-tunnel-0", "")
+	v4, v6 := baselineEgress(context.Background(), fp, p.restore(), failing, failing, true)
+	if v4 != "" || v6 != "" {
+		t.Fatalf("failed probe: baseline %q/%q, want none", v4, v6)
+	}
+	p.setBaseline(v4, v6)
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), "baseline_egress_ip") {
+		t.Errorf("state file keeps an empty baseline: %s", raw)
+	}
+
+	restored := newStatePersister(path, NewStateTracker("fake"), nil, "fake", "tundler-tunnel-0", "").restore()
+	if restored == nil {
+		t.Fatal("state file not restored")
+	}
+	var probes int
+	probe := func(context.Context) (string, error) { probes++; return "9.9.9.9", nil }
+	fp.connected.Store(true)
+	if v4, _ := baselineEgress(context.Background(), fp, restored, probe, probe, false); v4 != "" || probes != 0 {
+		t.Errorf("tunnel up: baseline %q after %d probes, want none without probing", v4, probes)
+	}
+	fp.connected.Store(false)
+	if v4, _ := baselineEgress(context.Background(), fp, restored, probe, probe, false); v4 != "9.9.9.9" || probes != 1 {
+		t.Errorf("no tunnel: baseline %q after %d probes, want 9.9.9.9 probed", v4, probes)
+	}
+}
+
+func TestStatePersister_IgnoresForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := newStatePersister(path, persistedTracker(), nil, "fake", "tundler-tunnel-0", "10.0.0.1").save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ name, provider, pod, node string }{
+		{"provider", "other", "tundler-tunnel-0", "10.0.0.1"},
+		{"pod", "fake", "tundler-tunnel-1", "10.0.0.1"},
+		{"node", "fake", "tundler-tunnel-0", "10.0.0.2"},
+	} {
+		st := NewStateTracker(tc.provider)
+		if ps := newStatePersister(path, st, nil, tc.provider, tc.pod, tc.node).restore(); ps != nil || st.Snapshot().RotationCountTotal != 0 {
+			t.Errorf("other %s: restored %+v", tc.name, ps)
+		}
+	}
+
+	for name, body := range map[string]string{
+		"version": `{"version":99,"provider":"fake","pod_name":"tundler-tunnel-0","rotation_count_total":5}`,
+		"corrupt": `{"version":1,`,
+	} {
+		bad := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(bad, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readStateFile(bad); err == nil {
+			t.Errorf("%s: read without error", name)
+		}
+		if ps := newStatePersister(bad, NewStateTracker("fake"), nil, "fake", "tundler-tunnel-0", "").restore(); ps != nil {
+			t.Errorf("%s: restored %+v", name, ps)
+		}
+	}
+	if ps, err := readStateFile(filepath.Join(dir, "missing.json")); ps != nil || err != nil {
+		t.Errorf("missing file: %+v %v, want nothing", ps, err)
+	}
+}
+
+// Unchanged state is not rewritten; a change is, atomically (no temp
+// files left behind).
+func TestStatePersister_WritesOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	st := persistedTracker()
+	p := newStatePersister(path, st, nil, "fake", "tundler-tunnel-0", "")
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged state rewritten (stat err=%v)", err)
+	}
+	st.RecordAuthFailure("again")
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+	if ps, err := readStateFile(path); err != nil || ps.AuthFailuresTotal != 2 {
+		t.Errorf("after a change: %+v %v, want 2 auth failures", ps, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("dir holds %d entries, want only state.json", len(entries))
+	}
+}
+
+func TestStateFilePath(t *testing.T) {
+	t.Setenv(envStateFile, "")
+	if got := stateFilePath(); got != defaultStateFile {
+		t.Errorf("unset: %q", got)
+	}
+	t.Setenv(envStateFile, stateFileOff)
+	if got := stateFilePath(); got != "" {
+		t.Errorf("off: %q", got)
+	}
+	if p := newStatePersister("", NewStateTracker("fake"), nil, "fake", "p", ""); p.restore() != nil || p.save() != nil {
+		t.Error("disabled persister did something")
+	}
+}
+
+func TestRecentExitIPs(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.1.1.1")
+	st.RecordTunnelUp("USA", "1.1.1.1") // same exit, new tunnel
+	st.RecordTunnelUp("USA", "")
+	for i := 0; i < recentExitIPsKept; i++ {
+		st.RecordTunnelUp("USA", fmt.Sprintf("10.0.0.%d", i))
+	}
+	if len(st.recentExitIPs) != recentExitIPsKept || st.recentExitIPs[0] == "1.1.1.1" {
+		t.Errorf("recent exits=%v, want the last %d", st.recentExitIPs, recentExitIPsKept)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/tunnelapi"
+)
+
+// Exit qualification. A tunnel can pass the exit-IP contract and still
+// be unusable: slow, lossy, or with a path MTU that silently drops
+// full-size packets. When configured, every new exit is measured right
+// after the contract, through the same path crawler traffic takes:
+//
+//	latency     each QUALIFY_LATENCY_TARGETS host:port is dialed
+//	            qualifyDialSamples times; the median TCP connect time
+//	            must stay within QUALIFY_MAX_LATENCY_MS, and no more
+//	            than half the dials may fail
+//	throughput  QUALIFY_THROUGHPUT_URL is downloaded up to
+//	            QUALIFY_THROUGHPUT_BYTES or QUALIFY_TIMEOUT_SECONDS,
+//	            whichever comes first, and must reach
+//	            QUALIFY_MIN_THROUGHPUT_KBPS
+//	PMTU        a download that connects, then stalls before a single
+//	            full-size segment arrives (or a TLS handshake that never
+//	            completes: the certificate flight is the first large
+//	            packet) is a PMTU black hole
+//
+// An exit that fails any of them is a failed attempt for its location:
+// connectWithRetry burns the location and retries, connectTunnel
+// returns the error to its caller's retry loop. Like the exit probe,
+// the knobs are deployment settings read once at boot; with neither a
+// latency target nor a throughput URL the step is off. Point them at
+// endpoints you control: a target outage fails every exit.
+const (
+	envQualifyLatencyTargets = "QUALIFY_LATENCY_TARGETS" // space-separated host:port
+	envQualifyMaxLatencyMS   = "QUALIFY_MAX_LATENCY_MS"  // 0 = measure only
+	envQualifyThroughputURL  = "QUALIFY_THROUGHPUT_URL"
+	envQualifyThroughputSize = "QUALIFY_THROUGHPUT_BYTES"
+	envQualifyMinKbps        = "QUALIFY_MIN_THROUGHPUT_KBPS" // 0 = measure only
+	envQualifyTimeoutSec     = "QUALIFY_TIMEOUT_SECONDS"
+
+	defaultQualifyThroughputSize = 1 << 20
+	defaultQualifyTimeout        = 10 * time.Second
+
+	// qualifyDialSamples is how many times each latency target is
+	// dialed; qualifyDialTimeout bounds one dial.
+	qualifyDialSamples = 3
+	qualifyDialTimeout = 5 * time.Second
+	// qualifyBlackHoleBytes is about one full-size segment: a download
+	// stalling short of it never got a large packet through.
+	qualifyBlackHoleBytes = 1400
+)
+
+// errExitUnqualified marks an exit that failed qualification. It counts
+// as a plain connect failure in the location health model.
+var errExitUnqualified = errors.New("exit failed qualification")
+
+// ExitQualification is `exit_qualification` in /status.
+type ExitQualification = tunnelapi.ExitQualification
+
+// exitQualifier measures new exits; nil (the default) turns
+// qualification off. main sets it from the environment.
+var exitQualifier *qualifier
+
+// qualifier holds the QUALIFY_* targets and thresholds.
+type qualifier struct {
+	targets    []string // host:port
+	maxLatency time.Duration
+	url        string
+	size       int64
+	minKbps    float64
+	timeout    time.Duration
+}
+
+// qualifierFromEnv builds the qualifier from the QUALIFY_* knobs; nil
+// when neither a latency target nor a throughput URL is set.
+func qualifierFromEnv() (*qualifier, error) {
+	q := &qualifier{
+		targets:    strings.Fields(os.Getenv(envQualifyLatencyTargets)),
+		maxLatency: time.Duration(getEnvInt(envQualifyMaxLatencyMS, 0)) * time.Millisecond,
+		url:        os.Getenv(envQualifyThroughputURL),
+		size:       int64(getEnvInt(envQualifyThroughputSize, defaultQualifyThroughputSize)),
+		minKbps:    float64(getEnvInt(envQualifyMinKbps, 0)),
+		timeout:    time.Duration(getEnvInt(envQualifyTimeoutSec, int(defaultQualifyTimeout.Seconds()))) * time.Second,
+	}
+	if len(q.targets) == 0 && q.url == "" {
+		return nil, nil
+	}
+	for _, t := range q.targets {
+		if _, _, err := net.SplitHostPort(t); err != nil {
+			return nil, fmt.Errorf("%s: %q: want host:port", envQualifyLatencyTargets, t)
+		}
+	}
+	if q.url != "" {
+		if u, err := url.Parse(q.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s=%q: want an http(s) URL", envQualifyThroughputURL, q.url)
+		}
+	}
+	if q.maxLatency < 0 || q.minKbps < 0 {
+		return nil, fmt.Errorf("%s and %s must not be negative", envQualifyMaxLatencyMS, envQualifyMinKbps)
+	}
+	if q.size <= 0 || q.timeout <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive", envQualifyThroughputSize, envQualifyTimeoutSec)
+	}
+	return q, nil
+}
+
+// String summarises the qualifier for the boot log.
+func (q *qualifier) String() string {
+	return fmt.Sprintf("latency_targets=%s max_latency=%s throughput_url=%s bytes=%d min_kbps=%g timeout=%s",
+		strings.Join(q.targets, ","), q.maxLatency, q.url, q.size, q.minKbps, q.timeout)
+}
+
+// qualifyExit measures exitIP with exitQualifier. It returns nil, nil
+// when qualification is off, and an errExitUnqualified error alongside
+// the result when the exit falls short.
+func qualifyExit(ctx context.Context, exitIP string) (*ExitQualification, error) {
+	q := exitQualifier
+	if q == nil {
+		return nil, nil
+	}
+	res := q.measure(ctx)
+	res.ExitIP = exitIP
+	if res.Failure == "" {
+		log.Printf("tundler-tunnel: exit qualification: OK (exit_ip=%s latency=%.0fms throughput=%.0fkbps)",
+			exitIP, res.ConnectLatencyMS, res.ThroughputKbps)
+		return res, nil
+	}
+	return res, fmt.Errorf("%w: %s", errExitUnqualified, res.Failure)
+}
+
+// measure runs the latency and throughput checks; Failure names the
+// first one the exit fell short of.
+func (q *qualifier) measure(ctx context.Context) *ExitQualification {
+	res := &ExitQualification{Passed: true, CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+	fail := func(format string, args ...any) {
+		if res.Failure == "" {
+			res.Failure = fmt.Sprintf(format, args...)
+		}
+		res.Passed = false
+	}
+	if len(q.targets) > 0 {
+		latency, failures := q.dialLatency(ctx)
+		res.ConnectAttempts = len(q.targets) * qualifyDialSamples
+		res.ConnectFailures = failures
+		res.ConnectLatencyMS = float64(latency.Microseconds()) / 1000
+		switch {
+		case failures*2 > res.ConnectAttempts:
+			fail("%d of %d TCP connects failed", failures, res.ConnectAttempts)
+		case q.maxLatency > 0 && latency > q.maxLatency:
+			fail("TCP connect latency %s above %s", latency.Round(time.Millisecond), q.maxLatency)
+		}
+	}
+	if q.url != "" {
+		n, took, blackHole, err := q.download(ctx)
+		res.DownloadedBytes = n
+		res.PMTUBlackHole = blackHole
+		if took > 0 {
+			res.ThroughputKbps = float64(n) * 8 / 1000 / took.Seconds()
+		}
+		switch {
+		case blackHole:
+			fail("PMTU black hole: the download stalled after %d bytes", n)
+		case err != nil:
+			fail("throughput download: %v", err)
+		case q.minKbps > 0 && res.ThroughputKbps < q.minKbps:
+			fail("throughput %.0fkbps below %.0fkbps", res.ThroughputKbps, q.minKbps)
+		}
+	}
+	return res
+}
+
+// qualifyDial connects to target the way the contract probe does:
+// through the proxy-chain dialer when one is installed, directly
+// otherwise.
+func qualifyDial(ctx context.Context, target string) (net.Conn, error) {
+	if contractProbeDialer != nil {
+		if conn, ok, err := contractProbeDialer(ctx, target); ok {
+			return conn, err
+		}
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp4", target)
+}
+
+// dialLatency dials every target qualifyDialSamples times in turn and
+// returns the median connect time of the dials that succeeded, and how
+// many failed.
+func (q *qualifier) dialLatency(ctx context.Context) (time.Duration, int) {
+	var samples []time.Duration
+	failures := 0
+	for _, target := range q.targets {
+		for range qualifyDialSamples {
+			dialCtx, cancel := context.WithTimeout(ctx, qualifyDialTimeout)
+			started := time.Now()
+			conn, err := qualifyDial(dialCtx, target)
+			took := time.Since(started)
+			cancel()
+			if err != nil {
+				failures++
+				continue
+			}
+			_ = conn.Close()
+			samples = append(samples, took)
+		}
+	}
+	if len(samples) == 0 {
+		return 0, failures
+	}
+	slices.Sort(samples)
+	return samples[len(samples)/2], failures
+}
+
+// download fetches up to q.size bytes of q.url within q.timeout and
+// returns how many body bytes arrived, over how long since the first
+// response byte. Running out of time after a full-size segment is the
+// bound doing its job, not an error; stalling before one, once
+// connected, reports a black hole.
+func (q *qualifier) download(ctx context.Context) (n int64, took time.Duration, blackHole bool, err error) {
+	dlCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	var connected, tlsStarted, tlsDone atomic.Bool
+	var firstByte atomic.Int64
+	trace := &httptrace.ClientTrace{
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				connected.Store(true)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStarted.Store(true) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				tlsDone.Store(true)
+			}
+		},
+		GotFirstResponseByte: func() { firstByte.Store(time.Now().UnixNano()) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(dlCtx, trace), http.MethodGet, q.url, nil)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	elapsed := func() time.Duration {
+		if at := firstByte.Load(); at != 0 {
+			return time.Since(time.Unix(0, at))
+		}
+		return 0
+	}
+	stalled := func(err error) bool {
+		if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(dlCtx.Err(), context.DeadlineExceeded) {
+			return false
+		}
+		return connected.Load() && (tlsStarted.Load() && !tlsDone.Load() || n < qualifyBlackHoleBytes)
+	}
+	resp, err := probeClient("tcp4", contractProbeDialer).Do(req)
+	if err != nil {
+		return 0, 0, stalled(err), err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return 0, 0, false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	n, err = io.Copy(io.Discard, io.LimitReader(resp.Body, q.size))
+	took = elapsed()
+	if err != nil {
+		if stalled(err) {
+			return n, took, true, err
+		}
+		if errors.Is(dlCtx.Err(), context.DeadlineExceeded) {
+			err = nil // the time bound, past the first full-size segment
+		}
+	}
+	return n, took, false, err
+}
+
+// RecordExitQualification notes the qualification of the exit about to
+// be recorded by RecordTunnelUp (nil when off), or of one that failed
+// it at location, which is published.
+func (s *StateTracker) RecordExitQualification(location string, q *ExitQualification) {
+	s.mu.Lock()
+	s.exitQualification = q
+	s.mu.Unlock()
+	if q != nil && !q.Passed {
+		log.Printf("tundler-tunnel: exit %s at location=%s failed qualification: %s", q.ExitIP, location, q.Failure)
+		s.Publish(eventExitUnqualified, map[string]any{
+			"exit_ip":         q.ExitIP,
+			"location":        location,
+			"failure":         q.Failure,
+			"pmtu_black_hole": q.PMTUBlackHole,
+		})
+	}
+}
+
+// ExitQualification returns the current exit's qualification, nil when
+// it wasn't measured.
+func (s *StateTracker) ExitQualification() *ExitQualification {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exitQualificationFor(s.currentExitIP)
+}
+
+// exitQualificationFor is the recorded qualification while it is
+// exitIP's; s.mu held.
+func (s *StateTracker) exitQualificationFor(exitIP string) *ExitQualification {
+	if s.exitQualification == nil || s.exitQualification.ExitIP != exitIP {
+		return nil
+	}
+	q := *s.exitQualification
+	return &q
+}
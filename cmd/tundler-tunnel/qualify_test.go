@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useQualifier installs q as exitQualifier for the test.
+func useQualifier(t *testing.T, q *qualifier) {
+	prev := exitQualifier
+	exitQualifier = q
+	t.Cleanup(func() { exitQualifier = prev })
+}
+
+// payload serves size bytes, or 503 while fail reports true.
+func payload(t *testing.T, size int, fail func() bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail != nil && fail() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", size)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestQualifierFromEnv(t *testing.T) {
+	if q, err := qualifierFromEnv(); q != nil || err != nil {
+		t.Fatalf("unset: %v, %v", q, err)
+	}
+	t.Setenv(envQualifyLatencyTargets, "1.1.1.1:443 example.net")
+	if _, err := qualifierFromEnv(); err == nil {
+		t.Error("a target without a port was accepted")
+	}
+	t.Setenv(envQualifyLatencyTargets, "1.1.1.1:443")
+	t.Setenv(envQualifyThroughputURL, "ftp://example.net/blob")
+	if _, err := qualifierFromEnv(); err == nil {
+		t.Error("an ftp throughput URL was accepted")
+	}
+	t.Setenv(envQualifyThroughputURL, "https://example.net/blob")
+	q, err := qualifierFromEnv()
+	if err != nil || q.size != defaultQualifyThroughputSize || q.timeout != defaultQualifyTimeout {
+		t.Errorf("defaults: %+v, %v", q, err)
+	}
+}
+
+func TestQualifier_Measure(t *testing.T) {
+	srv := payload(t, 64<<10, nil)
+	target := strings.TrimPrefix(srv.URL, "http://")
+	q := &qualifier{targets: []string{target}, url: srv.URL + "/blob", size: 32 << 10, timeout: 5 * time.Second}
+
+	res := q.measure(context.Background())
+	if !res.Passed || res.ConnectAttempts != qualifyDialSamples || res.ConnectFailures != 0 || res.DownloadedBytes != 32<<10 || res.ThroughputKbps <= 0 {
+		t.Errorf("measure = %+v, want a pass over 32KiB", res)
+	}
+
+	q.minKbps = 1e12
+	if res := q.measure(context.Background()); res.Passed || !strings.HasPrefix(res.Failure, "throughput ") {
+		t.Errorf("min_kbps: %+v", res)
+	}
+
+	// A closed port refuses every dial.
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	q = &qualifier{targets: []string{closed}, size: 1, timeout: time.Second}
+	if res := q.measure(context.Background()); res.Passed || res.ConnectFailures != qualifyDialSamples {
+		t.Errorf("refused dials: %+v", res)
+	}
+}
+
+// A download that connects and then never sees a byte is a black hole,
+// not merely slow.
+func TestQualifier_PMTUBlackHole(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	q := &qualifier{url: srv.URL, size: 1 << 20, timeout: 200 * time.Millisecond}
+
+	res := q.measure(context.Background())
+	if res.Passed || !res.PMTUBlackHole || !strings.HasPrefix(res.Failure, "PMTU black hole") {
+		t.Errorf("measure = %+v, want a PMTU black hole", res)
+	}
+}
+
+// An exit failing qualification is a failed attempt: its location is
+// burned and the rotation keeps the next exit that qualifies.
+func TestConnectWithRetry_FailsUnqualifiedExit(t *testing.T) {
+	var requests atomic.Int32
+	srv := payload(t, 4<<10, func() bool { return requests.Add(1) == 1 })
+	useQualifier(t, &qualifier{url: srv.URL, size: 4 << 10, timeout: 5 * time.Second})
+	sp := newScriptedProvider([]string{"Germany", "France"}, []bool{true, true}, []string{"5.6.7.8", "1.2.3.4"})
+	st := NewStateTracker("scripted")
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := st.SnapshotCurrentExitIP(); got != "1.2.3.4" || sp.attemptCount() != 2 {
+		t.Errorf("exit=%q after %d attempts, want 1.2.3.4 after 2", got, sp.attemptCount())
+	}
+	evs := eventsOfType(st, eventExitUnqualified)
+	if len(evs) != 1 || evs[0].Data["exit_ip"] != "5.6.7.8" || evs[0].Data["failure"] != "throughput download: status 503" {
+		t.Fatalf("exit_unqualified events=%+v", evs)
+	}
+	failed, _ := evs[0].Data["location"].(string)
+	if stats := st.LocationStats()[failed]; stats.ConnectFailures != 1 || !strings.Contains(stats.LastError, errExitUnqualified.Error()) {
+		t.Errorf("%s stats=%+v, want the failed qualification", failed, stats)
+	}
+	if q := st.Snapshot().ExitQualification; q == nil || !q.Passed || q.ExitIP != "1.2.3.4" || q.DownloadedBytes != 4<<10 {
+		t.Errorf("exit_qualification=%+v, want the kept exit's pass", q)
+	}
+}
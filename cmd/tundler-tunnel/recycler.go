@@ -10,15 +10,6 @@ import (
 	"github.com/laurentpellegrino/tundler/internal/shared"
 )
 
-// recycleRotationLimit, when > 0, makes runRotator recycle the container
-// INSTEAD of performing the next SCHEDULED relocation once the pod has done
-// that many (see runRotator). A recycle already yields a fresh exit IP, so
-// it cleanly replaces a relocation while also refreshing the image + env.
-// Only scheduled relocations count — crawler /rotate (throttle recovery)
-// must not trigger recycles. Set in main() from RECYCLE_AFTER_ROTATIONS;
-// 0 in tests and when periodic rotation is disabled.
-var recycleRotationLimit int
-
 // recycleContainer gracefully drains and then terminates the CONTAINER so
 // kubelet recreates it on the latest image (imagePullPolicy: Always) with
 // freshly-rendered env. This is how new builds + config roll out
@@ -33,7 +24,8 @@ func recycleContainer(ctx context.Context, state *StateTracker, drain drainContr
 	log.Printf("tundler-tunnel: recycling (%s) — draining then exiting container to refresh image/config", reason)
 	// Flip /readyz→503 so the crawler stops routing here and the headless
 	// Service drops the pod from its endpoints, then drain in-flight CONNECTs.
-	state.Set(StateDraining)
+	state.Publish(eventRecycle, map[string]any{"reason": reason})
+	state.Transition(StateDraining, "recycle: "+reason)
 	if drain != nil {
 		_ = drain.TriggerGracefulDrain(ctx)
 		_ = drain.WaitForActiveConnectionsToDrain(ctx, 30*time.Second)
@@ -42,6 +34,7 @@ func recycleContainer(ctx context.Context, state *StateTracker, drain drainContr
 	// the container (and its VPN daemon) is torn down. The kernel removing
 	// the netns would drop the interface locally, but the backend keeps the
 	// session "connected" until WE disconnect — see gracefulDisconnect.
+	state.RecordDisconnect(triggerRecycle)
 	gracefulDisconnect()
 	// `systemctl exit 0` asks PID-1 systemd to terminate the whole container
 	// (allowed for the system manager only when running in a container —
@@ -61,15 +54,16 @@ func recycleContainer(ctx context.Context, state *StateTracker, drain drainContr
 // runRecycler is the TIME-based recycle backstop: after a jittered max
 // lifetime it recycles the container (the rotation-count trigger lives in
 // the rotator so it can replace a rotation rather than pile on after one).
-func runRecycler(ctx context.Context, state *StateTracker, drain drainController, lifetime time.Duration) {
-	if lifetime <= 0 {
-		return
-	}
+//
+// The lifetime is read from cfg on every tick and measured from process
+// start, so a live change moves the deadline; 0 disarms it.
+func runRecycler(ctx context.Context, state *StateTracker, drain drainController, cfg *liveConfig) {
+	started := time.Now()
 	// Jitter by up to +33% so a fleet configured with the same value doesn't
-	// recycle in lockstep (on top of per-pod boot/rotation desync).
-	lifetime += time.Duration(rand.Int64N(int64(lifetime)/3 + 1))
-	deadline := time.Now().Add(lifetime)
-	log.Printf("tundler-tunnel: recycler armed — max lifetime ~%s", lifetime.Round(time.Minute))
+	// recycle in lockstep (on top of per-pod boot/rotation desync). Drawn
+	// once so reconfiguring doesn't re-roll it.
+	jitter := rand.Float64() / 3
+	var armed time.Duration
 
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
@@ -78,12 +72,23 @@ func runRecycler(ctx context.Context, state *StateTracker, drain drainController
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if time.Now().Before(deadline) {
+			lifetime := cfg.Get().RecycleAfter.d()
+			if lifetime <= 0 {
+				armed = 0
+				continue
+			}
+			lifetime += time.Duration(float64(lifetime) * jitter)
+			if lifetime != armed {
+				armed = lifetime
+				log.Printf("tundler-tunnel: recycler armed — max lifetime ~%s", lifetime.Round(time.Minute))
+			}
+			if time.Since(started) < lifetime {
 				continue
 			}
 			// Only recycle from a calm Ready state — catch the next Ready
-			// window rather than draining mid-rotation or mid-failure.
-			if state.Get() != StateReady {
+			// window rather than draining mid-rotation or mid-failure. A
+			// rotation pause holds the recycle too: it would change the exit.
+			if state.Get() != StateReady || state.RotationPaused() || state.ShuttingDown() {
 				continue
 			}
 			recycleContainer(ctx, state, drain, "max lifetime reached")
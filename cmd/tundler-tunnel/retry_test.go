@@ -101,7 +101,7 @@ func TestConnectWithRetry_SucceedsOnSecondAttempt(t *testing.T) {
 	st := NewStateTracker("scripted")
 	ns := &noSleep{}
 
-	err := connectWithRetry(context.Background(), sp, st, "scripted", nil, 3, ns.sleep, "")
+	err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, ns.sleep, "")
 	if err != nil {
 		t.Fatalf("connectWithRetry: %v", err)
 	}
@@ -132,7 +132,7 @@ func TestConnectWithRetry_ExhaustsAllAttempts(t *testing.T) {
 	st := NewStateTracker("scripted")
 	ns := &noSleep{}
 
-	err := connectWithRetry(context.Background(), sp, st, "scripted", nil, 3, ns.sleep, "")
+	err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, ns.sleep, "")
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -165,7 +165,7 @@ func TestConnectWithRetry_LocationPoolExhausted(t *testing.T) {
 	st := NewStateTracker("scripted")
 	ns := &noSleep{}
 
-	err := connectWithRetry(context.Background(), sp, st, "scripted", nil, 3, ns.sleep, "")
+	err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, ns.sleep, "")
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -192,7 +192,7 @@ func TestRotateIfReadyWithDeps_FailureRetriesThenSurrenders(t *testing.T) {
 	st.Set(StateReady)
 
 	ns := &noSleep{}
-	rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil, 3, ns.sleep, nil, "")
+	rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, ns.sleep, nil, "")
 
 	if st.Get() != StateFailed {
 		t.Errorf("state=%s after exhausted retries, want Failed", st.Get())
@@ -219,7 +219,7 @@ func TestRotateIfReadyWithDeps_RecoversOnSecondAttempt(t *testing.T) {
 	st.Set(StateReady)
 
 	ns := &noSleep{}
-	rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil, 3, ns.sleep, nil, "")
+	rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 3, ns.sleep, nil, "")
 
 	if st.Get() != StateReady {
 		t.Errorf("state=%s, want Ready", st.Get())
@@ -258,3 +258,157 @@ func TestRetryBackoff_Schedule(t *testing.T) {
 		}
 	}
 }
+
+// TestConnectWithRetry_RejectsAvoidedExitIP: an exit the caller asked to
+// avoid counts as a failed attempt; the next attempt's exit is kept.
+func TestConnectWithRetry_RejectsAvoidedExitIP(t *testing.T) {
+	sp := newScriptedProvider(
+		[]string{"USA", "UK"},
+		[]bool{true, true},
+		[]string{"9.9.9.9", "2.2.2.2"},
+	)
+	st := NewStateTracker("scripted")
+	ns := &noSleep{}
+	req := RotateRequest{AvoidExitIPs: []string{"9.9.9.9"}}
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, req, 3, ns.sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := st.SnapshotCurrentExitIP(); got != "2.2.2.2" {
+		t.Errorf("exit=%q, want 2.2.2.2 (9.9.9.9 must be rejected)", got)
+	}
+	if got := sp.attemptCount(); got != 2 {
+		t.Errorf("attempts=%d, want 2", got)
+	}
+}
+
+// TestConnectWithRetry_PinnedLocationRetriesSameLocation: with a single
+// candidate, rejecting its exit must not burn the location — the retry
+// goes back to it (providers hand out another server).
+func TestConnectWithRetry_PinnedLocationRetriesSameLocation(t *testing.T) {
+	sp := newScriptedProvider(
+		[]string{"USA", "UK"},
+		[]bool{true, true},
+		[]string{"9.9.9.9", "2.2.2.2"},
+	)
+	st := NewStateTracker("scripted")
+	ns := &noSleep{}
+	req := RotateRequest{Location: "UK", AvoidExitIPs: []string{"9.9.9.9"}}
+
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, req, 3, ns.sleep, ""); err != nil {
+		t.Fatalf("connectWithRetry: %v", err)
+	}
+	if got := sp.callsByLocation["UK"]; got != 2 {
+		t.Errorf("UK connects=%d, want 2 (pinned location retried)", got)
+	}
+	if got := sp.callsByLocation["USA"]; got != 0 {
+		t.Errorf("USA connects=%d, want 0 (outside the pinned location)", got)
+	}
+}
+
+// TestRotateIfReady_UnsatisfiableRequestKeepsTunnel: a location the
+// catalog doesn't have is refused before the drain/Disconnect, so the
+// current tunnel stays up.
+func TestRotateIfReady_UnsatisfiableRequestKeepsTunnel(t *testing.T) {
+	sp := newScriptedProvider([]string{"USA", "UK"}, []bool{true}, []string{"2.2.2.2"})
+	st := NewStateTracker("scripted")
+	st.RecordTunnelUp("USA", "1.1.1.1")
+	st.Set(StateReady)
+	ns := &noSleep{}
+
+	err := rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil,
+		RotateRequest{Country: "Atlantis"}, 3, ns.sleep, nil, "")
+	if !errors.Is(err, errLocationUnsatisfiable) {
+		t.Fatalf("err=%v, want errLocationUnsatisfiable", err)
+	}
+	if st.Get() != StateReady || st.SnapshotCurrentExitIP() != "1.1.1.1" {
+		t.Errorf("state=%s exit=%s, want untouched Ready 1.1.1.1", st.Get(), st.SnapshotCurrentExitIP())
+	}
+	if got := sp.attemptCount(); got != 0 {
+		t.Errorf("connect attempts=%d, want 0", got)
+	}
+}
+
+// TestRotateIfReady_SupersededConditionalKeepsTunnel: the rotator itself
+// re-checks the precondition, so a conditional request that lost the
+// race to another rotation doesn't tear the fresh tunnel down.
+func TestRotateIfReady_SupersededConditionalKeepsTunnel(t *testing.T) {
+	sp := newScriptedProvider([]string{"USA", "UK"}, []bool{true}, []string{"3.3.3.3"})
+	st := NewStateTracker("scripted")
+	st.RecordTunnelUp("USA", "1.1.1.1")
+	st.RecordTunnelUp("UK", "2.2.2.2")
+	st.Set(StateReady)
+	ns := &noSleep{}
+
+	err := rotateIfReadyWithDeps(context.Background(), sp, st, "scripted", nil,
+		RotateRequest{IfExitIP: "1.1.1.1"}, 3, ns.sleep, nil, "")
+	if !errors.Is(err, errRotationSuperseded) {
+		t.Fatalf("err=%v, want errRotationSuperseded", err)
+	}
+	if got := sp.attemptCount(); got != 0 {
+		t.Errorf("connect attempts=%d, want 0", got)
+	}
+	if st.SnapshotCurrentExitIP() != "2.2.2.2" {
+		t.Errorf("exit=%s, want untouched 2.2.2.2", st.SnapshotCurrentExitIP())
+	}
+}
+
+// slowLocations widens the gap between a rotation's first look at the
+// state and its drain.
+type slowLocations struct{ *fakeProvider }
+
+func (s slowLocations) Locations(ctx context.Context) []string {
+	time.Sleep(20 * time.Millisecond)
+	return s.fakeProvider.Locations(ctx)
+}
+
+// TestRotateIfReady_ConcurrentConditionalRotatesOnce: conditional
+// requests racing on the same exit check and drain atomically, so only
+// one of them tears the tunnel down.
+func TestRotateIfReady_ConcurrentConditionalRotatesOnce(t *testing.T) {
+	fp := &fakeProvider{locations: []string{"USA", "UK"}, connectIP: "2.2.2.2", connectOK: true}
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.1.1.1")
+	st.Set(StateReady)
+	_, gen := st.CurrentTunnel()
+	ns := &noSleep{}
+
+	const callers = 5
+	start := make(chan struct{})
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- rotateIfReadyWithDeps(context.Background(), slowLocations{fp}, st, "fake", nil,
+				RotateRequest{IfExitIP: "1.1.1.1", Location: "UK"}, 3, ns.sleep, nil, "")
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	var ok int
+	for err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case !errors.Is(err, errRotationSuperseded) && !errors.Is(err, errRotationSkipped):
+			t.Errorf("err=%v, want nil, superseded or skipped", err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d rotations succeeded, want 1", ok)
+	}
+	if got := fp.callCount(); got != 1 {
+		t.Errorf("connect calls=%d, want 1", got)
+	}
+	if got := fp.disconnectCalls.Load(); got != 1 {
+		t.Errorf("disconnect calls=%d, want 1", got)
+	}
+	if _, got := st.CurrentTunnel(); got != gen+1 {
+		t.Errorf("generation=%d, want %d", got, gen+1)
+	}
+}
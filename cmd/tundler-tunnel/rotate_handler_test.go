@@ -18,8 +18,9 @@ func TestRotateHandler_ReadyReturns202(t *testing.T) {
 	// atomic-bool polling. Trigger goroutine sends; test selects with a
 	// generous timeout.
 	triggered := make(chan struct{}, 1)
-	h := rotateHandler(st, func() {
+	h := rotateHandler(st, func(RotateRequest) error {
 		triggered <- struct{}{}
+		return nil
 	})
 
 	rr := httptest.NewRecorder()
@@ -58,7 +59,7 @@ func TestRotateHandler_DrainingReturns200Idempotent(t *testing.T) {
 			st.Set(s)
 
 			triggered := false
-			h := rotateHandler(st, func() { triggered = true })
+			h := rotateHandler(st, func(RotateRequest) error { triggered = true; return nil })
 
 			rr := httptest.NewRecorder()
 			h(rr, httptest.NewRequest(http.MethodPost, "/rotate", nil))
@@ -85,7 +86,7 @@ func TestRotateHandler_FailedReturns409Problem(t *testing.T) {
 	st.Set(StateFailed)
 
 	triggered := false
-	h := rotateHandler(st, func() { triggered = true })
+	h := rotateHandler(st, func(RotateRequest) error { triggered = true; return nil })
 
 	rr := httptest.NewRecorder()
 	h(rr, httptest.NewRequest(http.MethodPost, "/rotate", nil))
@@ -118,7 +119,7 @@ func TestRotateHandler_NotYetReadyReturns409Problem(t *testing.T) {
 			st.Set(s)
 
 			triggered := false
-			h := rotateHandler(st, func() { triggered = true })
+			h := rotateHandler(st, func(RotateRequest) error { triggered = true; return nil })
 
 			rr := httptest.NewRecorder()
 			h(rr, httptest.NewRequest(http.MethodPost, "/rotate", nil))
@@ -159,7 +160,7 @@ func TestRotateHandler_DebouncesWithinWindow(t *testing.T) {
 	st.RecordRotation("1.2.3.4", "5.6.7.8", "success", 1*time.Second)
 
 	triggered := false
-	h := rotateHandler(st, func() { triggered = true })
+	h := rotateHandler(st, func(RotateRequest) error { triggered = true; return nil })
 
 	rr := httptest.NewRecorder()
 	h(rr, httptest.NewRequest(http.MethodPost, "/rotate", nil))
@@ -195,7 +196,7 @@ func TestRotateHandler_AllowsAfterDebounceWindow(t *testing.T) {
 	st.mu.Unlock()
 
 	triggered := make(chan struct{}, 1)
-	h := rotateHandler(st, func() { triggered <- struct{}{} })
+	h := rotateHandler(st, func(RotateRequest) error { triggered <- struct{}{}; return nil })
 
 	rr := httptest.NewRecorder()
 	h(rr, httptest.NewRequest(http.MethodPost, "/rotate", nil))
@@ -213,7 +214,7 @@ func TestRotateHandler_AllowsAfterDebounceWindow(t *testing.T) {
 func TestRotateHandler_MethodNotAllowed(t *testing.T) {
 	st := NewStateTracker("fake")
 	st.Set(StateReady)
-	h := rotateHandler(st, func() {})
+	h := rotateHandler(st, func(RotateRequest) error { return nil })
 
 	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
 		t.Run(method, func(t *testing.T) {
@@ -228,3 +229,208 @@ func TestRotateHandler_MethodNotAllowed(t *testing.T) {
 		})
 	}
 }
+
+// TestRotateHandler_PassesParametersToTrigger: query and JSON body both
+// feed the RotateRequest; lists from both are merged.
+func TestRotateHandler_PassesParametersToTrigger(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+
+	got := make(chan RotateRequest, 1)
+	h := rotateHandler(st, func(req RotateRequest) error {
+		got <- req
+		return nil
+	})
+
+	body := strings.NewReader(`{"country":"Germany","exclude":["Germany - Berlin"],"avoid_exit_ips":["1.2.3.4"]}`)
+	req := httptest.NewRequest(http.MethodPost, "/rotate?exclude=Germany+-+Munich&avoid_exit_ip=5.6.7.8", body)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got %d, want 202 Accepted", rr.Code)
+	}
+
+	select {
+	case r := <-got:
+		if r.Country != "Germany" {
+			t.Errorf("country=%q, want Germany", r.Country)
+		}
+		if strings.Join(r.Exclude, "|") != "Germany - Berlin|Germany - Munich" {
+			t.Errorf("exclude=%q, want body then query entries", r.Exclude)
+		}
+		if strings.Join(r.AvoidExitIPs, "|") != "1.2.3.4|5.6.7.8" {
+			t.Errorf("avoid_exit_ips=%q, want body then query entries", r.AvoidExitIPs)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("rotation trigger was not invoked within 500ms")
+	}
+}
+
+func TestRotateHandler_RejectsBadParameters(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.Set(StateReady)
+	h := rotateHandler(st, func(RotateRequest) error {
+		t.Error("trigger must not fire for a bad request")
+		return nil
+	})
+	for _, target := range []string{
+		"/rotate?wait=maybe",
+		"/rotate?timeout=soon",
+		"/rotate?avoid_exit_ip=not-an-ip",
+	} {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(http.MethodPost, target, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", target, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: Content-Type=%q, want application/problem+json", target, ct)
+		}
+	}
+}
+
+// TestRotateHandler_WaitReturnsNewExit: wait=true blocks until the
+// rotation finishes and answers with the new exit and rotation record.
+func TestRotateHandler_WaitReturnsNewExit(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("USA", "1.2.3.4")
+	st.Set(StateReady)
+
+	h := rotateHandler(st, func(req RotateRequest) error {
+		st.RecordTunnelUp(req.Location, "5.6.7.8")
+		st.RecordRotation("1.2.3.4", "5.6.7.8", "success", time.Second)
+		return nil
+	})
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodPost, "/rotate?wait=true&location=UK", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200 OK: %s", rr.Code, rr.Body)
+	}
+	var res rotateResult
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.CurrentExitIP != "5.6.7.8" || res.CurrentLocation != "UK" || res.PreviousExitIP != "1.2.3.4" {
+		t.Errorf("result=%+v, want UK 1.2.3.4 → 5.6.7.8", res)
+	}
+	if res.Rotation == nil || res.Rotation.Location != "UK" || res.Rotation.Outcome != "success" {
+		t.Errorf("rotation=%+v, want a success record for UK", res.Rotation)
+	}
+}
+
+// TestRotateHandler_WaitMapsOutcomes: each rotation failure mode gets its
+// own status and problem type.
+func TestRotateHandler_WaitMapsOutcomes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		status   int
+		typeTail string
+	}{
+		{"unsatisfiable", errLocationUnsatisfiable, http.StatusUnprocessableEntity, "/errors/no-matching-location"},
+		{"skipped", errRotationSkipped, http.StatusConflict, "/errors/rotation-skipped"},
+		{"failed", errRotationExhausted, http.StatusBadGateway, "/errors/rotation-failed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st := NewStateTracker("fake")
+			st.Set(StateReady)
+			h := rotateHandler(st, func(RotateRequest) error { return tc.err })
+
+			rr := httptest.NewRecorder()
+			h(rr, httptest.NewRequest(http.MethodPost, "/rotate?wait=true", nil))
+			if rr.Code != tc.status {
+				t.Errorf("got %d, want %d", rr.Code, tc.status)
+			}
+			var p problemDetails
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !strings.HasSuffix(p.Type, tc.typeTail) {
+				t.Errorf("type=%q, want suffix %s", p.Type, tc.typeTail)
+			}
+		})
+	}
+}
+
+func TestRotateHandler_WaitTimesOut(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.Set(StateReady)
+	release := make(chan struct{})
+	defer close(release)
+	h := rotateHandler(st, func(RotateRequest) error {
+		<-release
+		return nil
+	})
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodPost, "/rotate?wait=true&timeout=20ms", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want 504", rr.Code)
+	}
+}
+
+// TestRotateHandler_ConditionalStaleIsNoOp: a caller that observed an
+// exit the pod has already left gets the current exit back and no new
+// rotation.
+func TestRotateHandler_ConditionalStaleIsNoOp(t *testing.T) {
+	for _, target := range []string{
+		"/rotate?if_exit_ip=1.2.3.4",
+		"/rotate?if_generation=1",
+	} {
+		t.Run(target, func(t *testing.T) {
+			st := NewStateTracker("fake")
+			st.RecordTunnelUp("USA", "1.2.3.4")
+			st.RecordTunnelUp("UK", "5.6.7.8") // someone else already rotated
+			st.Set(StateReady)
+
+			triggered := false
+			h := rotateHandler(st, func(RotateRequest) error { triggered = true; return nil })
+
+			rr := httptest.NewRecorder()
+			h(rr, httptest.NewRequest(http.MethodPost, target, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200 OK (no-op)", rr.Code)
+			}
+			if triggered {
+				t.Error("trigger must not fire when the precondition is stale")
+			}
+			var res rotateResult
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if res.CurrentExitIP != "5.6.7.8" || res.TunnelGeneration != 2 {
+				t.Errorf("result=%+v, want current exit 5.6.7.8 generation 2", res)
+			}
+		})
+	}
+}
+
+// TestRotateHandler_ConditionalMatchBypassesDebounce: a caller reporting
+// the CURRENT exit is the first to see it blocked, so it rotates even
+// inside the debounce window.
+func TestRotateHandler_ConditionalMatchBypassesDebounce(t *testing.T) {
+	st := NewStateTracker("fake")
+	st.RecordTunnelUp("UK", "5.6.7.8")
+	st.Set(StateReady)
+	st.RecordRotation("1.2.3.4", "5.6.7.8", "success", time.Second)
+
+	got := make(chan RotateRequest, 1)
+	h := rotateHandler(st, func(req RotateRequest) error { got <- req; return nil })
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodPost, "/rotate?if_exit_ip=5.6.7.8&if_generation=1", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got %d, want 202 Accepted", rr.Code)
+	}
+	select {
+	case req := <-got:
+		if req.IfExitIP != "5.6.7.8" || req.IfGeneration != 1 {
+			t.Errorf("request=%+v, want the precondition passed through", req)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("rotation trigger was not invoked within 500ms")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand/v2"
@@ -20,6 +21,99 @@ const (
 	drainWaitTimeout = 30 * time.Second
 )
 
+// RotateRequest carries the caller's constraints for one rotation, as
+// parsed from POST /rotate. The zero value is an unconstrained rotation —
+// what the scheduled rotator and a bare /rotate ask for.
+type RotateRequest struct {
+	// Location pins the rotation to one provider location (exact, same
+	// casing as the provider's catalog). Wins over Country.
+	Location string `json:"location,omitempty"`
+	// Country restricts the pick to the provider locations of one
+	// country, by name or ISO code (see candidates).
+	Country string `json:"country,omitempty"`
+	// Exclude adds selectors (locationfilter.go) to EXCLUDED_LOCATIONS
+	// for this rotation only.
+	Exclude []string `json:"exclude,omitempty"`
+	// AvoidExitIPs rejects a new tunnel whose exit IP is in the list and
+	// retries, within ROTATION_RETRY_MAX — e.g. the IPs a crawler slot
+	// has just seen blocked.
+	AvoidExitIPs []string `json:"avoid_exit_ips,omitempty"`
+	// IfExitIP / IfGeneration make the rotation conditional: it runs
+	// only while the pod is still on the exit IP (or tunnel generation,
+	// see Snapshot.TunnelGeneration) the caller observed. Several slots
+	// sharing a pod that hit the same block then cause one rotation, not
+	// one each. Zero values mean unconditional.
+	IfExitIP     string `json:"if_exit_ip,omitempty"`
+	IfGeneration uint64 `json:"if_generation,omitempty"`
+
+	// RecentExits, ExitGeo and ExitASN are the live recent-exit
+	// (exitreuse.go), exit geolocation (exitgeo.go) and exit ASN
+	// (exitasn.go) policies, filled in by the rotation triggers rather
+	// than the caller.
+	RecentExits recentExitPolicy `json:"-"`
+	ExitGeo     exitGeoPolicy    `json:"-"`
+	ExitASN     exitASNPolicy    `json:"-"`
+
+	// Audit is the control-API call that asked for this rotation (nil
+	// for scheduled ones); copied onto the rotation record.
+	Audit *AuditEntry `json:"-"`
+}
+
+// conditional reports whether the request carries a precondition.
+func (req RotateRequest) conditional() bool {
+	return req.IfExitIP != "" || req.IfGeneration != 0
+}
+
+// superseded reports whether the pod has already moved past the tunnel
+// the caller observed, i.e. the precondition no longer holds.
+func (req RotateRequest) superseded(exitIP string, generation uint64) bool {
+	return (req.IfExitIP != "" && req.IfExitIP != exitIP) ||
+		(req.IfGeneration != 0 && req.IfGeneration != generation)
+}
+
+// errLocationUnsatisfiable is returned by rotateIfReady, before anything
+// is torn down, when no catalog location matches the request.
+var errLocationUnsatisfiable = errors.New("no location satisfies the rotation request")
+
+// errRotationSuperseded is returned by rotateIfReady when a conditional
+// request's precondition no longer holds: someone else already rotated.
+var errRotationSuperseded = errors.New("pod already moved past the observed tunnel")
+
+// errRotationSkipped is returned by rotateIfReady when the pod was not in
+// a state it rotates from (another code path owns the connection).
+var errRotationSkipped = errors.New("rotation skipped")
+
+// beginRotation moves a Ready or Failed pod to Draining, checking a
+// conditional request's precondition in the same step: the handler's
+// own check can't stop two callers that both saw the same tunnel, and
+// conditional requests skip the debounce. One of several concurrent
+// callers wins; a conditional loser finds the tunnel it saw already
+// being replaced. Returns the exit being rotated away from.
+func beginRotation(state *StateTracker, req RotateRequest) (string, error) {
+	var previousIP string
+	err := state.TransitionIf(StateDraining, "rotation", func(current State, exitIP string, gen uint64) error {
+		switch {
+		case req.conditional() && (current == StateDraining || current == StateRotating):
+			return errRotationSuperseded
+		case current != StateReady && current != StateFailed:
+			return fmt.Errorf("%w: state=%s", errRotationSkipped, current)
+		case req.superseded(exitIP, gen):
+			return errRotationSuperseded
+		}
+		previousIP = exitIP
+		return nil
+	})
+	switch {
+	case errors.Is(err, errRotationSuperseded):
+		exitIP, gen := state.CurrentTunnel()
+		log.Printf("tundler-tunnel: conditional rotation skipped; now exit_ip=%s generation=%d (caller saw exit_ip=%q generation=%d)",
+			exitIP, gen, req.IfExitIP, req.IfGeneration)
+	case err != nil:
+		log.Printf("tundler-tunnel: rotator skipping; %v", err)
+	}
+	return previousIP, err
+}
+
 // runRotator fires a tunnel rotation every uniform random pick in
 // [minInterval, maxInterval], picking a fresh allowed location each
 // time. Lifecycle: Ready → Draining → Rotating → Ready / Failed.
@@ -30,15 +124,42 @@ const (
 // synchronized stampede min-window-seconds later either.
 //
 // Skipped if state != Ready/Failed (e.g., a previous rotation is in
-// flight, or the watchdog is reconnecting).
-func runRotator(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, minInterval, maxInterval time.Duration, drain drainController, baselineEgressIP string) {
-	initialOffset := pickRotationInterval(minInterval, maxInterval)
-	log.Printf("tundler-tunnel: rotator armed; first rotation in %s (then every random %s..%s)",
-		initialOffset.Round(time.Second), minInterval, maxInterval)
-
-	timer := time.NewTimer(initialOffset)
+// flight, the watchdog is reconnecting, or the tunnel is Parked), and
+// while rotation is paused (/rotation/pause); the timer keeps its
+// cadence either way.
+//
+// The window, exclusions and recycle limit are read from cfg live. A
+// changed window re-arms the timer with a fresh pick from the new one
+// (like a boot offset); 0/0 idles the rotator until re-enabled.
+func runRotator(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, cfg *liveConfig, drain drainController, baselineEgressIP string) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
 	defer timer.Stop()
-	state.RecordNextRotation(time.Now().Add(initialOffset))
+
+	// Take the change channel before the first read so an Apply in
+	// between is never missed.
+	changed := cfg.Changed()
+	var armedMin, armedMax seconds
+	arm := func(first bool) {
+		c := cfg.Get()
+		armedMin, armedMax = c.MinRotation, c.MaxRotation
+		if !c.rotationEnabled() {
+			timer.Stop()
+			state.RecordNextRotation(time.Time{})
+			log.Printf("tundler-tunnel: periodic rotation disabled; /rotate still honored")
+			return
+		}
+		next := pickRotationInterval(c.MinRotation.d(), c.MaxRotation.d())
+		if first {
+			log.Printf("tundler-tunnel: rotator armed; first rotation in %s (then every random %s..%s)",
+				next.Round(time.Second), c.MinRotation.d(), c.MaxRotation.d())
+		} else {
+			log.Printf("tundler-tunnel: next rotation in %s", next.Round(time.Second))
+		}
+		timer.Reset(next)
+		state.RecordNextRotation(time.Now().Add(next))
+	}
+	arm(true)
 
 	// Count only SCHEDULED relocations toward the recycle trigger. Crawler
 	// /rotate (AIMD throttle-recovery, which can fire every few minutes on a
@@ -48,26 +169,42 @@ func runRotator(ctx context.Context, prov provider.VPNProvider, state *StateTrac
 	// lives here and not in the shared rotateIfReadyWithDeps.
 	scheduledRotations := 0
 
+	// The timer may be hours out; beat on a ticker in between.
+	beat := time.NewTicker(heartbeatInterval)
+	defer beat.Stop()
+	state.Heartbeat(loopRotator, heartbeatInterval)
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-beat.C:
+			state.Heartbeat(loopRotator, heartbeatInterval)
+		case <-changed:
+			changed = cfg.Changed()
+			if c := cfg.Get(); c.MinRotation != armedMin || c.MaxRotation != armedMax {
+				arm(true)
+			}
 		case <-timer.C:
 			// Recycle instead of performing the next scheduled relocation
 			// once this pod has done its allotment: a fresh container gives a
 			// new exit IP AND picks up the latest image + freshest env. So the
 			// (limit+1)th scheduled relocation becomes a graceful recycle.
-			if recycleRotationLimit > 0 && scheduledRotations >= recycleRotationLimit {
+			if state.RotationPaused() {
+				log.Printf("tundler-tunnel: scheduled rotation skipped; paused via /rotation/pause")
+				arm(false)
+				continue
+			}
+			c := cfg.Get()
+			if limit := c.RecycleAfterRotations; limit > 0 && scheduledRotations >= limit {
 				recycleContainer(ctx, state, drain,
-					fmt.Sprintf("completed %d scheduled relocations", recycleRotationLimit))
+					fmt.Sprintf("completed %d scheduled relocations", limit))
 				return
 			}
-			rotateIfReady(ctx, prov, state, providerName, excluded, drain, baselineEgressIP)
+			state.Heartbeat(loopRotator, c.WedgeGuardThreshold.d())
+			_ = rotateIfReady(ctx, prov, state, providerName, c.locationFilter(), RotateRequest{RecentExits: c.recentExitPolicy(), ExitGeo: c.exitGeoPolicy(), ExitASN: c.exitASNPolicy()}, drain, baselineEgressIP)
+			state.Heartbeat(loopRotator, heartbeatInterval)
 			scheduledRotations++
-			next := pickRotationInterval(minInterval, maxInterval)
-			log.Printf("tundler-tunnel: next rotation in %s", next.Round(time.Second))
-			timer.Reset(next)
-			state.RecordNextRotation(time.Now().Add(next))
+			arm(false)
 		}
 	}
 }
@@ -82,35 +219,62 @@ func runRotator(ctx context.Context, prov provider.VPNProvider, state *StateTrac
 // all attempts fail, transitions to StateFailed; the watchdog will
 // keep retrying from there with its own backoff.
 //
+// req narrows the location pick and rejects unwanted exits (see
+// RotateRequest). A request no catalog location can satisfy is refused
+// BEFORE the drain, so a typo'd location can't take a healthy tunnel down.
+//
+// Returns nil on success, errRotationSkipped when the state didn't allow
+// a rotation, or the reason it failed. Only /rotate?wait=true looks at it;
+// the scheduled rotator fires and forgets.
+//
 // Production passes a proxyDrainController; tests use nil (skip the
 // drain) or a fakeDrainController.
-func rotateIfReady(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, drain drainController, baselineEgressIP string) {
-	rotateIfReadyWithDeps(ctx, prov, state, providerName, excluded,
+func rotateIfReady(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, req RotateRequest, drain drainController, baselineEgressIP string) error {
+	return rotateIfReadyWithDeps(ctx, prov, state, providerName, filter, req,
 		getEnvInt(envRotationRetryMax, defaultRotationRetryMax), time.Sleep, drain, baselineEgressIP)
 }
 
 // rotateIfReadyWithDeps is the testable form of rotateIfReady — exposes
 // maxAttempts + sleep so tests can drive deterministic behavior without
 // reading env vars or waiting for real backoffs.
-func rotateIfReadyWithDeps(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, excluded []string, maxAttempts int, sleep func(time.Duration), drain drainController, baselineEgressIP string) {
+func rotateIfReadyWithDeps(ctx context.Context, prov provider.VPNProvider, state *StateTracker, providerName string, filter *locationFilter, req RotateRequest, maxAttempts int, sleep func(time.Duration), drain drainController, baselineEgressIP string) error {
 	// Accept Failed too: the watchdog usually drives recovery, but the
 	// scheduled rotator is a periodic backup path for the rare case
-	// where the watchdog is wedged (e.g., a CPU-pinned thread).
-	current := state.Get()
-	if current != StateReady && current != StateFailed {
+	// where the watchdog is wedged (e.g., a CPU-pinned thread). A cheap
+	// early look; beginRotation re-checks it atomically.
+	if current := state.Get(); current != StateReady && current != StateFailed {
 		log.Printf("tundler-tunnel: rotator skipping; state=%s (not Ready/Failed)", current)
-		return
+		return fmt.Errorf("%w: state=%s", errRotationSkipped, current)
+	}
+	if state.ShuttingDown() {
+		log.Printf("tundler-tunnel: rotator skipping; shutdown drain in progress")
+		return fmt.Errorf("%w: shutting down", errRotationSkipped)
+	}
+	if req.Location != "" || req.Country != "" {
+		available := req.candidates(prov.Locations(ctx), state.locationCountry)
+		if _, err := pickLocation(available, filter.excluding(req.Exclude, nil).resolving(state.locationCountry)); err != nil {
+			log.Printf("tundler-tunnel: rotation refused; no location satisfies location=%q country=%q: %v",
+				req.Location, req.Country, err)
+			return fmt.Errorf("%w (location=%q country=%q): %v", errLocationUnsatisfiable, req.Location, req.Country, err)
+		}
 	}
 
 	started := time.Now()
-	previousIP := state.SnapshotCurrentExitIP()
 
 	// Draining: flip /readyz→503 immediately so the crawler slot pinned
 	// to this pod stops dispatching new CONNECTs, then wait for
 	// in-flight tunnels to drain so we don't yank the VPN out from
 	// under a live request.
-	state.Set(StateDraining)
+	previousIP, err := beginRotation(state, req)
+	if err != nil {
+		return err
+	}
 	log.Printf("tundler-tunnel: rotation started (previous_exit_ip=%s)", previousIP)
+	startedEv := map[string]any{"previous_exit_ip": previousIP, "request": req}
+	if req.Audit != nil {
+		startedEv["requested_by"] = req.Audit.Caller
+	}
+	state.Publish(eventRotationStarted, startedEv)
 
 	if drain != nil {
 		if err := drain.TriggerGracefulDrain(ctx); err != nil {
@@ -124,7 +288,13 @@ func rotateIfReadyWithDeps(ctx context.Context, prov provider.VPNProvider, state
 	// Rotating: disconnect, then reconnect with retry-with-different-
 	// location. The connectWithRetry helper tracks recentlyFailed within
 	// this rotation so the same broken location isn't retried twice.
-	state.Set(StateRotating)
+	state.Transition(StateRotating, "rotation: drained")
+	trigger := triggerScheduled
+	if req.Audit != nil {
+		trigger = triggerAPI
+	}
+	state.RecordDisconnect(trigger)
+	state.BeginConnect(trigger)
 	if err := prov.Disconnect(ctx); err != nil {
 		// Disconnect failures are surprisingly common when the network
 		// is already flaky. Log + continue — the subsequent Connect
@@ -132,17 +302,34 @@ func rotateIfReadyWithDeps(ctx context.Context, prov provider.VPNProvider, state
 		log.Printf("tundler-tunnel: rotation Disconnect failed (continuing to Connect): %v", err)
 	}
 
-	if err := connectWithRetry(ctx, prov, state, providerName, excluded, maxAttempts, sleep, baselineEgressIP); err != nil {
+	if err := connectWithRetry(ctx, prov, state, providerName, filter, req, maxAttempts, sleep, baselineEgressIP); err != nil {
 		log.Printf("tundler-tunnel: rotation failed after retries: %v", err)
 		state.RecordRotation(previousIP, "", "failed", time.Since(started))
-		state.Set(StateFailed)
-		return
+		state.RecordConnectFailure(err)
+		state.RecordRotationAudit(req.Audit)
+		state.Transition(StateFailed, "rotation failed: "+err.Error())
+		state.Publish(eventRotationFinished, map[string]any{
+			"outcome":          "failed",
+			"previous_exit_ip": previousIP,
+			"duration_seconds": time.Since(started).Seconds(),
+			"error":            err.Error(),
+		})
+		return err
 	}
 
 	newIP := state.SnapshotCurrentExitIP()
 	state.RecordRotation(previousIP, newIP, "success", time.Since(started))
+	state.RecordRotationAudit(req.Audit)
+	state.Publish(eventRotationFinished, map[string]any{
+		"outcome":          "success",
+		"previous_exit_ip": previousIP,
+		"new_exit_ip":      newIP,
+		"location":         state.Snapshot().CurrentLocation,
+		"duration_seconds": time.Since(started).Seconds(),
+	})
 	log.Printf("tundler-tunnel: rotation complete (%s → %s) in %s",
 		previousIP, newIP, time.Since(started).Round(time.Second))
+	return nil
 }
 
 // pickRotationInterval returns a uniform random duration in [min, max].
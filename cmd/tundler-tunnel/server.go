@@ -5,19 +5,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
+	"github.com/laurentpellegrino/tundler/tunnelapi"
 )
 
-// RotateTrigger runs one rotation cycle in the background. The /rotate
-// handler invokes it in a goroutine so the HTTP request returns 202
-// quickly while rotation completes asynchronously.
+// RotateTrigger runs one rotation cycle with the caller's constraints and
+// returns its outcome. The /rotate handler invokes it in a goroutine so a
+// plain request returns 202 quickly while rotation completes
+// asynchronously; with wait=true the handler blocks on the result.
 //
 // Production wires this to rotateIfReady (which guards on state==Ready
 // and is idempotent if the rotator timer fires concurrently). Tests pass
 // a stub that records invocations.
-type RotateTrigger func()
+type RotateTrigger func(req RotateRequest) error
 
 // httpListenAddr is the bind address for tundler-tunnel's control-plane API
 // (consumed by k8s probes and by the crawler slot pinned to this pod,
@@ -27,21 +35,129 @@ type RotateTrigger func()
 // pod-IP traffic here for /rotate, and httpGet probes target the pod IP.
 const httpListenAddr = "0.0.0.0:4242"
 
+// controlAPI is everything the :4242 handlers need. main fills it once;
+// tests build one with just the fields a route uses.
+type controlAPI struct {
+	state      *StateTracker
+	trigger    RotateTrigger
+	tunnelID   string
+	nodeIP     string
+	proxyStats func() proxyStats // nil: /status omits the proxy section
+	auth       *apiAuth          // nil: no authentication
+	audit      AuditSink         // nil: audit entries are only logged
+
+	// catalog backs GET /locations; config is the live runtime config
+	// (GET/PUT /config, "config" in /status).
+	catalog *cachedLocationsProvider
+	config  *liveConfig
+
+	// tunnel backs /tunnel/disconnect and /tunnel/connect (routes absent
+	// when its funcs are nil); drain backs POST /drain (absent when nil).
+	tunnel tunnelControl
+	drain  *proxyDrainController
+
+	// dataPlane feeds the listener and last_dial readiness checks
+	// (skipped when nil).
+	dataPlane dataPlane
+}
+
+// routeMux is a ServeMux that remembers its patterns, so a test can
+// hold the OpenAPI document (tunnelapi/openapi.json) to exactly the
+// routes served.
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *routeMux) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, h)
+}
+
+// routes builds the mux. Probes stay open; read endpoints need the read
+// scope and mutating ones the admin scope (no-ops while auth is off).
+// Mutating endpoints are audited. A new route also goes into
+// tunnelapi/openapi.json (openapi_test.go fails until it does).
+func (api controlAPI) routes() *routeMux {
+	mux := &routeMux{ServeMux: http.NewServeMux()}
+	mux.HandleFunc("/livez", livezHandler(api.state))
+	mux.HandleFunc("/readyz", readyzHandler(api.state, api.dataPlane))
+	mux.HandleFunc("/status", api.guard(scopeRead, api.statusHandler()))
+	mux.HandleFunc("/rotate", api.auth.audited(api.audit, api.guard(scopeAdmin,
+		rotateHandler(api.state, api.trigger))))
+	mux.HandleFunc("/selftest/fingerprint", api.guard(scopeRead,
+		fingerprintSelfTestHandler(api.tunnelID)))
+	mux.HandleFunc("/locations", api.guard(scopeRead, locationsHandler(api.state, api.catalog, api.locationFilter)))
+	if api.config != nil {
+		mux.HandleFunc("GET /config", api.guard(scopeRead, getConfigHandler(api.config)))
+		mux.HandleFunc("PUT /config", api.auth.audited(api.audit, api.guard(scopeAdmin,
+			putConfigHandler(api.config, api.state))))
+	}
+	mux.HandleFunc("POST /rotation/pause", api.auth.audited(api.audit, api.guard(scopeAdmin,
+		rotationPauseHandler(api.state, true))))
+	mux.HandleFunc("POST /rotation/resume", api.auth.audited(api.audit, api.guard(scopeAdmin,
+		rotationPauseHandler(api.state, false))))
+	if api.tunnel.park != nil && api.tunnel.unpark != nil {
+		mux.HandleFunc("POST /tunnel/disconnect", api.auth.audited(api.audit, api.guard(scopeAdmin,
+			tunnelDisconnectHandler(api.state, api.tunnel.park))))
+		mux.HandleFunc("POST /tunnel/connect", api.auth.audited(api.audit, api.guard(scopeAdmin,
+			tunnelConnectHandler(api.state, api.tunnel.unpark))))
+	}
+	if api.drain != nil {
+		mux.HandleFunc("POST /drain", api.auth.audited(api.audit, api.guard(scopeAdmin,
+			drainHandler(api.state, api.drain))))
+	}
+	mux.HandleFunc("/history", api.guard(scopeRead, historyHandler(api.state)))
+	mux.HandleFunc("/events", api.guard(scopeRead, eventsHandler(api.state.Events())))
+	mux.HandleFunc("GET /metrics", api.guard(scopeRead, metricsHandler(api.state)))
+	mux.HandleFunc("GET /openapi.json", api.guard(scopeRead, openAPIHandler))
+	return mux
+}
+
+// openAPIHandler implements GET /openapi.json.
+func openAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(tunnelapi.OpenAPI())
+}
+
+// locationFilter is the live location filter (none without a config).
+func (api controlAPI) locationFilter() *locationFilter {
+	if api.config == nil {
+		return nil
+	}
+	return api.config.Get().locationFilter()
+}
+
+// guard is require plus an auth_failure event for every 401/403, so a
+// credential drift on a caller shows up on the event stream.
+func (api controlAPI) guard(scope apiScope, h http.HandlerFunc) http.HandlerFunc {
+	guarded := api.auth.require(scope, h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		guarded(rec, r)
+		if rec.status == http.StatusUnauthorized || rec.status == http.StatusForbidden {
+			api.state.Publish(eventAuthFailure, map[string]any{
+				"source":      "control_api",
+				"endpoint":    r.Method + " " + r.URL.Path,
+				"remote_addr": r.RemoteAddr,
+				"status":      rec.status,
+			})
+		}
+	}
+}
+
 // startServer wires the HTTP handlers and starts listening. Returns when
 // ctx is cancelled or the server hits an error. Server lifecycle is the
 // caller's responsibility — main passes its own context.
-func startServer(ctx context.Context, state *StateTracker, triggerRotation RotateTrigger, tunnelID, nodeIP string) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/livez", livezHandler(state))
-	mux.HandleFunc("/readyz", readyzHandler(state))
-	mux.HandleFunc("/status", statusHandler(state, tunnelID, nodeIP))
-	mux.HandleFunc("/rotate", rotateHandler(state, triggerRotation))
-
+func startServer(ctx context.Context, api controlAPI) error {
 	srv := &http.Server{
 		Addr:              httpListenAddr,
-		Handler:           mux,
+		Handler:           api.routes(),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	if api.auth != nil {
+		srv.TLSConfig = api.auth.tlsConfig
+	}
 
 	go func() {
 		<-ctx.Done()
@@ -50,52 +166,66 @@ func startServer(ctx context.Context, state *StateTracker, triggerRotation Rotat
 		_ = srv.Shutdown(shutdownCtx)
 	}()
 
-	log.Printf("tundler-tunnel: HTTP API listening on %s", httpListenAddr)
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	var err error
+	if srv.TLSConfig != nil {
+		log.Printf("tundler-tunnel: HTTP API listening on %s (TLS, auth=%t)", httpListenAddr, api.auth.enabled())
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("tundler-tunnel: HTTP API listening on %s (auth=%t)", httpListenAddr, api.auth.enabled())
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
 
 // livezHandler answers the kubelet liveness probe. Liveness is "is
-// this process responsive?" — the act of serving this 200 IS the
-// liveness signal kubelet wants. We deliberately do NOT inspect VPN
-// state here: a rotation in progress, a brief Failed window, or even
-// a stuck VPN daemon are not reasons to have kubelet recreate the
-// CONTAINER (which wipes /var/log/journal and starts the kubelet
-// restart counter ticking). Instead, runWedgeGuard (in main.go) calls
-// os.Exit(1) when state stays not-Ready continuously past its
-// threshold; systemd's Restart=always then respawns the binary inside
-// the same container, preserving forensic logs and not touching the
-// kubelet-visible restart count.
+// this process responsive?" — and, since structured checks, "are the
+// goroutines that heal the tunnel still running?" (health.go). We
+// deliberately do NOT inspect VPN state here: a rotation in progress, a
+// brief Failed window, or even a stuck VPN daemon are not reasons to
+// have kubelet recreate the CONTAINER (which wipes /var/log/journal and
+// starts the kubelet restart counter ticking). Instead, runWedgeGuard
+// (in main.go) calls os.Exit(1) when state stays not-Ready continuously
+// past its threshold; systemd's Restart=always then respawns the binary
+// inside the same container, preserving forensic logs and not touching
+// the kubelet-visible restart count. /livez answers 503 only when the
+// watchdog, rotator or wedge guard missed its heartbeat, or the control
+// loop sat in a transitional state past controlLoopStuckAfter — hangs
+// nothing in-process can undo.
 //
 // /readyz remains the right probe for "should this pod accept traffic
 // right now?" — see readyzHandler below.
 func livezHandler(state *StateTracker) http.HandlerFunc {
-	_ = state // reserved for future structured liveness checks
-	return func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, r, livenessChecks(state), "not alive")
 	}
 }
 
 // readyzHandler returns 200 only when the pod is genuinely ready to
-// serve traffic — state==Ready. During rotation /readyz flips to 503
-// the instant tundler-tunnel transitions out of Ready (Draining), and
-// stays 503 until rotation succeeds (back to Ready) or surrenders
-// (Failed). The crawler slot pinned to this pod resolves Ready state
-// via headless-service DNS (publishNotReadyAddresses=false) and skips
-// dispatch while unready.
-func readyzHandler(state *StateTracker) http.HandlerFunc {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		s := state.Get()
-		if s != StateReady {
-			http.Error(w, "not ready: state="+string(s), http.StatusServiceUnavailable)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
+// serve traffic — state==Ready, and every readiness check in health.go
+// passes. During rotation /readyz flips to 503 the instant
+// tundler-tunnel transitions out of Ready (Draining), and stays 503
+// until rotation succeeds (back to Ready) or surrenders (Failed). A
+// shutdown drain (POST /drain, SIGTERM) holds it at 503 for good. The
+// crawler slot pinned to this pod resolves Ready state via
+// headless-service DNS (publishNotReadyAddresses=false) and skips
+// dispatch while unready. dp is the CONNECT proxy (nil in tests).
+func readyzHandler(state *StateTracker, dp dataPlane) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, r, readinessChecks(state, dp), "not ready")
 	}
 }
 
+// proxyStats is the data-plane section of /status: the counters of the
+// CONNECT proxy (:8485) and of the browser-impersonating fetch proxy
+// (:8486), both of which drain on rotation.
+type proxyStats struct {
+	Connect     proxy.Stats            `json:"connect"`
+	Impersonate proxy.ImpersonateStats `json:"impersonate"`
+}
+
 // statusHandler returns the JSON snapshot of the tracker state, plus
 // the pod's static identity fields (tunnel_id = POD_NAME via downward
 // API, node_ip = TUNDLER_TUNNEL_NODE_IP). The leak detector reads
@@ -103,23 +233,75 @@ func readyzHandler(state *StateTracker) http.HandlerFunc {
 // actual source IP a probe to checkip.amazonaws.com reports — that
 // comparison used to ride on response_headers_to_add at the hub
 // envoy, which is gone, so we surface the identity through /status
-// instead.
-func statusHandler(state *StateTracker, tunnelID, nodeIP string) http.HandlerFunc {
+// instead. proxyStats, when set, adds the proxies' counters under
+// "proxy"; config adds the effective runtime config under "config".
+func (api controlAPI) statusHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
-		snap := state.Snapshot()
+		snap := api.state.Snapshot()
 		// Anonymous struct embeds Snapshot so existing fields keep
-		// their JSON tags; the two new fields appear at the same
+		// their JSON tags; the extra fields appear at the same
 		// nesting level.
 		resp := struct {
 			Snapshot
-			TunnelID string `json:"tunnel_id,omitempty"`
-			NodeIP   string `json:"node_ip,omitempty"`
-		}{snap, tunnelID, nodeIP}
+			TunnelID string      `json:"tunnel_id,omitempty"`
+			NodeIP   string      `json:"node_ip,omitempty"`
+			Proxy    *proxyStats `json:"proxy,omitempty"`
+			Config   *ConfigView `json:"config,omitempty"`
+		}{Snapshot: snap, TunnelID: api.tunnelID, NodeIP: api.nodeIP}
+		if api.proxyStats != nil {
+			ps := api.proxyStats()
+			resp.Proxy = &ps
+		}
+		if api.config != nil {
+			v := api.config.View()
+			resp.Config = &v
+		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(resp)
 	}
 }
 
+// fingerprintSelfTestHandler implements GET /selftest/fingerprint: it runs
+// the impersonation stack's fingerprint self-test for THIS pod's browser
+// profile (the one PickProfile(tunnelID) assigns the :8486 proxy) and
+// compares JA3N / JA4NoPad (JA4 without padding; the report carries the
+// standard ja4 too) against the values pinned at build time, and h2
+// against the real browser's. For a profile that speaks h3 it also reports
+// the QUIC hello's JA4 (h3_ja4), which is Go's, not the browser's. Lets an
+// operator confirm a rolled-out image still presents the fingerprint it
+// was tested with, without capturing traffic.
+//
+//	fingerprint matches pin → 200 OK, report JSON; the known h2 and h3
+//	                          divergences are listed under divergences
+//	fingerprint drifted     → 500, report JSON (ok=false, mismatches)
+//	self-test couldn't run  → 500, application/problem+json
+func fingerprintSelfTestHandler(tunnelID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rep, err := proxy.CheckFingerprint(r.Context(), proxy.PickProfile(tunnelID))
+		if err != nil {
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/selftest-failed",
+				Title:  "Fingerprint self-test could not run",
+				Status: http.StatusInternalServerError,
+				Detail: err.Error(),
+			})
+			return
+		}
+		status := http.StatusOK
+		if !rep.OK {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
 // minTimeBetweenRotations caps how rapidly the /rotate endpoint will
 // trigger fresh rotations. The crawler's per-tunnel 429 tracking can
 // fan out a burst of /rotate POSTs against the same pod when an exit
@@ -137,12 +319,160 @@ func statusHandler(state *StateTracker, tunnelID, nodeIP string) http.HandlerFun
 // collapsed into a single rotation.
 const minTimeBetweenRotations = 30 * time.Second
 
+// Bounds for POST /rotate?wait=true. The default covers a full drain
+// (drainWaitTimeout) plus a few connect attempts; the cap keeps a
+// forgotten client from pinning a handler goroutine indefinitely.
+const (
+	defaultRotateWaitTimeout = 2 * time.Minute
+	maxRotateWaitTimeout     = 10 * time.Minute
+)
+
+// parseTimeoutParam reads a ?timeout= value: a Go duration (90s) or a
+// bare number of seconds.
+func parseTimeoutParam(v string) (time.Duration, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("timeout=%q: want a duration (90s) or seconds", v)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// rotateParams is a parsed POST /rotate request.
+type rotateParams struct {
+	RotateRequest
+	Wait    bool
+	Timeout time.Duration
+}
+
+// parseRotateParams reads the /rotate parameters from an optional JSON
+// body and from the query string:
+//
+//	location=Germany          exact provider location
+//	country=Germany           any location of that country
+//	exclude=A,eu,us-*         extra exclusion selectors (repeatable)
+//	avoid_exit_ip=1.2.3.4,…   exits to reject and retry (repeatable)
+//	if_exit_ip=1.2.3.4        rotate only if still on this exit
+//	if_generation=7           rotate only if still on this tunnel generation
+//	wait=true                 block until the rotation finishes
+//	timeout=90s               how long wait=true blocks (or seconds)
+//
+// The body uses the same names (lists as arrays, timeout_seconds). Query
+// values win for scalars; lists from both are merged.
+func parseRotateParams(r *http.Request) (rotateParams, error) {
+	var body struct {
+		RotateRequest
+		Wait           bool `json:"wait"`
+		TimeoutSeconds int  `json:"timeout_seconds"`
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		dec := json.NewDecoder(io.LimitReader(r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			return rotateParams{}, fmt.Errorf("body: %w", err)
+		}
+	}
+	p := rotateParams{
+		RotateRequest: body.RotateRequest,
+		Wait:          body.Wait,
+		Timeout:       time.Duration(body.TimeoutSeconds) * time.Second,
+	}
+
+	q := r.URL.Query()
+	if v := q.Get("location"); v != "" {
+		p.Location = v
+	}
+	if v := q.Get("country"); v != "" {
+		p.Country = v
+	}
+	for _, v := range q["exclude"] {
+		p.Exclude = append(p.Exclude, parseExcludedLocations(v)...)
+	}
+	for _, v := range q["avoid_exit_ip"] {
+		p.AvoidExitIPs = append(p.AvoidExitIPs, parseExcludedLocations(v)...)
+	}
+	if v := q.Get("if_exit_ip"); v != "" {
+		p.IfExitIP = v
+	}
+	if v := q.Get("if_generation"); v != "" {
+		gen, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return rotateParams{}, fmt.Errorf("if_generation=%q: not a generation number", v)
+		}
+		p.IfGeneration = gen
+	}
+	if v := q.Get("wait"); v != "" {
+		wait, err := strconv.ParseBool(v)
+		if err != nil {
+			return rotateParams{}, fmt.Errorf("wait=%q: not a boolean", v)
+		}
+		p.Wait = wait
+	}
+	if v := q.Get("timeout"); v != "" {
+		d, err := parseTimeoutParam(v)
+		if err != nil {
+			return rotateParams{}, err
+		}
+		p.Timeout = d
+	}
+
+	if errs := validateLocationSelectors("exclude", p.Exclude); len(errs) > 0 {
+		return rotateParams{}, errs[0]
+	}
+	for _, ip := range p.AvoidExitIPs {
+		if _, err := netip.ParseAddr(strings.TrimSpace(ip)); err != nil {
+			return rotateParams{}, fmt.Errorf("avoid_exit_ip %q: not an IP address", ip)
+		}
+	}
+	if p.IfExitIP != "" {
+		if _, err := netip.ParseAddr(p.IfExitIP); err != nil {
+			return rotateParams{}, fmt.Errorf("if_exit_ip %q: not an IP address", p.IfExitIP)
+		}
+	}
+	switch {
+	case p.Timeout < 0:
+		return rotateParams{}, fmt.Errorf("timeout must be positive")
+	case p.Timeout == 0:
+		p.Timeout = defaultRotateWaitTimeout
+	case p.Timeout > maxRotateWaitTimeout:
+		p.Timeout = maxRotateWaitTimeout
+	}
+	return p, nil
+}
+
+// rotateResult is the body of a completed POST /rotate?wait=true and of
+// a conditional /rotate that turned out to be a no-op.
+type rotateResult = tunnelapi.RotateResult
+
+// writeSuperseded answers a conditional /rotate whose precondition no
+// longer holds: 200 with the tunnel the pod is on now, so the caller
+// can carry on with it instead of rotating it away too.
+func writeSuperseded(w http.ResponseWriter, snap Snapshot) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(rotateResult{
+		State:            snap.State,
+		CurrentExitIP:    snap.CurrentExitIP,
+		CurrentLocation:  snap.CurrentLocation,
+		TunnelGeneration: snap.TunnelGeneration,
+		Message:          "rotation skipped: pod already moved past the observed exit",
+	})
+}
+
 // rotateHandler implements POST /rotate. Called directly by the
 // crawler slot pinned to this pod (via per-pod DNS). Response shapes
 // follow RFC 9457 (Problem Details for errors).
 //
+//	bad parameters              → 400 Bad Request, problem-details
+//	if_exit_ip/if_generation stale → 200 OK (no-op, current exit returned)
 //	state==Ready, debounced     → 200 OK (no-op, last rotation too recent)
 //	state==Ready                → 202 Accepted (rotation runs async)
+//	state==Ready, wait=true     → 200 OK with the new exit once Ready;
+//	                              422 no location matches the request;
+//	                              502 rotation failed (pod now Failed);
+//	                              504 still rotating at the timeout
 //	state==Draining/Rotating    → 200 OK (idempotent: already in progress)
 //	state==Failed               → 409 Conflict, application/problem+json
 //	state==Booting/LoggingIn/Connecting → 409 Conflict, problem-details
@@ -154,26 +484,66 @@ func rotateHandler(state *StateTracker, trigger RotateTrigger) http.HandlerFunc
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		params, err := parseRotateParams(r)
+		if err != nil {
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/invalid-rotate-request",
+				Title:  "Invalid rotation parameters",
+				Status: http.StatusBadRequest,
+				Detail: err.Error(),
+			})
+			return
+		}
 		snap := state.Snapshot()
+		if snap.ShuttingDown {
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/shutting-down",
+				Title:  "Pod is shutting down",
+				Status: http.StatusConflict,
+				Detail: "a shutdown drain is in progress; rotate another pod",
+			})
+			return
+		}
 		switch snap.State {
 		case StateReady:
-			if since, recent := timeSinceLastRotation(snap); recent {
+			// A conditional request is its own dedup — only the first
+			// caller to report an exit rotates it — so it skips the
+			// time-based debounce.
+			if params.conditional() {
+				if params.superseded(snap.CurrentExitIP, snap.TunnelGeneration) {
+					writeSuperseded(w, snap)
+					return
+				}
+			} else if since, recent := timeSinceLastRotation(snap); recent {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				_ = json.NewEncoder(w).Encode(map[string]string{
-					"state":   string(StateReady),
+					"state":           string(StateReady),
+					"current_exit_ip": snap.CurrentExitIP,
 					"message": fmt.Sprintf("rotation debounced (last completed %s ago, min %s)",
 						since.Round(time.Second), minTimeBetweenRotations),
 				})
 				return
 			}
-			go trigger()
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusAccepted)
-			_ = json.NewEncoder(w).Encode(map[string]string{
-				"state":            string(StateRotating),
-				"previous_exit_ip": snap.CurrentExitIP,
-			})
+			// Buffered so the rotation goroutine never blocks on a
+			// caller that stopped waiting.
+			done := make(chan error, 1)
+			req := params.RotateRequest
+			if e := auditFrom(r.Context()); e != nil {
+				a := *e
+				req.Audit = &a
+			}
+			go func() { done <- trigger(req) }()
+			if !params.Wait {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusAccepted)
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"state":            string(StateRotating),
+					"previous_exit_ip": snap.CurrentExitIP,
+				})
+				return
+			}
+			waitForRotation(w, r, state, snap.CurrentExitIP, done, params.Timeout)
 		case StateDraining, StateRotating:
 			// Idempotent dedup: the design says "Already rotating /
 			// draining → 200 OK" because the caller's intent (please
@@ -184,6 +554,13 @@ func rotateHandler(state *StateTracker, trigger RotateTrigger) http.HandlerFunc
 				"state":   string(snap.State),
 				"message": "rotation already in progress",
 			})
+		case StateParked:
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/tunnel-parked",
+				Title:  "Tunnel is parked",
+				Status: http.StatusConflict,
+				Detail: "the tunnel was disconnected via /tunnel/disconnect; POST /tunnel/connect first",
+			})
 		case StateFailed:
 			writeProblem(w, problemDetails{
 				Type:   "https://tundler-tunnel/errors/pod-failed-awaiting-restart",
@@ -202,16 +579,68 @@ func rotateHandler(state *StateTracker, trigger RotateTrigger) http.HandlerFunc
 	}
 }
 
+// waitForRotation blocks a /rotate?wait=true caller until the triggered
+// rotation reports back on done, the timeout elapses, or the client goes
+// away. The rotation itself is never cancelled by the caller: it runs on
+// the process context, and a timed-out caller can poll /status.
+func waitForRotation(w http.ResponseWriter, r *http.Request, state *StateTracker, previousExitIP string, done <-chan error, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		switch {
+		case err == nil:
+			snap := state.Snapshot()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(rotateResult{
+				State:            snap.State,
+				PreviousExitIP:   previousExitIP,
+				CurrentExitIP:    snap.CurrentExitIP,
+				CurrentLocation:  snap.CurrentLocation,
+				TunnelGeneration: snap.TunnelGeneration,
+				Rotation:         snap.LastRotation,
+			})
+		case errors.Is(err, errRotationSuperseded):
+			writeSuperseded(w, state.Snapshot())
+		case errors.Is(err, errLocationUnsatisfiable):
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/no-matching-location",
+				Title:  "No location matches the rotation request",
+				Status: http.StatusUnprocessableEntity,
+				Detail: err.Error(),
+			})
+		case errors.Is(err, errRotationSkipped):
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/rotation-skipped",
+				Title:  "Rotation did not start",
+				Status: http.StatusConflict,
+				Detail: err.Error(),
+			})
+		default:
+			writeProblem(w, problemDetails{
+				Type:   "https://tundler-tunnel/errors/rotation-failed",
+				Title:  "Rotation failed",
+				Status: http.StatusBadGateway,
+				Detail: err.Error(),
+			})
+		}
+	case <-timer.C:
+		writeProblem(w, problemDetails{
+			Type:   "https://tundler-tunnel/errors/rotation-timeout",
+			Title:  "Rotation still in progress",
+			Status: http.StatusGatewayTimeout,
+			Detail: fmt.Sprintf("not finished after %s; poll /status for the outcome", timeout),
+		})
+	case <-r.Context().Done():
+	}
+}
+
 // problemDetails is the RFC 9457 "Problem Details for HTTP APIs" shape.
 // type is the stable machine-readable error code (clients dispatch on
 // it, not on status code or title). status mirrors the HTTP status code
 // for clients that want a single source.
-type problemDetails struct {
-	Type   string `json:"type"`
-	Title  string `json:"title"`
-	Status int    `json:"status"`
-	Detail string `json:"detail,omitempty"`
-}
+type problemDetails = tunnelapi.Problem
 
 func writeProblem(w http.ResponseWriter, p problemDetails) {
 	w.Header().Set("Content-Type", "application/problem+json")
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/proxy"
 )
 
 func TestLivezHandler(t *testing.T) {
@@ -56,7 +59,7 @@ func TestReadyzHandler(t *testing.T) {
 			st := NewStateTracker("expressvpn")
 			st.Set(c.state)
 			rr := httptest.NewRecorder()
-			readyzHandler(st)(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			readyzHandler(st, nil)(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
 			if rr.Code != c.want {
 				t.Errorf("state=%s: /readyz got %d, want %d", c.state, rr.Code, c.want)
 			}
@@ -69,7 +72,7 @@ func TestStatusHandler_BootingShape(t *testing.T) {
 	st.RecordBootLoginJitter(47 * time.Second)
 
 	rr := httptest.NewRecorder()
-	statusHandler(st, "", "")(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	controlAPI{state: st}.statusHandler()(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
 
 	if rr.Code != http.StatusOK {
 		t.Fatalf("/status got %d, want 200", rr.Code)
@@ -102,7 +105,7 @@ func TestStatusHandler_ReadyEmitsLoggedInAt(t *testing.T) {
 	st.Set(StateReady)
 
 	rr := httptest.NewRecorder()
-	statusHandler(st, "", "")(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	controlAPI{state: st}.statusHandler()(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
 
 	var snap map[string]any
 	if err := json.NewDecoder(rr.Body).Decode(&snap); err != nil {
@@ -126,7 +129,7 @@ func TestStatusHandler_TunnelUpFields(t *testing.T) {
 	st.Set(StateReady)
 
 	rr := httptest.NewRecorder()
-	statusHandler(st, "", "")(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	controlAPI{state: st}.statusHandler()(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
 
 	var snap map[string]any
 	if err := json.NewDecoder(rr.Body).Decode(&snap); err != nil {
@@ -195,3 +198,59 @@ func TestStateTracker_TunnelUpListenerNilSafe(t *testing.T) {
 		t.Errorf("current_exit_ip=%q, want 1.2.3.4 (state still recorded)", got)
 	}
 }
+
+// The endpoint must run the real self-test for the pod's own profile and
+// report it as pinned — the same check the proxy package test gates on.
+func TestFingerprintSelfTestHandler_ReportsPodProfile(t *testing.T) {
+	rr := httptest.NewRecorder()
+	fingerprintSelfTestHandler("tundler-tunnel-expressvpn-0")(rr,
+		httptest.NewRequest(http.MethodGet, "/selftest/fingerprint", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200; body=%s", rr.Code, rr.Body)
+	}
+	var rep proxy.FingerprintReport
+	if err := json.NewDecoder(rr.Body).Decode(&rep); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	hello := proxy.PickProfile("tundler-tunnel-expressvpn-0")
+	if want := hello.Str(); rep.Profile != want {
+		t.Errorf("profile=%q, want %q", rep.Profile, want)
+	}
+	if !rep.OK || rep.JA4 == "" || rep.H2 == "" {
+		t.Errorf("report=%+v, want ok with ja4 and h2 populated", rep)
+	}
+	if len(rep.Divergences) == 0 || !strings.HasPrefix(rep.Divergences[0], "h2: ") {
+		t.Errorf("divergences=%q, want Go's h2 framing reported", rep.Divergences)
+	}
+}
+
+// /status surfaces both proxies' counters under "proxy" so an operator can
+// see the :8486 fetch proxy's traffic and drain state next to :8485's.
+func TestStatusHandler_ProxyStats(t *testing.T) {
+	st := NewStateTracker("mullvad")
+	stats := func() proxyStats {
+		return proxyStats{
+			Connect:     proxy.Stats{TotalConnect: 7, OpenTunnels: 2},
+			Impersonate: proxy.ImpersonateStats{TotalRequests: 5, TotalDraining: 1, OpenRequests: 3},
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	controlAPI{state: st, proxyStats: stats}.statusHandler()(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var resp struct {
+		Proxy *proxyStats `json:"proxy"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Proxy == nil {
+		t.Fatal("proxy section missing from /status")
+	}
+	if got := resp.Proxy.Connect.OpenTunnels; got != 2 {
+		t.Errorf("proxy.connect.open_tunnels=%d, want 2", got)
+	}
+	if got := resp.Proxy.Impersonate; got.TotalRequests != 5 || got.TotalDraining != 1 || got.OpenRequests != 3 {
+		t.Errorf("proxy.impersonate=%+v, want total_requests=5 total_draining=1 open_requests=3", got)
+	}
+}
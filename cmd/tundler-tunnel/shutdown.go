@@ -18,11 +18,19 @@ import (
 // the provider's server-side idle timeout to reclaim the device slot.
 const shutdownDisconnectTimeout = 8 * time.Second
 
+// sigtermDrainTimeout is what the SIGTERM path gives shutdownDrain: the
+// rest of systemd's 10s stop budget after shutdownDisconnectTimeout.
+// The real wait belongs in the preStop hook (POST /drain), which runs
+// before SIGTERM with the pod's full termination grace period; after
+// it, this drain finds nothing open and returns at once.
+const sigtermDrainTimeout = 2 * time.Second
+
 var (
 	shutdownMu       sync.Mutex
 	shutdownProv     provider.VPNProvider
 	shutdownProvName string
 	shutdownDone     bool
+	shutdownSave     func()
 )
 
 // registerShutdownDisconnect records the active provider so that EVERY
@@ -44,6 +52,14 @@ func registerShutdownDisconnect(prov provider.VPNProvider, providerName string)
 	shutdownProvName = providerName
 }
 
+// registerShutdownSave hooks the final state-file write (persist.go)
+// into gracefulDisconnect, which every exit path already runs.
+func registerShutdownSave(save func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownSave = save
+}
+
 // gracefulDisconnect tears down the VPN tunnel so the provider backend
 // frees the device slot. It is:
 //   - best-effort: Disconnect errors are logged, never fatal (we're already
@@ -62,7 +78,12 @@ func gracefulDisconnect() {
 		return
 	}
 	shutdownDone = true
+	save := shutdownSave
 	shutdownMu.Unlock()
+	if save != nil {
+		// After the disconnect, so the file records it.
+		defer save()
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownDisconnectTimeout)
 	defer cancel()
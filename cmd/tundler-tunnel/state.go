@@ -3,20 +3,35 @@ package main
 import (
 	"sync"
 	"time"
+
+	"github.com/laurentpellegrino/tundler/tunnelapi"
 )
 
 // State is the tundler-tunnel pod's lifecycle position. Drives the
-// /readyz HTTP probe and the /status JSON.
-type State string
+// /readyz HTTP probe and the /status JSON. The wire types live in
+// tunnelapi, shared with the typed client.
+type State = tunnelapi.State
 
 const (
-	StateBooting    State = "Booting"    // process started, awaiting boot-login jitter
-	StateLoggingIn  State = "LoggingIn"  // calling provider.Login()
-	StateConnecting State = "Connecting" // calling provider.Connect() + waiting for tunnel up
-	StateReady      State = "Ready"      // tunnel up; serving traffic
-	StateDraining   State = "Draining"   // rotation start: /readyz→503; proxy drain in progress
-	StateRotating   State = "Rotating"   // Disconnect done; reconnecting to new location
-	StateFailed     State = "Failed"     // surrendered; watchdog will retry with backoff
+	StateBooting    = tunnelapi.StateBooting
+	StateLoggingIn  = tunnelapi.StateLoggingIn
+	StateConnecting = tunnelapi.StateConnecting
+	StateReady      = tunnelapi.StateReady
+	StateDraining   = tunnelapi.StateDraining
+	StateRotating   = tunnelapi.StateRotating
+	StateFailed     = tunnelapi.StateFailed
+	StateParked     = tunnelapi.StateParked
+)
+
+type (
+	// Snapshot is the /status body (without the controlAPI extras).
+	Snapshot = tunnelapi.Snapshot
+	// RotationRecord is `last_rotation` in /status.
+	RotationRecord = tunnelapi.RotationRecord
+	// ExitRejection is an entry of RotationRecord.RejectedExits.
+	ExitRejection = tunnelapi.ExitRejection
+	// LocationHealth is an entry of `location_health` in /status.
+	LocationHealth = tunnelapi.LocationHealth
 )
 
 // StateTracker is the source of truth for the /status JSON and the
@@ -56,6 +71,12 @@ type StateTracker struct {
 	// "rotation not yet scheduled", which /status reports as 0.
 	nextRotationAt time.Time
 
+	// tunnelGeneration counts RecordTunnelUp calls: every new tunnel
+	// (initial connect, watchdog reconnect, rotation) gets a fresh
+	// number, even when the provider hands back the same exit IP.
+	// Conditional /rotate compares against it.
+	tunnelGeneration uint64
+
 	// authFailuresTotal counts every Login() failure observed since
 	// process boot. Surfaced on /status so an aggregator (the crawler)
 	// can sum across the fleet and page when a single provider's
@@ -69,38 +90,170 @@ type StateTracker struct {
 	authFailuresTotal     int
 	lastAuthFailureAt     time.Time
 	lastAuthFailureReason string
-}
 
-// RotationRecord is the JSON shape under `last_rotation` in /status.
-// Populated by RecordRotation after a rotation completes (success or
-// surrender).
-type RotationRecord struct {
-	CompletedAt     string `json:"completed_at"`
-	DurationSeconds int    `json:"duration_seconds"`
-	Outcome         string `json:"outcome"` // "success" or "failed"
-	PreviousExitIP  string `json:"previous_exit_ip,omitempty"`
-	NewExitIP       string `json:"new_exit_ip,omitempty"`
+	// events is the pod's typed event stream (GET /events). Owned here
+	// so every state transition publishes without callers threading a
+	// second handle around.
+	events *eventBus
+	// onReady runs (outside the lock) on every move into Ready; see
+	// SetOnReady.
+	onReady func()
+
+	// history is the bounded session log behind GET /history
+	// (history.go).
+	history sessionLog
+
+	// locationStats counts connect outcomes per provider location since
+	// boot, for GET /locations (catalog.go).
+	locationStats map[string]*LocationStats
+
+	// rotationPausedAt is when POST /rotation/pause held scheduled
+	// rotations (zero: not paused); rotationPausedBy is the caller
+	// (maintenance.go).
+	rotationPausedAt time.Time
+	rotationPausedBy string
+
+	// shutdownSince is when the shutdown drain (POST /drain or SIGTERM)
+	// began; zero while the pod is not going away (drain.go).
+	shutdownSince time.Time
+
+	// Probe inputs (health.go): loop heartbeats, the last exit-IP
+	// contract check, and the last state update of any kind, which is
+	// what the control_loop liveness check watches.
+	heartbeats           map[string]heartbeat
+	contractProbeAt      time.Time
+	contractProbeOutcome string
+	lastProgressAt       time.Time
+
+	// processStartedAt is this process's start; podStartedAt the first
+	// start recorded in the state file, so it survives systemd respawns
+	// and container recycles (persist.go). processRestarts counts the
+	// respawns in between; recentExitIPs is the last recentExitIPsKept
+	// distinct exits, newest last.
+	processStartedAt time.Time
+	podStartedAt     time.Time
+	processRestarts  int
+	recentExitIPs    []string
+
+	// strategy picks connect locations (strategy.go); locationLastUsed
+	// is when each location last carried a tunnel, for lru.
+	strategy         locationStrategy
+	locationLastUsed map[string]time.Time
+	// countryOf gives a location's ISO country (location.go).
+	countryOf func(string) string
+
+	// locationHealth scores every location tried, under healthPolicy
+	// (locationhealth.go).
+	locationHealth map[string]*locationHealth
+	healthPolicy   healthPolicy
+
+	// exitRejections collects the exits the rotation in progress turned
+	// down (exitreuse.go), until RecordRotation files them.
+	exitRejections []ExitRejection
+
+	// exitGeo is the geolocation of the current exit (exitgeo.go); stale
+	// once the exit moves on.
+	exitGeo *ExitGeo
+	// exitASN is the ASN of the current exit (exitasn.go); stale once
+	// the exit moves on.
+	exitASN *ExitASN
+	// exitQualification is the performance check of the current exit
+	// (qualify.go), or of the last one to fail it.
+	exitQualification *ExitQualification
 }
 
 // NewStateTracker initializes a tracker in StateBooting, parking the
 // per-pod provider name so the /status JSON can echo it from t=0.
 func NewStateTracker(provider string) *StateTracker {
-	return &StateTracker{state: StateBooting, provider: provider}
+	now := time.Now().UTC()
+	return &StateTracker{state: StateBooting, provider: provider, events: newEventBus(eventBufferSize),
+		processStartedAt: now, podStartedAt: now, healthPolicy: defaultHealthPolicy()}
 }
 
 func (s *StateTracker) Set(state State) {
-	now := time.Now().UTC()
+	s.Transition(state, "")
+}
+
+// Transition is Set with a human-readable reason, carried on the
+// state_changed event. Re-setting the current state publishes nothing.
+func (s *StateTracker) Transition(state State, reason string) {
+	s.mu.Lock()
+	from := s.transitionLocked(state)
+	s.mu.Unlock()
+	s.publishTransition(from, state, reason)
+}
+
+// TransitionIf is Transition guarded by pre, which sees the state and
+// current tunnel under the same lock the move is made in: of several
+// callers racing to start a rotation or a park, exactly one wins. pre
+// returns why the move must not happen, which TransitionIf returns
+// unchanged; it must not call back into the tracker.
+func (s *StateTracker) TransitionIf(state State, reason string, pre func(current State, exitIP string, generation uint64) error) error {
 	s.mu.Lock()
+	if err := pre(s.state, s.currentExitIP, s.tunnelGeneration); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	from := s.transitionLocked(state)
+	s.mu.Unlock()
+	s.publishTransition(from, state, reason)
+	return nil
+}
+
+// transitionLocked moves to state, returning the state it left; s.mu held.
+func (s *StateTracker) transitionLocked(state State) State {
+	now := time.Now().UTC()
+	from := s.state
 	s.state = state
+	s.lastProgressAt = now
 	if state == StateReady {
 		if s.loggedInAt.IsZero() {
 			s.loggedInAt = now
 		}
 		s.lastReadyAt = now
 	}
+	return from
+}
+
+// publishTransition publishes state_changed for a move that changed
+// the state.
+func (s *StateTracker) publishTransition(from, to State, reason string) {
+	if from == to {
+		return
+	}
+	data := map[string]any{"from": from, "to": to}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	s.Publish(eventStateChanged, data)
+	if to == StateReady {
+		s.mu.RLock()
+		onReady := s.onReady
+		s.mu.RUnlock()
+		if onReady != nil {
+			onReady()
+		}
+	}
+}
+
+// SetOnReady installs f to run on every move into Ready, whichever path
+// brought the tunnel up. f must not block.
+func (s *StateTracker) SetOnReady(f func()) {
+	s.mu.Lock()
+	s.onReady = f
 	s.mu.Unlock()
 }
 
+// Publish emits a typed event on the pod's event stream.
+func (s *StateTracker) Publish(eventType string, data map[string]any) {
+	s.events.Publish(eventType, data)
+}
+
+// Events is the stream GET /events serves.
+func (s *StateTracker) Events() *eventBus {
+	return s.events
+}
+
 func (s *StateTracker) Get() State {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -139,6 +292,20 @@ func (s *StateTracker) RecordTunnelUp(location, exitIP string) {
 	s.currentLocation = location
 	s.currentExitIP = exitIP
 	s.tunnelConnectedAt = time.Now().UTC()
+	s.tunnelGeneration++
+	if location != "" {
+		if s.locationLastUsed == nil {
+			s.locationLastUsed = make(map[string]time.Time)
+		}
+		s.locationLastUsed[location] = s.tunnelConnectedAt
+	}
+	if n := len(s.recentExitIPs); exitIP != "" && (n == 0 || s.recentExitIPs[n-1] != exitIP) {
+		s.recentExitIPs = append(s.recentExitIPs, exitIP)
+		if len(s.recentExitIPs) > recentExitIPsKept {
+			s.recentExitIPs = s.recentExitIPs[len(s.recentExitIPs)-recentExitIPsKept:]
+		}
+	}
+	s.openSessionLocked(location, exitIP, s.tunnelConnectedAt)
 	listener := s.tunnelUpListener
 	s.mu.Unlock()
 
@@ -160,6 +327,14 @@ func (s *StateTracker) SetTunnelUpListener(fn TunnelUpListener) {
 	s.mu.Unlock()
 }
 
+// CurrentTunnel returns the exit IP and generation of the current tunnel
+// under one lock, for conditional rotation checks.
+func (s *StateTracker) CurrentTunnel() (exitIP string, generation uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.currentExitIP, s.tunnelGeneration
+}
+
 // SnapshotCurrentExitIP returns the exit IP recorded by the last
 // RecordTunnelUp. Used by the rotator to capture previous_exit_ip
 // before the new Connect overwrites it.
@@ -182,6 +357,27 @@ func (s *StateTracker) RecordRotation(previousExitIP, newExitIP, outcome string,
 		Outcome:         outcome,
 		PreviousExitIP:  previousExitIP,
 		NewExitIP:       newExitIP,
+		RejectedExits:   s.exitRejections,
+	}
+	s.exitRejections = nil
+	if newExitIP != "" {
+		// RecordTunnelUp ran just before a successful rotation is
+		// recorded, so the current location is the one it landed on.
+		s.lastRotation.Location = s.currentLocation
+		s.lastRotation.Country = s.locationCountryLocked(s.currentLocation)
+	}
+	s.mu.Unlock()
+}
+
+// RecordRotationAudit attaches the control-API call that requested the
+// rotation to the record RecordRotation just wrote. Nil is a no-op.
+func (s *StateTracker) RecordRotationAudit(a *AuditEntry) {
+	if a == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.lastRotation != nil {
+		s.lastRotation.RequestedBy = a
 	}
 	s.mu.Unlock()
 }
@@ -205,13 +401,28 @@ func (s *StateTracker) Snapshot() Snapshot {
 		State:                        s.state,
 		Provider:                     s.provider,
 		CurrentLocation:              s.currentLocation,
+		CurrentCountry:               s.locationCountryLocked(s.currentLocation),
 		CurrentExitIP:                s.currentExitIP,
+		TunnelGeneration:             s.tunnelGeneration,
 		RotationCountTotal:           s.rotationCountTotal,
 		LastRotation:                 s.lastRotation,
 		BootLoginJitterActualSeconds: int(s.bootLoginJitterActual.Round(time.Second).Seconds()),
 		AuthFailuresTotal:            s.authFailuresTotal,
 		LastAuthFailureReason:        s.lastAuthFailureReason,
+		ProcessStartedAt:             s.processStartedAt.Format(time.RFC3339),
+		ProcessUptimeSeconds:         int(time.Since(s.processStartedAt).Round(time.Second).Seconds()),
+		PodStartedAt:                 s.podStartedAt.Format(time.RFC3339),
+		PodUptimeSeconds:             int(time.Since(s.podStartedAt).Round(time.Second).Seconds()),
+		ProcessRestarts:              s.processRestarts,
+		LocationStrategy:             strategyRandom,
 	}
+	if s.strategy != nil {
+		snap.LocationStrategy = s.strategy.Name()
+	}
+	snap.LocationHealth = s.locationHealthViewLocked(time.Now().UTC())
+	snap.ExitGeo = s.exitGeoLocked()
+	snap.ExitASN = s.exitASNFor(s.currentExitIP)
+	snap.ExitQualification = s.exitQualificationFor(s.currentExitIP)
 	if !s.loggedInAt.IsZero() {
 		snap.LoggedInAt = s.loggedInAt.Format(time.RFC3339)
 	}
@@ -221,6 +432,15 @@ func (s *StateTracker) Snapshot() Snapshot {
 	if !s.lastAuthFailureAt.IsZero() {
 		snap.LastAuthFailureAt = s.lastAuthFailureAt.Format(time.RFC3339)
 	}
+	if !s.rotationPausedAt.IsZero() {
+		snap.RotationPaused = true
+		snap.RotationPausedAt = s.rotationPausedAt.Format(time.RFC3339)
+		snap.RotationPausedBy = s.rotationPausedBy
+	}
+	if !s.shutdownSince.IsZero() {
+		snap.ShuttingDown = true
+		snap.ShuttingDownSince = s.shutdownSince.Format(time.RFC3339)
+	}
 	if !s.nextRotationAt.IsZero() {
 		if remaining := time.Until(s.nextRotationAt); remaining > 0 {
 			snap.NextRotationInSeconds = int(remaining.Round(time.Second).Seconds())
@@ -244,26 +464,5 @@ func (s *StateTracker) RecordAuthFailure(reason string) {
 	s.lastAuthFailureAt = time.Now().UTC()
 	s.lastAuthFailureReason = reason
 	s.mu.Unlock()
-}
-
-// Snapshot is the JSON shape returned by /status. Field tags +
-// omitempty rules: a slot consumer (crawler / leak detector) reads
-// these to introspect the tunnel's current state.
-type Snapshot struct {
-	State                        State           `json:"state"`
-	Provider                     string          `json:"provider"`
-	CurrentLocation              string          `json:"current_location,omitempty"`
-	CurrentExitIP                string          `json:"current_exit_ip,omitempty"`
-	TunnelAgeSeconds             int             `json:"tunnel_age_seconds"`
-	NextRotationInSeconds        int             `json:"next_rotation_in_seconds"`
-	RotationCountTotal           int             `json:"rotation_count_total"`
-	LoggedInAt                   string          `json:"logged_in_at,omitempty"`
-	BootLoginJitterActualSeconds int             `json:"boot_login_jitter_actual_seconds"`
-	LastRotation                 *RotationRecord `json:"last_rotation,omitempty"`
-	// AuthFailuresTotal is the count of Login() rejections observed
-	// since this process started. Always present (zero-valued at
-	// boot) so an aggregator can poll without conditional branches.
-	AuthFailuresTotal     int    `json:"auth_failures_total"`
-	LastAuthFailureAt     string `json:"last_auth_failure_at,omitempty"`
-	LastAuthFailureReason string `json:"last_auth_failure_reason,omitempty"`
+	s.Publish(eventAuthFailure, map[string]any{"source": "provider_login", "reason": reason})
 }
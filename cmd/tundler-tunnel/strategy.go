@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laurentpellegrino/tundler/internal/geo"
+)
+
+// Location strategies. Every connect (initial, watchdog reconnect,
+// rotation attempt) filters the catalog through the exclusions, then
+// asks the pod's strategy to pick among what is left:
+//
+//	random       uniform (the default, and the historical behaviour)
+//	weighted     random, weighted by location_weights (live config)
+//	lru          the location this pod used longest ago; never-used first
+//	round_robin  the next location after the current one, by name
+//	sticky       the current location while it is allowed, else random
+//	partition    this pod's share of the catalog, by StatefulSet ordinal
+//
+// LOCATION_STRATEGY selects one at boot; /status reports it as
+// location_strategy.
+const (
+	envLocationStrategy   = "LOCATION_STRATEGY"
+	envLocationWeights    = "LOCATION_WEIGHTS"
+	envLocationPartitions = "LOCATION_PARTITIONS"
+
+	strategyRandom     = "random"
+	strategyWeighted   = "weighted"
+	strategyLRU        = "lru"
+	strategyRoundRobin = "round_robin"
+	strategySticky     = "sticky"
+	strategyPartition  = "partition"
+
+	// maxLocationWeight bounds location_weights values.
+	maxLocationWeight = 1000
+)
+
+// locationStrategy picks the next location. allowed is never empty and
+// already excludes everything the caller must not use.
+type locationStrategy interface {
+	Name() string
+	Pick(allowed []string, pc pickContext) string
+}
+
+// pickContext is what a strategy may know about the pod beyond the
+// allowed list.
+type pickContext struct {
+	// catalog is the candidate list before exclusions, so a partition
+	// doesn't shift when a rotation burns a location.
+	catalog []string
+	// current is the location of the live (or last) tunnel.
+	current string
+	// lastUsed is when each location last carried a tunnel.
+	lastUsed map[string]time.Time
+	// country gives a location's ISO country; resolveCountry when nil.
+	country func(string) string
+}
+
+func (pc pickContext) countryOf(loc string) string {
+	if pc.country == nil {
+		return resolveCountry(loc)
+	}
+	return pc.country(loc)
+}
+
+type randomStrategy struct{}
+
+func (randomStrategy) Name() string { return strategyRandom }
+
+func (randomStrategy) Pick(allowed []string, _ pickContext) string {
+	return allowed[rand.IntN(len(allowed))]
+}
+
+// weightedStrategy reads its weights on every pick, so PUT /config
+// reweights the next rotation.
+type weightedStrategy struct {
+	weights func() map[string]int
+}
+
+func (weightedStrategy) Name() string { return strategyWeighted }
+
+func (s weightedStrategy) Pick(allowed []string, pc pickContext) string {
+	weights := s.weights()
+	total := 0
+	per := make([]int, len(allowed))
+	for i, loc := range allowed {
+		per[i] = locationWeight(weights, loc, pc.countryOf(loc))
+		total += per[i]
+	}
+	if total == 0 {
+		return allowed[rand.IntN(len(allowed))]
+	}
+	n := rand.IntN(total)
+	for i, w := range per {
+		if n < w {
+			return allowed[i]
+		}
+		n -= w
+	}
+	return allowed[len(allowed)-1]
+}
+
+// locationWeight is the weight of an exact key, else of the longest key
+// naming loc's country — by name, alias or ISO code matching country, or
+// by leading words (see matchesCountry) — else 1. Weight 0 keeps a
+// location out unless every allowed location weighs 0.
+func locationWeight(weights map[string]int, loc, country string) int {
+	if w, ok := weights[loc]; ok {
+		return w
+	}
+	best, w := -1, 1
+	for k, v := range weights {
+		if len(k) > best && (matchesCountry(loc, k) || country != "" && namesCountry(k, country)) {
+			best, w = len(k), v
+		}
+	}
+	return w
+}
+
+// namesCountry reports whether key names ISO country code.
+func namesCountry(key, code string) bool {
+	c, ok := geo.Named(key)
+	return ok && c.Code == code
+}
+
+type lruStrategy struct{}
+
+func (lruStrategy) Name() string { return strategyLRU }
+
+func (lruStrategy) Pick(allowed []string, pc pickContext) string {
+	var oldest []string
+	var at time.Time
+	for _, loc := range allowed {
+		t := pc.lastUsed[loc] // zero: never used
+		switch {
+		case len(oldest) == 0 || t.Before(at):
+			oldest, at = []string{loc}, t
+		case t.Equal(at):
+			oldest = append(oldest, loc)
+		}
+	}
+	return oldest[rand.IntN(len(oldest))]
+}
+
+// roundRobinStrategy walks the allowed list in name order, using the
+// current location as the cursor: the order survives catalog changes
+// and respawns without state of its own.
+type roundRobinStrategy struct{}
+
+func (roundRobinStrategy) Name() string { return strategyRoundRobin }
+
+func (roundRobinStrategy) Pick(allowed []string, pc pickContext) string {
+	sorted := slices.Sorted(slices.Values(allowed))
+	for _, loc := range sorted {
+		if loc > pc.current {
+			return loc
+		}
+	}
+	return sorted[0]
+}
+
+// stickyStrategy keeps the pod's geography across rotations and
+// reconnects: a new server, same location — or, when that location is
+// no longer allowed, the same country.
+type stickyStrategy struct{}
+
+func (stickyStrategy) Name() string { return strategySticky }
+
+func (stickyStrategy) Pick(allowed []string, pc pickContext) string {
+	if pc.current == "" {
+		return allowed[rand.IntN(len(allowed))]
+	}
+	if slices.Contains(allowed, pc.current) {
+		return pc.current
+	}
+	if code := pc.countryOf(pc.current); code != "" {
+		var same []string
+		for _, loc := range allowed {
+			if pc.countryOf(loc) == code {
+				same = append(same, loc)
+			}
+		}
+		if len(same) > 0 {
+			return same[rand.IntN(len(same))]
+		}
+	}
+	return allowed[rand.IntN(len(allowed))]
+}
+
+// partitionStrategy gives pod ordinal i of n the catalog's countries
+// i, i+n, i+2n, ... in order (ISO code, or countryKey for a location of
+// unknown country), so a StatefulSet's pods spread over different
+// countries without coordinating. With fewer countries than
+// pods it partitions locations instead; a pod whose share is entirely
+// excluded falls back to any allowed location.
+type partitionStrategy struct {
+	ordinal, partitions int
+}
+
+func (partitionStrategy) Name() string { return strategyPartition }
+
+func (s partitionStrategy) Pick(allowed []string, pc pickContext) string {
+	catalog := pc.catalog
+	if len(catalog) == 0 {
+		catalog = allowed
+	}
+	mine := s.share(catalog, func(loc string) string {
+		if code := pc.countryOf(loc); code != "" {
+			return code
+		}
+		return countryKey(loc)
+	})
+	if len(mine) == 0 {
+		mine = s.share(catalog, func(loc string) string { return loc })
+	}
+	var out []string
+	for _, loc := range allowed {
+		if mine[loc] {
+			out = append(out, loc)
+		}
+	}
+	if len(out) == 0 {
+		out = allowed
+	}
+	return out[rand.IntN(len(out))]
+}
+
+// share returns the locations whose key falls in this pod's partition.
+func (s partitionStrategy) share(catalog []string, key func(string) string) map[string]bool {
+	var keys []string
+	for _, loc := range catalog {
+		keys = append(keys, key(loc))
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	mine := make(map[string]bool)
+	for _, loc := range catalog {
+		if i, _ := slices.BinarySearch(keys, key(loc)); i%s.partitions == s.ordinal {
+			mine[loc] = true
+		}
+	}
+	return mine
+}
+
+// countryKey is the country part of a location name: what precedes
+// " - ", ",", " (" or "/" ("Germany - Frankfurt" → "Germany"). A slug or
+// a bare city is its own country.
+func countryKey(loc string) string {
+	cut := len(loc)
+	for _, sep := range []string{" - ", ",", " (", "/"} {
+		if i := strings.Index(loc, sep); i > 0 && i < cut {
+			cut = i
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(loc[:cut]))
+}
+
+var podOrdinalRE = regexp.MustCompile(`-(\d+)$`)
+
+// podOrdinal is the StatefulSet ordinal at the end of a pod name.
+func podOrdinal(pod string) (int, bool) {
+	m := podOrdinalRE.FindStringSubmatch(pod)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+// newLocationStrategy builds the named strategy. weights feeds weighted;
+// pod and partitions feed partition, which needs both.
+func newLocationStrategy(name string, weights func() map[string]int, pod string, partitions int) (locationStrategy, error) {
+	switch name {
+	case "", strategyRandom:
+		return randomStrategy{}, nil
+	case strategyWeighted:
+		return weightedStrategy{weights: weights}, nil
+	case strategyLRU:
+		return lruStrategy{}, nil
+	case strategyRoundRobin:
+		return roundRobinStrategy{}, nil
+	case strategySticky:
+		return stickyStrategy{}, nil
+	case strategyPartition:
+		if partitions < 1 {
+			return nil, fmt.Errorf("%s needs %s (the StatefulSet's replica count)", strategyPartition, envLocationPartitions)
+		}
+		ordinal, ok := podOrdinal(pod)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a pod name ending in its ordinal, got %q", strategyPartition, pod)
+		}
+		return partitionStrategy{ordinal: ordinal % partitions, partitions: partitions}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q (want %s, %s, %s, %s, %s or %s)", name,
+		strategyRandom, strategyWeighted, strategyLRU, strategyRoundRobin, strategySticky, strategyPartition)
+}
+
+// locationStrategyFromEnv builds LOCATION_STRATEGY for pod.
+func locationStrategyFromEnv(weights func() map[string]int, pod string) (locationStrategy, error) {
+	return newLocationStrategy(strings.TrimSpace(os.Getenv(envLocationStrategy)), weights, pod,
+		getEnvInt(envLocationPartitions, 0))
+}
+
+// parseLocationWeights parses LOCATION_WEIGHTS ("USA=3,Germany=2").
+func parseLocationWeights(csv string) (map[string]int, error) {
+	if strings.TrimSpace(csv) == "" {
+		return nil, nil
+	}
+	out := make(map[string]int)
+	for _, part := range strings.Split(csv, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%q is not location=weight", part)
+		}
+		out[strings.TrimSpace(k)] = n
+	}
+	return out, nil
+}
+
+// SetLocationStrategy installs the pod's strategy (random until then).
+func (s *StateTracker) SetLocationStrategy(ls locationStrategy) {
+	s.mu.Lock()
+	s.strategy = ls
+	s.mu.Unlock()
+}
+
+// PickLocation is pickLocation through the pod's strategy, skipping
+// quarantined locations while others remain (locationhealth.go).
+func (s *StateTracker) PickLocation(locations []string, filter *locationFilter) (string, error) {
+	allowed := s.AllowedLocations(locations, filter)
+	if len(allowed) == 0 {
+		return "", errNoAllowedLocations
+	}
+	allowed = s.withoutQuarantined(allowed)
+	s.mu.RLock()
+	ls := s.strategy
+	pc := pickContext{catalog: locations, current: s.currentLocation, lastUsed: maps.Clone(s.locationLastUsed), country: s.locationCountry}
+	s.mu.RUnlock()
+	if ls == nil {
+		ls = randomStrategy{}
+	}
+	return ls.Pick(allowed, pc), nil
+}
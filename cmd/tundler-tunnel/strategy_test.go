@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLocationStrategy(t *testing.T) {
+	for _, name := range []string{"", strategyRandom, strategyWeighted, strategyLRU, strategyRoundRobin, strategySticky} {
+		if _, err := newLocationStrategy(name, nil, "tundler-tunnel-local", 0); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, tc := range []struct {
+		name, pod  string
+		partitions int
+	}{
+		{"fastest", "tundler-tunnel-0", 0},
+		{strategyPartition, "tundler-tunnel-0", 0},
+		{strategyPartition, "tundler-tunnel-local", 3},
+	} {
+		if _, err := newLocationStrategy(tc.name, nil, tc.pod, tc.partitions); err == nil {
+			t.Errorf("%+v accepted", tc)
+		}
+	}
+	ls, err := newLocationStrategy(strategyPartition, nil, "tundler-tunnel-7", 3)
+	if err != nil || ls.(partitionStrategy).ordinal != 1 {
+		t.Errorf("partition of pod 7 over 3: %+v %v, want ordinal 1", ls, err)
+	}
+}
+
+func TestWeightedStrategy(t *testing.T) {
+	weights := map[string]int{"Germany": 0, "Germany - Berlin": 5, "UK": 0}
+	ls := weightedStrategy{weights: func() map[string]int { return weights }}
+	allowed := []string{"Germany - Frankfurt", "Germany - Berlin", "USA"}
+	counts := map[string]int{}
+	for i := 0; i < 600; i++ {
+		counts[ls.Pick(allowed, pickContext{})]++
+	}
+	// Berlin's exact weight beats Germany's; USA is unlisted (1).
+	if counts["Germany - Frankfurt"] != 0 || counts["Germany - Berlin"] < 400 || counts["USA"] == 0 {
+		t.Errorf("counts=%v, want Frankfurt never and Berlin ~5x USA", counts)
+	}
+	if got := ls.Pick([]string{"UK"}, pickContext{}); got != "UK" {
+		t.Errorf("all-zero pick=%q, want the only location", got)
+	}
+}
+
+func TestLRUStrategy(t *testing.T) {
+	now := time.Now()
+	pc := pickContext{lastUsed: map[string]time.Time{"USA": now, "UK": now.Add(-time.Hour)}}
+	if got := (lruStrategy{}).Pick([]string{"USA", "UK"}, pc); got != "UK" {
+		t.Errorf("pick=%q, want UK (used longest ago)", got)
+	}
+	if got := (lruStrategy{}).Pick([]string{"USA", "UK", "Japan"}, pc); got != "Japan" {
+		t.Errorf("pick=%q, want Japan (never used)", got)
+	}
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	allowed := []string{"USA", "Germany", "UK"}
+	var got []string
+	current := ""
+	for range 4 {
+		current = (roundRobinStrategy{}).Pick(allowed, pickContext{current: current})
+		got = append(got, current)
+	}
+	if want := "Germany UK USA Germany"; strings.Join(got, " ") != want {
+		t.Errorf("order=%q, want %q", strings.Join(got, " "), want)
+	}
+	// A current location no longer allowed still advances by name.
+	if got := (roundRobinStrategy{}).Pick(allowed, pickContext{current: "Japan"}); got != "UK" {
+		t.Errorf("after Japan: %q, want UK", got)
+	}
+}
+
+func TestStickyStrategy(t *testing.T) {
+	if got := (stickyStrategy{}).Pick([]string{"USA", "UK"}, pickContext{current: "UK"}); got != "UK" {
+		t.Errorf("pick=%q, want the current UK", got)
+	}
+	if got := (stickyStrategy{}).Pick([]string{"USA"}, pickContext{current: "UK"}); got != "USA" {
+		t.Errorf("pick=%q, want USA once UK is excluded", got)
+	}
+}
+
+// Three pods over four countries take disjoint shares, whatever the
+// exclusions of the moment.
+func TestPartitionStrategy(t *testing.T) {
+	catalog := []string{"Germany - Berlin", "Germany - Frankfurt", "France", "Japan", "USA - Chicago", "USA - New York"}
+	owner := map[string]int{}
+	for ordinal := range 3 {
+		ls := partitionStrategy{ordinal: ordinal, partitions: 3}
+		for range 50 {
+			loc := ls.Pick(catalog, pickContext{catalog: catalog})
+			if prev, ok := owner[countryKey(loc)]; ok && prev != ordinal {
+				t.Fatalf("%s picked by pods %d and %d", loc, prev, ordinal)
+			}
+			owner[countryKey(loc)] = ordinal
+		}
+	}
+	if len(owner) != 4 {
+		t.Errorf("countries used=%v, want all 4", owner)
+	}
+
+	// Countries go by ISO code (DE FR JP US): pod 0 owns Germany and
+	// USA; burning Germany leaves it USA.
+	ls := partitionStrategy{ordinal: 0, partitions: 3}
+	for range 20 {
+		if got := ls.Pick(allowedLocations(catalog, newLocationFilter(nil, []string{"Germany"})), pickContext{catalog: catalog}); countryKey(got) != "usa" {
+			t.Fatalf("pick=%q, want a USA location", got)
+		}
+	}
+	// Its whole share excluded: any allowed location.
+	if got := ls.Pick([]string{"Japan"}, pickContext{catalog: catalog}); got != "Japan" {
+		t.Errorf("pick=%q, want the fallback", got)
+	}
+	// More pods than countries: locations are partitioned instead.
+	ls = partitionStrategy{ordinal: 4, partitions: 5}
+	if got := ls.Pick(catalog, pickContext{catalog: catalog}); got != "USA - Chicago" {
+		t.Errorf("pick=%q, want the 5th location", got)
+	}
+}
+
+func TestCountryKey(t *testing.T) {
+	for loc, want := range map[string]string{
+		"Germany - Frankfurt":     "germany",
+		"USA (New York)":          "usa",
+		"Hong Kong, Central":      "hong kong",
+		"Bosnia-Herzegovina":      "bosnia-herzegovina",
+		"de-frankfurt":            "de-frankfurt",
+		"UK/London":               "uk",
+		"United States - Seattle": "united states",
+	} {
+		if got := countryKey(loc); got != want {
+			t.Errorf("countryKey(%q)=%q, want %q", loc, got, want)
+		}
+	}
+}
+
+func TestParseLocationWeights(t *testing.T) {
+	w, err := parseLocationWeights(" USA=3, Germany - Berlin = 2,,")
+	if err != nil || len(w) != 2 || w["USA"] != 3 || w["Germany - Berlin"] != 2 {
+		t.Errorf("got %v %v", w, err)
+	}
+	for _, bad := range []string{"USA", "USA=x"} {
+		if _, err := parseLocationWeights(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+	c := defaultRuntimeConfig()
+	c.LocationWeights = map[string]int{"USA": maxLocationWeight + 1}
+	if err := c.validate(); err == nil {
+		t.Error("out-of-range weight accepted")
+	}
+}
+
+// The tracker feeds lru from its tunnels, and /status names the strategy.
+func TestStateTracker_PickLocation(t *testing.T) {
+	st := NewStateTracker("fake")
+	if got := st.Snapshot().LocationStrategy; got != strategyRandom {
+		t.Errorf("default strategy=%q", got)
+	}
+	st.SetLocationStrategy(lruStrategy{})
+	st.RecordTunnelUp("UK", "1.1.1.1")
+	st.RecordTunnelUp("USA", "2.2.2.2")
+	if got, err := st.PickLocation([]string{"USA", "UK"}, nil); err != nil || got != "UK" {
+		t.Errorf("pick=%q %v, want UK", got, err)
+	}
+	if _, err := st.PickLocation([]string{"USA"}, newLocationFilter(nil, []string{"USA"})); err != errNoAllowedLocations {
+		t.Errorf("err=%v, want errNoAllowedLocations", err)
+	}
+	if got := st.Snapshot().LocationStrategy; got != strategyLRU {
+		t.Errorf("strategy=%q, want lru", got)
+	}
+
+	// A rotation under sticky keeps the location.
+	st.SetLocationStrategy(stickyStrategy{})
+	sp := newScriptedProvider([]string{"UK", "USA", "Japan"}, []bool{true}, []string{"3.3.3.3"})
+	if err := connectWithRetry(context.Background(), sp, st, "scripted", nil, RotateRequest{}, 1, (&noSleep{}).sleep, ""); err != nil {
+		t.Fatal(err)
+	}
+	if loc := st.Snapshot().CurrentLocation; loc != "USA" {
+		t.Errorf("location=%q, want USA kept", loc)
+	}
+}
@@ -4,7 +4,9 @@ go 1.25.12
 
 require (
 	github.com/ProtonMail/go-srp v0.0.7
+	github.com/quic-go/quic-go v0.61.0
 	github.com/refraction-networking/utls v1.8.2
+	golang.org/x/crypto v0.54.0
 	golang.org/x/net v0.57.0
 )
 
@@ -16,7 +18,7 @@ require (
 	github.com/cronokirby/saferith v0.33.0 // indirect
 	github.com/klauspost/compress v1.19.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/crypto v0.54.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	golang.org/x/sys v0.47.0 // indirect
 	golang.org/x/text v0.40.0 // indirect
 )
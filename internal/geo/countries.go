@@ -0,0 +1,249 @@
+package geo
+
+// countryTable is ISO-3166 alpha-2 code | English name | subregion |
+// aliases (";"-separated), one country or territory per line. It covers
+// what VPN catalogs list, not every ISO entry. Subregions follow the UN
+// geoscheme loosely, bent where VPN catalogs disagree with it (Cyprus
+// and the Caucasus sit with Europe, the Gulf and Levant form mde):
+//
+//	weu neu seu eeu  Western, Northern, Southern, Eastern Europe
+//	cau              Caucasus
+//	cas eas sea sas  Central, East, South-East, South Asia
+//	mde              Middle East
+//	oce              Oceania
+//	nam cam car sam  Northern, Central America, Caribbean, South America
+//	naf waf maf eaf saf  Northern, Western, Middle, Eastern, Southern Africa
+const countryTable = `
+AD|Andorra|seu|
+AE|United Arab Emirates|mde|uae;emirates
+AF|Afghanistan|sas|
+AG|Antigua and Barbuda|car|antigua
+AL|Albania|seu|
+AM|Armenia|cau|
+AO|Angola|maf|
+AR|Argentina|sam|
+AT|Austria|weu|
+AU|Australia|oce|
+AW|Aruba|car|
+AX|Aland Islands|neu|åland islands;aland
+AZ|Azerbaijan|cau|
+BA|Bosnia and Herzegovina|seu|bosnia;bosnia herzegovina
+BB|Barbados|car|
+BD|Bangladesh|sas|
+BE|Belgium|weu|
+BF|Burkina Faso|waf|
+BG|Bulgaria|eeu|
+BH|Bahrain|mde|
+BI|Burundi|eaf|
+BJ|Benin|waf|
+BM|Bermuda|nam|
+BN|Brunei|sea|brunei darussalam
+BO|Bolivia|sam|
+BR|Brazil|sam|brasil
+BS|Bahamas|car|the bahamas
+BT|Bhutan|sas|
+BW|Botswana|saf|
+BY|Belarus|eeu|
+BZ|Belize|cam|
+CA|Canada|nam|
+CD|DR Congo|maf|democratic republic of the congo;congo kinshasa
+CF|Central African Republic|maf|
+CG|Congo|maf|republic of the congo;congo brazzaville
+CH|Switzerland|weu|
+CI|Ivory Coast|waf|cote d'ivoire;côte d'ivoire;cote divoire
+CL|Chile|sam|
+CM|Cameroon|maf|
+CN|China|eas|
+CO|Colombia|sam|
+CR|Costa Rica|cam|
+CU|Cuba|car|
+CV|Cape Verde|waf|cabo verde
+CW|Curacao|car|curaçao
+CY|Cyprus|seu|
+CZ|Czech Republic|eeu|czechia
+DE|Germany|weu|deutschland
+DJ|Djibouti|eaf|
+DK|Denmark|neu|
+DM|Dominica|car|
+DO|Dominican Republic|car|
+DZ|Algeria|naf|
+EC|Ecuador|sam|
+EE|Estonia|neu|
+EG|Egypt|naf|
+EH|Western Sahara|naf|
+ER|Eritrea|eaf|
+ES|Spain|seu|españa
+ET|Ethiopia|eaf|
+FI|Finland|neu|
+FJ|Fiji|oce|
+FO|Faroe Islands|neu|faroes
+FR|France|weu|
+GA|Gabon|maf|
+GB|United Kingdom|neu|uk;great britain;britain;england;scotland;wales;northern ireland
+GD|Grenada|car|
+GE|Georgia|cau|
+GF|French Guiana|sam|
+GG|Guernsey|neu|
+GH|Ghana|waf|
+GI|Gibraltar|seu|
+GL|Greenland|nam|
+GM|Gambia|waf|the gambia
+GN|Guinea|waf|
+GQ|Equatorial Guinea|maf|
+GR|Greece|seu|
+GT|Guatemala|cam|
+GU|Guam|oce|
+GW|Guinea-Bissau|waf|
+GY|Guyana|sam|
+HK|Hong Kong|eas|
+HN|Honduras|cam|
+HR|Croatia|seu|
+HT|Haiti|car|
+HU|Hungary|eeu|
+ID|Indonesia|sea|
+IE|Ireland|neu|
+IL|Israel|mde|
+IM|Isle of Man|neu|
+IN|India|sas|
+IQ|Iraq|mde|
+IR|Iran|mde|
+IS|Iceland|neu|
+IT|Italy|seu|italia
+JE|Jersey|neu|
+JM|Jamaica|car|
+JO|Jordan|mde|
+JP|Japan|eas|
+KE|Kenya|eaf|
+KG|Kyrgyzstan|cas|
+KH|Cambodia|sea|
+KM|Comoros|eaf|
+KN|Saint Kitts and Nevis|car|
+KP|North Korea|eas|
+KR|South Korea|eas|korea;republic of korea
+KW|Kuwait|mde|
+KY|Cayman Islands|car|
+KZ|Kazakhstan|cas|
+LA|Laos|sea|
+LB|Lebanon|mde|
+LC|Saint Lucia|car|
+LI|Liechtenstein|weu|
+LK|Sri Lanka|sas|
+LR|Liberia|waf|
+LS|Lesotho|saf|
+LT|Lithuania|neu|
+LU|Luxembourg|weu|
+LV|Latvia|neu|
+LY|Libya|naf|
+MA|Morocco|naf|
+MC|Monaco|weu|
+MD|Moldova|eeu|
+ME|Montenegro|seu|
+MG|Madagascar|eaf|
+MK|North Macedonia|seu|macedonia
+ML|Mali|waf|
+MM|Myanmar|sea|burma
+MN|Mongolia|eas|
+MO|Macau|eas|macao
+MR|Mauritania|waf|
+MT|Malta|seu|
+MU|Mauritius|eaf|
+MV|Maldives|sas|
+MW|Malawi|eaf|
+MX|Mexico|cam|
+MY|Malaysia|sea|
+MZ|Mozambique|eaf|
+NA|Namibia|saf|
+NC|New Caledonia|oce|
+NE|Niger|waf|
+NG|Nigeria|waf|
+NI|Nicaragua|cam|
+NL|Netherlands|weu|the netherlands;holland
+NO|Norway|neu|
+NP|Nepal|sas|
+NZ|New Zealand|oce|
+OM|Oman|mde|
+PA|Panama|cam|
+PE|Peru|sam|
+PF|French Polynesia|oce|
+PG|Papua New Guinea|oce|
+PH|Philippines|sea|
+PK|Pakistan|sas|
+PL|Poland|eeu|
+PR|Puerto Rico|car|
+PS|Palestine|mde|
+PT|Portugal|seu|
+PY|Paraguay|sam|
+QA|Qatar|mde|
+RE|Reunion|eaf|réunion
+RO|Romania|eeu|
+RS|Serbia|seu|
+RU|Russia|eeu|russian federation
+RW|Rwanda|eaf|
+SA|Saudi Arabia|mde|
+SB|Solomon Islands|oce|
+SC|Seychelles|eaf|
+SD|Sudan|naf|
+SE|Sweden|neu|
+SG|Singapore|sea|
+SI|Slovenia|seu|
+SK|Slovakia|eeu|
+SL|Sierra Leone|waf|
+SM|San Marino|seu|
+SN|Senegal|waf|
+SO|Somalia|eaf|
+SR|Suriname|sam|
+SS|South Sudan|eaf|
+ST|Sao Tome and Principe|maf|são tomé and príncipe
+SV|El Salvador|cam|
+SY|Syria|mde|
+SZ|Eswatini|saf|swaziland
+TD|Chad|maf|
+TG|Togo|waf|
+TH|Thailand|sea|
+TJ|Tajikistan|cas|
+TL|Timor-Leste|sea|east timor
+TM|Turkmenistan|cas|
+TN|Tunisia|naf|
+TO|Tonga|oce|
+TR|Turkey|mde|turkiye;türkiye
+TT|Trinidad and Tobago|car|trinidad
+TW|Taiwan|eas|
+TZ|Tanzania|eaf|
+UA|Ukraine|eeu|
+UG|Uganda|eaf|
+US|United States|nam|usa;united states of america;america
+UY|Uruguay|sam|
+UZ|Uzbekistan|cas|
+VA|Vatican City|seu|vatican;holy see
+VC|Saint Vincent and the Grenadines|car|
+VE|Venezuela|sam|
+VG|British Virgin Islands|car|
+VI|US Virgin Islands|car|
+VN|Vietnam|sea|viet nam
+VU|Vanuatu|oce|
+WS|Samoa|oce|
+XK|Kosovo|seu|
+YE|Yemen|mde|
+YT|Mayotte|eaf|
+ZA|South Africa|saf|
+ZM|Zambia|eaf|
+ZW|Zimbabwe|eaf|
+`
+
+// groupTable maps each region group to subregions (lower case) and
+// country codes (upper case).
+var groupTable = map[string][]string{
+	"europe": {"weu", "neu", "seu", "eeu", "cau"},
+	"eu": {"AT", "BE", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "GR", "HU", "IE",
+		"IT", "LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE"},
+	"eea":           {"eu", "IS", "LI", "NO"},
+	"asia":          {"cas", "eas", "sea", "sas", "mde", "cau"},
+	"apac":          {"eas", "sea", "sas", "oce"},
+	"middle_east":   {"mde", "EG"},
+	"oceania":       {"oce"},
+	"africa":        {"naf", "waf", "maf", "eaf", "saf"},
+	"north_america": {"nam", "cam", "car"},
+	"south_america": {"sam"},
+	"latam":         {"cam", "sam", "CU", "DO", "PR"},
+	"americas":      {"nam", "cam", "car", "sam"},
+}
@@ -0,0 +1,161 @@
+// Package geo maps VPN provider location names onto ISO-3166 countries
+// and region groups, so fleet-wide location policy ("EU only", "no
+// apac") can be written once and hold for every provider.
+//
+// Providers name their locations in their own way:
+//
+//	Germany, United States       full English names (most providers)
+//	United_States                underscores (nordvpn)
+//	DE, de                       ISO alpha-2 codes (ovpn, purevpn, tunnelbear)
+//	de-frankfurt, uk-london      code or name slugs with a city (pia, expressvpn)
+//	usa-new-york, france-paris-2
+//
+// Resolve recognises all of those. Names that carry no country ("auto",
+// a bare city) don't resolve; callers treat them as unknown rather than
+// guessing.
+//
+// Region groups are lower-case words that never collide with an ISO
+// code: eu, eea, europe, asia, apac, middle_east, oceania, africa,
+// north_america, south_america, latam, americas.
+package geo
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Country is one ISO-3166 country or territory.
+type Country struct {
+	Code      string // ISO-3166 alpha-2, upper case
+	Name      string // English short name
+	Subregion string // see countryTable
+}
+
+var (
+	byCode map[string]Country
+	byName map[string]string // normalized name or alias → code
+	groups map[string][]string
+	// maxNameWords bounds Resolve's word-prefix search.
+	maxNameWords int
+)
+
+func init() {
+	byCode = make(map[string]Country)
+	byName = make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(countryTable), "\n") {
+		f := strings.Split(line, "|")
+		c := Country{Code: f[0], Name: f[1], Subregion: f[2]}
+		byCode[c.Code] = c
+		names := []string{c.Name}
+		if f[3] != "" {
+			names = append(names, strings.Split(f[3], ";")...)
+		}
+		for _, n := range names {
+			key := normalize(n)
+			byName[key] = c.Code
+			maxNameWords = max(maxNameWords, len(strings.Fields(key)))
+		}
+	}
+	groups = make(map[string][]string, len(groupTable))
+	for g := range groupTable {
+		groups[g] = expandGroup(g)
+	}
+}
+
+// expandGroup resolves a groupTable entry to sorted country codes.
+// Members are subregions, country codes or other groups.
+func expandGroup(g string) []string {
+	var out []string
+	for _, m := range groupTable[g] {
+		switch {
+		case groupTable[m] != nil:
+			out = append(out, expandGroup(m)...)
+		case m == strings.ToUpper(m):
+			out = append(out, m)
+		default:
+			for code, c := range byCode {
+				if c.Subregion == m {
+					out = append(out, code)
+				}
+			}
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// normalize lower-cases s and turns every run of separators into one
+// space: "United_States" and "united-states" both become "united states".
+func normalize(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}), " ")
+}
+
+// Lookup returns the country with ISO code code, in any case.
+func Lookup(code string) (Country, bool) {
+	c, ok := byCode[strings.ToUpper(strings.TrimSpace(code))]
+	return c, ok
+}
+
+// Named returns the country s names in full: an English name, a common
+// alias ("UK", "USA", "Holland") or an ISO code. Unlike Resolve it
+// doesn't look inside longer location names.
+func Named(s string) (Country, bool) {
+	key := normalize(s)
+	if code, ok := byName[key]; ok {
+		return byCode[code], true
+	}
+	if len(key) == 2 {
+		return Lookup(key)
+	}
+	return Country{}, false
+}
+
+// Resolve returns the country a provider location name is in. It tries,
+// in order: the whole name (see Named); the longest run of leading words
+// that names a country ("France Paris 2", "usa-new-york"); and a leading
+// ISO code set off by '-' or '_', or upper-cased ("de-frankfurt",
+// "US East").
+func Resolve(location string) (Country, bool) {
+	if c, ok := Named(location); ok {
+		return c, true
+	}
+	words := strings.Fields(normalize(location))
+	for n := min(len(words)-1, maxNameWords); n >= 1; n-- {
+		if code, ok := byName[strings.Join(words[:n], " ")]; ok {
+			return byCode[code], true
+		}
+	}
+	s := strings.TrimSpace(location)
+	if len(s) > 3 && isLetter(s[0]) && isLetter(s[1]) && !isLetter(s[2]) &&
+		(s[2] == '-' || s[2] == '_' || strings.ToUpper(s[:2]) == s[:2]) {
+		return Lookup(s[:2])
+	}
+	return Country{}, false
+}
+
+func isLetter(b byte) bool { return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' }
+
+// Group returns the sorted ISO codes of region group name, in any case.
+func Group(name string) ([]string, bool) {
+	codes, ok := groups[strings.ToLower(strings.TrimSpace(name))]
+	return slices.Clone(codes), ok
+}
+
+// InGroup reports whether ISO code code belongs to region group name.
+func InGroup(name, code string) bool {
+	_, found := slices.BinarySearch(groups[strings.ToLower(name)], strings.ToUpper(code))
+	return found
+}
+
+// Groups returns every region group name, sorted.
+func Groups() []string {
+	out := make([]string, 0, len(groups))
+	for g := range groups {
+		out = append(out, g)
+	}
+	slices.Sort(out)
+	return out
+}
@@ -0,0 +1,96 @@
+package geo
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	for loc, want := range map[string]string{
+		"Germany":                 "DE",
+		"United_States":           "US",
+		"United States":           "US",
+		"DE":                      "DE",
+		"de":                      "DE",
+		"uk":                      "GB",
+		"UK":                      "GB",
+		"uk-london":               "GB",
+		"usa-new-york":            "US",
+		"france-paris-2":          "FR",
+		"de-frankfurt":            "DE",
+		"us_chicago":              "US",
+		"US East":                 "US",
+		"South Korea":             "KR",
+		"Korea":                   "KR",
+		"Guinea-Bissau":           "GW",
+		"Guinea":                  "GN",
+		"South Africa - Jhb":      "ZA",
+		"Czechia":                 "CZ",
+		"Côte d'Ivoire":           "CI",
+		"Netherlands (Amsterdam)": "NL",
+	} {
+		c, ok := Resolve(loc)
+		if !ok || c.Code != want {
+			t.Errorf("Resolve(%q) = %q %v, want %s", loc, c.Code, ok, want)
+		}
+	}
+	for _, loc := range []string{"auto", "", "Frankfurt", "in mumbai", "Some-where"} {
+		if c, ok := Resolve(loc); ok {
+			t.Errorf("Resolve(%q) = %s, want no country", loc, c.Code)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	if c, ok := Named("holland"); !ok || c.Code != "NL" {
+		t.Errorf("Named(holland) = %+v %v", c, ok)
+	}
+	if _, ok := Named("Germany Berlin"); ok {
+		t.Error("Named matched inside a longer name")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	eu, ok := Group("EU")
+	if !ok || len(eu) != 27 || !slices.Contains(eu, "DE") || slices.Contains(eu, "GB") || slices.Contains(eu, "CH") {
+		t.Errorf("eu = %v", eu)
+	}
+	eea, _ := Group("eea")
+	if len(eea) != 30 || !slices.Contains(eea, "NO") {
+		t.Errorf("eea = %v", eea)
+	}
+	for group, code := range map[string]string{
+		"apac":          "JP",
+		"europe":        "GB",
+		"asia":          "AE",
+		"middle_east":   "EG",
+		"north_america": "MX",
+		"latam":         "BR",
+	} {
+		if !InGroup(group, code) {
+			t.Errorf("%s not in %s", code, group)
+		}
+	}
+	if InGroup("apac", "DE") || InGroup("nope", "DE") {
+		t.Error("DE in apac")
+	}
+	// A group name must never read as a country.
+	for _, g := range Groups() {
+		if _, ok := Named(g); ok {
+			t.Errorf("group %q is also a country", g)
+		}
+	}
+}
+
+// Every subregion in the table belongs to at least one continent group.
+func TestGroups_CoverEveryCountry(t *testing.T) {
+	for code := range byCode {
+		found := false
+		for _, g := range []string{"europe", "asia", "oceania", "africa", "americas"} {
+			found = found || InGroup(g, code)
+		}
+		if !found {
+			t.Errorf("%s is in no continent group", code)
+		}
+	}
+}
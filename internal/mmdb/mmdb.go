@@ -0,0 +1,408 @@
+// Package mmdb reads MaxMind DB files (.mmdb): GeoLite2/GeoIP2 Country,
+// City and ASN, DB-IP, IPinfo and the other databases published in that
+// format. It implements the parts of the format specification
+// (https://maxmind.github.io/MaxMind-DB/) a lookup needs — metadata, the
+// binary search tree with 24-, 28- and 32-bit records, and the data
+// section — and nothing else: no writer, no memory mapping, no decoding
+// into structs. Records decode to plain Go values (see Reader.Lookup).
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// metadataStart marks the metadata section at the end of the file.
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the run of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparator = 16
+
+// maxDepth bounds nested maps and arrays, so a corrupt file can't
+// recurse without end.
+const maxDepth = 32
+
+// ErrInvalid marks a file that isn't a MaxMind DB or is corrupt.
+var ErrInvalid = errors.New("mmdb: invalid database")
+
+// Metadata is the database's self-description.
+type Metadata struct {
+	DatabaseType string
+	Description  map[string]string
+	Languages    []string
+	IPVersion    int
+	NodeCount    uint32
+	RecordSize   int
+	BuildEpoch   uint64
+}
+
+// Reader looks addresses up in one database held in memory. It is safe
+// for concurrent use.
+type Reader struct {
+	Metadata Metadata
+
+	tree      []byte
+	data      []byte
+	nodeBytes int
+	ipv4Start uint32 // node of ::/96 in an IPv6 tree; 0 in an IPv4 one
+}
+
+// Open reads the database at path.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := New(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// New parses a database from its bytes; buf must not change afterwards.
+func New(buf []byte) (*Reader, error) {
+	at := bytes.LastIndex(buf, metadataStart)
+	if at < 0 {
+		return nil, fmt.Errorf("%w: no metadata marker", ErrInvalid)
+	}
+	meta := buf[at+len(metadataStart):]
+	raw, _, err := (&decoder{buf: meta}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalid, err)
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalid)
+	}
+	r := &Reader{Metadata: Metadata{
+		DatabaseType: stringOf(m["database_type"]),
+		IPVersion:    int(uintOf(m["ip_version"])),
+		NodeCount:    uint32(uintOf(m["node_count"])),
+		RecordSize:   int(uintOf(m["record_size"])),
+		BuildEpoch:   uintOf(m["build_epoch"]),
+	}}
+	if d, ok := m["description"].(map[string]any); ok {
+		r.Metadata.Description = make(map[string]string, len(d))
+		for k, v := range d {
+			r.Metadata.Description[k] = stringOf(v)
+		}
+	}
+	if l, ok := m["languages"].([]any); ok {
+		for _, v := range l {
+			r.Metadata.Languages = append(r.Metadata.Languages, stringOf(v))
+		}
+	}
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalid, r.Metadata.RecordSize)
+	}
+	if v := r.Metadata.IPVersion; v != 4 && v != 6 {
+		return nil, fmt.Errorf("%w: ip_version %d", ErrInvalid, v)
+	}
+	r.nodeBytes = r.Metadata.RecordSize / 4
+	treeSize := int(r.Metadata.NodeCount) * r.nodeBytes
+	if treeSize+dataSectionSeparator > at {
+		return nil, fmt.Errorf("%w: search tree overruns the file", ErrInvalid)
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : at]
+	if r.Metadata.IPVersion == 6 {
+		node := uint32(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record is the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node uint32, bit uint) uint32 {
+	b := r.tree[int(node)*r.nodeBytes:]
+	switch r.Metadata.RecordSize {
+	case 24:
+		if bit == 1 {
+			b = b[3:]
+		}
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 1 {
+			return uint32(b[3]&0x0F)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+		}
+		return uint32(b[3]&0xF0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	default:
+		return binary.BigEndian.Uint32(b[bit*4:])
+	}
+}
+
+// Lookup returns the record of the network holding addr, and that
+// network. The record is nil when the database has none for addr.
+// Records decode to map[string]any, []any, string, []byte, bool,
+// float64, uint64 (every unsigned type but uint128), int64 (int32) and
+// *big.Int (uint128).
+func (r *Reader) Lookup(addr netip.Addr) (any, netip.Prefix, error) {
+	addr = addr.Unmap()
+	if addr.Is6() && r.Metadata.IPVersion == 4 {
+		return nil, netip.Prefix{}, fmt.Errorf("mmdb: %s in an IPv4-only database", addr)
+	}
+	ip := addr.AsSlice()
+	node := uint32(0)
+	if addr.Is4() && r.Metadata.IPVersion == 6 {
+		node = r.ipv4Start
+	}
+	bits := len(ip) * 8
+	i := 0
+	for ; i < bits && node < r.Metadata.NodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+	prefix, _ := addr.Prefix(i)
+	switch {
+	case node == r.Metadata.NodeCount:
+		return nil, prefix, nil
+	case node < r.Metadata.NodeCount:
+		return nil, netip.Prefix{}, fmt.Errorf("%w: search tree deeper than the address", ErrInvalid)
+	}
+	offset := int(node-r.Metadata.NodeCount) - dataSectionSeparator
+	if offset < 0 || offset >= len(r.data) {
+		return nil, netip.Prefix{}, fmt.Errorf("%w: data pointer out of range", ErrInvalid)
+	}
+	v, _, err := (&decoder{buf: r.data}).decode(offset, 0)
+	if err != nil {
+		return nil, netip.Prefix{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return v, prefix, nil
+}
+
+// Path walks a decoded record through nested maps: Path(rec, "country",
+// "iso_code"). It returns nil when a step is missing or not a map.
+func Path(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// String is Path's value when it is a string, else "".
+func String(v any, keys ...string) string { return stringOf(Path(v, keys...)) }
+
+// Uint is Path's value when it is an unsigned integer, else 0.
+func Uint(v any, keys ...string) uint64 { return uintOf(Path(v, keys...)) }
+
+func stringOf(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func uintOf(v any) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
+
+// Data section types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// uintSize is the most bytes each unsigned type may hold.
+var uintSize = map[int]int{typeUint16: 2, typeUint32: 4, typeUint64: 8}
+
+// decoder reads values out of a data (or metadata) section; pointers
+// are offsets into buf.
+type decoder struct {
+	buf []byte
+}
+
+var errTruncated = errors.New("value runs past the section")
+
+// take returns n bytes at offset.
+func (d *decoder) take(offset, n int) ([]byte, error) {
+	if offset < 0 || n < 0 || offset+n > len(d.buf) {
+		return nil, errTruncated
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+// decode returns the value at offset and the offset just past it.
+func (d *decoder) decode(offset, depth int) (any, int, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("values nested too deep")
+	}
+	ctrl, err := d.take(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+	typ := int(ctrl[0] >> 5)
+	if typ == typePointer {
+		target, next, err := d.pointer(ctrl[0], offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// A pointer may not point at another pointer, so this can't
+		// loop.
+		if b, err := d.take(target, 1); err != nil || b[0]>>5 == typePointer {
+			return nil, 0, errors.New("bad pointer")
+		}
+		v, _, err := d.decode(target, depth+1)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		b, err := d.take(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset++
+		typ = 7 + int(b[0])
+	}
+	size := int(ctrl[0] & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.take(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + int(b[0])
+		case 2:
+			size = 285 + (int(b[0])<<8 | int(b[1]))
+		default:
+			size = 65821 + (int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+		}
+	}
+	switch typ {
+	case typeMap:
+		// The size comes from the file; like an array's, a corrupt
+		// one must not force a huge allocation before the entries
+		// run out.
+		m := make(map[string]any, min(size, 1024))
+		for range size {
+			k, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			v, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], offset = v, next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 1024))
+		for range size {
+			v, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, offset = append(a, v), next
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, errors.New("bad boolean")
+		}
+		return size == 1, offset, nil
+	}
+	b, err := d.take(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return bytes.Clone(b), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("bad double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("bad float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > uintSize[typ] {
+			return nil, 0, errors.New("unsigned integer too long")
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("int32 too long")
+		}
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		return int64(int32(u)), offset, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errors.New("uint128 too long")
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// pointer decodes the pointer whose control byte is ctrl and whose
+// payload starts at offset, returning its target and the offset past it.
+func (d *decoder) pointer(ctrl byte, offset int) (target, next int, err error) {
+	n := int(ctrl>>3&0x3) + 1
+	b, err := d.take(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	vvv := int(ctrl & 0x7)
+	switch n {
+	case 1:
+		target = vvv<<8 | int(b[0])
+	case 2:
+		target = 2048 + (vvv<<16 | int(b[0])<<8 | int(b[1]))
+	case 3:
+		target = 526336 + (vvv<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+	default:
+		target = int(binary.BigEndian.Uint32(b))
+	}
+	return target, offset + n, nil
+}
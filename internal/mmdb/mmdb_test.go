@@ -0,0 +1,282 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// build writes a minimal IPv6 database (IPv4 under ::/96, as MaxMind
+// lays it out) with the given record size, mapping each network to its
+// record.
+func build(t *testing.T, recordSize int, networks map[string]any) []byte {
+	t.Helper()
+	type node struct {
+		child [2]*node
+		data  int // data offset + 1 on a leaf
+	}
+	root := &node{}
+	var data bytes.Buffer
+	// Insert shorter prefixes first so longer ones split them.
+	keys := make([]string, 0, len(networks))
+	for k := range networks {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return netip.MustParsePrefix(keys[i]).Bits() < netip.MustParsePrefix(keys[j]).Bits()
+	})
+	for _, k := range keys {
+		p := netip.MustParsePrefix(k)
+		ip, bits := p.Addr().As16(), p.Bits()
+		if p.Addr().Is4() {
+			v4 := p.Addr().As4()
+			ip = [16]byte{}
+			copy(ip[12:], v4[:])
+			bits += 96
+		}
+		offset := data.Len()
+		data.Write(encode(t, networks[k]))
+		n := root
+		for i := range bits {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.child[bit] == nil {
+				n.child[bit] = &node{}
+				if n.data != 0 { // splitting a shorter network
+					n.child[bit].data = n.data
+					n.child[1-bit] = &node{data: n.data}
+					n.data = 0
+				}
+			}
+			n = n.child[bit]
+		}
+		*n = node{data: offset + 1}
+	}
+	// Number the inner nodes breadth first.
+	var inner []*node
+	ids := map[*node]int{}
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		ids[n] = len(inner)
+		inner = append(inner, n)
+		for _, c := range n.child {
+			if c != nil && c.data == 0 {
+				queue = append(queue, c)
+			}
+		}
+	}
+	count := len(inner)
+	ref := func(c *node) uint32 {
+		switch {
+		case c == nil:
+			return uint32(count)
+		case c.data != 0:
+			return uint32(count + dataSectionSeparator + c.data - 1)
+		}
+		return uint32(ids[c])
+	}
+	var out bytes.Buffer
+	for _, n := range inner {
+		l, r := ref(n.child[0]), ref(n.child[1])
+		switch recordSize {
+		case 24:
+			out.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 28:
+			out.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>24)<<4 | byte(r>>24)&0x0F, byte(r >> 16), byte(r >> 8), byte(r)})
+		default:
+			out.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, l), r))
+		}
+	}
+	out.Write(make([]byte, dataSectionSeparator))
+	out.Write(data.Bytes())
+	out.Write(metadataStart)
+	out.Write(encode(t, map[string]any{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(6),
+		"database_type":               "Test-Country",
+		"languages":                   []any{"en"},
+		"binary_format_major_version": uint16(2),
+		"build_epoch":                 uint64(1700000000),
+		"description":                 map[string]any{"en": "test"},
+	}))
+	return out.Bytes()
+}
+
+// encode writes v in the data section format.
+func encode(t *testing.T, v any) []byte {
+	t.Helper()
+	head := func(typ, size int) []byte {
+		var b []byte
+		ctrl := byte(0)
+		if typ < 8 {
+			ctrl = byte(typ) << 5
+		}
+		switch {
+		case size < 29:
+			b = []byte{ctrl | byte(size)}
+		case size < 285:
+			b = []byte{ctrl | 29, byte(size - 29)}
+		case size < 65821:
+			b = []byte{ctrl | 30, byte((size - 285) >> 8), byte(size - 285)}
+		case size < 65821+1<<24:
+			b = []byte{ctrl | 31, byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+		default:
+			t.Fatalf("encode: size %d", size)
+		}
+		if typ >= 8 {
+			b = slices.Insert(b, 1, byte(typ-7))
+		}
+		return b
+	}
+	uint := func(typ int, u uint64) []byte {
+		var digits []byte
+		for ; u > 0; u >>= 8 {
+			digits = append([]byte{byte(u)}, digits...)
+		}
+		return append(head(typ, len(digits)), digits...)
+	}
+	switch v := v.(type) {
+	case string:
+		return append(head(typeString, len(v)), v...)
+	case uint16:
+		return uint(typeUint16, uint64(v))
+	case uint32:
+		return uint(typeUint32, uint64(v))
+	case uint64:
+		return uint(typeUint64, v)
+	case bool:
+		if v {
+			return head(typeBool, 1)
+		}
+		return head(typeBool, 0)
+	case float64:
+		return binary.BigEndian.AppendUint64(head(typeDouble, 8), math.Float64bits(v))
+	case []any:
+		b := head(typeArray, len(v))
+		for _, e := range v {
+			b = append(b, encode(t, e)...)
+		}
+		return b
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		b := head(typeMap, len(v))
+		for _, k := range keys {
+			b = append(b, encode(t, k)...)
+			b = append(b, encode(t, v[k])...)
+		}
+		return b
+	}
+	t.Fatalf("encode: %T", v)
+	return nil
+}
+
+func country(code string) map[string]any {
+	return map[string]any{"country": map[string]any{"iso_code": code, "names": map[string]any{"en": "x"}}}
+}
+
+func TestLookup(t *testing.T) {
+	for _, size := range []int{24, 28, 32} {
+		buf := build(t, size, map[string]any{
+			"1.2.0.0/16":    country("DE"),
+			"1.2.3.0/24":    country("NL"),
+			"2001:db8::/32": map[string]any{"autonomous_system_number": uint32(64500), "hosting": true, "score": 0.5},
+		})
+		r, err := New(buf)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if r.Metadata.DatabaseType != "Test-Country" || r.Metadata.RecordSize != size || r.Metadata.Description["en"] != "test" {
+			t.Errorf("size %d: metadata %+v", size, r.Metadata)
+		}
+		for _, c := range []struct {
+			ip, want, network string
+		}{
+			{"1.2.3.4", "NL", "1.2.3.0/24"},
+			{"1.2.200.1", "DE", "1.2.128.0/17"}, // the /24 split the /16
+			{"::ffff:1.2.3.4", "NL", "1.2.3.0/24"},
+			{"9.9.9.9", "", ""},
+		} {
+			rec, network, err := r.Lookup(netip.MustParseAddr(c.ip))
+			if err != nil {
+				t.Fatalf("size %d: %s: %v", size, c.ip, err)
+			}
+			if got := String(rec, "country", "iso_code"); got != c.want {
+				t.Errorf("size %d: %s: country %q, want %q", size, c.ip, got, c.want)
+			}
+			if c.want != "" && network.String() != c.network {
+				t.Errorf("size %d: %s: network %s, want %s", size, c.ip, network, c.network)
+			}
+		}
+		rec, _, err := r.Lookup(netip.MustParseAddr("2001:db8::1"))
+		if err != nil || Uint(rec, "autonomous_system_number") != 64500 || Path(rec, "hosting") != true || Path(rec, "score") != 0.5 {
+			t.Errorf("size %d: 2001:db8::1 = %v, %v", size, rec, err)
+		}
+	}
+}
+
+func TestDecode_Pointer(t *testing.T) {
+	// [0] "ab"; [3] map{"k": pointer to 0}.
+	buf := []byte{typeString<<5 | 2, 'a', 'b', typeMap<<5 | 1, typeString<<5 | 1, 'k', typePointer << 5, 0}
+	v, next, err := (&decoder{buf: buf}).decode(3, 0)
+	if err != nil || String(v, "k") != "ab" || next != len(buf) {
+		t.Errorf("decode = %v, %d, %v", v, next, err)
+	}
+	// A pointer to a pointer is refused rather than followed.
+	loop := []byte{typePointer << 5, 0}
+	if _, _, err := (&decoder{buf: loop}).decode(0, 0); err == nil {
+		t.Error("pointer loop decoded")
+	}
+}
+
+// Sizes past 28 take one, two or three extra bytes.
+func TestDecode_Sizes(t *testing.T) {
+	for _, size := range []int{28, 29, 284, 285, 286, 65820, 65821, 65822} {
+		want := strings.Repeat("x", size)
+		buf := encode(t, want)
+		v, next, err := (&decoder{buf: buf}).decode(0, 0)
+		if got, _ := v.(string); err != nil || got != want || next != len(buf) {
+			t.Errorf("size %d: decoded %d bytes up to %d, err %v", size, len(got), next, err)
+		}
+	}
+}
+
+// A corrupt size claiming ~16.8M entries fails on the missing entries
+// without allocating for all of them first.
+func TestDecode_OversizedContainer(t *testing.T) {
+	for _, typ := range []byte{typeMap, typeArray} {
+		buf := []byte{typ<<5 | 31, 0xFF, 0xFF, 0xFF}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err := (&decoder{buf: buf}).decode(0, 0)
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("type %d: truncated container decoded", typ)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("type %d: allocated %d bytes for an empty container", typ, n)
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for name, buf := range map[string][]byte{
+		"empty":     nil,
+		"no marker": []byte("not a database"),
+		"truncated": append(append([]byte{}, metadataStart...), typeMap<<5|3),
+	} {
+		if _, err := New(buf); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err=%v, want ErrInvalid", name, err)
+		}
+	}
+}
@@ -22,7 +22,10 @@
 //	]
 //
 // Event fields available for selection: type, provider_id, exit_ip, node_ip,
-// pod, timestamp. An empty (or omitted) "fields" sends them all.
+// pod, timestamp, asn, asn_org, hosting (when tundler-tunnel's ASN_DB holds
+// the exit), plus the per-event payload key of ad-hoc events sent via
+// Event (e.g. "audit" for control-API audit entries). An empty (or omitted)
+// "fields" sends them all.
 //
 // # Security
 //
@@ -167,6 +170,25 @@ func (n *Notifier) OnTunnelUp() {
 	}()
 }
 
+// Event emits an ad-hoc event of the given type without blocking the
+// caller, with extra merged over the usual snapshot fields. Unlike the
+// heartbeat it is sent even before a tunnel is up (the snapshot fields are
+// then simply absent): an audit or state event matters regardless.
+func (n *Notifier) Event(eventType string, extra map[string]any) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+		defer cancel()
+		base, ok := n.snapshot()
+		if !ok {
+			base = make(map[string]any, len(extra)+2)
+		}
+		for k, v := range extra {
+			base[k] = v
+		}
+		n.send(ctx, eventType, base)
+	}()
+}
+
 // emit snapshots current state and POSTs the (per-sink projected) event to
 // every destination. Best-effort: each failure is logged, none propagate.
 func (n *Notifier) emit(ctx context.Context, eventType string) {
@@ -174,6 +196,11 @@ func (n *Notifier) emit(ctx context.Context, eventType string) {
 	if !ok {
 		return
 	}
+	n.send(ctx, eventType, base)
+}
+
+// send stamps base with type and timestamp and POSTs it to every sink.
+func (n *Notifier) send(ctx context.Context, eventType string, base map[string]any) {
 	base["type"] = eventType
 	base["timestamp"] = time.Now().UTC().Format(time.RFC3339)
 
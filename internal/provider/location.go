@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/laurentpellegrino/tundler/internal/geo"
+)
+
+// Location is one catalog entry in a provider-neutral shape.
+//
+//   - Name     – the provider-native string: what Locations() lists and
+//     what Connect expects. Never rewritten.
+//   - Country  – ISO-3166 alpha-2, upper case; empty when unknown (WARP's
+//     and Psiphon's "auto").
+//   - City     – city name, when the entry is a single city.
+//   - Server   – server or pool identifier, when the provider has one.
+//   - Features – provider feature tags, lower case ("p2p", "streaming",
+//     "secure_core", "tor").
+type Location struct {
+	Name     string   `json:"name"`
+	Country  string   `json:"country,omitempty"`
+	City     string   `json:"city,omitempty"`
+	Server   string   `json:"server,omitempty"`
+	Features []string `json:"features,omitempty"`
+}
+
+// LocationDescriber is the optional structured counterpart of
+// VPNProvider.Locations. A provider implements it when it knows more about
+// its locations than their names; the others are described from the name
+// alone (see Describe).
+type LocationDescriber interface {
+	// DescribeLocations returns one entry per Locations() name.
+	DescribeLocations(ctx context.Context) []Location
+}
+
+// Describe is the normalization layer over Locations(): one Location per
+// name p lists, in the same order. Entries come from DescribeLocations
+// when p implements it, names it leaves out are described from the name
+// alone, and a missing or unrecognised Country is resolved from the name
+// (geo.Resolve) — so "Germany", "de-frankfurt" and "DE" all read "DE".
+func Describe(ctx context.Context, p VPNProvider) []Location {
+	return DescribeNames(ctx, p, p.Locations(ctx))
+}
+
+// DescribeNames is Describe over names already fetched from p.Locations.
+func DescribeNames(ctx context.Context, p VPNProvider, names []string) []Location {
+	var entries []Location
+	if d, ok := p.(LocationDescriber); ok {
+		entries = d.DescribeLocations(ctx)
+	}
+	return Join(names, entries)
+}
+
+// Join is the normalized entry of every name, taken from entries when
+// they describe it.
+func Join(names []string, entries []Location) []Location {
+	described := make(map[string]Location, len(entries))
+	for _, l := range entries {
+		described[l.Name] = l
+	}
+	out := make([]Location, 0, len(names))
+	for _, name := range names {
+		l, ok := described[name]
+		if !ok {
+			l = Location{Name: name}
+		}
+		out = append(out, Normalize(l))
+	}
+	return out
+}
+
+// Normalize turns Country into its ISO code (a describer may give a code
+// in any case or a country name), resolving it from Name when it is
+// missing or unrecognised, and sorts and lower-cases Features.
+func Normalize(l Location) Location {
+	if c, ok := geo.Named(l.Country); ok {
+		l.Country = c.Code
+	} else if c, ok := geo.Resolve(l.Name); ok {
+		l.Country = c.Code
+	} else {
+		l.Country = ""
+	}
+	if len(l.Features) > 0 {
+		features := make([]string, 0, len(l.Features))
+		for _, f := range l.Features {
+			if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+				features = append(features, f)
+			}
+		}
+		slices.Sort(features)
+		l.Features = slices.Compact(features)
+	}
+	return l
+}
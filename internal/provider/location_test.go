@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// names lists locations and nothing else.
+type names struct {
+	VPNProvider
+	list []string
+}
+
+func (n names) Locations(context.Context) []string { return n.list }
+
+// describer adds DescribeLocations over some of its names.
+type describer struct {
+	names
+	entries []Location
+}
+
+func (d describer) DescribeLocations(context.Context) []Location { return d.entries }
+
+func TestDescribe_FromNames(t *testing.T) {
+	got := Describe(context.Background(), names{list: []string{"United_States", "de-frankfurt", "uk", "auto"}})
+	want := []Location{
+		{Name: "United_States", Country: "US"},
+		{Name: "de-frankfurt", Country: "DE"},
+		{Name: "uk", Country: "GB"},
+		{Name: "auto"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDescribe_Describer(t *testing.T) {
+	p := describer{
+		names: names{list: []string{"Sweden", "pool-7", "Germany"}},
+		entries: []Location{
+			{Name: "Sweden", Country: "se", City: "Gothenburg"},
+			{Name: "pool-7", Country: "Netherlands", Server: "7", Features: []string{"P2P", "streaming", "p2p"}},
+			{Name: "gone", Country: "FR"},
+		},
+	}
+	got := Describe(context.Background(), p)
+	want := []Location{
+		{Name: "Sweden", Country: "SE", City: "Gothenburg"},
+		{Name: "pool-7", Country: "NL", Server: "7", Features: []string{"p2p", "streaming"}},
+		{Name: "Germany", Country: "DE"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe = %+v\nwant %+v", got, want)
+	}
+}
@@ -310,6 +310,39 @@ func (m Mullvad) Locations(ctx context.Context) []string {
 	return locations
 }
 
+// DescribeLocations adds what the relay list knows to each country: its
+// ISO code (the prefix of Mullvad's "se-got" location codes) and, for a
+// country served from a single city, that city.
+func (m Mullvad) DescribeLocations(ctx context.Context) []provider.Location {
+	relays, err := loadRelays()
+	if err != nil {
+		return nil
+	}
+	byCountry := make(map[string]*provider.Location)
+	var out []*provider.Location
+	for _, r := range relays {
+		if r.Country == "" {
+			continue
+		}
+		l := byCountry[r.Country]
+		if l == nil {
+			l = &provider.Location{Name: r.Country, City: r.City}
+			if code, _, ok := strings.Cut(r.Location, "-"); ok {
+				l.Country = strings.ToUpper(code)
+			}
+			byCountry[r.Country] = l
+			out = append(out, l)
+		} else if l.City != r.City {
+			l.City = ""
+		}
+	}
+	locations := make([]provider.Location, len(out))
+	for i, l := range out {
+		locations[i] = *l
+	}
+	return locations
+}
+
 func (m Mullvad) LoggedIn(ctx context.Context) bool { return loggedIn }
 
 // Login is a no-op handshake for this provider — there is no account
@@ -417,6 +417,47 @@ func (p ProtonVPN) Locations(ctx context.Context) []string {
 	return locations
 }
 
+// DescribeLocations adds the features any server of each country offers
+// and, for a country served from a single city, that city.
+func (p ProtonVPN) DescribeLocations(ctx context.Context) []provider.Location {
+	servers, err := fetchServers(ctx)
+	if err != nil {
+		return nil
+	}
+	byCountry := make(map[string]*provider.Location)
+	bits := make(map[string]int)
+	var names []string
+	for _, srv := range servers {
+		if srv.Country == "" {
+			continue
+		}
+		l := byCountry[srv.Country]
+		if l == nil {
+			l = &provider.Location{Name: srv.Country, City: srv.City}
+			byCountry[srv.Country] = l
+			names = append(names, srv.Country)
+		} else if l.City != srv.City {
+			l.City = ""
+		}
+		bits[srv.Country] |= srv.Features
+	}
+	sort.Strings(names)
+	locations := make([]provider.Location, 0, len(names))
+	for _, n := range names {
+		l := *byCountry[n]
+		for _, f := range []struct {
+			bit int
+			tag string
+		}{{featureSecureCore, "secure_core"}, {featureTor, "tor"}, {featureP2P, "p2p"}, {featureStream, "streaming"}} {
+			if bits[n]&f.bit != 0 {
+				l.Features = append(l.Features, f.tag)
+			}
+		}
+		locations = append(locations, l)
+	}
+	return locations
+}
+
 func (p ProtonVPN) LoggedIn(ctx context.Context) bool {
 	return loggedIn
 }
@@ -132,6 +132,21 @@ func TestFetchServersHonorsProtocol(t *testing.T) {
 	}
 }
 
+func TestDescribeLocations(t *testing.T) {
+	resetTestState(t)
+	useTestServers(t)
+	t.Setenv("PROTON_OPENVPN_PROTOCOL", "tcp")
+	embeddedServers = []byte(strings.Replace(testServersJSON, `"server_name": "DE#1",`, `"server_name": "DE#1", "features": 5,`, 1))
+
+	got := ProtonVPN{}.DescribeLocations(context.Background())
+	if len(got) != 2 || got[0].Name != "France" || got[0].City != "Paris" || got[1].Name != "Germany" {
+		t.Fatalf("unexpected locations: %+v", got)
+	}
+	if !slices.Equal(got[1].Features, []string{"secure_core", "p2p"}) {
+		t.Errorf("Germany features = %v, want [secure_core p2p]", got[1].Features)
+	}
+}
+
 func TestBuildOpenVPNConfig(t *testing.T) {
 	resetTestState(t)
 	t.Setenv("PROTON_OPENVPN_PROTOCOL", "tcp")
@@ -0,0 +1,714 @@
+package proxy
+
+// Fingerprint self-test: what does HandshakeAs ACTUALLY put on the wire?
+//
+// A utls upgrade can silently change a preset's ClientHello, and the h2
+// framing is Go's, not the browser's — neither is visible from the client
+// side. So we impersonate against an in-process TLS listener, capture the
+// raw ClientHello record and the first h2 frames, compute the fingerprints
+// an edge would (JA3, JA4, Akamai h2), and compare them to the pinned
+// values in fingerprints.json. The package test is the CI gate; the tunnel's
+// /selftest/fingerprint endpoint runs the same check against the deployed
+// binary.
+//
+// The h2 pins are the browsers' published Akamai fingerprints, not what
+// this build emits: the transport frames h2 with x/net, whose SETTINGS,
+// WINDOW_UPDATE and pseudo-header order are Go's. That gap is reported as
+// a known divergence rather than passed off as a match. So is the QUIC
+// hello for profiles that speak h3: crypto/tls builds it, not uTLS, and the
+// self-test upgrades to h3 once to fingerprint it (JA4 "q" variant).
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// selfTestHost is the SNI the self-test presents. A name (not an IP) so the
+// JA4 SNI flag reads "d", as it does for every real crawl target.
+const selfTestHost = "selftest.tundler.invalid"
+
+// selfTestTimeout bounds one self-test handshake + request.
+const selfTestTimeout = 5 * time.Second
+
+// ExpectedFingerprint is one profile's pinned values. JA3N is the JA3 hash
+// with extensions sorted: Chrome presets shuffle extension order per
+// connection (as Chrome does), so raw JA3 is not stable and is reported
+// but never compared.
+//
+// JA3N and JA4NoPad both ignore the padding extension (21). Chromium-style
+// hellos add it only when the hello falls in a certain size band, and the
+// randomised GREASE ECH payload makes that a coin flip per connection — it
+// carries no identity, and counting it would make every pin flaky. That
+// makes JA4NoPad non-standard: JA4 counts padding in its extension count,
+// so an edge or a FoxIO tool reports the raw JA4 (FingerprintReport.JA4)
+// for the same hello, which is not pinned.
+//
+// H2 is the real browser's Akamai h2 fingerprint; see Divergences.
+type ExpectedFingerprint struct {
+	JA3N     string `json:"ja3n"`
+	JA4NoPad string `json:"ja4_nopad"`
+	H2       string `json:"h2"`
+}
+
+// FingerprintReport is the self-test outcome for one profile.
+type FingerprintReport struct {
+	Profile    string               `json:"profile"`
+	JA3        string               `json:"ja3"`
+	JA3Hash    string               `json:"ja3_hash"`
+	JA3N       string               `json:"ja3n"`
+	JA4        string               `json:"ja4"`       // standard JA4, padding counted
+	JA4NoPad   string               `json:"ja4_nopad"` // JA4 without padding; pinned
+	H2         string               `json:"h2"`
+	H3JA4      string               `json:"h3_ja4,omitempty"` // JA4 of the QUIC hello, for profiles that speak h3
+	Expected   *ExpectedFingerprint `json:"expected,omitempty"`
+	Mismatches []string             `json:"mismatches,omitempty"`
+	// Divergences are known gaps between what the stack sends and what
+	// the browser does (the h2 framing, and the QUIC hello). They don't
+	// fail OK, but an edge can see them.
+	Divergences []string `json:"divergences,omitempty"`
+	OK          bool     `json:"ok"`
+
+	h3Err error // why a profile that speaks h3 produced no H3JA4
+}
+
+// fingerprintsJSON pins the expected fingerprint of every rotation profile.
+// Regenerate the JA3N/JA4NoPad pins with `go test ./internal/proxy -run Fingerprint
+// -update` after a deliberate utls bump, and review the diff like any other
+// behaviour change. -update keeps the h2 pins: they are the browsers' values.
+//
+//go:embed fingerprints.json
+var fingerprintsJSON []byte
+
+// ExpectedFingerprints returns the pinned fingerprints, keyed by profile
+// name (ClientHelloID.Str()).
+func ExpectedFingerprints() (map[string]ExpectedFingerprint, error) {
+	var m map[string]ExpectedFingerprint
+	if err := json.Unmarshal(fingerprintsJSON, &m); err != nil {
+		return nil, fmt.Errorf("fingerprints.json: %w", err)
+	}
+	return m, nil
+}
+
+// CheckFingerprint runs the self-test for hello and compares the result to
+// its pinned values. A profile with no pinned entry is reported as not OK;
+// an h2 fingerprint other than the browser's is a divergence, not a pass.
+func CheckFingerprint(ctx context.Context, hello utls.ClientHelloID) (FingerprintReport, error) {
+	rep, err := SelfTestFingerprint(ctx, hello)
+	if err != nil {
+		return rep, err
+	}
+	expected, err := ExpectedFingerprints()
+	if err != nil {
+		return rep, err
+	}
+	want, ok := expected[rep.Profile]
+	if !ok {
+		rep.Mismatches = []string{"no pinned fingerprint for profile"}
+		return rep, nil
+	}
+	rep.Expected = &want
+	for _, c := range []struct{ name, got, want string }{
+		{"ja3n", rep.JA3N, want.JA3N},
+		{"ja4_nopad", rep.JA4NoPad, want.JA4NoPad},
+	} {
+		if c.got != c.want {
+			rep.Mismatches = append(rep.Mismatches, fmt.Sprintf("%s: got %q, want %q", c.name, c.got, c.want))
+		}
+	}
+	if rep.H2 != want.H2 {
+		rep.Divergences = append(rep.Divergences,
+			fmt.Sprintf("h2: sends %q (Go's x/net framing), %s sends %q", rep.H2, rep.Profile, want.H2))
+	}
+	switch {
+	case rep.H3JA4 != "":
+		rep.Divergences = append(rep.Divergences,
+			fmt.Sprintf("h3: QUIC hello %q is Go's crypto/tls, not %s's (uTLS has no QUIC mode)", rep.H3JA4, rep.Profile))
+	case rep.h3Err != nil:
+		rep.Divergences = append(rep.Divergences, fmt.Sprintf("h3: QUIC hello not captured: %v", rep.h3Err))
+	}
+	rep.OK = len(rep.Mismatches) == 0
+	return rep, nil
+}
+
+// SelfTestFingerprint performs one impersonated h2 request against an
+// in-process TLS listener and fingerprints what arrived. It exercises the
+// real ImpersonatingTransport path, so it sees exactly what an upstream
+// edge would. For profiles that speak h3 the listener also advertises an
+// in-process h3 server via Alt-Svc, and a second request fingerprints the
+// QUIC hello.
+func SelfTestFingerprint(ctx context.Context, hello utls.ClientHelloID) (FingerprintReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	rep := FingerprintReport{Profile: hello.Str()}
+
+	cert, err := selfSignedCert(selfTestHost)
+	if err != nil {
+		return rep, err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return rep, err
+	}
+	defer ln.Close()
+
+	var d net.Dialer
+	tr := NewImpersonatingTransport(func(ctx context.Context, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", ln.Addr().String())
+	}, hello)
+	tr.insecure = true // self-signed; verification isn't what's under test
+	tr.EnableHTTP3(func(ctx context.Context) (net.PacketConn, error) {
+		var lc net.ListenConfig
+		return lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
+	})
+	var altSvc string
+	var quicHellos <-chan *clientHello
+	if tr.HTTP3Enabled() {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return rep, err
+		}
+		defer pc.Close()
+		srv, hellos := captureQUICHello(pc, cert)
+		defer srv.Close()
+		altSvc, quicHellos = fmt.Sprintf("h3=%q", pc.LocalAddr()), hellos
+	}
+
+	type captured struct {
+		hello, h2 []byte
+		err       error
+	}
+	got := make(chan captured, 1)
+	clientDone := make(chan struct{})
+	go func() {
+		h, f, err := captureHandshake(ctx, ln, cert, altSvc, clientDone)
+		got <- captured{h, f, err}
+	}()
+
+	get := func() (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+selfTestHost+"/", nil)
+		resp, err := tr.RoundTrip(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return resp, err
+	}
+	_, rtErr := get()
+	if rtErr == nil && quicHellos != nil {
+		// The h2 response taught the transport the Alt-Svc; this one rides
+		// h3, or falls back to h2 and says why.
+		if resp, err := get(); err != nil {
+			rep.h3Err = err
+		} else if resp.ProtoMajor != 3 {
+			rep.h3Err = fmt.Errorf("request went over %s", resp.Proto)
+		}
+	}
+	close(clientDone)
+	tr.closeAll()
+
+	var c captured
+	select {
+	case c = <-got:
+	case <-ctx.Done():
+		return rep, fmt.Errorf("fingerprint self-test: %w", ctx.Err())
+	}
+	if c.err != nil {
+		return rep, fmt.Errorf("fingerprint self-test: capture: %w", c.err)
+	}
+	if rtErr != nil {
+		return rep, fmt.Errorf("fingerprint self-test: client: %w", rtErr)
+	}
+
+	ch, err := parseClientHello(c.hello)
+	if err != nil {
+		return rep, fmt.Errorf("fingerprint self-test: %w", err)
+	}
+	rep.JA3 = ch.ja3(false)
+	rep.JA3Hash = md5Hex(rep.JA3)
+	stable := ch.withoutPadding()
+	rep.JA3N = md5Hex(stable.ja3(true))
+	rep.JA4 = ch.ja4()
+	rep.JA4NoPad = stable.ja4()
+	if rep.H2, err = h2Fingerprint(c.h2); err != nil {
+		return rep, fmt.Errorf("fingerprint self-test: %w", err)
+	}
+	if quicHellos != nil && rep.h3Err == nil {
+		select {
+		case qh := <-quicHellos:
+			rep.H3JA4 = qh.ja4()
+		default:
+			rep.h3Err = errors.New("h3 request completed without a handshake")
+		}
+	}
+	return rep, nil
+}
+
+// captureQUICHello serves h3 on pc and hands back the ClientHello of the
+// first QUIC handshake. QUIC carries the hello in CRYPTO frames rather than
+// a TLS record, so it is rebuilt from what crypto/tls parsed.
+func captureQUICHello(pc net.PacketConn, cert tls.Certificate) (*http3.Server, <-chan *clientHello) {
+	hellos := make(chan *clientHello, 1)
+	srv := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				select {
+				case hellos <- helloFromInfo(info):
+				default:
+				}
+				return nil, nil
+			},
+		}),
+	}
+	go func() { _ = srv.Serve(pc) }()
+	return srv, hellos
+}
+
+// helloFromInfo converts crypto/tls's view of a QUIC ClientHello. The
+// legacy version is fixed at TLS 1.2 on the wire; the real one is in
+// supported_versions.
+func helloFromInfo(info *tls.ClientHelloInfo) *clientHello {
+	ch := &clientHello{
+		quic:       true,
+		version:    tls.VersionTLS12,
+		ciphers:    slices.Clone(info.CipherSuites),
+		extensions: slices.Clone(info.Extensions),
+		points:     slices.Clone(info.SupportedPoints),
+		alpn:       slices.Clone(info.SupportedProtos),
+		versions:   slices.Clone(info.SupportedVersions),
+		sni:        info.ServerName != "",
+	}
+	for _, c := range info.SupportedCurves {
+		ch.groups = append(ch.groups, uint16(c))
+	}
+	for _, s := range info.SignatureSchemes {
+		ch.sigAlgs = append(ch.sigAlgs, uint16(s))
+	}
+	return ch
+}
+
+// captureHandshake accepts one connection on ln, records the raw bytes of
+// the TLS handshake and the plaintext h2 bytes that follow, and answers
+// requests with 204 (plus altSvc, when set) so the client completes
+// cleanly. The conn is held open until clientDone closes, so the response
+// is never cut off.
+func captureHandshake(ctx context.Context, ln net.Listener, cert tls.Certificate, altSvc string, clientDone <-chan struct{}) (hello, h2 []byte, err error) {
+	raw, err := ln.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer raw.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = raw.SetDeadline(dl)
+	}
+
+	rec := &recordingConn{Conn: raw}
+	tconn := tls.Server(rec, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{http2.NextProtoTLS, "http/1.1"},
+	})
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	hello = rec.snapshot()
+	if p := tconn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+		return hello, nil, fmt.Errorf("negotiated %q, want h2", p)
+	}
+
+	plain := &recordingConn{Conn: tconn}
+	served := make(chan struct{})
+	var once sync.Once
+	go (&http2.Server{}).ServeConn(plain, &http2.ServeConnOpts{
+		Context: ctx,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if altSvc != "" {
+				w.Header().Set("Alt-Svc", altSvc)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			once.Do(func() { close(served) })
+		}),
+	})
+	select {
+	case <-served:
+	case <-ctx.Done():
+		return hello, nil, ctx.Err()
+	}
+	h2 = plain.snapshot()
+	select {
+	case <-clientDone:
+	case <-ctx.Done():
+	}
+	return hello, h2, nil
+}
+
+// recordingConn keeps a copy of every byte read through it.
+type recordingConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.buf.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *recordingConn) snapshot() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.buf.Bytes())
+}
+
+// clientHello is the subset of a ClientHello the fingerprints need.
+type clientHello struct {
+	version    uint16
+	ciphers    []uint16
+	extensions []uint16
+	groups     []uint16
+	points     []uint8
+	sigAlgs    []uint16
+	alpn       []string
+	versions   []uint16
+	sni        bool
+	quic       bool // carried in QUIC CRYPTO frames, not a TCP TLS record
+}
+
+// parseClientHello decodes the ClientHello from the start of a captured
+// handshake stream, reassembling it across TLS records if needed.
+func parseClientHello(stream []byte) (*clientHello, error) {
+	var msg []byte
+	s := cryptobyte.String(stream)
+	for {
+		var typ uint8
+		var vers uint16
+		var frag cryptobyte.String
+		if !s.ReadUint8(&typ) || !s.ReadUint16(&vers) || !s.ReadUint16LengthPrefixed(&frag) {
+			return nil, errors.New("client hello: truncated record")
+		}
+		if typ != 22 { // handshake
+			return nil, fmt.Errorf("client hello: record type %d, want handshake", typ)
+		}
+		msg = append(msg, frag...)
+		if len(msg) >= 4 && len(msg) >= 4+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3])) {
+			break
+		}
+	}
+
+	m := cryptobyte.String(msg)
+	var typ uint8
+	var body cryptobyte.String
+	if !m.ReadUint8(&typ) || typ != 1 || !m.ReadUint24LengthPrefixed(&body) {
+		return nil, errors.New("client hello: not a ClientHello")
+	}
+	ch := &clientHello{}
+	var sessionID, ciphers, compression, exts cryptobyte.String
+	if !body.ReadUint16(&ch.version) || !body.Skip(32) ||
+		!body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16LengthPrefixed(&ciphers) ||
+		!body.ReadUint8LengthPrefixed(&compression) ||
+		!body.ReadUint16LengthPrefixed(&exts) {
+		return nil, errors.New("client hello: malformed body")
+	}
+	for !ciphers.Empty() {
+		var c uint16
+		if !ciphers.ReadUint16(&c) {
+			return nil, errors.New("client hello: malformed cipher list")
+		}
+		ch.ciphers = append(ch.ciphers, c)
+	}
+	for !exts.Empty() {
+		var typ uint16
+		var data cryptobyte.String
+		if !exts.ReadUint16(&typ) || !exts.ReadUint16LengthPrefixed(&data) {
+			return nil, errors.New("client hello: malformed extension")
+		}
+		ch.extensions = append(ch.extensions, typ)
+		if err := ch.parseExtension(typ, data); err != nil {
+			return nil, err
+		}
+	}
+	return ch, nil
+}
+
+func (ch *clientHello) parseExtension(typ uint16, data cryptobyte.String) error {
+	switch typ {
+	case 0x0000: // server_name
+		ch.sni = true
+	case 0x000a: // supported_groups
+		var l cryptobyte.String
+		if !data.ReadUint16LengthPrefixed(&l) || !readUint16s(&l, &ch.groups) {
+			return errors.New("client hello: malformed supported_groups")
+		}
+	case 0x000b: // ec_point_formats
+		var l cryptobyte.String
+		if !data.ReadUint8LengthPrefixed(&l) {
+			return errors.New("client hello: malformed ec_point_formats")
+		}
+		ch.points = append(ch.points, l...)
+	case 0x000d: // signature_algorithms
+		var l cryptobyte.String
+		if !data.ReadUint16LengthPrefixed(&l) || !readUint16s(&l, &ch.sigAlgs) {
+			return errors.New("client hello: malformed signature_algorithms")
+		}
+	case 0x0010: // application_layer_protocol_negotiation
+		var l cryptobyte.String
+		if !data.ReadUint16LengthPrefixed(&l) {
+			return errors.New("client hello: malformed alpn")
+		}
+		for !l.Empty() {
+			var p cryptobyte.String
+			if !l.ReadUint8LengthPrefixed(&p) {
+				return errors.New("client hello: malformed alpn")
+			}
+			ch.alpn = append(ch.alpn, string(p))
+		}
+	case 0x002b: // supported_versions
+		var l cryptobyte.String
+		if !data.ReadUint8LengthPrefixed(&l) || !readUint16s(&l, &ch.versions) {
+			return errors.New("client hello: malformed supported_versions")
+		}
+	}
+	return nil
+}
+
+func readUint16s(s *cryptobyte.String, out *[]uint16) bool {
+	for !s.Empty() {
+		var v uint16
+		if !s.ReadUint16(&v) {
+			return false
+		}
+		*out = append(*out, v)
+	}
+	return true
+}
+
+// withoutPadding returns a copy of ch minus the padding extension.
+func (ch *clientHello) withoutPadding() *clientHello {
+	out := *ch
+	out.extensions = slices.DeleteFunc(slices.Clone(ch.extensions), func(e uint16) bool { return e == 0x0015 })
+	return &out
+}
+
+// isGREASE reports RFC 8701 GREASE values (0x0a0a, 0x1a1a, … 0xfafa),
+// which every fingerprint format ignores.
+func isGREASE(v uint16) bool { return v&0x0f0f == 0x0a0a && v>>8 == v&0xff }
+
+func dropGREASE(vs []uint16) []uint16 {
+	out := make([]uint16, 0, len(vs))
+	for _, v := range vs {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ja3 renders the JA3 string: version,ciphers,extensions,groups,points.
+// sorted=true sorts the extension list (JA3N) to neutralise shuffling.
+func (ch *clientHello) ja3(sorted bool) string {
+	exts := dropGREASE(ch.extensions)
+	if sorted {
+		slices.Sort(exts)
+	}
+	points := make([]string, len(ch.points))
+	for i, p := range ch.points {
+		points[i] = strconv.Itoa(int(p))
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(ch.version)),
+		joinDec(dropGREASE(ch.ciphers)),
+		joinDec(exts),
+		joinDec(dropGREASE(ch.groups)),
+		strings.Join(points, "-"),
+	}, ",")
+}
+
+// ja4 renders the FoxIO JA4 TLS client fingerprint; the protocol prefix is
+// "q" for a QUIC hello and "t" otherwise.
+func (ch *clientHello) ja4() string {
+	version := ch.version
+	for _, v := range dropGREASE(ch.versions) {
+		if v > version {
+			version = v
+		}
+	}
+	vs := map[uint16]string{0x0304: "13", 0x0303: "12", 0x0302: "11", 0x0301: "10", 0x0300: "s3"}[version]
+	if vs == "" {
+		vs = "00"
+	}
+	sni := "i"
+	if ch.sni {
+		sni = "d"
+	}
+	ciphers := dropGREASE(ch.ciphers)
+	exts := dropGREASE(ch.extensions)
+	alpn := "00"
+	if len(ch.alpn) > 0 && ch.alpn[0] != "" {
+		a := ch.alpn[0]
+		alpn = a[:1] + a[len(a)-1:]
+	}
+	proto := "t"
+	if ch.quic {
+		proto = "q"
+	}
+	a := fmt.Sprintf("%s%s%s%02d%02d%s", proto, vs, sni, min(len(ciphers), 99), min(len(exts), 99), alpn)
+
+	slices.Sort(ciphers)
+	b := truncHash(joinHex(ciphers))
+
+	var sortedExts []uint16
+	for _, e := range exts {
+		if e != 0x0000 && e != 0x0010 {
+			sortedExts = append(sortedExts, e)
+		}
+	}
+	slices.Sort(sortedExts)
+	cIn := joinHex(sortedExts)
+	if len(ch.sigAlgs) > 0 {
+		cIn += "_" + joinHex(ch.sigAlgs)
+	}
+	return a + "_" + b + "_" + truncHash(cIn)
+}
+
+// h2Fingerprint renders the Akamai HTTP/2 fingerprint of a captured client
+// stream: SETTINGS|WINDOW_UPDATE|PRIORITY|pseudo-header order.
+func h2Fingerprint(stream []byte) (string, error) {
+	if !bytes.HasPrefix(stream, []byte(http2.ClientPreface)) {
+		return "", errors.New("h2: missing client preface")
+	}
+	fr := http2.NewFramer(io.Discard, bytes.NewReader(stream[len(http2.ClientPreface):]))
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	var settings, priorities []string
+	window := "00"
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return "", fmt.Errorf("h2: no HEADERS frame captured: %w", err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() || settings != nil {
+				continue
+			}
+			settings = []string{}
+			_ = f.ForeachSetting(func(s http2.Setting) error {
+				settings = append(settings, fmt.Sprintf("%d:%d", s.ID, s.Val))
+				return nil
+			})
+		case *http2.WindowUpdateFrame:
+			if f.StreamID == 0 && window == "00" {
+				window = strconv.FormatUint(uint64(f.Increment), 10)
+			}
+		case *http2.PriorityFrame:
+			priorities = append(priorities, fmt.Sprintf("%d:%d:%d:%d",
+				f.StreamID, b2i(f.Exclusive), f.StreamDep, int(f.Weight)+1))
+		case *http2.MetaHeadersFrame:
+			var pseudo []string
+			for _, hf := range f.Fields {
+				if strings.HasPrefix(hf.Name, ":") && len(hf.Name) > 1 {
+					pseudo = append(pseudo, hf.Name[1:2])
+				}
+			}
+			prio := "0"
+			if len(priorities) > 0 {
+				prio = strings.Join(priorities, ",")
+			}
+			return strings.Join([]string{
+				strings.Join(settings, ";"), window, prio, strings.Join(pseudo, ","),
+			}, "|"), nil
+		}
+	}
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func joinDec(vs []uint16) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
+
+func joinHex(vs []uint16) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+// truncHash is JA4's "first 12 hex chars of SHA-256", with the spec's
+// all-zero value for an empty input.
+func truncHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// selfSignedCert mints a throwaway P-256 certificate for host.
+func selfSignedCert(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
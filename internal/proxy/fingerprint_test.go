@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+var updateFingerprints = flag.Bool("update", false, "rewrite the JA3N/JA4NoPad pins in fingerprints.json from the current utls build")
+
+// Every rotation profile must still emit its pinned fingerprint. A failure
+// here after a utls bump means the wire changed: inspect the diff, and only
+// then re-pin with -update.
+func TestFingerprint_ProfilesMatchPinnedValues(t *testing.T) {
+	ctx := context.Background()
+	if *updateFingerprints {
+		old, err := ExpectedFingerprints()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinned := map[string]ExpectedFingerprint{}
+		for _, p := range browserProfiles {
+			rep, err := SelfTestFingerprint(ctx, p)
+			if err != nil {
+				t.Fatalf("%s: %v", p.Str(), err)
+			}
+			// The h2 pin is the browser's, not this build's.
+			pinned[rep.Profile] = ExpectedFingerprint{JA3N: rep.JA3N, JA4NoPad: rep.JA4NoPad, H2: old[rep.Profile].H2}
+		}
+		out, _ := json.MarshalIndent(pinned, "", "  ")
+		if err := os.WriteFile("fingerprints.json", append(out, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Skip("fingerprints.json rewritten; re-run without -update")
+	}
+
+	for _, p := range browserProfiles {
+		t.Run(p.Str(), func(t *testing.T) {
+			rep, err := CheckFingerprint(ctx, p)
+			if err != nil {
+				t.Fatalf("self-test: %v", err)
+			}
+			if !rep.OK {
+				t.Fatalf("fingerprint drifted: %s", strings.Join(rep.Mismatches, "; "))
+			}
+			// x/net's h2 framing is not the browser's, nor is the QUIC
+			// hello of a profile that speaks h3, and the report must say
+			// so rather than pass them.
+			if rep.H2 != rep.Expected.H2 && !hasDivergence(rep, "h2: ") {
+				t.Errorf("h2 %q differs from the browser's %q without a divergence: %+v", rep.H2, rep.Expected.H2, rep.Divergences)
+			}
+			if _, h3 := quicProfileFor(p); !h3 {
+				if rep.H3JA4 != "" || hasDivergence(rep, "h3: ") {
+					t.Errorf("profile without h3 reports h3_ja4=%q divergences=%q", rep.H3JA4, rep.Divergences)
+				}
+			} else if !strings.HasPrefix(rep.H3JA4, "q13d") || rep.H3JA4[8:10] != "h3" || !hasDivergence(rep, "h3: ") {
+				t.Errorf("h3_ja4=%q divergences=%q, want a q13d….h3_… QUIC hello reported as a divergence", rep.H3JA4, rep.Divergences)
+			}
+		})
+	}
+}
+
+func hasDivergence(rep FingerprintReport, prefix string) bool {
+	for _, d := range rep.Divergences {
+		if strings.HasPrefix(d, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// The self-test must see what a browser sends: TLS 1.3, a domain SNI and h2
+// as the first ALPN — otherwise it isn't measuring the crawl path at all —
+// and the h2 fingerprint must carry all four Akamai sections.
+func TestFingerprint_JA4ShapeAndStability(t *testing.T) {
+	ctx := context.Background()
+	first, err := SelfTestFingerprint(ctx, browserProfiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first.JA4, "t13d") || first.JA4[8:10] != "h2" {
+		t.Fatalf("JA4 = %q, want t13d….h2_…", first.JA4)
+	}
+	// Padding only changes the extension count and hash: the raw JA4
+	// and the pinned one share everything else.
+	if raw, pinned := strings.Split(first.JA4, "_"), strings.Split(first.JA4NoPad, "_"); raw[0][:6] != pinned[0][:6] || raw[1] != pinned[1] {
+		t.Errorf("ja4=%q ja4_nopad=%q, want the same version, SNI, cipher count and cipher hash", first.JA4, first.JA4NoPad)
+	}
+	if parts := strings.Split(first.H2, "|"); len(parts) != 4 || parts[0] == "" || len(parts[3]) != len("m,a,s,p") {
+		t.Fatalf("h2 fingerprint = %q, want SETTINGS|WINDOW_UPDATE|PRIORITY|pseudo-headers", first.H2)
+	}
+	again, err := SelfTestFingerprint(ctx, browserProfiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.JA4NoPad != first.JA4NoPad || again.JA3N != first.JA3N {
+		t.Fatalf("fingerprint not stable across handshakes: %+v vs %+v", first, again)
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGREASE(v) {
+			t.Errorf("isGREASE(%#04x) = false", v)
+		}
+	}
+	for _, v := range []uint16{0x0000, 0x1301, 0x0a1a, 0xfe0d} {
+		if isGREASE(v) {
+			t.Errorf("isGREASE(%#04x) = true", v)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	utls "github.com/refraction-networking/utls"
+)
+
+// PacketDialFunc opens a UDP socket for one QUIC connection. It must route
+// through the same egress as the TCP DialFunc — in a kernel-tunnel pod that
+// is simply an unconnected socket on the default route (tun0). Proxy-chain
+// providers have no UDP path at all and must not install one: a direct UDP
+// socket there would egress from the node IP.
+type PacketDialFunc func(ctx context.Context) (net.PacketConn, error)
+
+// HTTP/3 tunables.
+//
+//	h3DialTimeout — how long a QUIC handshake may take before we give up and
+//	    fall back to h2 over TCP. Browsers race TCP after a few hundred ms; we
+//	    try sequentially, so keep this short enough that a UDP-blocked path
+//	    costs one slow request per host, not a stalled crawl.
+//	altSvcBrokenBase / altSvcBrokenMax — after an h3 failure the host's
+//	    alternative is marked broken for base·2^n, capped at max — the same
+//	    exponential "broken alternative service" back-off Chromium applies,
+//	    so a network that drops UDP isn't re-probed on every request.
+//	altSvcMaxHosts — bound on the per-host cache; hostile or huge crawl
+//	    lists must not grow it without limit.
+//
+// vars (not consts) so tests can dial them down.
+var (
+	h3DialTimeout    = 3 * time.Second
+	altSvcBrokenBase = 5 * time.Minute
+	altSvcBrokenMax  = 48 * time.Hour
+)
+
+const (
+	altSvcMaxHosts = 4096
+	// altSvcDefaultMaxAge is RFC 7838's default freshness when an
+	// advertisement carries no ma= parameter.
+	altSvcDefaultMaxAge = 24 * time.Hour
+)
+
+// quicProfile is the subset of QUIC transport parameters a browser family
+// advertises that quic-go lets us set. The values approximate each
+// browser's shipped defaults so the transport_parameters extension looks
+// like that browser's rather than quic-go's. (The QUIC ClientHello itself is
+// built by crypto/tls — uTLS has no QUIC mode — so JA4 over QUIC is Go's;
+// the TCP leg keeps the byte-exact browser hello.)
+type quicProfile struct {
+	streamWindow      uint64
+	connWindow        uint64
+	maxStreams        int64
+	maxUniStreams     int64
+	idleTimeout       time.Duration
+	initialPacketSize uint16
+}
+
+var (
+	chromiumQUIC = quicProfile{
+		streamWindow:      6 << 20,  // initial_max_stream_data_* = 6 MiB
+		connWindow:        15 << 20, // initial_max_data = 15 MiB
+		maxStreams:        100,
+		maxUniStreams:     103,
+		idleTimeout:       30 * time.Second,
+		initialPacketSize: 1250,
+	}
+	firefoxQUIC = quicProfile{
+		streamWindow:      12 << 20,
+		connWindow:        25 << 20,
+		maxStreams:        100,
+		maxUniStreams:     100,
+		idleTimeout:       30 * time.Second,
+		initialPacketSize: 1280,
+	}
+)
+
+// quicProfileFor maps a TLS preset to the QUIC profile of the same browser.
+// ok=false for presets whose real browser does not speak h3 by default
+// (Safari 16 shipped it off) — for those, offering h3 would itself be the
+// anomaly, so the pod stays on h2.
+func quicProfileFor(hello utls.ClientHelloID) (quicProfile, bool) {
+	switch hello.Client {
+	case utls.HelloChrome_Auto.Client, utls.HelloEdge_Auto.Client:
+		return chromiumQUIC, true
+	case utls.HelloFirefox_Auto.Client:
+		return firefoxQUIC, true
+	default:
+		return quicProfile{}, false
+	}
+}
+
+// config renders the profile as a quic.Config. MaxIncomingStreams is set
+// explicitly because http3 otherwise advertises zero bidirectional streams,
+// which no browser does.
+func (p quicProfile) config() *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout:           h3DialTimeout,
+		MaxIdleTimeout:                 p.idleTimeout,
+		InitialStreamReceiveWindow:     p.streamWindow,
+		MaxStreamReceiveWindow:         p.streamWindow,
+		InitialConnectionReceiveWindow: p.connWindow,
+		MaxConnectionReceiveWindow:     p.connWindow,
+		MaxIncomingStreams:             p.maxStreams,
+		MaxIncomingUniStreams:          p.maxUniStreams,
+		InitialPacketSize:              p.initialPacketSize,
+	}
+}
+
+// altSvcEntry is one host's learned h3 alternative.
+type altSvcEntry struct {
+	authority   string    // "host:port" to dial; host may be empty (= origin host)
+	expires     time.Time // from ma=
+	brokenUntil time.Time // zero unless a recent h3 attempt failed
+	brokenCount int       // consecutive failures; drives the back-off
+}
+
+// altSvcCache remembers, per origin host, whether it advertised h3 via
+// Alt-Svc and whether our last attempt at it failed. Safe for concurrent
+// use.
+type altSvcCache struct {
+	now func() time.Time // injectable for tests
+
+	mu      sync.Mutex
+	entries map[string]*altSvcEntry
+}
+
+func newAltSvcCache() *altSvcCache {
+	return &altSvcCache{now: time.Now, entries: make(map[string]*altSvcEntry)}
+}
+
+// lookup returns the h3 authority to dial for host, or ok=false when the
+// host never advertised h3, the advertisement expired, or the alternative
+// is currently marked broken.
+func (c *altSvcCache) lookup(host string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[host]
+	if e == nil {
+		return "", false
+	}
+	now := c.now()
+	if now.After(e.expires) {
+		delete(c.entries, host)
+		return "", false
+	}
+	if now.Before(e.brokenUntil) {
+		return "", false
+	}
+	altHost, port, _ := net.SplitHostPort(e.authority)
+	if altHost == "" {
+		altHost = host
+	}
+	return net.JoinHostPort(altHost, port), true
+}
+
+// learn records the Alt-Svc header values of a response from host.
+// "clear" drops the entry; an h3 alternative (re)arms it, keeping any
+// broken back-off state so a re-advertisement can't bypass it.
+func (c *altSvcCache) learn(host string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	authority, maxAge, clear := parseAltSvc(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if clear {
+		delete(c.entries, host)
+		return
+	}
+	if authority == "" {
+		return
+	}
+	e := c.entries[host]
+	if e == nil {
+		if len(c.entries) >= altSvcMaxHosts {
+			c.evictLocked()
+		}
+		e = &altSvcEntry{}
+		c.entries[host] = e
+	}
+	e.authority = authority
+	e.expires = c.now().Add(maxAge)
+}
+
+// markBroken backs the host's alternative off exponentially.
+func (c *altSvcCache) markBroken(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[host]
+	if e == nil {
+		return
+	}
+	d := altSvcBrokenBase << e.brokenCount
+	if d <= 0 || d > altSvcBrokenMax {
+		d = altSvcBrokenMax
+	} else {
+		e.brokenCount++
+	}
+	e.brokenUntil = c.now().Add(d)
+}
+
+// markWorking clears the broken back-off after a successful h3 exchange.
+func (c *altSvcCache) markWorking(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[host]; e != nil {
+		e.brokenCount = 0
+		e.brokenUntil = time.Time{}
+	}
+}
+
+// evictLocked makes room for one entry: expired ones first, otherwise an
+// arbitrary one (map order). Caller holds c.mu.
+func (c *altSvcCache) evictLocked() {
+	now := c.now()
+	for h, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, h)
+		}
+	}
+	if len(c.entries) < altSvcMaxHosts {
+		return
+	}
+	for h := range c.entries {
+		delete(c.entries, h)
+		return
+	}
+}
+
+// parseAltSvc extracts the first "h3" alternative from Alt-Svc header
+// values (RFC 7838). Returns its authority ("host:port", host possibly
+// empty), its max age, and whether the header was the literal "clear".
+// Drafts (h3-29 …) are ignored: quic-go speaks only the RFC versions.
+func parseAltSvc(values []string) (authority string, maxAge time.Duration, clear bool) {
+	for _, v := range values {
+		if strings.TrimSpace(v) == "clear" {
+			return "", 0, true
+		}
+		for _, alt := range strings.Split(v, ",") {
+			params := strings.Split(alt, ";")
+			proto, val, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+			if !ok || proto != "h3" {
+				continue
+			}
+			val = strings.Trim(val, `"`)
+			host, port, err := net.SplitHostPort(val)
+			if err != nil {
+				continue
+			}
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				continue
+			}
+			age := altSvcDefaultMaxAge
+			for _, p := range params[1:] {
+				k, pv, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if k != "ma" {
+					continue
+				}
+				if secs, err := strconv.Atoi(strings.Trim(pv, `"`)); err == nil && secs >= 0 {
+					age = time.Duration(secs) * time.Second
+				}
+			}
+			return net.JoinHostPort(host, port), age, false
+		}
+	}
+	return "", 0, false
+}
+
+// dialQUIC is the http3.Transport Dial hook: it opens a UDP socket via the
+// injected PacketDialFunc (so QUIC follows the tunnel exactly like TCP),
+// redirects to the host's Alt-Svc authority, and ties the socket's lifetime
+// to the QUIC connection's (quic.Dial never closes a caller-supplied conn).
+func (t *ImpersonatingTransport) dialQUIC(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, h3DialError{err}
+	}
+	if alt, ok := t.altSvc.lookup(host); ok {
+		addr = alt
+	}
+	dctx, cancel := context.WithTimeout(ctx, h3DialTimeout)
+	defer cancel()
+	udpAddr, err := resolveUDP(dctx, addr)
+	if err != nil {
+		return nil, h3DialError{err}
+	}
+	pc, err := t.dialUDP(dctx)
+	if err != nil {
+		return nil, h3DialError{err}
+	}
+	conn, err := quic.Dial(dctx, pc, udpAddr, tlsCfg, cfg)
+	if err != nil {
+		_ = pc.Close()
+		return nil, h3DialError{err}
+	}
+	go func() {
+		<-conn.Context().Done()
+		_ = pc.Close()
+	}()
+	return conn, nil
+}
+
+// h3DialError marks a failure to set up the QUIC connection: no request
+// was written, so any method can be replayed over h2.
+type h3DialError struct{ err error }
+
+func (e h3DialError) Error() string { return e.err.Error() }
+func (e h3DialError) Unwrap() error { return e.err }
+
+// h3NotSent reports whether err from the h3 leg guarantees the request
+// never left the client.
+func h3NotSent(err error) bool {
+	var de h3DialError
+	return errors.As(err, &de)
+}
+
+// resolveUDP resolves addr honouring ctx (net.ResolveUDPAddr does not).
+func resolveUDP(ctx context.Context, addr string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	// Prefer IPv4, like the rest of the pod (tunnels are v4-first).
+	pick := ips[0]
+	for _, ip := range ips {
+		if ip.Is4() || ip.Is4In6() {
+			pick = ip.Unmap()
+			break
+		}
+	}
+	return &net.UDPAddr{IP: pick.AsSlice(), Port: p}, nil
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	utls "github.com/refraction-networking/utls"
+)
+
+func TestParseAltSvc(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		in        []string
+		authority string
+		maxAge    time.Duration
+		clear     bool
+	}{
+		{"h3 with ma", []string{`h3=":443"; ma=86400`}, ":443", 24 * time.Hour, false},
+		{"default ma", []string{`h3=":8443"`}, ":8443", altSvcDefaultMaxAge, false},
+		{"alt host", []string{`h3="alt.example.com:443"; ma=60`}, "alt.example.com:443", time.Minute, false},
+		{"drafts skipped", []string{`h3-29=":443"; ma=10, h3=":4433"; ma=20`}, ":4433", 20 * time.Second, false},
+		{"second header value", []string{`h2=":443"`, `h3=":443"`}, ":443", altSvcDefaultMaxAge, false},
+		{"no h3", []string{`h2=":443"; ma=60`}, "", 0, false},
+		{"bad port", []string{`h3=":99999"`}, "", 0, false},
+		{"clear", []string{"clear"}, "", 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, age, clear := parseAltSvc(tc.in)
+			if a != tc.authority || age != tc.maxAge || clear != tc.clear {
+				t.Fatalf("parseAltSvc(%q) = (%q, %s, %t), want (%q, %s, %t)",
+					tc.in, a, age, clear, tc.authority, tc.maxAge, tc.clear)
+			}
+		})
+	}
+}
+
+// A failed alternative must back off exponentially and be re-enabled by a
+// success; expiry and "clear" must drop it.
+func TestAltSvcCache_BrokenBackoffAndExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := newAltSvcCache()
+	c.now = func() time.Time { return now }
+
+	c.learn("example.com", []string{`h3=":443"; ma=3600`})
+	if got, ok := c.lookup("example.com"); !ok || got != "example.com:443" {
+		t.Fatalf("lookup = (%q, %t), want (example.com:443, true)", got, ok)
+	}
+
+	c.markBroken("example.com")
+	if _, ok := c.lookup("example.com"); ok {
+		t.Fatal("alternative still offered right after markBroken")
+	}
+	now = now.Add(altSvcBrokenBase + time.Second)
+	if _, ok := c.lookup("example.com"); !ok {
+		t.Fatal("alternative not re-offered after the first back-off")
+	}
+	c.markBroken("example.com")
+	now = now.Add(altSvcBrokenBase + time.Second)
+	if _, ok := c.lookup("example.com"); ok {
+		t.Fatal("second failure must back off longer than the first")
+	}
+	now = now.Add(altSvcBrokenBase)
+	c.markWorking("example.com")
+	if _, ok := c.lookup("example.com"); !ok {
+		t.Fatal("markWorking must clear the back-off")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := c.lookup("example.com"); ok {
+		t.Fatal("expired advertisement still offered")
+	}
+
+	c.learn("example.com", []string{`h3=":443"`})
+	c.learn("example.com", []string{"clear"})
+	if _, ok := c.lookup("example.com"); ok {
+		t.Fatal(`"clear" did not drop the alternative`)
+	}
+}
+
+func TestQuicProfileFor(t *testing.T) {
+	if _, ok := quicProfileFor(utls.HelloChrome_120); !ok {
+		t.Error("Chrome preset must have a QUIC profile")
+	}
+	if _, ok := quicProfileFor(utls.HelloFirefox_120); !ok {
+		t.Error("Firefox preset must have a QUIC profile")
+	}
+	if _, ok := quicProfileFor(utls.HelloSafari_16_0); ok {
+		t.Error("Safari 16 preset must stay on h2 (h3 was off by default)")
+	}
+}
+
+// startH3Origin serves h on both an h2 TLS listener and an h3 UDP listener,
+// with the TCP side advertising the UDP port via Alt-Svc — the shape of a
+// CDN edge. Returns the TCP server's URL.
+func startH3Origin(t *testing.T, h http.Handler) string {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpPort := udp.LocalAddr().(*net.UDPAddr).Port
+
+	tcp := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%d"; ma=3600`, udpPort))
+		h.ServeHTTP(w, r)
+	}))
+	tcp.EnableHTTP2 = true
+	tcp.StartTLS()
+	t.Cleanup(tcp.Close)
+
+	h3srv := &http3.Server{Handler: h, TLSConfig: http3.ConfigureTLSConfig(tcp.TLS.Clone())}
+	go func() { _ = h3srv.Serve(udp) }()
+	t.Cleanup(func() { _ = h3srv.Close(); _ = udp.Close() })
+	return tcp.URL
+}
+
+func protoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	})
+}
+
+// First visit goes over h2 and learns Alt-Svc; the next request to the same
+// host must ride h3 through the injected UDP dialer.
+func TestImpersonatingTransport_UpgradesToH3ViaAltSvc(t *testing.T) {
+	base := startH3Origin(t, protoHandler())
+
+	tr := newTestTransport(utls.HelloChrome_120)
+	var udpDials atomic.Int32
+	tr.EnableHTTP3(func(ctx context.Context) (net.PacketConn, error) {
+		udpDials.Add(1)
+		var lc net.ListenConfig
+		return lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
+	})
+
+	for i, want := range []int{2, 3, 3} {
+		req, _ := http.NewRequest(http.MethodGet, base+"/p", nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("round trip %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != want {
+			t.Fatalf("round trip %d: proto %d (%s), want %d", i, resp.ProtoMajor, body, want)
+		}
+	}
+	if n := udpDials.Load(); n != 1 {
+		t.Fatalf("UDP dialer called %d times, want 1 (one reused QUIC conn)", n)
+	}
+}
+
+// When UDP can't get out, the request must still succeed over h2 — even a
+// POST, since a failed dial sent nothing — and the host must not be
+// re-probed on the very next request.
+func TestImpersonatingTransport_FallsBackToH2WhenUDPBlocked(t *testing.T) {
+	base := startH3Origin(t, protoHandler())
+
+	tr := newTestTransport(utls.HelloChrome_120)
+	var udpDials atomic.Int32
+	tr.EnableHTTP3(func(context.Context) (net.PacketConn, error) {
+		udpDials.Add(1)
+		return nil, errors.New("udp blocked (test)")
+	})
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodPost, base+"/p", strings.NewReader("x"))
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("round trip %d: %v — a blocked UDP path must fall back to h2", i, err)
+		}
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("round trip %d: proto %d, want h2", i, resp.ProtoMajor)
+		}
+	}
+	if n := udpDials.Load(); n != 1 {
+		t.Fatalf("UDP dialer called %d times, want 1 (broken alternative must back off)", n)
+	}
+}
+
+// An h3 origin that took the request and then reset the stream may have
+// acted on it: a POST must surface the error rather than replay over h2,
+// while a GET may still fall back.
+func TestImpersonatingTransport_NoH2ReplayOfSentPost(t *testing.T) {
+	var h3Hits, h2Posts atomic.Int32
+	base := startH3Origin(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 3 {
+			if r.Method == http.MethodPost {
+				h2Posts.Add(1)
+			}
+			_, _ = io.WriteString(w, r.Proto)
+			return
+		}
+		h3Hits.Add(1)
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.(http3.HTTPStreamer).HTTPStream().CancelWrite(quic.StreamErrorCode(http3.ErrCodeInternalError))
+	}))
+
+	tr := newTestTransport(utls.HelloChrome_120)
+	tr.EnableHTTP3(func(ctx context.Context) (net.PacketConn, error) {
+		var lc net.ListenConfig
+		return lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
+	})
+	get := func() *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, base+"/p", nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	get() // learn Alt-Svc over h2
+	req, _ := http.NewRequest(http.MethodPost, base+"/p", strings.NewReader("charge=1"))
+	if resp, err := tr.RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("POST succeeded over %s, want the h3 reset surfaced", resp.Proto)
+	}
+	if n := h3Hits.Load(); n != 1 {
+		t.Fatalf("h3 saw %d requests, want 1", n)
+	}
+	if n := h2Posts.Load(); n != 0 {
+		t.Fatalf("POST replayed over h2 %d times after reaching the origin", n)
+	}
+
+	// Re-learn the alternative, then check an idempotent request still
+	// falls back.
+	tr.altSvc.markWorking(req.URL.Hostname())
+	if resp := get(); resp.ProtoMajor != 2 {
+		t.Fatalf("GET proto %d, want the h2 fallback", resp.ProtoMajor)
+	}
+	if n := h3Hits.Load(); n != 2 {
+		t.Fatalf("h3 saw %d requests, want 2", n)
+	}
+}
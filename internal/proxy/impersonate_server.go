@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	utls "github.com/refraction-networking/utls"
@@ -54,6 +55,16 @@ type ImpersonateServer struct {
 	addr      string
 	transport *ImpersonatingTransport
 	hello     utls.ClientHelloID
+
+	draining atomic.Bool // when true, refuse new fetches with 503
+
+	// stats — same shape and meaning as Server's, per request instead of
+	// per CONNECT tunnel.
+	totalRequests atomic.Uint64
+	totalSuccess  atomic.Uint64
+	totalError    atomic.Uint64
+	totalDraining atomic.Uint64
+	openRequests  atomic.Int64
 }
 
 // NewImpersonateServer builds a server bound to addr. podName selects this
@@ -74,6 +85,64 @@ func NewImpersonateServer(addr, podName string, dial dialFunc) *ImpersonateServe
 	}
 }
 
+// EnableHTTP3 lets the upstream leg speak h3 to hosts that advertise it via
+// Alt-Svc. dial must open UDP sockets on the same egress as the TCP dialer;
+// nil means a plain unconnected socket on the default route, which in a
+// kernel-tunnel pod is the VPN. Never enable it for proxy-chain providers:
+// their only egress is the upstream proxy, and a bare UDP socket would leave
+// via the node IP. The QUIC hello is not impersonated (see quicProfile), so
+// h3 exposes a Go TLS fingerprint: keep it off unless the operator asks.
+func (s *ImpersonateServer) EnableHTTP3(dial PacketDialFunc) {
+	if dial == nil {
+		dial = func(ctx context.Context) (net.PacketConn, error) {
+			var lc net.ListenConfig
+			return lc.ListenPacket(ctx, "udp", ":0")
+		}
+	}
+	s.transport.EnableHTTP3(dial)
+}
+
+// SetDraining toggles drain mode, mirroring Server.SetDraining: while
+// draining, in-flight fetches finish but new ones get 503 so the client
+// retries on another tunnel pod. Leaving drain mode also drops every pooled
+// upstream conn — they were opened through the tunnel that was just torn
+// down, and reusing one would egress from the previous exit.
+func (s *ImpersonateServer) SetDraining(draining bool) {
+	if !s.draining.Swap(draining) || draining {
+		return
+	}
+	s.transport.closeAll()
+}
+
+// IsDraining reports the current drain flag.
+func (s *ImpersonateServer) IsDraining() bool { return s.draining.Load() }
+
+// IncOpenRequests adjusts the open-request counter by delta. Test seam
+// for the drain controller — production ServeHTTP tracks it itself.
+func (s *ImpersonateServer) IncOpenRequests(delta int64) { s.openRequests.Add(delta) }
+
+// Stats returns a snapshot of cumulative counters, for /status.
+func (s *ImpersonateServer) Stats() ImpersonateStats {
+	return ImpersonateStats{
+		TotalRequests: s.totalRequests.Load(),
+		TotalSuccess:  s.totalSuccess.Load(),
+		TotalError:    s.totalError.Load(),
+		TotalDraining: s.totalDraining.Load(),
+		OpenRequests:  s.openRequests.Load(),
+	}
+}
+
+// ImpersonateStats is the counter snapshot returned by
+// ImpersonateServer.Stats. Success means the upstream answered (whatever
+// its status code); error covers rejected requests and upstream failures.
+type ImpersonateStats struct {
+	TotalRequests uint64 `json:"total_requests"`
+	TotalSuccess  uint64 `json:"total_success"`
+	TotalError    uint64 `json:"total_error"`
+	TotalDraining uint64 `json:"total_draining"`
+	OpenRequests  int64  `json:"open_requests"`
+}
+
 // Profile reports the browser preset this pod presents (for logging/metrics).
 func (s *ImpersonateServer) Profile() string { return s.hello.Str() }
 
@@ -90,7 +159,7 @@ func (s *ImpersonateServer) Serve(ctx context.Context) error {
 		defer cancel()
 		_ = srv.Shutdown(shutCtx)
 	}()
-	log.Printf("impersonate-proxy: listening on %s as %s", s.addr, s.hello.Str())
+	log.Printf("impersonate-proxy: listening on %s as %s (h3=%t)", s.addr, s.hello.Str(), s.transport.HTTP3Enabled())
 	err := srv.ListenAndServe()
 	if err == http.ErrServerClosed {
 		return nil
@@ -106,8 +175,20 @@ var hopByHop = map[string]bool{
 }
 
 func (s *ImpersonateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.totalRequests.Add(1)
+	if s.draining.Load() {
+		s.totalDraining.Add(1)
+		http.Error(w, "Service Unavailable (draining)", http.StatusServiceUnavailable)
+		return
+	}
+	// Counted open from here until the upstream body is fully relayed, so
+	// the drain controller waits for streaming responses too.
+	s.openRequests.Add(1)
+	defer s.openRequests.Add(-1)
+
 	host := strings.TrimSpace(r.Header.Get(TargetHostHeader))
 	if host == "" {
+		s.totalError.Add(1)
 		http.Error(w, TargetHostHeader+" is required (names the upstream host to fetch)",
 			http.StatusBadRequest)
 		return
@@ -115,6 +196,7 @@ func (s *ImpersonateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Defend the upstream leg from a malformed/hostile header: a value carrying
 	// a scheme, path or port would otherwise be pasted straight into the URL.
 	if strings.ContainsAny(host, "/\\ :") {
+		s.totalError.Add(1)
 		http.Error(w, TargetHostHeader+" must be a bare host name", http.StatusBadRequest)
 		return
 	}
@@ -122,6 +204,7 @@ func (s *ImpersonateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	target := url.URL{Scheme: "https", Host: host, Opaque: "", Path: r.URL.Path, RawQuery: r.URL.RawQuery}
 	outReq, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), r.Body)
 	if err != nil {
+		s.totalError.Add(1)
 		http.Error(w, "bad target: "+err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -133,10 +216,12 @@ func (s *ImpersonateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		// 502 is what a gateway owes its client when the upstream leg fails;
 		// the caller's retry/backoff treats it like any transient tunnel error.
+		s.totalError.Add(1)
 		http.Error(w, "upstream error: "+err.Error(), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	s.totalSuccess.Add(1)
 
 	copyHeaders(w.Header(), resp.Header)
 	w.WriteHeader(resp.StatusCode)
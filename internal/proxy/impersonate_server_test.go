@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -129,6 +130,93 @@ func TestImpersonateServer_RejectsBadTargetHeader(t *testing.T) {
 	}
 }
 
+// Draining must bounce new fetches with 503 without touching the upstream,
+// and the counters must tell the three outcomes apart.
+func TestImpersonateServer_DrainingAndStats(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	bu, _ := url.Parse(backend.URL)
+
+	srv, addr := startFetchServer(t, bu.Host)
+	fetch := func(target string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/x", nil)
+		if target != "" {
+			req.Header.Set(TargetHostHeader, target)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := fetch("example.com"); got != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204 relayed", got)
+	}
+	if got := fetch(""); got != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", got)
+	}
+	srv.SetDraining(true)
+	if got := fetch("example.com"); got != http.StatusServiceUnavailable {
+		t.Fatalf("status while draining = %d, want 503", got)
+	}
+	srv.SetDraining(false)
+	if got := fetch("example.com"); got != http.StatusNoContent {
+		t.Fatalf("status after drain = %d, want 204", got)
+	}
+
+	if n := hits.Load(); n != 2 {
+		t.Errorf("upstream hits = %d, want 2 (drained request must not reach it)", n)
+	}
+	want := ImpersonateStats{TotalRequests: 4, TotalSuccess: 2, TotalError: 1, TotalDraining: 1}
+	if got := srv.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+// Leaving drain mode must drop pooled upstream conns: they were dialled
+// through the tunnel the rotation just tore down.
+func TestImpersonateServer_UndrainDropsPooledConns(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	bu, _ := url.Parse(backend.URL)
+
+	srv, addr := startFetchServer(t, bu.Host)
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/x", nil)
+	req.Header.Set(TargetHostHeader, "example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+
+	pooled := func() int {
+		srv.transport.mu.Lock()
+		defer srv.transport.mu.Unlock()
+		return len(srv.transport.conns)
+	}
+	if n := pooled(); n != 1 {
+		t.Fatalf("pooled conns after fetch = %d, want 1", n)
+	}
+	srv.SetDraining(true)
+	srv.SetDraining(false)
+	if n := pooled(); n != 0 {
+		t.Fatalf("pooled conns after drain = %d, want 0", n)
+	}
+}
+
 func TestPickProfile_ServerReportsItsBrowser(t *testing.T) {
 	s := NewImpersonateServer("127.0.0.1:0", "tundler-tunnel-mullvad-0", nil)
 	if s.Profile() == "" || !strings.Contains(s.Profile(), "-") {
@@ -3,12 +3,15 @@ package proxy
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"sync"
 
+	"github.com/quic-go/quic-go/http3"
 	utls "github.com/refraction-networking/utls"
 	"golang.org/x/net/http2"
 )
@@ -34,11 +37,24 @@ type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
 // request for that host over a single handshake — so the ~100-300 ms VPN TLS
 // cost is paid once, not per request. A dead conn is dropped and re-handshaked
 // on the next call.
+//
+// HTTP/3: once EnableHTTP3 is called, a host that advertises h3 via Alt-Svc is
+// fetched over QUIC on subsequent requests, as a browser would after its first
+// visit. The QUIC leg dials UDP through the injected PacketDialFunc (same
+// tunnel as TCP) with the preset's browser QUIC profile. Any h3 failure marks
+// the host's alternative broken with exponential back-off and the request is
+// retried over h2 — a path that drops UDP degrades to today's behaviour.
 type ImpersonatingTransport struct {
 	dial  dialFunc
 	hello utls.ClientHelloID
 	h2    *http2.Transport
 
+	// h3 is nil until EnableHTTP3; dialUDP feeds its Dial hook and altSvc
+	// records which hosts advertised h3.
+	h3      *http3.Transport
+	dialUDP PacketDialFunc
+	altSvc  *altSvcCache
+
 	// insecure skips upstream cert verification — set ONLY by tests (against
 	// self-signed httptest certs). Production leaves it false: impersonating a
 	// browser is pointless if we don't also validate like one.
@@ -52,13 +68,33 @@ type ImpersonatingTransport struct {
 // the given browser preset (see PickProfile).
 func NewImpersonatingTransport(dial dialFunc, hello utls.ClientHelloID) *ImpersonatingTransport {
 	return &ImpersonatingTransport{
-		dial:  dial,
-		hello: hello,
-		h2:    &http2.Transport{},
-		conns: make(map[string]*http2.ClientConn),
+		dial:   dial,
+		hello:  hello,
+		h2:     &http2.Transport{},
+		conns:  make(map[string]*http2.ClientConn),
+		altSvc: newAltSvcCache(),
+	}
+}
+
+// EnableHTTP3 turns on Alt-Svc-driven h3 origination, dialing UDP via dial.
+// It is a no-op for presets whose browser doesn't speak h3 by default (see
+// quicProfileFor). Call before the first RoundTrip; not safe to race with it.
+func (t *ImpersonatingTransport) EnableHTTP3(dial PacketDialFunc) {
+	profile, ok := quicProfileFor(t.hello)
+	if !ok || dial == nil {
+		return
+	}
+	t.dialUDP = dial
+	t.h3 = &http3.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: t.insecure},
+		QUICConfig:      profile.config(),
+		Dial:            t.dialQUIC,
 	}
 }
 
+// HTTP3Enabled reports whether EnableHTTP3 took effect.
+func (t *ImpersonatingTransport) HTTP3Enabled() bool { return t.h3 != nil }
+
 // RoundTrip implements http.RoundTripper.
 func (t *ImpersonatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	host := req.URL.Hostname()
@@ -66,6 +102,41 @@ func (t *ImpersonatingTransport) RoundTrip(req *http.Request) (*http.Response, e
 		return nil, fmt.Errorf("impersonate: request URL has no host: %q", req.URL)
 	}
 
+	if t.h3 != nil && replayable(req) {
+		if _, ok := t.altSvc.lookup(host); ok {
+			resp, err := t.h3.RoundTrip(req)
+			if err == nil {
+				t.altSvc.markWorking(host)
+				t.altSvc.learn(host, resp.Header.Values("Alt-Svc"))
+				return resp, nil
+			}
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			// UDP blocked, QUIC handshake timed out, or the h3 conn died:
+			// back the alternative off and replay the request over TCP.
+			// A request that may have reached the origin is only replayed
+			// when its method is idempotent.
+			t.altSvc.markBroken(host)
+			if !idempotent(req.Method) && !h3NotSent(err) {
+				return nil, err
+			}
+			log.Printf("impersonate: h3 to %s failed, falling back to h2: %v", host, err)
+			if req, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	resp, err := t.roundTripTCP(req, host)
+	if err == nil && t.h3 != nil {
+		t.altSvc.learn(host, resp.Header.Values("Alt-Svc"))
+	}
+	return resp, err
+}
+
+// roundTripTCP is the h2 (or one-shot h1) leg over a uTLS handshake.
+func (t *ImpersonatingTransport) roundTripTCP(req *http.Request, host string) (*http.Response, error) {
 	// Fast path: reuse a live cached h2 conn for this host.
 	if cc := t.cachedH2(host); cc != nil {
 		resp, err := cc.RoundTrip(req)
@@ -149,6 +220,37 @@ func (t *ImpersonatingTransport) dropH2(host string, cc *http2.ClientConn) {
 	_ = cc.Close()
 }
 
+// replayable reports whether req can be re-sent after a failed h3 attempt:
+// no body, or a body GetBody can recreate. Other requests skip h3 entirely
+// rather than risk a half-consumed body.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// idempotent reports whether a request with this method is safe to send
+// twice (RFC 9110 §9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rewind returns req ready to send again, with a fresh body when it has one.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("impersonate: rewind body for h2 fallback: %w", err)
+	}
+	out := req.Clone(req.Context())
+	out.Body = body
+	return out, nil
+}
+
 func portOr(p, def string) string {
 	if p == "" {
 		return def
@@ -167,3 +269,21 @@ func (b *closingBody) Close() error {
 	_ = b.conn.Close()
 	return err
 }
+
+// closeAll drops every pooled upstream conn, h2 and h3. The self-test
+// needs it because its transport is throwaway and must not leak a conn per
+// call; the server needs it after a drain, because a conn pooled before a
+// rotation still rides the OLD tunnel (or the old upstream proxy, for
+// proxy-chain providers) and would keep egressing from the old exit.
+func (t *ImpersonatingTransport) closeAll() {
+	t.mu.Lock()
+	conns := t.conns
+	t.conns = make(map[string]*http2.ClientConn)
+	t.mu.Unlock()
+	for _, cc := range conns {
+		_ = cc.Close()
+	}
+	if t.h3 != nil {
+		t.h3.CloseIdleConnections()
+	}
+}
@@ -71,8 +71,9 @@ type Server struct {
 	podName string
 	nodeIP  string
 
-	exitIP   atomic.Value // string; updated by SetExitIP
-	draining atomic.Bool  // when true, refuse new CONNECTs with 503
+	exitIP   atomic.Value            // string; updated by SetExitIP
+	exitGeo  atomic.Pointer[ExitGeo] // updated by SetExitGeo; nil = no headers
+	draining atomic.Bool             // when true, refuse new CONNECTs with 503
 
 	// dial is an optional override for how the proxy reaches the
 	// upstream target. Nil (the default for every kernel-tunnel
@@ -123,9 +124,16 @@ type Server struct {
 	// success — reset on any success. The watchdog only attempts a
 	// reconnect when this exceeds a small threshold (so a single
 	// transient upstream blip doesn't trigger needless rotation).
+	//
+	// lastDialOKAtUnixNano is the time of the most recent SUCCESSFUL
+	// dial, for the readiness check's "last successful dial age".
 	lastDialAtUnixNano   atomic.Int64
 	lastDialOK           atomic.Bool
+	lastDialOKAtUnixNano atomic.Int64
 	consecutiveDialFails atomic.Int64
+
+	// listening is true while Serve holds its listener.
+	listening atomic.Bool
 }
 
 // DialFunc reaches an upstream target (host:port) on the proxy's
@@ -141,6 +149,7 @@ type DialFunc func(ctx context.Context, target string) (net.Conn, error)
 type TunnelHealth struct {
 	LastDialAt          time.Time
 	LastDialSucceeded   bool
+	LastSuccessAt       time.Time
 	ConsecutiveFailures int64
 }
 
@@ -165,6 +174,18 @@ func New(addr, podName, nodeIP string) *Server {
 // omitted from responses entirely.
 func (s *Server) SetExitIP(ip string) { s.exitIP.Store(ip) }
 
+// ExitGeo is the exit's geolocation as the CONNECT response reports it:
+// x-tundler-exit-country (ISO-3166 alpha-2) and
+// x-tundler-exit-country-check (match, mismatch or unknown).
+type ExitGeo struct {
+	Country string
+	Check   string
+}
+
+// SetExitGeo updates the exit geolocation headers. Like SetExitIP it
+// takes effect on the next CONNECT response; nil omits them.
+func (s *Server) SetExitGeo(g *ExitGeo) { s.exitGeo.Store(g) }
+
 // SetDialer installs a custom upstream dialer (proxy-chain providers).
 // Passing nil restores the default direct dial. Safe to call from any
 // goroutine; takes effect on the next CONNECT. Providers set this on
@@ -220,9 +241,11 @@ func (s *Server) IncOpenTunnels(delta int64) { s.openTunnels.Add(delta) }
 // All three fields are atomic so this is safe to call from many
 // goroutines without locking.
 func (s *Server) recordDial(success bool) {
-	s.lastDialAtUnixNano.Store(time.Now().UnixNano())
+	now := time.Now().UnixNano()
+	s.lastDialAtUnixNano.Store(now)
 	s.lastDialOK.Store(success)
 	if success {
+		s.lastDialOKAtUnixNano.Store(now)
 		s.consecutiveDialFails.Store(0)
 	} else {
 		s.consecutiveDialFails.Add(1)
@@ -240,17 +263,26 @@ func (s *Server) SeedDialOutcome(success bool) { s.recordDial(success) }
 // the watchdog. Returned LastDialAt is the zero value when no dial
 // has happened yet — the watchdog treats that as "no signal."
 func (s *Server) RecentTunnelHealth() TunnelHealth {
-	var t time.Time
-	if ns := s.lastDialAtUnixNano.Load(); ns != 0 {
-		t = time.Unix(0, ns)
-	}
 	return TunnelHealth{
-		LastDialAt:          t,
+		LastDialAt:          unixNanoTime(s.lastDialAtUnixNano.Load()),
 		LastDialSucceeded:   s.lastDialOK.Load(),
+		LastSuccessAt:       unixNanoTime(s.lastDialOKAtUnixNano.Load()),
 		ConsecutiveFailures: s.consecutiveDialFails.Load(),
 	}
 }
 
+// unixNanoTime is time.Unix(0, ns), with 0 mapped to the zero Time.
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Listening reports whether Serve has bound its listener and is still
+// accepting. Read by the readiness check.
+func (s *Server) Listening() bool { return s.listening.Load() }
+
 // Stats returns a snapshot of cumulative counters. Useful for log
 // reporting and exposing via the existing /status JSON endpoint.
 func (s *Server) Stats() Stats {
@@ -282,6 +314,8 @@ func (s *Server) Serve(ctx context.Context) error {
 		return err
 	}
 	s.listener = ln
+	s.listening.Store(true)
+	defer s.listening.Store(false)
 	log.Printf("proxy: listening on %s", s.addr)
 
 	// Close listener when ctx done so Accept unblocks.
@@ -373,7 +407,7 @@ func (s *Server) handle(client net.Conn) {
 	// tundler-* headers. Spec: "200 OK" or "200 Connection
 	// established" both accepted by clients in practice; envoy uses
 	// the latter so we match for consistency.
-	if err := writeConnectResponse(client, s.podName, s.nodeIP, s.exitIP.Load().(string)); err != nil {
+	if err := writeConnectResponse(client, s.podName, s.nodeIP, s.exitIP.Load().(string), s.exitGeo.Load()); err != nil {
 		s.totalError.Add(1)
 		return
 	}
@@ -443,7 +477,7 @@ func parseConnect(br *bufio.Reader) (string, error) {
 // plus tundler-* headers, then the empty-line terminator. Matches
 // envoy's CONNECT response shape (which the crawler / hub envoy
 // already parse correctly).
-func writeConnectResponse(w io.Writer, podName, nodeIP, exitIP string) error {
+func writeConnectResponse(w io.Writer, podName, nodeIP, exitIP string, geo *ExitGeo) error {
 	var sb strings.Builder
 	sb.WriteString("HTTP/1.1 200 Connection established\r\n")
 	if podName != "" {
@@ -461,6 +495,18 @@ func writeConnectResponse(w io.Writer, podName, nodeIP, exitIP string) error {
 		sb.WriteString(exitIP)
 		sb.WriteString("\r\n")
 	}
+	if geo != nil {
+		if geo.Country != "" {
+			sb.WriteString("x-tundler-exit-country: ")
+			sb.WriteString(geo.Country)
+			sb.WriteString("\r\n")
+		}
+		if geo.Check != "" {
+			sb.WriteString("x-tundler-exit-country-check: ")
+			sb.WriteString(geo.Check)
+			sb.WriteString("\r\n")
+		}
+	}
 	sb.WriteString("\r\n")
 	_, err := w.Write([]byte(sb.String()))
 	return err
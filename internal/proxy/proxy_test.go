@@ -123,6 +123,9 @@ func TestConnect_TunnelsBytesAndInjectsHeaders(t *testing.T) {
 	if s.TotalConnect == 0 || s.TotalSuccess == 0 {
 		t.Fatalf("stats not updated: %+v", s)
 	}
+	if h := srv.RecentTunnelHealth(); h.LastSuccessAt.IsZero() || !srv.Listening() {
+		t.Fatalf("health=%+v listening=%t, want a recorded successful dial on a bound listener", h, srv.Listening())
+	}
 }
 
 func TestConnect_DrainingReturns503(t *testing.T) {
@@ -213,3 +216,20 @@ func TestSetExitIP_TakesEffectOnNextResponse(t *testing.T) {
 		t.Fatalf("exitIP not cleared: %s", got)
 	}
 }
+
+func TestWriteConnectResponse_ExitGeo(t *testing.T) {
+	var b strings.Builder
+	if err := writeConnectResponse(&b, "pod", "", "203.0.113.7", &ExitGeo{Country: "NL", Check: "mismatch"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"x-tundler-exit-country: NL\r\n", "x-tundler-exit-country-check: mismatch\r\n"} {
+		if !strings.Contains(b.String(), h) {
+			t.Errorf("response lacks %q:\n%s", h, b.String())
+		}
+	}
+	b.Reset()
+	_ = writeConnectResponse(&b, "pod", "", "203.0.113.7", nil)
+	if strings.Contains(b.String(), "exit-country") {
+		t.Errorf("unchecked exit reports a country:\n%s", b.String())
+	}
+}
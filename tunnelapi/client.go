@@ -0,0 +1,238 @@
+// Package tunnelapi is the Go client for a tundler-tunnel pod's :4242
+// control API: its wire types (shared with the server), a typed Client
+// for the endpoints a crawler slot uses (status, rotate, readiness), and
+// the API's OpenAPI document.
+package tunnelapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client defaults; every one can be changed on the Client after NewClient.
+const (
+	DefaultTimeout    = 10 * time.Second
+	DefaultMaxRetries = 2
+	DefaultBackoff    = 250 * time.Millisecond
+)
+
+// Client calls one pod's control API. Safe for concurrent use once
+// configured.
+type Client struct {
+	// BaseURL is the pod's API root, e.g.
+	// http://tundler-tunnel-3.tundler-tunnel:4242.
+	BaseURL string
+	// HTTPClient carries the requests (set its Transport for mTLS). Its
+	// Timeout bounds each attempt; Rotate with Wait needs a longer one.
+	HTTPClient *http.Client
+	// Token is sent as a bearer token when set.
+	Token string
+	// MaxRetries is how many times a retryable failure is retried; the
+	// first retry waits Backoff, doubling after each.
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+// NewClient returns a Client for baseURL with the package defaults.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// Status is the GET /status body: the Snapshot plus the pod's identity
+// and the sections a slot rarely needs, kept raw.
+type Status struct {
+	Snapshot
+	TunnelID string `json:"tunnel_id,omitempty"`
+	NodeIP   string `json:"node_ip,omitempty"`
+	// Proxy is the :8485/:8486 counters; Config the effective runtime
+	// config. Absent when the pod doesn't report them.
+	Proxy  json.RawMessage `json:"proxy,omitempty"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// RotateOptions are POST /rotate's parameters; the zero value asks for
+// an unconstrained rotation.
+type RotateOptions struct {
+	Location     string   `json:"location,omitempty"`
+	Country      string   `json:"country,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	AvoidExitIPs []string `json:"avoid_exit_ips,omitempty"`
+	// IfExitIP / IfGeneration make the rotation conditional on the pod
+	// still being on the tunnel the caller observed.
+	IfExitIP     string `json:"if_exit_ip,omitempty"`
+	IfGeneration uint64 `json:"if_generation,omitempty"`
+	// Wait blocks until the rotation finishes (server-side up to
+	// Timeout, default 2m). Give the HTTPClient a longer timeout.
+	Wait    bool          `json:"wait,omitempty"`
+	Timeout time.Duration `json:"-"`
+}
+
+// errMalformed wraps a 2xx body that didn't decode into the expected type.
+var errMalformed = errors.New("tunnelapi: malformed response")
+
+// Error is a non-2xx answer. Problem is decoded from an
+// application/problem+json body; any other body (e.g. /readyz's plain
+// text) lands in Problem.Detail with an empty Type.
+type Error struct {
+	StatusCode int
+	Problem
+}
+
+func (e *Error) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("tunnelapi: %d %s (%s): %s", e.StatusCode, e.Title, e.Type, e.Detail)
+	}
+	return fmt.Sprintf("tunnelapi: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Detail)
+}
+
+// ProblemType returns the problem type URI of an *Error in err's chain,
+// or "" — what callers dispatch on.
+func ProblemType(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Type
+	}
+	return ""
+}
+
+// Status fetches GET /status.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var s Status
+	if _, err := c.do(ctx, http.MethodGet, "/status", nil, true, &s, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Ready reports whether GET /readyz answers 200. A 503 is (false, nil);
+// only transport failures and unexpected statuses are errors.
+func (c *Client) Ready(ctx context.Context) (bool, error) {
+	code, err := c.do(ctx, http.MethodGet, "/readyz", nil, true, nil, http.StatusOK, http.StatusServiceUnavailable)
+	return code == http.StatusOK, err
+}
+
+// Rotate calls POST /rotate. State in the result is Rotating when the
+// rotation was accepted (202); Ready with a Message when it was
+// debounced or superseded; the new tunnel with Rotation set when Wait
+// was requested. Refusals (409, 422, 502, 504, ...) are *Error.
+//
+// Only transport failures, 429 and 503 are retried: the server dedups
+// concurrent requests, but 502/504 report a rotation's outcome.
+func (c *Client) Rotate(ctx context.Context, opts RotateOptions) (*RotateResult, error) {
+	body := struct {
+		RotateOptions
+		TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	}{opts, int(opts.Timeout / time.Second)}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var res RotateResult
+	if _, err := c.do(ctx, http.MethodPost, "/rotate", raw, false, &res, http.StatusOK, http.StatusAccepted); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// do runs one call with retries. ok lists the statuses that are answers
+// (decoded into out when it is non-nil); anything else becomes *Error.
+// idempotent calls also retry 500/502/504.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, idempotent bool, out any, ok ...int) (int, error) {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		code, err := c.once(ctx, method, path, body, out, ok)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err, idempotent) || ctx.Err() != nil {
+			return code, err
+		}
+		select {
+		case <-ctx.Done():
+			return code, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) once(ctx context.Context, method, path string, body []byte, out any, ok []int) (int, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, rd)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	for _, want := range ok {
+		if resp.StatusCode != want {
+			continue
+		}
+		if out != nil {
+			if err := json.Unmarshal(raw, out); err != nil {
+				return resp.StatusCode, fmt.Errorf("%w: %s %s: %v", errMalformed, method, path, err)
+			}
+		}
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, decodeError(resp, raw)
+}
+
+func decodeError(resp *http.Response, raw []byte) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt == "application/problem+json" {
+		if json.Unmarshal(raw, &e.Problem) == nil {
+			return e
+		}
+	}
+	e.Status = resp.StatusCode
+	e.Detail = strings.TrimSpace(string(raw))
+	return e
+}
+
+func retryable(err error, idempotent bool) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		// Transport failure (refused, reset, attempt timeout); a body
+		// that didn't decode won't decode next time either.
+		return !errors.Is(err, errMalformed)
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
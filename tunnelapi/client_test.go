@@ -0,0 +1,125 @@
+package tunnelapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL + "/")
+	c.Backoff = time.Millisecond
+	c.Token = "s3cret"
+	return c
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func TestStatus_RetriesUnavailable(t *testing.T) {
+	var calls atomic.Int32
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			t.Errorf("Authorization=%q", r.Header.Get("Authorization"))
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"state":"Ready","current_exit_ip":"1.2.3.4","tunnel_id":"tundler-tunnel-0","config":{"source":"env"}}`)
+	})
+	s, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 || s.State != StateReady || s.CurrentExitIP != "1.2.3.4" || s.TunnelID != "tundler-tunnel-0" || len(s.Config) == 0 {
+		t.Errorf("calls=%d status=%+v, want Ready on 1.2.3.4 after one retry", calls.Load(), s)
+	}
+}
+
+func TestRotate_DecodesProblemWithoutRetrying(t *testing.T) {
+	var calls atomic.Int32
+	var body map[string]any
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Method != http.MethodPost || r.URL.Path != "/rotate" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		writeProblem(w, Problem{
+			Type:   "https://tundler-tunnel/errors/connect-failed",
+			Title:  "Rotation failed",
+			Status: http.StatusBadGateway,
+			Detail: "no location connected",
+		})
+	})
+	_, err := c.Rotate(context.Background(), RotateOptions{Country: "DE", Wait: true, Timeout: 90 * time.Second})
+	if ProblemType(err) != "https://tundler-tunnel/errors/connect-failed" {
+		t.Fatalf("err=%v, want the connect-failed problem", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls=%d, want a 502 from /rotate not retried", calls.Load())
+	}
+	if body["country"] != "DE" || body["wait"] != true || body["timeout_seconds"] != float64(90) {
+		t.Errorf("body=%v, want country, wait and timeout_seconds", body)
+	}
+}
+
+func TestRotate_Accepted(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = io.WriteString(w, `{"state":"Rotating","previous_exit_ip":"1.2.3.4"}`)
+	})
+	res, err := c.Rotate(context.Background(), RotateOptions{})
+	if err != nil || res.State != StateRotating || res.PreviousExitIP != "1.2.3.4" {
+		t.Errorf("res=%+v err=%v, want Rotating from 1.2.3.4", res, err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	var calls atomic.Int32
+	var ready atomic.Bool
+	ready.Store(true)
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !ready.Load() {
+			http.Error(w, "not ready: state=Rotating", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ready\n")
+	})
+	if ok, err := c.Ready(context.Background()); !ok || err != nil {
+		t.Errorf("ready: got %t, %v", ok, err)
+	}
+	ready.Store(false)
+	if ok, err := c.Ready(context.Background()); ok || err != nil {
+		t.Errorf("not ready: got %t, %v, want false without an error", ok, err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls=%d, want a 503 readiness answer not retried", calls.Load())
+	}
+}
+
+func TestError_PlainBody(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	_, err := c.Status(context.Background())
+	e, ok := err.(*Error)
+	if !ok || e.StatusCode != http.StatusForbidden || e.Type != "" || e.Detail != "forbidden" {
+		t.Errorf("err=%#v, want a 403 *Error carrying the plain body", err)
+	}
+}
@@ -0,0 +1,18 @@
+package tunnelapi
+
+import (
+	"bytes"
+	_ "embed"
+)
+
+// openAPIDoc is checked against the server's routes and types by
+// cmd/tundler-tunnel's openapi_test.go.
+//
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// OpenAPI returns the OpenAPI 3.1 document (JSON) of the :4242 control
+// API; tundler-tunnel also serves it at GET /openapi.json.
+func OpenAPI() []byte {
+	return bytes.Clone(openAPIDoc)
+}
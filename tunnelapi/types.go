@@ -0,0 +1,229 @@
+package tunnelapi
+
+// Wire types of the :4242 control API. tundler-tunnel serves these very
+// types, so a client built on them cannot drift from the server.
+
+// State is the tunnel pod's lifecycle position: "state" in /status.
+// /readyz is 200 only in StateReady.
+type State string
+
+const (
+	StateBooting    State = "Booting"    // process started, awaiting boot-login jitter
+	StateLoggingIn  State = "LoggingIn"  // calling provider.Login()
+	StateConnecting State = "Connecting" // calling provider.Connect() + waiting for tunnel up
+	StateReady      State = "Ready"      // tunnel up; serving traffic
+	StateDraining   State = "Draining"   // rotation start: /readyz→503; proxy drain in progress
+	StateRotating   State = "Rotating"   // Disconnect done; reconnecting to new location
+	StateFailed     State = "Failed"     // surrendered; watchdog will retry with backoff
+	StateParked     State = "Parked"     // disconnected on request (/tunnel/disconnect); nothing reconnects until /tunnel/connect
+)
+
+// RotationRecord is the JSON shape under `last_rotation` in /status.
+// Written once a rotation completes (success or surrender).
+type RotationRecord struct {
+	CompletedAt     string `json:"completed_at"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Outcome         string `json:"outcome"` // "success" or "failed"
+	PreviousExitIP  string `json:"previous_exit_ip,omitempty"`
+	NewExitIP       string `json:"new_exit_ip,omitempty"`
+	// Location is where the rotation landed; empty when it failed.
+	// Country is its ISO-3166 code, when known.
+	Location string `json:"location,omitempty"`
+	Country  string `json:"country,omitempty"`
+	// RequestedBy is the audited /rotate call behind this rotation;
+	// absent for scheduled rotations.
+	RequestedBy *AuditEntry `json:"requested_by,omitempty"`
+	// RejectedExits lists the tunnels the rotation came up on and
+	// turned down before the one it kept.
+	RejectedExits []ExitRejection `json:"rejected_exits,omitempty"`
+}
+
+// ExitRejection is one tunnel a rotation connected and turned down.
+// Reason is "avoid_exit_ips" (the caller listed the exit),
+// "recent_exit" (the exit, or its subnet, served the pod recently),
+// "exit_country" (the exit geolocates outside the location's country) or
+// "exit_asn" (the exit's ASN is listed as hosting).
+type ExitRejection struct {
+	ExitIP   string `json:"exit_ip"`
+	Location string `json:"location"`
+	Reason   string `json:"reason"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Snapshot is the JSON shape returned by /status. Field tags +
+// omitempty rules: a slot consumer (crawler / leak detector) reads
+// these to introspect the tunnel's current state.
+type Snapshot struct {
+	State                        State           `json:"state"`
+	Provider                     string          `json:"provider"`
+	CurrentLocation              string          `json:"current_location,omitempty"`
+	CurrentCountry               string          `json:"current_country,omitempty"` // ISO-3166 alpha-2
+	CurrentExitIP                string          `json:"current_exit_ip,omitempty"`
+	TunnelAgeSeconds             int             `json:"tunnel_age_seconds"`
+	NextRotationInSeconds        int             `json:"next_rotation_in_seconds"`
+	RotationCountTotal           int             `json:"rotation_count_total"`
+	LoggedInAt                   string          `json:"logged_in_at,omitempty"`
+	BootLoginJitterActualSeconds int             `json:"boot_login_jitter_actual_seconds"`
+	LastRotation                 *RotationRecord `json:"last_rotation,omitempty"`
+	// AuthFailuresTotal is the count of Login() rejections observed
+	// since the pod started (carried across process respawns by the
+	// state file). Always present (zero-valued at boot) so an
+	// aggregator can poll without conditional branches.
+	AuthFailuresTotal     int    `json:"auth_failures_total"`
+	LastAuthFailureAt     string `json:"last_auth_failure_at,omitempty"`
+	LastAuthFailureReason string `json:"last_auth_failure_reason,omitempty"`
+	// TunnelGeneration increments on every tunnel-up; 0 until the
+	// first. Pass it back as /rotate?if_generation= to rotate only if
+	// the pod is still on the tunnel the caller observed.
+	TunnelGeneration uint64 `json:"tunnel_generation"`
+	// RotationPaused is true while POST /rotation/pause holds scheduled
+	// rotations; /rotate is still honoured.
+	RotationPaused   bool   `json:"rotation_paused"`
+	RotationPausedAt string `json:"rotation_paused_at,omitempty"`
+	RotationPausedBy string `json:"rotation_paused_by,omitempty"`
+	// ShuttingDown is true once the shutdown drain has started (POST
+	// /drain or SIGTERM): /readyz is 503 whatever State says.
+	ShuttingDown      bool   `json:"shutting_down"`
+	ShuttingDownSince string `json:"shutting_down_since,omitempty"`
+	// Process* cover this tundler-tunnel process; Pod* the pod, across
+	// the systemd respawns (wedge guard) and container recycles counted
+	// in ProcessRestarts. They match until the first respawn, or when
+	// the pod runs without a state file.
+	ProcessStartedAt     string `json:"process_started_at"`
+	ProcessUptimeSeconds int    `json:"process_uptime_seconds"`
+	PodStartedAt         string `json:"pod_started_at"`
+	PodUptimeSeconds     int    `json:"pod_uptime_seconds"`
+	ProcessRestarts      int    `json:"process_restarts"`
+	// LocationStrategy is how the pod picks connect locations
+	// (LOCATION_STRATEGY): random, weighted, lru, round_robin, sticky
+	// or partition.
+	LocationStrategy string `json:"location_strategy"`
+	// LocationHealth scores every location the pod has tried, keyed by
+	// location; see LocationHealth.
+	LocationHealth map[string]LocationHealth `json:"location_health,omitempty"`
+	// ExitGeo is the offline geolocation of the current exit; absent
+	// without GEOIP_DB.
+	ExitGeo *ExitGeo `json:"exit_geo,omitempty"`
+	// ExitASN is the autonomous system of the current exit; absent
+	// without ASN_DB or when the database doesn't hold the exit.
+	ExitASN *ExitASN `json:"exit_asn,omitempty"`
+	// ExitQualification is the post-connect performance check of the
+	// current exit; absent without QUALIFY_* targets.
+	ExitQualification *ExitQualification `json:"exit_qualification,omitempty"`
+}
+
+// ExitGeo is an exit IP looked up in the GEOIP_DB database and checked
+// against the ISO country of the location it was picked for. Verdict is
+// "match", "mismatch", or "unknown" when the database doesn't hold the
+// exit or the location has no country.
+type ExitGeo struct {
+	ExitIP          string `json:"exit_ip"`
+	Country         string `json:"country,omitempty"` // ISO-3166 alpha-2
+	City            string `json:"city,omitempty"`
+	Network         string `json:"network,omitempty"` // the database network holding the exit
+	ExpectedCountry string `json:"expected_country,omitempty"`
+	Verdict         string `json:"verdict"`
+	CheckedAt       string `json:"checked_at"`
+}
+
+// ExitASN is an exit IP looked up in the ASN_DB database. Hosting is
+// true when the ASN is listed in HOSTING_ASNS; false reads as
+// residential.
+type ExitASN struct {
+	ExitIP  string `json:"exit_ip"`
+	ASN     uint32 `json:"asn"`
+	Org     string `json:"org,omitempty"`
+	Network string `json:"network,omitempty"` // the database network holding the exit
+	Hosting bool   `json:"hosting"`
+}
+
+// ExitQualification is an exit measured after the exit-IP contract:
+// the median TCP connect time to the QUALIFY_LATENCY_TARGETS, a bounded
+// download of QUALIFY_THROUGHPUT_URL, and whether that download stalled
+// like a PMTU black hole. Failure names the first threshold missed; an
+// exit that isn't Passed is never kept.
+type ExitQualification struct {
+	ExitIP           string  `json:"exit_ip"`
+	ConnectLatencyMS float64 `json:"connect_latency_ms,omitempty"`
+	ConnectAttempts  int     `json:"connect_attempts,omitempty"`
+	ConnectFailures  int     `json:"connect_failures,omitempty"`
+	ThroughputKbps   float64 `json:"throughput_kbps,omitempty"`
+	DownloadedBytes  int64   `json:"downloaded_bytes,omitempty"`
+	PMTUBlackHole    bool    `json:"pmtu_black_hole,omitempty"`
+	Passed           bool    `json:"passed"`
+	Failure          string  `json:"failure,omitempty"`
+	CheckedAt        string  `json:"checked_at"`
+}
+
+// LocationHealth is one location's decaying health record. Counts halve
+// every score half-life; Score is (successes+1) over that plus the
+// failures, contract failures counting double. A location scoring under
+// the quarantine threshold after a failure is skipped until
+// QuarantinedUntil, for a period doubling with ConsecutiveQuarantines.
+type LocationHealth struct {
+	Country                string  `json:"country,omitempty"` // ISO-3166 alpha-2, when known
+	Score                  float64 `json:"score"`
+	Successes              float64 `json:"successes"`
+	ConnectFailures        float64 `json:"connect_failures"`
+	ContractFailures       float64 `json:"contract_failures"`
+	Drops                  float64 `json:"drops"`
+	AvgConnectSeconds      float64 `json:"avg_connect_seconds"`
+	Quarantined            bool    `json:"quarantined"`
+	QuarantinedUntil       string  `json:"quarantined_until,omitempty"`
+	ConsecutiveQuarantines int     `json:"consecutive_quarantines"`
+}
+
+// AuditEntry records one call to a mutating control-API endpoint. It is
+// logged, forwarded to the event sinks, and attached to the rotation
+// record of the rotation it triggered.
+type AuditEntry struct {
+	At         string         `json:"at"`
+	Caller     string         `json:"caller"`
+	AuthMethod string         `json:"auth_method"`
+	RemoteAddr string         `json:"remote_addr,omitempty"`
+	Endpoint   string         `json:"endpoint"`
+	Params     map[string]any `json:"params,omitempty"`
+	Result     string         `json:"result"`
+}
+
+// Problem is an RFC 9457 problem-details body
+// (application/problem+json), the shape of every control-API error.
+// Type is a stable URI under https://tundler-tunnel/errors/.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RotateResult is the body of every non-error POST /rotate response:
+// 202 accepted (state, previous_exit_ip), 200 debounced or in progress
+// (state, message), 200 superseded conditional request, and the 200 of a
+// completed wait=true rotation (with the rotation record).
+type RotateResult struct {
+	State            State           `json:"state"`
+	PreviousExitIP   string          `json:"previous_exit_ip,omitempty"`
+	CurrentExitIP    string          `json:"current_exit_ip,omitempty"`
+	CurrentLocation  string          `json:"current_location,omitempty"`
+	TunnelGeneration uint64          `json:"tunnel_generation"`
+	Rotation         *RotationRecord `json:"rotation,omitempty"`
+	Message          string          `json:"message,omitempty"`
+}
+
+// ProbeReport is the body of GET /livez?verbose and GET /readyz?verbose,
+// served with the probe's usual status code (200, or 503 when OK is
+// false).
+type ProbeReport struct {
+	OK     bool         `json:"ok"`
+	Checks []ProbeCheck `json:"checks"`
+}
+
+// ProbeCheck is one named check in a ProbeReport. AgeSeconds is the age
+// of the signal the check looked at (last heartbeat, last successful
+// dial, last contract probe), when there is one.
+type ProbeCheck struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	Detail     string  `json:"detail,omitempty"`
+	AgeSeconds float64 `json:"age_seconds,omitempty"`
+}